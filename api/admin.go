@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUsersHandler lists every account of the instance.
+func GetUsersHandler(c *gin.Context) {
+	users, err := services.ListUsers()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, users)
+}
+
+// UpdateUserAccessHandler changes the role of an account and disables or enables
+// it, e.g. {"role": "viewer"} or {"disabled": true}. A disabled account is
+// logged out everywhere and cannot log in until it is enabled again.
+func UpdateUserAccessHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role     *models.Role `json:"role"`
+		Disabled *bool        `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Role == nil && req.Disabled == nil {
+		c.JSON(400, gin.H{"error": "role or disabled is required"})
+		return
+	}
+	if req.Role != nil && !req.Role.Valid() {
+		c.JSON(400, gin.H{"error": "role must be one of admin, editor or viewer"})
+		return
+	}
+
+	user, err := services.UpdateUserAccess(userID, req.Role, req.Disabled)
+	if err != nil {
+		respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(200, user)
+}
+
+// ResyncUserHandler forces a refresh of every project of an account, whether
+// or not it opted in to background sync, as a sync job of that account.
+func ResyncUserHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := services.GetUserByID(userID)
+	if err != nil {
+		respondAdminError(c, err)
+		return
+	}
+	if user.Disabled {
+		respondAdminError(c, services.ErrUserDisabled)
+		return
+	}
+
+	job, err := syncJobs.Enqueue(user, models.SyncJobAll, nil)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(202, gin.H{
+		"job_id": job.ID,
+		"job":    job,
+	})
+}
+
+// GetUserSyncJobsHandler lists the most recent sync jobs of an account, e.g. to
+// follow a forced resync.
+func GetUserSyncJobsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if _, err := services.GetUserByID(userID); err != nil {
+		respondAdminError(c, err)
+		return
+	}
+	jobs, err := services.GetSyncJobsByUser(userID, 50)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, jobs)
+}
+
+// respondAdminError maps account administration errors to a status code.
+func respondAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrUserDisabled):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
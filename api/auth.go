@@ -1,15 +1,165 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"strings"
 
-// LogoutHandler handles user logout requests
+	"my-cucumber-backend/middleware"
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func RefreshHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	session, refreshToken, err := services.RotateSession(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRefreshToken), errors.Is(err, services.ErrRefreshTokenReused),
+			errors.Is(err, services.ErrSessionRevoked), errors.Is(err, services.ErrSessionExpired):
+			c.JSON(401, gin.H{"error": err.Error()})
+		default:
+			c.JSON(500, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	respondTokens(c, session, refreshToken)
+}
+
+// LogoutHandler revokes the session of the refresh token in the body or, without
+// one, of the access token in the Authorization header. Access tokens of the
+// session are rejected from then on.
 func LogoutHandler(c *gin.Context) {
-	// Currently, since we're using JWTs, we can't actually invalidate the token
-	// The client should:
-	// 1. Delete the token from their storage
-	// 2. Stop using the token for future requests
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	var err error
+	if req.RefreshToken != "" {
+		err = services.RevokeSessionByRefreshToken(req.RefreshToken)
+	} else {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(400, gin.H{"error": "A refresh_token or an Authorization header is required"})
+			return
+		}
+		userID, sessionID, parseErr := middleware.ParseAccessToken(strings.Replace(authHeader, "Bearer ", "", 1))
+		if parseErr != nil {
+			c.JSON(401, gin.H{"error": "Invalid token"})
+			return
+		}
+		err = services.RevokeSession(sessionID, userID)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(200, gin.H{
 		"message": "Successfully logged out",
 	})
 }
+
+// GetSessionsHandler lists the active sessions of the user, flagging the one
+// making the request.
+func GetSessionsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	sessions, err := services.GetActiveSessions(typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	current := c.GetString(middleware.SessionContextKey)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(200, sessions)
+}
+
+// RevokeSessionHandler revokes one session of the user, e.g. the one of a
+// stolen laptop. Its refresh token and access tokens stop working at once.
+func RevokeSessionHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.RevokeSession(c.Param("id"), typedUser.ID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(204)
+}
+
+// RevokeOtherSessionsHandler revokes every session of the user but the current one.
+func RevokeOtherSessionsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	revoked, err := services.RevokeOtherSessions(typedUser.ID, c.GetString(middleware.SessionContextKey))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"revoked": revoked})
+}
+
+// respondTokens issues an access token for a session and returns it with the
+// session's new refresh token.
+func respondTokens(c *gin.Context, session *models.Session, refreshToken string) {
+	accessToken, ttl, err := middleware.IssueAccessToken(session.UserID, session.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(200, models.TokenPair{
+		AccessToken:  accessToken,
+		Token:        accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(ttl.Seconds()),
+		RefreshToken: refreshToken,
+		SessionID:    session.ID,
+	})
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"strconv"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardPatch holds the fields of a partial dashboard update; nil fields are left unchanged.
+type dashboardPatch struct {
+	Name    *string                   `json:"name"`
+	Layout  *[]models.DashboardWidget `json:"layout"`
+	Filters *models.DashboardFilters  `json:"filters"`
+}
+
+// CreateDashboardHandler creates a dashboard, e.g.
+//
+//	{"name": "Release health", "filters": {"tag_expr": "@smoke"},
+//	 "layout": [{"kind": "chart", "id": 3, "x": 0, "y": 0, "w": 6, "h": 4}]}
+func CreateDashboardHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var dashboard models.Dashboard
+	if err := c.ShouldBindJSON(&dashboard); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	dashboard.UserID = typedUser.ID
+	if err := services.ValidateDashboard(&dashboard); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if err := services.CreateDashboard(&dashboard); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := services.GetDashboard(dashboard.ID, typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+// GetDashboardsHandler lists the dashboards of the user.
+func GetDashboardsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	dashboards, err := services.GetDashboardsByUser(typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, dashboards)
+}
+
+// GetDashboardHandler returns one dashboard of the user.
+func GetDashboardHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	dashboardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	dashboard, err := services.GetDashboard(dashboardID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, dashboard)
+}
+
+// UpdateDashboardHandler replaces a dashboard (PUT) or changes some of its
+// fields (PATCH), e.g. {"layout": [...]} after the user rearranged widgets.
+func UpdateDashboardHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	dashboardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	var dashboard models.Dashboard
+	if c.Request.Method == "PATCH" {
+		var patch dashboardPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		current, err := services.GetDashboard(dashboardID, typedUser.ID)
+		if err != nil {
+			respondVisualizationError(c, err)
+			return
+		}
+		dashboard = *current
+		if patch.Name != nil {
+			dashboard.Name = *patch.Name
+		}
+		if patch.Layout != nil {
+			dashboard.Layout = *patch.Layout
+		}
+		if patch.Filters != nil {
+			dashboard.Filters = *patch.Filters
+		}
+	} else if err := c.ShouldBindJSON(&dashboard); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	dashboard.ID = dashboardID
+	dashboard.UserID = typedUser.ID
+	if err := services.ValidateDashboard(&dashboard); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if err := services.UpdateDashboard(&dashboard); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	saved, err := services.GetDashboard(dashboardID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+	c.JSON(200, saved)
+}
+
+// DeleteDashboardHandler deletes a dashboard of the user.
+func DeleteDashboardHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	dashboardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.DeleteDashboard(dashboardID, typedUser.ID); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// RenderDashboardHandler returns the data of every widget of a dashboard.
+// project_id and tag_expr override the dashboard's saved filters, e.g. to look
+// at the same dashboard for another project.
+func RenderDashboardHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	dashboardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+	overrides := models.DashboardFilters{TagExpr: c.Query("tag_expr")}
+	if value := c.Query("project_id"); value != "" {
+		projectID, err := strconv.Atoi(value)
+		if err != nil || projectID <= 0 {
+			c.JSON(400, gin.H{"error": "A valid project_id is required"})
+			return
+		}
+		overrides.ProjectID = &projectID
+	}
+
+	typedUser := user.(*models.User)
+	render, err := services.RenderDashboard(c.Request.Context(), dashboardID, typedUser.ID, overrides)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, render)
+}
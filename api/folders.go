@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"my-cucumber-backend/models"
 	"my-cucumber-backend/services"
 	"strconv"
@@ -25,6 +26,10 @@ func GetFoldersHierarchyHandler(c *gin.Context) {
 	typedUser := user.(*models.User)
 	folders, err := services.GetFoldersHierarchy(projectID, typedUser.ID)
 	if err != nil {
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -47,11 +52,17 @@ func RefreshFoldersHandler(c *gin.Context) {
 	}
 
 	typedUser := user.(*models.User)
-	err = services.RefreshFolders(typedUser, projectID)
+	if wantsAsync(c) {
+		enqueueSyncJob(c, typedUser, models.SyncJobFolders, &projectID)
+		return
+	}
+
+	summary, err := services.RefreshFolders(c.Request.Context(), studioClient, typedUser, projectID)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		respondStudioError(c, "", err)
 		return
 	}
 
+	setRefreshSummaryHeaders(c, summary)
 	c.JSON(200, gin.H{"message": "Folders refreshed successfully"})
 }
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newStudioTestRouter opens a fresh database, points the handlers at the fake
+// server and returns a router that serves requests as a user who sees project 1.
+func newStudioTestRouter(t *testing.T, studio *fakestudio.Server) *gin.Engine {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.SetCredentialKeys("test:" + base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatalf("SetCredentialKeys: %v", err)
+	}
+	if err := services.InitializeDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitializeDB: %v", err)
+	}
+	t.Cleanup(services.CloseDB)
+
+	client, err := services.NewCucumberClient(services.CucumberClientConfig{
+		BaseURL:        studio.URL,
+		RequestTimeout: 5 * time.Second,
+		MaxRetries:     -1,
+	})
+	if err != nil {
+		t.Fatalf("NewCucumberClient: %v", err)
+	}
+	SetStudioClient(client)
+	t.Cleanup(func() { SetStudioClient(nil) })
+
+	user, err := services.CreateUser("qa@example.com", "password", "client", "token", func(*models.User) ([]models.Project, error) {
+		return []models.Project{{ID: "1", Name: "Shop"}}, nil
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user", user)
+		c.Next()
+	})
+	return router
+}
+
+// serve sends a request to router and returns the recorded response.
+func serve(router *gin.Engine, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+// refreshSummary reads the X-Refresh-* headers of a refresh response.
+func refreshSummary(w *httptest.ResponseRecorder) models.RefreshSummary {
+	count := func(name string) int {
+		n, _ := strconv.Atoi(w.Header().Get("X-Refresh-" + name))
+		return n
+	}
+	return models.RefreshSummary{
+		Added:     count("Added"),
+		Updated:   count("Updated"),
+		Removed:   count("Removed"),
+		Unchanged: count("Unchanged"),
+	}
+}
+
+func TestRefreshScenariosHandler(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	router := newStudioTestRouter(t, studio)
+	router.POST("/refresh-scenarios", RefreshScenariosHandler)
+
+	w := serve(router, http.MethodPost, "/refresh-scenarios?project_id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var scenarios []models.Scenario
+	if err := json.Unmarshal(w.Body.Bytes(), &scenarios); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	want := len(fakestudio.DefaultFixtures().Scenarios["1"])
+	if len(scenarios) != want {
+		t.Errorf("got %d scenarios, want %d", len(scenarios), want)
+	}
+	if got := refreshSummary(w); got != (models.RefreshSummary{Added: want}) {
+		t.Errorf("first refresh reported %+v, want %d added", got, want)
+	}
+
+	// A second refresh finds nothing new.
+	w = serve(router, http.MethodPost, "/refresh-scenarios?project_id=1")
+	if got := refreshSummary(w); got != (models.RefreshSummary{Unchanged: want}) {
+		t.Errorf("second refresh reported %+v, want %d unchanged", got, want)
+	}
+}
+
+func TestRefreshScenariosHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		failStatus int
+		wantStatus int
+	}{
+		{name: "missing project", target: "/refresh-scenarios", wantStatus: http.StatusBadRequest},
+		{name: "invalid project", target: "/refresh-scenarios?project_id=shop", wantStatus: http.StatusBadRequest},
+		{name: "unknown project", target: "/refresh-scenarios?project_id=99", wantStatus: http.StatusNotFound},
+		{name: "Studio unavailable", target: "/refresh-scenarios?project_id=1", failStatus: 503, wantStatus: http.StatusBadGateway},
+		{name: "Studio rejects credentials", target: "/refresh-scenarios?project_id=1", failStatus: 401, wantStatus: 424},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			studio := fakestudio.NewServer()
+			defer studio.Close()
+			router := newStudioTestRouter(t, studio)
+			router.POST("/refresh-scenarios", RefreshScenariosHandler)
+			if tt.failStatus != 0 {
+				studio.FailNext(1, tt.failStatus, "")
+			}
+
+			w := serve(router, http.MethodPost, tt.target)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestRefreshFoldersHandler(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	router := newStudioTestRouter(t, studio)
+	router.POST("/refresh-folders", RefreshFoldersHandler)
+	router.GET("/folders", GetFoldersHierarchyHandler)
+
+	w := serve(router, http.MethodPost, "/refresh-folders?project_id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	want := len(fakestudio.DefaultFixtures().Folders["1"])
+	if got := refreshSummary(w); got != (models.RefreshSummary{Added: want}) {
+		t.Errorf("got summary %+v, want %d added", got, want)
+	}
+
+	w = serve(router, http.MethodGet, "/folders?project_id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var folders []models.Folder
+	if err := json.Unmarshal(w.Body.Bytes(), &folders); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if len(folders) == 0 {
+		t.Error("the refreshed folders are not served")
+	}
+
+	studio.FailNext(1, 503, "")
+	if w := serve(router, http.MethodPost, "/refresh-folders?project_id=1"); w.Code != http.StatusBadGateway {
+		t.Errorf("got status %d for an unavailable Studio, want 502: %s", w.Code, w.Body)
+	}
+}
@@ -1,6 +1,12 @@
 package api
 
 import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -10,7 +16,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GetScenariosHandler retrieves scenarios based on query parameters.
+// GetScenariosHandler searches cached scenarios. All filters combine:
+//
+//	project_id  required
+//	folder_id   scenarios of a folder; recursive=true includes its subfolders
+//	tags        comma-separated key:value pairs that must all match
+//	expr        Cucumber tag expression, e.g. "(@smoke or @regression) and not @wip"
+//	keyword     substring of the scenario name
+//	sort        name, folder or id (default); prefix with - to sort descending
+//	limit       page size, all results when omitted
+//	offset      rows to skip
+//	cursor      next_cursor of the previous page, instead of offset
 func GetScenariosHandler(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -18,61 +34,302 @@ func GetScenariosHandler(c *gin.Context) {
 		return
 	}
 
+	typedUser := user.(*models.User)
+	query, ok := scenarioQueryFromRequest(c, typedUser)
+	if !ok {
+		return
+	}
+
+	page, err := services.SearchScenarios(*query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScenarioQuery) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to get scenarios: " + err.Error()})
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// scenarioQueryFromRequest parses the scenario filters of GetScenariosHandler.
+// It writes a 400 response and returns false when a parameter is invalid.
+func scenarioQueryFromRequest(c *gin.Context, user *models.User) (*services.ScenarioQuery, bool) {
 	projectIDStr := c.Query("project_id")
 	if projectIDStr == "" {
 		c.JSON(400, gin.H{"error": "project_id is required"})
-		return
+		return nil, false
 	}
-
 	projectID, err := strconv.Atoi(projectIDStr)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid project ID"})
+		return nil, false
+	}
+
+	query := &services.ScenarioQuery{
+		ProjectID: projectID,
+		UserID:    user.ID,
+		Keyword:   c.Query("keyword"),
+		Recursive: c.Query("recursive") == "true",
+		Cursor:    c.Query("cursor"),
+	}
+
+	if folderIDStr := c.Query("folder_id"); folderIDStr != "" {
+		folderID, err := strconv.Atoi(folderIDStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid folder ID"})
+			return nil, false
+		}
+		query.FolderID = &folderID
+	}
+
+	if tagsStr := c.Query("tags"); tagsStr != "" {
+		query.Tags = strings.Split(tagsStr, ",") // Each tag should be in format "key:value"
+	}
+
+	if exprStr := c.Query("expr"); exprStr != "" {
+		query.TagExpr, err = services.ParseTagExpr(exprStr)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+
+	sort := c.Query("sort")
+	if strings.HasPrefix(sort, "-") {
+		query.Descending = true
+		sort = sort[1:]
+	}
+	query.Sort = services.ScenarioSort(sort)
+
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(400, gin.H{"error": "Invalid " + name})
+			return nil, false
+		}
+		*target = n
+	}
+
+	return query, true
+}
+
+// ExportScenariosHandler downloads the scenarios matching the GET /scenarios filters
+// as a zip of Gherkin .feature files, one per folder, laid out like the folder hierarchy.
+func ExportScenariosHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
 		return
 	}
 
 	typedUser := user.(*models.User)
-	tagsStr := c.Query("tags")
-	folderIDStr := c.Query("folder_id")
-	keyword := c.Query("keyword")
-
-	var scenarios []models.Scenario
-	userID := typedUser.ID
-
-	// Call the appropriate service function based on the provided parameters
-	if tagsStr != "" {
-		tags := strings.Split(tagsStr, ",") // Split comma-separated tags
-		// Each tag should be in format "key:value"
-		scenarios, err = services.GetScenariosByTags(projectID, userID, tags)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get scenarios by tags: " + err.Error()})
+	query, ok := scenarioQueryFromRequest(c, typedUser)
+	if !ok {
+		return
+	}
+
+	files, err := services.ExportFeatures(*query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScenarioQuery) {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-	} else if folderIDStr != "" {
-		folderID, err := strconv.Atoi(folderIDStr) // Use := to declare a *new* err in this scope
-		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid folder ID"})
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
 			return
 		}
-		scenarios, err = services.GetScenariosByFolderID(projectID, userID, folderID)
-		if err != nil { // Use the newly declared 'err' from this block
-			c.JSON(500, gin.H{"error": "Failed to get scenarios by folder ID: " + err.Error()})
+		c.JSON(500, gin.H{"error": "Failed to export scenarios: " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="project-%d-features.zip"`, query.ProjectID))
+	c.Status(200)
+	if err := services.WriteFeatureZip(c.Writer, files); err != nil {
+		// The status line is already sent; all we can do is cut the download short.
+		log.Printf("Failed to write scenario export: %v", err)
+	}
+}
+
+// maxFeatureUploadSize caps zip uploads to the drift endpoint.
+const maxFeatureUploadSize = 32 << 20
+
+// ScenarioDriftHandler compares an uploaded zip of .feature files with the cached
+// scenarios of a project. The zip goes in the multipart field "file"; the optional
+// "root" field names the folder inside the zip that mirrors the Studio hierarchy.
+func ScenarioDriftHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	projectID, err := strconv.Atoi(c.Query("project_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFeatureUploadSize)
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A zip of .feature files is required in the \"file\" field: " + err.Error()})
+		return
+	}
+	file, err := upload.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Failed to read upload: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, upload.Size)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Upload is not a valid zip: " + err.Error()})
+		return
+	}
+	var features fs.FS = archive
+	if root := strings.Trim(c.PostForm("root"), "/"); root != "" {
+		if features, err = fs.Sub(archive, root); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid root: " + err.Error()})
 			return
 		}
-	} else if keyword != "" {
-		scenarios, err = services.GetScenariosByName(projectID, userID, keyword)
-		if err != nil { // Use the outer-scope 'err'
-			c.JSON(500, gin.H{"error": "Failed to get scenarios by name: " + err.Error()})
+	}
+
+	report, err := services.DiffFeatureFiles(features, projectID, typedUser.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
 			return
 		}
-	} else {
-		scenarios, err = services.GetScenariosByProjectID(projectID, userID) // Default: get all by project ID
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get scenarios: " + err.Error()})
+		c.JSON(500, gin.H{"error": "Failed to compare feature files: " + err.Error()})
+		return
+	}
+
+	c.JSON(200, report)
+}
+
+// GetScenarioHandler returns a cached scenario with its description, definition,
+// steps, datatable and datasets.
+func GetScenarioHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	scenario, err := services.GetScenarioByID(c.Param("id"), typedUser.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrScenarioNotFound) {
+			c.JSON(404, gin.H{"error": "Scenario not found"})
 			return
 		}
+		c.JSON(500, gin.H{"error": "Failed to get scenario: " + err.Error()})
+		return
 	}
 
-	c.JSON(200, scenarios)
+	c.JSON(200, scenario)
+}
+
+// SearchScenariosHandler runs a ranked full-text search over the names, descriptions
+// and steps of a project's scenarios, e.g. GET /scenarios/search?project_id=1&q=login%20pass.
+func SearchScenariosHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	projectID, err := strconv.Atoi(c.Query("project_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A valid project_id is required"})
+		return
+	}
+	text := c.Query("q")
+	if text == "" {
+		c.JSON(400, gin.H{"error": "q is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		c.JSON(400, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(400, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	results, err := services.SearchScenarioText(projectID, typedUser.ID, text, limit, offset)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSearchQuery):
+			c.JSON(400, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrSearchUnavailable):
+			c.JSON(503, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrProjectAccessDenied):
+			c.JSON(403, gin.H{"error": err.Error()})
+		default:
+			c.JSON(500, gin.H{"error": "Failed to search scenarios: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, results)
+}
+
+// FlakyScenariosHandler ranks a project's scenarios by how flaky they have been
+// in recent test runs, e.g. GET /scenarios/flaky?project_id=1&runs=20. Every
+// scenario comes with its score components and the runs behind them.
+func FlakyScenariosHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	projectID, err := strconv.Atoi(c.Query("project_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A valid project_id is required"})
+		return
+	}
+	window, err := strconv.Atoi(c.DefaultQuery("runs", "20"))
+	if err != nil || window <= 0 || window > services.MaxFlakyWindow {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("runs must be between 1 and %d", services.MaxFlakyWindow)})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		c.JSON(400, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	report, err := services.FindFlakyScenarios(projectID, typedUser.ID, window, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to score flaky scenarios: " + err.Error()})
+		return
+	}
+
+	c.JSON(200, report)
 }
 
 // RefreshScenariosHandler fetches the latest scenarios from Cucumber Studio and updates the database.
@@ -96,11 +353,17 @@ func RefreshScenariosHandler(c *gin.Context) {
 		return
 	}
 
-	scenarios, err := services.RefreshScenarios(typedUser, projectID)
+	if wantsAsync(c) {
+		enqueueSyncJob(c, typedUser, models.SyncJobScenarios, &projectID)
+		return
+	}
+
+	scenarios, summary, err := services.RefreshScenarios(c.Request.Context(), studioClient, typedUser, projectID)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		respondStudioError(c, "", err)
 		return
 	}
 
+	setRefreshSummaryHeaders(c, summary)
 	c.JSON(200, scenarios)
 }
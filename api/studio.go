@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+var studioClient services.StudioClient
+
+// SetStudioClient sets the Cucumber Studio client used by the handlers.
+func SetStudioClient(client services.StudioClient) {
+	studioClient = client
+}
+
+// studioErrorStatus maps an error returned by the Cucumber Studio client to an HTTP status.
+func studioErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrStudioUnauthorized):
+		// Not 401: the caller's session is fine, their stored Studio credentials are not.
+		return 424
+	case errors.Is(err, services.ErrStudioNotFound):
+		return 404
+	case errors.Is(err, services.ErrStudioRateLimited):
+		return 429
+	case errors.Is(err, context.DeadlineExceeded):
+		return 504
+	case errors.Is(err, services.ErrStudioUnavailable), errors.Is(err, services.ErrStudioBadRequest):
+		return 502
+	default:
+		return 500
+	}
+}
+
+// respondStudioError writes err with the status matching the Cucumber Studio
+// failure. Studio's response body is only logged: it is not ours to pass on.
+func respondStudioError(c *gin.Context, message string, err error) {
+	log.Printf("Cucumber Studio call for %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+	status := studioErrorStatus(err)
+	var studioErr *services.StudioError
+	if status == 429 && errors.As(err, &studioErr) && studioErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(studioErr.RetryAfter.Round(time.Second)/time.Second)))
+	}
+	c.JSON(status, gin.H{"error": message + services.PublicError(err)})
+}
+
+// setRefreshSummaryHeaders reports what a refresh changed in X-Refresh-*
+// headers, leaving the response body as it was before refreshes were counted.
+func setRefreshSummaryHeaders(c *gin.Context, summary *models.RefreshSummary) {
+	c.Header("X-Refresh-Added", strconv.Itoa(summary.Added))
+	c.Header("X-Refresh-Updated", strconv.Itoa(summary.Updated))
+	c.Header("X-Refresh-Removed", strconv.Itoa(summary.Removed))
+	c.Header("X-Refresh-Unchanged", strconv.Itoa(summary.Unchanged))
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSyncSettingsHandler returns the user's background sync opt-in and the last sync of each project.
+func GetSyncSettingsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	projects, err := services.GetProjectSyncs(typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"auto_sync":      typedUser.AutoSync,
+		"last_synced_at": typedUser.LastSyncedAt,
+		"projects":       projects,
+	})
+}
+
+// UpdateSyncSettingsHandler opts the user in to or out of background sync.
+func UpdateSyncSettingsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		AutoSync *bool `json:"auto_sync" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.SetAutoSync(typedUser.ID, *req.AutoSync); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":   "Sync settings updated successfully",
+		"auto_sync": *req.AutoSync,
+	})
+}
+
+var syncJobs *services.SyncJobRunner
+
+// SetSyncJobRunner sets the runner used for asynchronous refreshes.
+func SetSyncJobRunner(runner *services.SyncJobRunner) {
+	syncJobs = runner
+}
+
+// wantsAsync reports whether the client asked for the refresh to run as a background job.
+func wantsAsync(c *gin.Context) bool {
+	async, _ := strconv.ParseBool(c.Query("async"))
+	return async
+}
+
+// enqueueSyncJob starts a background job and answers 202 with its ID.
+func enqueueSyncJob(c *gin.Context, user *models.User, kind models.SyncJobKind, projectID *int) {
+	job, err := syncJobs.Enqueue(user, kind, projectID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/protected/sync-jobs/%d", job.ID))
+	c.JSON(202, gin.H{
+		"job_id": job.ID,
+		"job":    job,
+	})
+}
+
+// CreateSyncJobHandler enqueues a sync job of any kind, including a refresh of every project.
+func CreateSyncJobHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Kind      models.SyncJobKind `json:"kind" binding:"required"`
+		ProjectID *int               `json:"project_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	switch req.Kind {
+	case models.SyncJobScenarios, models.SyncJobFolders:
+		if req.ProjectID == nil {
+			c.JSON(400, gin.H{"error": "project_id is required"})
+			return
+		}
+	case models.SyncJobAll:
+	default:
+		c.JSON(400, gin.H{"error": "kind must be one of scenarios, folders or all"})
+		return
+	}
+
+	enqueueSyncJob(c, user.(*models.User), req.Kind, req.ProjectID)
+}
+
+// GetSyncJobsHandler lists the user's most recent sync jobs.
+func GetSyncJobsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	jobs, err := services.GetSyncJobsByUser(typedUser.ID, 50)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, jobs)
+}
+
+// GetSyncJobHandler returns the current state of a sync job.
+func GetSyncJobHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	job, err := services.GetSyncJob(jobID, typedUser.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrSyncJobNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, job)
+}
+
+// StreamSyncJobHandler streams a sync job's progress as Server-Sent Events.
+// Every change is sent as a "progress" event; the stream ends with a "done" event.
+func StreamSyncJobHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	// Subscribe before reading the job so no update can slip in between.
+	updates, unsubscribe := syncJobs.Subscribe(jobID)
+	defer unsubscribe()
+
+	typedUser := user.(*models.User)
+	job, err := services.GetSyncJob(jobID, typedUser.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrSyncJobNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	current := *job
+	c.Stream(func(w io.Writer) bool {
+		if current.Finished() {
+			c.SSEvent("done", current)
+			return false
+		}
+		c.SSEvent("progress", current)
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case next := <-updates:
+			current = next
+		case <-heartbeat.C:
+			// Keep proxies from closing an idle connection; the state is resent.
+		}
+		return true
+	})
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateTeamHandler creates a team with the user as its creator and first member.
+func CreateTeamHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var team models.Team
+	if err := c.ShouldBindJSON(&team); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := services.ValidateTeam(&team); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	typedUser := user.(*models.User)
+	team.CreatedBy = typedUser.ID
+	if err := services.CreateTeam(&team); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := services.GetTeam(team.ID, typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, saved)
+}
+
+// GetTeamsHandler lists the teams of the user.
+func GetTeamsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	teams, err := services.GetTeamsByUser(typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, teams)
+}
+
+// GetTeamHandler returns a team of the user with its members.
+func GetTeamHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid team ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	team, err := services.GetTeam(teamID, typedUser.ID)
+	if err != nil {
+		respondTeamError(c, err)
+		return
+	}
+
+	c.JSON(200, team)
+}
+
+// DeleteTeamHandler deletes a team the user is an admin of. Charts and data
+// tables shared with it become private to their owners.
+func DeleteTeamHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid team ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.DeleteTeam(teamID, typedUser.ID); err != nil {
+		respondTeamError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// AddTeamMemberHandler adds a registered user to a team, e.g.
+// {"email": "dev@example.com", "role": "viewer"}. The role defaults to editor.
+// Only admins of the team can add members.
+func AddTeamMemberHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid team ID"})
+		return
+	}
+
+	var req struct {
+		Email string      `json:"email" binding:"required"`
+		Role  models.Role `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleEditor
+	}
+	if !req.Role.Valid() {
+		c.JSON(400, gin.H{"error": "role must be one of admin, editor or viewer"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	member, err := services.AddTeamMember(teamID, typedUser.ID, req.Email, req.Role)
+	if err != nil {
+		respondTeamError(c, err)
+		return
+	}
+
+	c.JSON(201, member)
+}
+
+// UpdateTeamMemberHandler changes the role of a member of a team, e.g. {"role": "admin"}.
+// Only admins of the team can change roles.
+func UpdateTeamMemberHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid team ID"})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !req.Role.Valid() {
+		c.JSON(400, gin.H{"error": "role must be one of admin, editor or viewer"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	member, err := services.SetTeamMemberRole(teamID, typedUser.ID, memberID, req.Role)
+	if err != nil {
+		respondTeamError(c, err)
+		return
+	}
+
+	c.JSON(200, member)
+}
+
+// RemoveTeamMemberHandler removes a member from a team. Admins of the team can
+// remove any member but the creator; members can remove themselves to leave.
+func RemoveTeamMemberHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid team ID"})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.RemoveTeamMember(teamID, typedUser.ID, memberID); err != nil {
+		respondTeamError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// respondTeamError maps team errors to a status code.
+func respondTeamError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTeamNotFound), errors.Is(err, services.ErrTeamMemberNotFound),
+		errors.Is(err, services.ErrUserNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTeamRoleDenied):
+		c.JSON(403, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadyTeamMember), errors.Is(err, services.ErrTeamCreatorCannotLeave),
+		errors.Is(err, services.ErrTeamCreatorRole):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTestReportSize caps test report uploads.
+const maxTestReportSize = 32 << 20
+
+// CreateTestRunHandler stores a test report from CI as a test run, e.g.
+//
+//	curl -X POST --data-binary @cucumber.json \
+//	    "/api/protected/test-runs?project_id=1&name=nightly%20%23512"
+//
+// Cucumber JSON, Cucumber Messages (NDJSON) and JUnit XML reports are accepted.
+// The format is detected from the Content-Type and the report itself, unless
+// given as format=cucumber-json, cucumber-messages or junit-xml. Every executed
+// scenario is matched to a cached scenario of the project by name and tags.
+func CreateTestRunHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	projectID, err := strconv.Atoi(c.Query("project_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A valid project_id is required"})
+		return
+	}
+
+	report, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxTestReportSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(413, gin.H{"error": "The test report is larger than 32 MB"})
+			return
+		}
+		c.JSON(400, gin.H{"error": "Failed to read the test report: " + err.Error()})
+		return
+	}
+
+	format, results, err := services.ParseTestReport(c.Query("format"), c.ContentType(), report)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	run, err := services.CreateTestRun(typedUser.ID, projectID, c.Query("name"), format, results)
+	if err != nil {
+		if errors.Is(err, services.ErrProjectAccessDenied) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to store test run: " + err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/protected/test-runs/%d", run.ID))
+	c.JSON(201, run)
+}
+
+// GetTestRunsHandler lists the most recent test runs of a project.
+func GetTestRunsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	projectID, err := strconv.Atoi(c.Query("project_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A valid project_id is required"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	runs, err := services.GetTestRuns(projectID, typedUser.ID, 50)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, runs)
+}
+
+// GetTestRunHandler returns a test run with the result of every scenario it executed.
+func GetTestRunHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	runID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid test run ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	run, err := services.GetTestRun(runID, typedUser.ID, true)
+	if err != nil {
+		if errors.Is(err, services.ErrTestRunNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, run)
+}
+
+// PushTestRunHandler pushes the results of a test run to the matching Cucumber
+// Studio test run, creating it if needed, using the user's Studio credentials.
+// With dry_run=true it only reports the changes the push would make.
+func PushTestRunHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	runID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid test run ID"})
+		return
+	}
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(400, gin.H{"error": "dry_run must be true or false"})
+			return
+		}
+	}
+
+	typedUser := user.(*models.User)
+	push, err := services.PushTestRun(c.Request.Context(), studioClient, typedUser, runID, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTestRunNotFound):
+			c.JSON(404, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNothingToPush):
+			c.JSON(422, gin.H{"error": err.Error()})
+		default:
+			respondStudioError(c, "Failed to push test run: ", err)
+		}
+		return
+	}
+
+	c.JSON(200, push)
+}
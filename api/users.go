@@ -2,14 +2,12 @@ package api
 
 import (
 	"encoding/json"
-	"time"
+	"errors"
 
-	"my-cucumber-backend/middleware"
 	"my-cucumber-backend/models"
 	"my-cucumber-backend/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 // RegisterHandler handles user registration.
@@ -26,20 +24,17 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := services.CreateUser(req.Email, req.Password, req.CucumberClientID, req.CucumberAccessToken)
+	// Registration fails before anything is saved if Studio rejects the credentials.
+	user, err := services.CreateUser(req.Email, req.Password, req.CucumberClientID, req.CucumberAccessToken,
+		services.NewCredentialVerifier(c.Request.Context(), studioClient))
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create user: " + err.Error()})
+		respondCredentialError(c, "Failed to create user: ", err)
 		return
 	}
 
-	projects, err := services.GetProjects(user)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to fetch initial projects: " + err.Error()})
-		return
-	}
-
-	if err := services.UpdateUserProjects(user, projects); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update user projects: " + err.Error()})
+	var projects []models.Project
+	if err := json.Unmarshal([]byte(user.Projects), &projects); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unmarshal projects"})
 		return
 	}
 
@@ -50,7 +45,8 @@ func RegisterHandler(c *gin.Context) {
 	})
 }
 
-// LoginHandler handles user login and issues a JWT.
+// LoginHandler handles user login. It starts a session and issues a short-lived
+// access token (a JWT) with a refresh token to renew it; see RefreshHandler.
 func LoginHandler(c *gin.Context) {
 	var req struct {
 		Email    string `json:"email" binding:"required"`
@@ -63,24 +59,22 @@ func LoginHandler(c *gin.Context) {
 	}
 
 	user, err := services.AuthenticateUser(req.Email, req.Password)
+	if errors.Is(err, services.ErrUserDisabled) {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+		return
+	}
 	if err != nil {
 		c.JSON(401, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(middleware.SecretKey))
+	session, refreshToken, err := services.CreateSession(user.ID, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		c.JSON(500, gin.H{"error": "Failed to create session: " + err.Error()})
 		return
 	}
 
-	c.JSON(200, gin.H{"token": tokenString})
+	respondTokens(c, session, refreshToken)
 }
 
 // RefreshProjectsHandler allows a user to refresh their list of projects.
@@ -92,9 +86,9 @@ func RefreshProjectsHandler(c *gin.Context) {
 	}
 
 	typedUser := user.(*models.User)
-	projects, err := services.GetProjects(typedUser)
+	projects, err := studioClient.GetProjects(c.Request.Context(), typedUser)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to fetch projects: " + err.Error()})
+		respondStudioError(c, "Failed to fetch projects: ", err)
 		return
 	}
 
@@ -125,9 +119,11 @@ func ProtectedHandler(c *gin.Context) {
 	}
 
 	c.JSON(200, gin.H{
-		"message":  "Hello, here is the protected data.",
-		"email":    typedUser.Email,
-		"projects": projects,
+		"message":               "Hello, here is the protected data.",
+		"email":                 typedUser.Email,
+		"projects":              projects,
+		"cucumber_client_id":    models.MaskSecret(typedUser.CucumberClientID),
+		"cucumber_access_token": models.MaskSecret(typedUser.CucumberAccessToken),
 	})
 }
 
@@ -150,12 +146,62 @@ func UpdateCucumberCredentialsHandler(c *gin.Context) {
 	}
 
 	typedUser := user.(*models.User)
-	if err := services.UpdateCucumberCredentials(typedUser.ID, req.CucumberClientID, req.CucumberAccessToken); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+	projects, err := services.UpdateCucumberCredentials(typedUser, req.CucumberClientID, req.CucumberAccessToken,
+		services.NewCredentialVerifier(c.Request.Context(), studioClient))
+	if err != nil {
+		respondCredentialError(c, "Failed to update credentials: ", err)
 		return
 	}
 
 	c.JSON(200, gin.H{
-		"message": "Cucumber credentials updated successfully",
+		"message":               "Cucumber credentials updated successfully",
+		"cucumber_client_id":    models.MaskSecret(typedUser.CucumberClientID),
+		"cucumber_access_token": models.MaskSecret(typedUser.CucumberAccessToken),
+		"projects":              projects,
 	})
 }
+
+// CheckCucumberCredentialsHandler reports whether the stored Cucumber Studio
+// credentials of the user still work. Rejected credentials are a normal answer
+// ({"valid": false}); a Studio outage is an error, since it proves nothing.
+func CheckCucumberCredentialsHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	projects, err := services.VerifyCucumberCredentials(c.Request.Context(), studioClient, typedUser)
+	if errors.Is(err, services.ErrInvalidCucumberCredentials) {
+		c.JSON(200, gin.H{
+			"valid": false,
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondStudioError(c, "Failed to check credentials: ", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"valid":    true,
+		"projects": len(projects),
+	})
+}
+
+// respondCredentialError maps the errors of saving Cucumber Studio credentials
+// to a status code: taken emails and rejected credentials are the client's to fix.
+func respondCredentialError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(409, gin.H{"error": message + err.Error()})
+	case errors.Is(err, services.ErrInvalidCucumberCredentials):
+		c.JSON(422, gin.H{"error": message + err.Error()})
+	case studioErrorStatus(err) != 500:
+		respondStudioError(c, message, err)
+	default:
+		c.JSON(500, gin.H{"error": message + err.Error()})
+	}
+}
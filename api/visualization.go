@@ -1,12 +1,44 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
 	"my-cucumber-backend/models"
 	"my-cucumber-backend/services"
 
 	"github.com/gin-gonic/gin"
 )
 
+// chartPatch holds the fields of a partial chart update; nil fields are left unchanged.
+type chartPatch struct {
+	Name   *string           `json:"name"`
+	Type   *models.ChartType `json:"type"`
+	Config *string           `json:"config"`
+	Query  *string           `json:"query"`
+	TeamID optionalTeamID    `json:"team_id"`
+}
+
+// dataTablePatch holds the fields of a partial data table update; nil fields are left unchanged.
+type dataTablePatch struct {
+	Name    *string        `json:"name"`
+	Columns *string        `json:"columns"`
+	Query   *string        `json:"query"`
+	TeamID  optionalTeamID `json:"team_id"`
+}
+
+// optionalTeamID tells a missing team_id (left unchanged) from null (unshared).
+type optionalTeamID struct {
+	Set   bool
+	Value *int
+}
+
+func (o *optionalTeamID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
 func CreateChartHandler(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -19,15 +51,24 @@ func CreateChartHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid request body"})
 		return
 	}
+	if err := services.ValidateChart(&chart); err != nil {
+		respondValidationError(c, err)
+		return
+	}
 
 	typedUser := user.(*models.User)
 	chart.UserID = typedUser.ID
 	if err := services.CreateChart(&chart); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		respondVisualizationError(c, err)
 		return
 	}
 
-	c.JSON(200, chart)
+	saved, err := services.GetChart(chart.ID, typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
 }
 
 func GetChartsHandler(c *gin.Context) {
@@ -47,6 +88,170 @@ func GetChartsHandler(c *gin.Context) {
 	c.JSON(200, charts)
 }
 
+// GetChartHandler returns one chart of the user.
+func GetChartHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	chartID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chart ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	chart, err := services.GetChart(chartID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, chart)
+}
+
+// UpdateChartHandler replaces a chart (PUT) or changes some of its fields
+// (PATCH), e.g. {"name": "Smoke pass rate"} to rename it.
+func UpdateChartHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	chartID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chart ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	var chart models.Chart
+	if c.Request.Method == "PATCH" {
+		var patch chartPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		current, err := services.GetChart(chartID, typedUser.ID)
+		if err != nil {
+			respondVisualizationError(c, err)
+			return
+		}
+		chart = *current
+		if patch.Name != nil {
+			chart.Name = *patch.Name
+		}
+		if patch.Type != nil {
+			chart.Type = *patch.Type
+		}
+		if patch.Config != nil {
+			chart.Config = *patch.Config
+		}
+		if patch.Query != nil {
+			chart.Query = *patch.Query
+		}
+		if patch.TeamID.Set {
+			chart.TeamID = patch.TeamID.Value
+		}
+	} else if err := c.ShouldBindJSON(&chart); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := services.ValidateChart(&chart); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	chart.ID = chartID
+	chart.UserID = typedUser.ID
+	if err := services.UpdateChart(&chart); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	saved, err := services.GetChart(chartID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+	c.JSON(200, saved)
+}
+
+// DeleteChartHandler deletes a chart of the user.
+func DeleteChartHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	chartID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chart ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.DeleteChart(chartID, typedUser.ID); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// GetChartDataHandler runs the query of a chart against the local cache and
+// stored test runs, and returns labels and series ready to plot.
+func GetChartDataHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	chartID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chart ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	data, err := services.GetChartData(chartID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, data)
+}
+
+// GetDataTableDataHandler runs the query of a data table and returns its rows.
+func GetDataTableDataHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tableID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data table ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	data, err := services.GetTableData(tableID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, data)
+}
+
 func CreateDataTableHandler(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
@@ -59,15 +264,24 @@ func CreateDataTableHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid request body"})
 		return
 	}
+	if err := services.ValidateDataTable(&table); err != nil {
+		respondValidationError(c, err)
+		return
+	}
 
 	typedUser := user.(*models.User)
 	table.UserID = typedUser.ID
 	if err := services.CreateDataTable(&table); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		respondVisualizationError(c, err)
 		return
 	}
 
-	c.JSON(200, table)
+	saved, err := services.GetDataTable(table.ID, typedUser.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
 }
 
 func GetDataTablesHandler(c *gin.Context) {
@@ -87,4 +301,144 @@ func GetDataTablesHandler(c *gin.Context) {
 	c.JSON(200, tables)
 }
 
-// Similar handlers for DataTables...
+// GetDataTableHandler returns one data table of the user.
+func GetDataTableHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tableID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data table ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	table, err := services.GetDataTable(tableID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.JSON(200, table)
+}
+
+// UpdateDataTableHandler replaces a data table (PUT) or changes some of its
+// fields (PATCH).
+func UpdateDataTableHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tableID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data table ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	var table models.DataTable
+	if c.Request.Method == "PATCH" {
+		var patch dataTablePatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		current, err := services.GetDataTable(tableID, typedUser.ID)
+		if err != nil {
+			respondVisualizationError(c, err)
+			return
+		}
+		table = *current
+		if patch.Name != nil {
+			table.Name = *patch.Name
+		}
+		if patch.Columns != nil {
+			table.Columns = *patch.Columns
+		}
+		if patch.Query != nil {
+			table.Query = *patch.Query
+		}
+		if patch.TeamID.Set {
+			table.TeamID = patch.TeamID.Value
+		}
+	} else if err := c.ShouldBindJSON(&table); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := services.ValidateDataTable(&table); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	table.ID = tableID
+	table.UserID = typedUser.ID
+	if err := services.UpdateDataTable(&table); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	saved, err := services.GetDataTable(tableID, typedUser.ID)
+	if err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+	c.JSON(200, saved)
+}
+
+// DeleteDataTableHandler deletes a data table of the user.
+func DeleteDataTableHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tableID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data table ID"})
+		return
+	}
+
+	typedUser := user.(*models.User)
+	if err := services.DeleteDataTable(tableID, typedUser.ID); err != nil {
+		respondVisualizationError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// respondValidationError reports every invalid field, e.g.
+//
+//	{"error": "Validation failed", "fields": [{"field": "query.metric", "message": "value must be one of \"count\", \"pass_rate\""}]}
+func respondValidationError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, gin.H{"error": "Validation failed", "fields": validationErr.Fields})
+		return
+	}
+	c.JSON(400, gin.H{"error": err.Error()})
+}
+
+// respondVisualizationError maps chart, data table and dashboard errors to a status code.
+func respondVisualizationError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		respondValidationError(c, err)
+	case errors.Is(err, services.ErrChartNotFound), errors.Is(err, services.ErrDataTableNotFound),
+		errors.Is(err, services.ErrDashboardNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotVisualizationOwner), errors.Is(err, services.ErrProjectAccessDenied):
+		c.JSON(403, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidChartQuery), errors.Is(err, services.ErrInvalidTableQuery):
+		c.JSON(422, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
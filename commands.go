@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+)
+
+// runCommand runs a command-line tool against the configured database instead of
+// starting the server, and returns the process exit code.
+//
+//	my-cucumber-backend drift -email me@example.com -project 1 ./features
+//	my-cucumber-backend set-role -email admin@example.com -role admin
+func runCommand(args []string) int {
+	switch args[0] {
+	case "drift":
+		return runDriftCommand(args[1:])
+	case "set-role":
+		return runSetRoleCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, available commands: drift, set-role\n", args[0])
+		return 2
+	}
+}
+
+// runDriftCommand prints the drift report of a local directory of .feature files as JSON.
+// It exits with 1 when code and Studio disagree, so it can gate a CI pipeline.
+func runDriftCommand(args []string) int {
+	flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user whose cached scenarios are compared")
+	projectID := flags.Int("project", 0, "Cucumber Studio project ID")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: drift -email EMAIL -project ID DIR")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" || *projectID == 0 || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := services.GetUserByEmail(*email)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	report, err := services.DiffFeatureFiles(os.DirFS(flags.Arg(0)), *projectID, user.ID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if report.HasDrift() {
+		return 1
+	}
+	return 0
+}
+
+// runSetRoleCommand changes the role of a user and enables their account. It is
+// how the first admin of an instance is appointed; admins manage the others
+// through the API.
+func runSetRoleCommand(args []string) int {
+	flags := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user")
+	role := flags.String("role", "", "admin, editor or viewer")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: set-role -email EMAIL -role ROLE")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" || !models.Role(*role).Valid() || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := services.GetUserByEmail(*email)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	newRole, disabled := models.Role(*role), false
+	user, err = services.UpdateUserAccess(user.ID, &newRole, &disabled)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("%s is now %s\n", user.Email, user.Role)
+	return 0
+}
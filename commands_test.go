@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"my-cucumber-backend/models"
+	"my-cucumber-backend/services"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.SetCredentialKeys("test:" + base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatalf("SetCredentialKeys: %v", err)
+	}
+	if err := services.InitializeDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitializeDB: %v", err)
+	}
+	t.Cleanup(services.CloseDB)
+}
+
+func TestSetRoleCommand(t *testing.T) {
+	openTestDB(t)
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		_, err := services.CreateUser(email, "password", "client", "token", func(*models.User) ([]models.Project, error) {
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("CreateUser(%s): %v", email, err)
+		}
+	}
+	user := func(email string) *models.User {
+		t.Helper()
+		user, err := services.GetUserByEmail(email)
+		if err != nil {
+			t.Fatalf("GetUserByEmail: %v", err)
+		}
+		return user
+	}
+
+	// A new instance has no admin; set-role appoints the first one, even if
+	// their account was disabled.
+	if _, err := services.DB.Exec("UPDATE users SET disabled = 1 WHERE email = ?", "first@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand([]string{"set-role", "-email", "first@example.com", "-role", "admin"}); code != 0 {
+		t.Fatalf("set-role exited with %d, want 0", code)
+	}
+	if first := user("first@example.com"); first.Role != models.RoleAdmin || first.Disabled {
+		t.Errorf("got %+v, want an enabled admin", first)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing email", args: []string{"set-role", "-role", "admin"}},
+		{name: "unknown role", args: []string{"set-role", "-email", "second@example.com", "-role", "owner"}},
+		{name: "extra argument", args: []string{"set-role", "-email", "second@example.com", "-role", "viewer", "now"}},
+		{name: "unknown user", args: []string{"set-role", "-email", "nobody@example.com", "-role", "viewer"}},
+		{name: "demote the last admin", args: []string{"set-role", "-email", "first@example.com", "-role", "editor"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runCommand(tt.args); code != 2 {
+				t.Errorf("set-role exited with %d, want 2", code)
+			}
+		})
+	}
+	if first, second := user("first@example.com"), user("second@example.com"); first.Role != models.RoleAdmin || second.Role != models.RoleEditor {
+		t.Errorf("got roles %s and %s after failed commands, want admin and editor", first.Role, second.Role)
+	}
+
+	if code := runCommand([]string{"set-role", "-email", "second@example.com", "-role", "viewer"}); code != 0 {
+		t.Fatalf("set-role exited with %d, want 0", code)
+	}
+	if second := user("second@example.com"); second.Role != models.RoleViewer {
+		t.Errorf("got role %s, want viewer", second.Role)
+	}
+}
@@ -0,0 +1,796 @@
+// Package fakestudio provides an in-memory Cucumber Studio API served over
+// httptest, so the services and api packages can be exercised without network.
+//
+// Typical use:
+//
+//	studio := fakestudio.NewServer()
+//	defer studio.Close()
+//	client, _ := services.NewCucumberClient(services.CucumberClientConfig{BaseURL: studio.URL})
+//	api.SetStudioClient(client)
+package fakestudio
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures/*.json
+var defaultFixtures embed.FS
+
+// DefaultPageSize is used when a request does not send page[size].
+const DefaultPageSize = 25
+
+// Project is a Cucumber Studio project fixture.
+type Project struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Folder is a Cucumber Studio folder fixture.
+type Folder struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+// Tag is a Cucumber Studio tag fixture.
+type Tag struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Scenario is a Cucumber Studio scenario fixture.
+type Scenario struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	FolderID    int       `json:"folder_id"`
+	TagIDs      []string  `json:"tag_ids"`
+	Description string    `json:"description"`
+	Definition  string    `json:"definition"`
+	Datatable   string    `json:"datatable"`
+	Datasets    []Dataset `json:"datasets"`
+}
+
+// Dataset is a Cucumber Studio dataset fixture, one row of a scenario's datatable.
+type Dataset struct {
+	ID   string            `json:"id"`
+	Name string            `json:"name"`
+	Data map[string]string `json:"data"`
+}
+
+// TestRun is a Cucumber Studio test run fixture. Every scenario in the run
+// has a test snapshot holding its latest execution status.
+type TestRun struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Snapshots []TestSnapshot `json:"snapshots"`
+}
+
+// TestSnapshot is the execution of one scenario in a test run.
+type TestSnapshot struct {
+	ID         string       `json:"id"`
+	ScenarioID string       `json:"scenario_id"`
+	Name       string       `json:"name"`
+	Status     string       `json:"status"`
+	Results    []TestResult `json:"results,omitempty"` // Results posted to the snapshot, oldest first
+}
+
+// TestResult is a result posted to a test snapshot.
+type TestResult struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// testResultStatuses are the execution statuses Studio accepts.
+var testResultStatuses = map[string]bool{
+	"passed": true, "failed": true, "retest": true, "undefined": true,
+	"blocked": true, "skipped": true, "wip": true,
+}
+
+// Fixtures is the data served by the fake server. Folders, tags, scenarios
+// and test runs are keyed by project ID.
+type Fixtures struct {
+	Projects  []Project
+	Folders   map[string][]Folder
+	Tags      map[string][]Tag
+	Scenarios map[string][]Scenario
+	TestRuns  map[string][]TestRun
+}
+
+// Credentials are the headers a request must carry to be authorized.
+type Credentials struct {
+	AccessToken string
+	ClientID    string
+	UID         string
+}
+
+// RecordedRequest is a request received by the fake server.
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   []byte
+}
+
+// Server is a fake Cucumber Studio API.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	fixtures    *Fixtures
+	maxPageSize int
+	credentials []Credentials
+	requests    []RecordedRequest
+	failures    []failure
+	nextID      int // For resources created through the API
+}
+
+// failure is a canned error response queued with FailNext.
+type failure struct {
+	status     int
+	retryAfter string
+}
+
+// LoadFixtures reads projects.json, folders.json, tags.json, scenarios.json and
+// test_runs.json from dir in fsys.
+func LoadFixtures(fsys fs.FS, dir string) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	files := []struct {
+		name   string
+		target interface{}
+	}{
+		{"projects.json", &fixtures.Projects},
+		{"folders.json", &fixtures.Folders},
+		{"tags.json", &fixtures.Tags},
+		{"scenarios.json", &fixtures.Scenarios},
+		{"test_runs.json", &fixtures.TestRuns},
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, dir+"/"+file.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture %s: %v", file.name, err)
+		}
+		if err := json.Unmarshal(data, file.target); err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %s: %v", file.name, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// DefaultFixtures returns a fresh copy of the fixtures bundled with this package.
+func DefaultFixtures() *Fixtures {
+	fixtures, err := LoadFixtures(defaultFixtures, "fixtures")
+	if err != nil {
+		panic(err) // The fixtures are embedded; failing here is a programming error.
+	}
+	return fixtures
+}
+
+// NewServer starts a fake server serving the bundled fixtures.
+func NewServer() *Server {
+	return NewServerWithFixtures(DefaultFixtures())
+}
+
+// NewServerWithFixtures starts a fake server serving the given fixtures.
+func NewServerWithFixtures(fixtures *Fixtures) *Server {
+	if fixtures.TestRuns == nil {
+		fixtures.TestRuns = make(map[string][]TestRun)
+	}
+	s := &Server{fixtures: fixtures, nextID: 90000}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// AllowCredentials restricts the server to the given credentials. Until it is
+// called, any request carrying non-empty auth headers is accepted.
+func (s *Server) AllowCredentials(creds ...Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials = append(s.credentials, creds...)
+}
+
+// SetMaxPageSize caps page[size] so that small fixtures still span several pages.
+func (s *Server) SetMaxPageSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPageSize = size
+}
+
+// FailNext makes the next n requests fail with status, optionally sending a
+// Retry-After header, to exercise client retries and error mapping.
+func (s *Server) FailNext(n, status int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, retryAfter: retryAfter})
+	}
+}
+
+// SetFixtures replaces the data served by the server, e.g. to simulate a sync
+// after scenarios changed in Studio.
+func (s *Server) SetFixtures(fixtures *Fixtures) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fixtures.TestRuns == nil {
+		fixtures.TestRuns = make(map[string][]TestRun)
+	}
+	s.fixtures = fixtures
+}
+
+// TestRuns returns a copy of the test runs of a project, including those
+// created and the results posted through the API, so writes can be verified.
+func (s *Server) TestRuns(projectID string) []TestRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]TestRun, 0, len(s.fixtures.TestRuns[projectID]))
+	for _, run := range s.fixtures.TestRuns[projectID] {
+		snapshots := make([]TestSnapshot, len(run.Snapshots))
+		for i, snapshot := range run.Snapshots {
+			snapshot.Results = append([]TestResult(nil), snapshot.Results...)
+			snapshots[i] = snapshot
+		}
+		run.Snapshots = snapshots
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// WriteRequests returns the requests received so far that were not GETs.
+func (s *Server) WriteRequests() []RecordedRequest {
+	var writes []RecordedRequest
+	for _, request := range s.Requests() {
+		if request.Method != http.MethodGet {
+			writes = append(writes, request)
+		}
+	}
+	return writes
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects", s.handleProjects)
+	mux.HandleFunc("GET /projects/{projectID}/folders", s.handleFolders)
+	mux.HandleFunc("GET /projects/{projectID}/tags", s.handleTags)
+	mux.HandleFunc("GET /projects/{projectID}/scenarios", s.handleScenarios)
+	mux.HandleFunc("GET /projects/{projectID}/test_runs", s.handleTestRuns)
+	mux.HandleFunc("POST /projects/{projectID}/test_runs", s.handleCreateTestRun)
+	mux.HandleFunc("GET /projects/{projectID}/test_runs/{testRunID}/test_snapshots", s.handleTestSnapshots)
+	mux.HandleFunc("POST /projects/{projectID}/test_runs/{testRunID}/test_snapshots/{snapshotID}/test_results", s.handleCreateTestResult)
+	return s.record(s.injectFailures(s.authorize(mux)))
+}
+
+func (s *Server) injectFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		var f *failure
+		if len(s.failures) > 0 {
+			f = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		if f != nil {
+			if f.retryAfter != "" {
+				w.Header().Set("Retry-After", f.retryAfter)
+			}
+			writeErrors(w, f.status, http.StatusText(f.status))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, RecordedRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Body:   body,
+		})
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := Credentials{
+			AccessToken: r.Header.Get("access-token"),
+			ClientID:    r.Header.Get("client"),
+			UID:         r.Header.Get("uid"),
+		}
+		if !s.allowed(got) {
+			writeErrors(w, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) allowed(got Credentials) bool {
+	if got.AccessToken == "" || got.ClientID == "" || got.UID == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.credentials) == 0 {
+		return true
+	}
+	for _, creds := range s.credentials {
+		if creds == got {
+			return true
+		}
+	}
+	return false
+}
+
+// project looks up the project named in the request path, writing a 404 if it is unknown.
+func (s *Server) project(w http.ResponseWriter, r *http.Request) (*Fixtures, string, bool) {
+	s.mu.Lock()
+	fixtures := s.fixtures
+	s.mu.Unlock()
+
+	projectID := r.PathValue("projectID")
+	for _, p := range fixtures.Projects {
+		if p.ID == projectID {
+			return fixtures, projectID, true
+		}
+	}
+	writeErrors(w, http.StatusNotFound, "Project not found")
+	return nil, "", false
+}
+
+func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	fixtures := s.fixtures
+	s.mu.Unlock()
+
+	resources := make([]resource, 0, len(fixtures.Projects))
+	for _, p := range fixtures.Projects {
+		resources = append(resources, resource{
+			Type:       "projects",
+			ID:         p.ID,
+			Attributes: map[string]interface{}{"name": p.Name},
+		})
+	}
+	s.writePage(w, r, resources, nil)
+}
+
+func (s *Server) handleFolders(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	folders := fixtures.Folders[projectID]
+	resources := make([]resource, 0, len(folders))
+	for _, f := range folders {
+		var parentID interface{}
+		if f.ParentID != nil {
+			parentID = json.Number(*f.ParentID)
+		}
+		resources = append(resources, resource{
+			Type: "folders",
+			ID:   f.ID,
+			Attributes: map[string]interface{}{
+				"name":      f.Name,
+				"parent-id": parentID,
+			},
+		})
+	}
+	s.writePage(w, r, resources, nil)
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	tags := fixtures.Tags[projectID]
+	resources := make([]resource, 0, len(tags))
+	for _, t := range tags {
+		resources = append(resources, tagResource(t))
+	}
+	s.writePage(w, r, resources, nil)
+}
+
+func (s *Server) handleScenarios(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	tagsByID := make(map[string]Tag)
+	for _, t := range fixtures.Tags[projectID] {
+		tagsByID[t.ID] = t
+	}
+	includes := make(map[string]bool)
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		includes[include] = true
+	}
+	datasetsByID := make(map[string]Dataset)
+
+	scenarios := fixtures.Scenarios[projectID]
+	resources := make([]resource, 0, len(scenarios))
+	for _, sc := range scenarios {
+		tagRefs := make([]resourceRef, 0, len(sc.TagIDs))
+		for _, tagID := range sc.TagIDs {
+			tagRefs = append(tagRefs, resourceRef{Type: "tags", ID: tagID})
+		}
+		datasetRefs := make([]resourceRef, 0, len(sc.Datasets))
+		for _, dataset := range sc.Datasets {
+			datasetRefs = append(datasetRefs, resourceRef{Type: "datasets", ID: dataset.ID})
+			datasetsByID[dataset.ID] = dataset
+		}
+		resources = append(resources, resource{
+			Type: "scenarios",
+			ID:   sc.ID,
+			Attributes: map[string]interface{}{
+				"name":        sc.Name,
+				"folder-id":   sc.FolderID,
+				"description": sc.Description,
+				"definition":  sc.Definition,
+				"datatable":   sc.Datatable,
+			},
+			Relationships: map[string]relationship{
+				"tags":     {Data: tagRefs},
+				"datasets": {Data: datasetRefs},
+			},
+		})
+	}
+
+	// Only the tags and datasets referenced from the returned page are included, like the real API.
+	var includeFor func(page []resource) []resource
+	if includes["tags"] || includes["datasets"] {
+		includeFor = func(page []resource) []resource {
+			seen := make(map[string]bool)
+			var included []resource
+			for _, res := range page {
+				if includes["tags"] {
+					for _, ref := range res.Relationships["tags"].Data {
+						tag, ok := tagsByID[ref.ID]
+						if !ok || seen["tags/"+ref.ID] {
+							continue
+						}
+						seen["tags/"+ref.ID] = true
+						included = append(included, tagResource(tag))
+					}
+				}
+				if includes["datasets"] {
+					for _, ref := range res.Relationships["datasets"].Data {
+						if seen["datasets/"+ref.ID] {
+							continue
+						}
+						seen["datasets/"+ref.ID] = true
+						included = append(included, datasetResource(datasetsByID[ref.ID]))
+					}
+				}
+			}
+			sort.Slice(included, func(i, j int) bool {
+				if included[i].Type != included[j].Type {
+					return included[i].Type > included[j].Type // Tags first
+				}
+				return included[i].ID < included[j].ID
+			})
+			return included
+		}
+	}
+	s.writePage(w, r, resources, includeFor)
+}
+
+func (s *Server) handleTestRuns(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	resources := make([]resource, 0, len(fixtures.TestRuns[projectID]))
+	for _, run := range fixtures.TestRuns[projectID] {
+		resources = append(resources, testRunResource(run))
+	}
+	s.mu.Unlock()
+	s.writePage(w, r, resources, nil)
+}
+
+// handleCreateTestRun creates a test run with a snapshot in status "undefined"
+// for every scenario listed in scenario_ids.
+func (s *Server) handleCreateTestRun(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Data struct {
+			Attributes struct {
+				Name        string   `json:"name"`
+				ScenarioIDs []string `json:"scenario_ids"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrors(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	attributes := body.Data.Attributes
+	if strings.TrimSpace(attributes.Name) == "" {
+		writeErrors(w, http.StatusUnprocessableEntity, "Name can't be blank")
+		return
+	}
+	if len(attributes.ScenarioIDs) == 0 {
+		writeErrors(w, http.StatusUnprocessableEntity, "Scenario ids can't be blank")
+		return
+	}
+
+	scenarios := make(map[string]Scenario)
+	for _, sc := range fixtures.Scenarios[projectID] {
+		scenarios[sc.ID] = sc
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := TestRun{ID: s.newID(), Name: attributes.Name}
+	for _, scenarioID := range attributes.ScenarioIDs {
+		sc, ok := scenarios[scenarioID]
+		if !ok {
+			writeErrors(w, http.StatusUnprocessableEntity, "Unknown scenario "+scenarioID)
+			return
+		}
+		run.Snapshots = append(run.Snapshots, TestSnapshot{ID: s.newID(), ScenarioID: sc.ID, Name: sc.Name, Status: "undefined"})
+	}
+	fixtures.TestRuns[projectID] = append(fixtures.TestRuns[projectID], run)
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": testRunResource(run)})
+}
+
+func (s *Server) handleTestSnapshots(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	run := findTestRun(fixtures, projectID, r.PathValue("testRunID"))
+	if run == nil {
+		s.mu.Unlock()
+		writeErrors(w, http.StatusNotFound, "Test run not found")
+		return
+	}
+	resources := make([]resource, 0, len(run.Snapshots))
+	for _, snapshot := range run.Snapshots {
+		scenarioID, _ := strconv.Atoi(snapshot.ScenarioID)
+		resources = append(resources, resource{
+			Type: "test-snapshots",
+			ID:   snapshot.ID,
+			Attributes: map[string]interface{}{
+				"name":        snapshot.Name,
+				"status":      snapshot.Status,
+				"scenario-id": scenarioID,
+			},
+		})
+	}
+	s.mu.Unlock()
+	s.writePage(w, r, resources, nil)
+}
+
+// handleCreateTestResult records a result on a test snapshot and makes its status the snapshot's status.
+func (s *Server) handleCreateTestResult(w http.ResponseWriter, r *http.Request) {
+	fixtures, projectID, ok := s.project(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Data struct {
+			Type       string     `json:"type"`
+			Attributes TestResult `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrors(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if body.Data.Type != "test-results" {
+		writeErrors(w, http.StatusUnprocessableEntity, "Type must be test-results")
+		return
+	}
+	result := body.Data.Attributes
+	if !testResultStatuses[result.Status] {
+		writeErrors(w, http.StatusUnprocessableEntity, "Unknown status "+strconv.Quote(result.Status))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := findTestRun(fixtures, projectID, r.PathValue("testRunID"))
+	if run == nil {
+		writeErrors(w, http.StatusNotFound, "Test run not found")
+		return
+	}
+	for i := range run.Snapshots {
+		snapshot := &run.Snapshots[i]
+		if snapshot.ID != r.PathValue("snapshotID") {
+			continue
+		}
+		snapshot.Status = result.Status
+		snapshot.Results = append(snapshot.Results, result)
+
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": resource{
+			Type:       "test-results",
+			ID:         s.newID(),
+			Attributes: map[string]interface{}{"status": result.Status, "description": result.Description},
+		}})
+		return
+	}
+	writeErrors(w, http.StatusNotFound, "Test snapshot not found")
+}
+
+// findTestRun returns the stored test run, for modification. The caller holds s.mu.
+func findTestRun(fixtures *Fixtures, projectID, testRunID string) *TestRun {
+	runs := fixtures.TestRuns[projectID]
+	for i := range runs {
+		if runs[i].ID == testRunID {
+			return &runs[i]
+		}
+	}
+	return nil
+}
+
+// newID returns an unused resource ID. The caller holds s.mu.
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func testRunResource(run TestRun) resource {
+	return resource{
+		Type:       "test-runs",
+		ID:         run.ID,
+		Attributes: map[string]interface{}{"name": run.Name},
+	}
+}
+
+func tagResource(t Tag) resource {
+	return resource{
+		Type: "tags",
+		ID:   t.ID,
+		Attributes: map[string]interface{}{
+			"key":   t.Key,
+			"value": t.Value,
+		},
+	}
+}
+
+func datasetResource(d Dataset) resource {
+	data := d.Data
+	if data == nil {
+		data = map[string]string{}
+	}
+	return resource{
+		Type: "datasets",
+		ID:   d.ID,
+		Attributes: map[string]interface{}{
+			"name": d.Name,
+			"data": data,
+		},
+	}
+}
+
+// writePage renders the page of resources selected by page[number] and page[size].
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, all []resource, includeFor func([]resource) []resource) {
+	query := r.URL.Query()
+	pageNumber, err := strconv.Atoi(query.Get("page[number]"))
+	if err != nil || pageNumber < 1 {
+		pageNumber = 1
+	}
+	pageSize, err := strconv.Atoi(query.Get("page[size]"))
+	if err != nil || pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	s.mu.Lock()
+	if s.maxPageSize > 0 && pageSize > s.maxPageSize {
+		pageSize = s.maxPageSize
+	}
+	s.mu.Unlock()
+
+	totalPages := (len(all) + pageSize - 1) / pageSize
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	start := (pageNumber - 1) * pageSize
+	if start > len(all) {
+		start = len(all)
+	}
+	end := start + pageSize
+	if end > len(all) {
+		end = len(all)
+	}
+	page := all[start:end]
+
+	doc := document{
+		Data: page,
+		Meta: map[string]int{
+			"current_page": pageNumber,
+			"total_pages":  totalPages,
+			"total_count":  len(all),
+		},
+		Links: map[string]string{"self": pageLink(r, pageNumber)},
+	}
+	if pageNumber < totalPages {
+		doc.Links["next"] = pageLink(r, pageNumber+1)
+	}
+	if includeFor != nil {
+		doc.Included = includeFor(page)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func pageLink(r *http.Request, pageNumber int) string {
+	query := r.URL.Query()
+	query.Set("page[number]", strconv.Itoa(pageNumber))
+	return "http://" + r.Host + r.URL.Path + "?" + query.Encode()
+}
+
+func writeErrors(w http.ResponseWriter, status int, title string) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"status": strconv.Itoa(status), "title": title}},
+	})
+}
+
+type document struct {
+	Data     []resource        `json:"data"`
+	Included []resource        `json:"included,omitempty"`
+	Links    map[string]string `json:"links"`
+	Meta     map[string]int    `json:"meta"`
+}
+
+type resource struct {
+	Type          string                  `json:"type"`
+	ID            string                  `json:"id"`
+	Attributes    map[string]interface{}  `json:"attributes"`
+	Relationships map[string]relationship `json:"relationships,omitempty"`
+}
+
+type relationship struct {
+	Data []resourceRef `json:"data"`
+}
+
+type resourceRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
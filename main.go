@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"my-cucumber-backend/api"
 	"my-cucumber-backend/middleware"
+	"my-cucumber-backend/models"
 	"my-cucumber-backend/services"
 
 	"github.com/gin-gonic/gin"
@@ -30,11 +34,68 @@ func main() {
 	}
 	defer services.CloseDB()
 
+	// Cucumber Studio credentials are encrypted at rest. Rows written before
+	// encryption, or sealed with a key that has since been rotated out of the
+	// primary slot, are migrated here.
+	if err := services.SetCredentialKeys(os.Getenv("CREDENTIALS_KEYS")); err != nil {
+		log.Fatal("Invalid CREDENTIALS_KEYS: ", err)
+	}
+	migrated, err := services.EncryptStoredCredentials()
+	if err != nil {
+		log.Fatal("Failed to encrypt stored credentials: ", err)
+	}
+	if migrated > 0 {
+		log.Printf("Encrypted the Cucumber Studio credentials of %d user(s) with the primary key", migrated)
+	}
+
+	// Command-line tools, e.g. "drift", run against the database and exit
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		services.CloseDB()
+		os.Exit(code)
+	}
+
+	// Builds without FTS5 answer every full-text search with a 503
+	if !services.SearchAvailable() {
+		log.Printf("Warning: %v", services.ErrSearchUnavailable)
+	}
+
 	secretKey := os.Getenv("SECRET_KEY")
 	if secretKey == "" {
 		log.Fatal("SECRET_KEY environment variable not set")
 	}
 	middleware.SetSecretKey(secretKey)
+	middleware.SetAccessTokenTTL(durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute))
+	services.SetRefreshTokenTTL(durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour))
+	services.SetSessionMaxAge(durationFromEnv("SESSION_MAX_AGE", 90*24*time.Hour))
+
+	// Cucumber Studio client (override the base URL to use a staging instance or a stub)
+	studioClient, err := services.NewCucumberClient(services.CucumberClientConfig{
+		BaseURL:        os.Getenv("CUCUMBER_STUDIO_BASE_URL"),
+		RequestTimeout: durationFromEnv("CUCUMBER_STUDIO_TIMEOUT", 30*time.Second),
+		MaxRetries:     intFromEnv("CUCUMBER_STUDIO_MAX_RETRIES", 3),
+	})
+	if err != nil {
+		log.Fatal("Failed to configure Cucumber Studio client:", err)
+	}
+	api.SetStudioClient(studioClient)
+
+	// Background sync of opted-in users
+	scheduler := services.NewScheduler(studioClient, services.SchedulerConfig{
+		Interval:    durationFromEnv("SYNC_INTERVAL", time.Hour),
+		Jitter:      durationFromEnv("SYNC_JITTER", 5*time.Minute),
+		Concurrency: intFromEnv("SYNC_CONCURRENCY", 4),
+	})
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	scheduler.Start(ctx)
+
+	// Asynchronous refresh jobs
+	syncJobRunner, err := services.NewSyncJobRunner(studioClient, intFromEnv("SYNC_JOB_CONCURRENCY", 2))
+	if err != nil {
+		log.Fatal("Failed to initialize sync jobs:", err)
+	}
+	api.SetSyncJobRunner(syncJobRunner)
 
 	// Initialize Gin router
 	r := gin.Default() // Includes Logger and Recovery middleware
@@ -45,23 +106,78 @@ func main() {
 	// Public routes
 	r.POST("/api/register", api.RegisterHandler)
 	r.POST("/api/login", api.LoginHandler)
+	r.POST("/api/refresh", api.RefreshHandler)
 	r.POST("/api/logout", api.LogoutHandler)
 
-	// Protected routes
+	// Protected routes. Every route declares the permission it needs from the
+	// role of the user; account routes (profile, sessions, credentials) only
+	// need a valid session, so that viewers can still manage their account.
+	canRead := middleware.RequirePermission(models.PermissionRead)
+	canWrite := middleware.RequirePermission(models.PermissionWrite)
+	canAdmin := middleware.RequirePermission(models.PermissionAdmin)
 	protected := r.Group("/api/protected")
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.GET("/data", api.ProtectedHandler)
+		protected.GET("/sessions", api.GetSessionsHandler)
+		protected.DELETE("/sessions", api.RevokeOtherSessionsHandler)
+		protected.DELETE("/sessions/:id", api.RevokeSessionHandler)
 		protected.POST("/refresh-projects", api.RefreshProjectsHandler)
-		protected.GET("/scenarios", api.GetScenariosHandler)
-		protected.POST("/refresh-scenarios", api.RefreshScenariosHandler)
-		protected.GET("/folders", api.GetFoldersHierarchyHandler)
-		protected.POST("/refresh-folders", api.RefreshFoldersHandler)
-		protected.POST("/charts", api.CreateChartHandler)
-		protected.GET("/charts", api.GetChartsHandler)
-		protected.POST("/data-tables", api.CreateDataTableHandler)
-		protected.GET("/data-tables", api.GetDataTablesHandler)
+		protected.GET("/scenarios", canRead, api.GetScenariosHandler)
+		protected.GET("/scenarios/search", canRead, api.SearchScenariosHandler)
+		protected.GET("/scenarios/export", canRead, api.ExportScenariosHandler)
+		protected.GET("/scenarios/flaky", canRead, api.FlakyScenariosHandler)
+		protected.POST("/scenarios/drift", canRead, api.ScenarioDriftHandler)
+		protected.GET("/scenarios/:id", canRead, api.GetScenarioHandler)
+		protected.POST("/refresh-scenarios", canWrite, api.RefreshScenariosHandler)
+		protected.GET("/folders", canRead, api.GetFoldersHierarchyHandler)
+		protected.POST("/refresh-folders", canWrite, api.RefreshFoldersHandler)
+		protected.POST("/charts", canWrite, api.CreateChartHandler)
+		protected.GET("/charts", canRead, api.GetChartsHandler)
+		protected.GET("/charts/:id", canRead, api.GetChartHandler)
+		protected.PUT("/charts/:id", canWrite, api.UpdateChartHandler)
+		protected.PATCH("/charts/:id", canWrite, api.UpdateChartHandler)
+		protected.DELETE("/charts/:id", canWrite, api.DeleteChartHandler)
+		protected.GET("/charts/:id/data", canRead, api.GetChartDataHandler)
+		protected.POST("/data-tables", canWrite, api.CreateDataTableHandler)
+		protected.GET("/data-tables", canRead, api.GetDataTablesHandler)
+		protected.GET("/data-tables/:id", canRead, api.GetDataTableHandler)
+		protected.PUT("/data-tables/:id", canWrite, api.UpdateDataTableHandler)
+		protected.PATCH("/data-tables/:id", canWrite, api.UpdateDataTableHandler)
+		protected.DELETE("/data-tables/:id", canWrite, api.DeleteDataTableHandler)
+		protected.GET("/data-tables/:id/data", canRead, api.GetDataTableDataHandler)
+		protected.POST("/dashboards", canWrite, api.CreateDashboardHandler)
+		protected.GET("/dashboards", canRead, api.GetDashboardsHandler)
+		protected.GET("/dashboards/:id", canRead, api.GetDashboardHandler)
+		protected.PUT("/dashboards/:id", canWrite, api.UpdateDashboardHandler)
+		protected.PATCH("/dashboards/:id", canWrite, api.UpdateDashboardHandler)
+		protected.DELETE("/dashboards/:id", canWrite, api.DeleteDashboardHandler)
+		protected.GET("/dashboards/:id/render", canRead, api.RenderDashboardHandler)
+		protected.POST("/teams", canWrite, api.CreateTeamHandler)
+		protected.GET("/teams", canRead, api.GetTeamsHandler)
+		protected.GET("/teams/:id", canRead, api.GetTeamHandler)
+		protected.DELETE("/teams/:id", canWrite, api.DeleteTeamHandler)
+		protected.POST("/teams/:id/members", canWrite, api.AddTeamMemberHandler)
+		protected.PATCH("/teams/:id/members/:user_id", canWrite, api.UpdateTeamMemberHandler)
+		protected.DELETE("/teams/:id/members/:user_id", canRead, api.RemoveTeamMemberHandler) // Members may leave; removing others needs a team admin
 		protected.PUT("/update-cucumber-credentials", api.UpdateCucumberCredentialsHandler)
+		protected.GET("/check-cucumber-credentials", api.CheckCucumberCredentialsHandler)
+		protected.GET("/sync-settings", canRead, api.GetSyncSettingsHandler)
+		protected.PUT("/sync-settings", canWrite, api.UpdateSyncSettingsHandler)
+		protected.POST("/sync-jobs", canWrite, api.CreateSyncJobHandler)
+		protected.GET("/sync-jobs", canRead, api.GetSyncJobsHandler)
+		protected.GET("/sync-jobs/:id", canRead, api.GetSyncJobHandler)
+		protected.GET("/sync-jobs/:id/events", canRead, api.StreamSyncJobHandler)
+		protected.POST("/test-runs", canWrite, api.CreateTestRunHandler)
+		protected.GET("/test-runs", canRead, api.GetTestRunsHandler)
+		protected.GET("/test-runs/:id", canRead, api.GetTestRunHandler)
+		protected.POST("/test-runs/:id/push", canWrite, api.PushTestRunHandler)
+
+		// Administration of the instance
+		protected.GET("/admin/users", canAdmin, api.GetUsersHandler)
+		protected.PATCH("/admin/users/:id", canAdmin, api.UpdateUserAccessHandler)
+		protected.POST("/admin/users/:id/resync", canAdmin, api.ResyncUserHandler)
+		protected.GET("/admin/users/:id/sync-jobs", canAdmin, api.GetUserSyncJobsHandler)
 	}
 
 	port := os.Getenv("PORT")
@@ -71,3 +187,31 @@ func main() {
 	log.Printf("Server listening on :%s", port)
 	r.Run(":" + port)
 }
+
+// durationFromEnv parses a time.Duration (e.g. "30s") from an environment variable.
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s: %v", name, value, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+// intFromEnv parses an integer from an environment variable.
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d: %v", name, value, fallback, err)
+		return fallback
+	}
+	return n
+}
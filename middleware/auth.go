@@ -2,7 +2,12 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
 	"strings"
+	"time"
 
 	"my-cucumber-backend/models"
 	"my-cucumber-backend/services"
@@ -17,12 +22,69 @@ const UserContextKey contextKey = "user" // Exported for use in api package.
 
 var SecretKey string // Exported variable for the secret key
 
+// accessTokenTTL is how long an access token is accepted. Sessions outlive it
+// through refresh tokens.
+var accessTokenTTL = 15 * time.Minute
+
+// SessionContextKey holds the ID of the session of the request.
+const SessionContextKey = "session_id"
+
 // SetSecretKey sets the secret key for JWT verification.
 func SetSecretKey(key string) {
 	SecretKey = key
 }
 
-// AuthMiddleware checks for a valid JWT.
+// SetAccessTokenTTL sets how long access tokens are accepted.
+func SetAccessTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		accessTokenTTL = ttl
+	}
+}
+
+// IssueAccessToken signs a short-lived access token for a session of a user.
+func IssueAccessToken(userID int, sessionID string) (string, time.Duration, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"sid":     sessionID,
+		"jti":     hex.EncodeToString(jti),
+		"iat":     now.Unix(),
+		"exp":     now.Add(accessTokenTTL).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SecretKey))
+	if err != nil {
+		return "", 0, err
+	}
+	return token, accessTokenTTL, nil
+}
+
+// ParseAccessToken verifies an access token and returns its user and session.
+// Tokens issued before sessions existed have no session and are rejected.
+func ParseAccessToken(tokenString string) (int, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(SecretKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", errors.New("invalid token claims")
+	}
+	userID, ok := claims["user_id"].(float64)
+	sessionID, hasSession := claims["sid"].(string)
+	if !ok || !hasSession || sessionID == "" {
+		return 0, "", errors.New("invalid token claims")
+	}
+	return int(userID), sessionID, nil
+}
+
+// AuthMiddleware checks for a valid access token of a live session.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -32,29 +94,65 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(SecretKey), nil
-		})
-
-		if err != nil || !token.Valid {
+		userID, sessionID, err := ParseAccessToken(tokenString)
+		if err != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token"})
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token claims"})
+		if err := services.CheckSession(sessionID, userID); err != nil {
+			switch {
+			case errors.Is(err, services.ErrSessionRevoked), errors.Is(err, services.ErrSessionExpired),
+				errors.Is(err, services.ErrSessionNotFound):
+				c.AbortWithStatusJSON(401, gin.H{"error": "Session " + strings.TrimPrefix(err.Error(), "session ")})
+			default:
+				c.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+			}
 			return
 		}
 
-		userID := int(claims["user_id"].(float64))
 		user, err := services.GetUserByID(userID)
-		if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
 			c.AbortWithStatusJSON(401, gin.H{"error": "User not found"})
 			return
 		}
+		if err != nil {
+			log.Printf("Failed to load user %d of session %s: %v", userID, sessionID, err)
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to load user"})
+			return
+		}
+		if user.Disabled {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Account disabled"})
+			return
+		}
 
 		c.Set("user", user)
+		c.Set(SessionContextKey, sessionID)
+		c.Next()
+	}
+}
+
+// RequirePermission rejects requests of users whose role lacks a permission.
+// It runs after AuthMiddleware, which loads the user on every request, so a
+// role change applies at once.
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		typedUser := user.(*models.User)
+		if !typedUser.Role.Can(permission) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error":               "Your role does not allow this action",
+				"role":                typedUser.Role,
+				"required_permission": permission,
+			})
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -70,7 +168,8 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Refresh-Added, X-Refresh-Updated, X-Refresh-Removed, X-Refresh-Unchanged")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
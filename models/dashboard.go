@@ -0,0 +1,59 @@
+package models
+
+// WidgetKind is the kind of record a dashboard widget shows.
+type WidgetKind string
+
+const (
+	WidgetChart     WidgetKind = "chart"
+	WidgetDataTable WidgetKind = "data_table"
+)
+
+// DashboardGridColumns is the width of the dashboard grid.
+const DashboardGridColumns = 12
+
+// Dashboard arranges charts and data tables of a user on a grid.
+type Dashboard struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Layout    []DashboardWidget `json:"layout"`
+	Filters   DashboardFilters  `json:"filters"`
+	UserID    int               `json:"user_id"`
+	CreatedAt string            `json:"created_at"`
+	UpdatedAt string            `json:"updated_at"`
+}
+
+// DashboardWidget places a chart or data table on the grid. X and W are in grid
+// columns, Y and H in rows.
+type DashboardWidget struct {
+	Kind WidgetKind `json:"kind"`
+	ID   int        `json:"id"` // Chart or data table ID
+	X    int        `json:"x"`
+	Y    int        `json:"y"`
+	W    int        `json:"w"`
+	H    int        `json:"h"`
+}
+
+// DashboardFilters apply to the query of every widget: ProjectID replaces the
+// widget's project, and TagExpr is combined with the widget's own with "and".
+type DashboardFilters struct {
+	ProjectID *int   `json:"project_id,omitempty"`
+	TagExpr   string `json:"tag_expr,omitempty"`
+}
+
+// DashboardRender holds the data of every widget of a dashboard, in layout order.
+type DashboardRender struct {
+	DashboardID int              `json:"dashboard_id"`
+	Name        string           `json:"name"`
+	Filters     DashboardFilters `json:"filters"`
+	Widgets     []RenderedWidget `json:"widgets"`
+}
+
+// RenderedWidget is a widget with its data, or the reason it could not be
+// rendered (e.g. the chart was deleted since).
+type RenderedWidget struct {
+	DashboardWidget
+	Name  string     `json:"name,omitempty"`
+	Chart *ChartData `json:"chart,omitempty"`
+	Table *TableData `json:"table,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
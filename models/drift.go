@@ -0,0 +1,45 @@
+package models
+
+// DriftReport compares the .feature files of a code base with the scenarios cached from Cucumber Studio.
+type DriftReport struct {
+	OnlyInStudio  []DriftScenario     `json:"only_in_studio"`
+	OnlyInCode    []DriftScenario     `json:"only_in_code"`
+	TagMismatches []TagDrift          `json:"tag_mismatches"`
+	InSync        int                 `json:"in_sync"` // Scenarios in both with the same tags
+	ParseErrors   []FeatureParseError `json:"parse_errors"`
+}
+
+// HasDrift reports whether code and Studio disagree in any way.
+func (r *DriftReport) HasDrift() bool {
+	return len(r.OnlyInStudio) > 0 || len(r.OnlyInCode) > 0 || len(r.TagMismatches) > 0 || len(r.ParseErrors) > 0
+}
+
+// DriftScenario identifies a scenario on either side. ScenarioID is set for
+// Studio scenarios, File and Line for scenarios found in code.
+type DriftScenario struct {
+	ScenarioID string   `json:"scenario_id,omitempty"`
+	Name       string   `json:"name"`
+	FolderPath string   `json:"folder_path"`
+	File       string   `json:"file,omitempty"`
+	Line       int      `json:"line,omitempty"`
+	Tags       []string `json:"tags"`
+}
+
+// TagDrift is a scenario present on both sides whose tags differ.
+type TagDrift struct {
+	ScenarioID      string   `json:"scenario_id"`
+	Name            string   `json:"name"`
+	FolderPath      string   `json:"folder_path"`
+	File            string   `json:"file"`
+	Line            int      `json:"line"`
+	StudioTags      []string `json:"studio_tags"`
+	CodeTags        []string `json:"code_tags"`
+	MissingInCode   []string `json:"missing_in_code"`
+	MissingInStudio []string `json:"missing_in_studio"`
+}
+
+// FeatureParseError is a .feature file that could not be parsed.
+type FeatureParseError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// FlakyScenario scores how unreliable a scenario has been over recent test runs.
+// Each component is between 0 and 1, and so is the Score that weighs them.
+type FlakyScenario struct {
+	Scenario      Scenario   `json:"scenario"`
+	Score         float64    `json:"score"`
+	FlipRate      float64    `json:"flip_rate"`       // Share of consecutive runs whose outcome flipped between passed and failed
+	RetryPassRate float64    `json:"retry_pass_rate"` // Share of runs that only passed after a retry
+	DurationCV    float64    `json:"duration_cv"`     // Coefficient of variation of passing durations, capped at 1
+	Executions    int        `json:"executions"`      // Runs in which the scenario passed or failed
+	Runs          []FlakyRun `json:"runs"`            // The runs behind the score, oldest first
+}
+
+// FlakyRun is the outcome of a scenario in one test run. Scenario outlines and
+// retries can execute a scenario several times per run; Status is failed if
+// any final attempt failed.
+type FlakyRun struct {
+	RunID        int        `json:"run_id"`
+	RunName      string     `json:"run_name"`
+	CreatedAt    string     `json:"created_at"`
+	Status       TestStatus `json:"status"`
+	Attempts     int        `json:"attempts"`
+	PassedRetry  bool       `json:"passed_on_retry"`
+	DurationMS   int64      `json:"duration_ms"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+}
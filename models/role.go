@@ -0,0 +1,41 @@
+package models
+
+// Role decides what a user may do: on the whole instance as User.Role, and
+// within a team as TeamMember.Role.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"  // Everything an editor can, plus administering users (or the team)
+	RoleEditor Role = "editor" // Refresh caches, upload test runs, create and share charts, tables and dashboards
+	RoleViewer Role = "viewer" // Read only
+)
+
+// Valid reports whether r is one of the Role constants.
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleEditor || r == RoleViewer
+}
+
+// Permission is what a route or an action requires of the role of a user.
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	PermissionAdmin Permission = "admin"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:  {PermissionRead, PermissionWrite, PermissionAdmin},
+	RoleEditor: {PermissionRead, PermissionWrite},
+	RoleViewer: {PermissionRead},
+}
+
+// Can reports whether the role grants a permission.
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -8,10 +8,45 @@ type Tag struct {
 }
 
 // Scenario represents a simplified scenario with ID, Name, FolderID, ProjectID, and Tags.
+// Details are only loaded for single-scenario lookups and refreshes.
 type Scenario struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	FolderID  int    `json:"folder_id"`
-	ProjectID int    `json:"project_id"`
-	Tags      []Tag  `json:"tags"`
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	FolderID  int              `json:"folder_id"`
+	ProjectID int              `json:"project_id"`
+	Tags      []Tag            `json:"tags"`
+	StepCount int              `json:"step_count"`
+	Details   *ScenarioDetails `json:"details,omitempty"`
+}
+
+// ScenarioDetails is the content of a scenario as written in Cucumber Studio.
+type ScenarioDetails struct {
+	Description string     `json:"description"`
+	Definition  string     `json:"definition"` // Gherkin source of the scenario
+	Datatable   [][]string `json:"datatable"`  // Examples table; the first row holds the parameter names
+	Steps       []Step     `json:"steps"`
+	Datasets    []Dataset  `json:"datasets"`
+}
+
+// Step is one step of a scenario definition.
+type Step struct {
+	Keyword    string          `json:"keyword"`     // Given, When, Then, And, But or *
+	Text       string          `json:"text"`        // Step text as written, with parameter values
+	ActionWord string          `json:"action_word"` // Text with each quoted parameter value replaced by its name
+	Parameters []StepParameter `json:"parameters"`
+	DataTable  [][]string      `json:"data_table,omitempty"`
+	DocString  string          `json:"doc_string,omitempty"`
+}
+
+// StepParameter is a quoted value passed to an action word, named p1, p2, ... in order.
+type StepParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Dataset is a named set of parameter values a scenario runs with.
+type Dataset struct {
+	ID   string            `json:"id"`
+	Name string            `json:"name"`
+	Data map[string]string `json:"data"`
 }
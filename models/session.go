@@ -0,0 +1,28 @@
+package models
+
+// Session is a login of a user on one device. Access tokens carry the session
+// ID, so revoking the session locks the device out once its refresh token is
+// used or its access token checked.
+type Session struct {
+	ID                string  `json:"id"`
+	UserID            int     `json:"user_id"`
+	UserAgent         string  `json:"user_agent"`
+	IP                string  `json:"ip"`
+	CreatedAt         string  `json:"created_at"`
+	LastUsedAt        string  `json:"last_used_at"`        // Last login or refresh
+	ExpiresAt         string  `json:"expires_at"`          // When the refresh token stops working unless used
+	AbsoluteExpiresAt string  `json:"absolute_expires_at"` // When the session ends however often it is refreshed
+	RevokedAt         *string `json:"revoked_at,omitempty"`
+	Current           bool    `json:"current"` // The session of the request listing sessions
+}
+
+// TokenPair is issued at login and on every refresh. The refresh token is only
+// ever returned here; the server keeps a hash of it.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	Token        string `json:"token"` // Same as AccessToken, for clients of the single-token login
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+	RefreshToken string `json:"refresh_token"`
+	SessionID    string `json:"session_id"`
+}
@@ -0,0 +1,74 @@
+package models
+
+// RefreshSummary counts what a refresh changed in the local cache.
+type RefreshSummary struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Add accumulates another summary into s.
+func (s *RefreshSummary) Add(other RefreshSummary) {
+	s.Added += other.Added
+	s.Updated += other.Updated
+	s.Removed += other.Removed
+	s.Unchanged += other.Unchanged
+}
+
+// ProjectSync records the outcome of the latest sync of one project for one user.
+type ProjectSync struct {
+	ProjectID     int            `json:"project_id"`
+	LastAttemptAt *string        `json:"last_attempt_at"`
+	LastSyncedAt  *string        `json:"last_synced_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	Summary       RefreshSummary `json:"summary"`
+}
+
+// SyncJobKind is what a sync job refreshes.
+type SyncJobKind string
+
+const (
+	SyncJobScenarios SyncJobKind = "scenarios" // Scenarios of one project
+	SyncJobFolders   SyncJobKind = "folders"   // Folders of one project
+	SyncJobAll       SyncJobKind = "all"       // Scenarios of every project of the user
+)
+
+// SyncJobState is the lifecycle state of a sync job.
+type SyncJobState string
+
+const (
+	SyncJobQueued    SyncJobState = "queued"
+	SyncJobRunning   SyncJobState = "running"
+	SyncJobSucceeded SyncJobState = "succeeded"
+	SyncJobPartial   SyncJobState = "partially_failed" // Some projects of a SyncJobAll job failed
+	SyncJobFailed    SyncJobState = "failed"
+)
+
+// ProjectFailure is a project a sync job could not refresh, and why.
+type ProjectFailure struct {
+	ProjectID int    `json:"project_id"`
+	Error     string `json:"error"`
+}
+
+// SyncJob is an asynchronous refresh requested by a user.
+type SyncJob struct {
+	ID             int              `json:"id"`
+	UserID         int              `json:"user_id"`
+	Kind           SyncJobKind      `json:"kind"`
+	ProjectID      *int             `json:"project_id"`
+	State          SyncJobState     `json:"state"`
+	ProjectsTotal  int              `json:"projects_total"`
+	ProjectsDone   int              `json:"projects_done"`
+	Summary        RefreshSummary   `json:"summary"`
+	Error          string           `json:"error,omitempty"`
+	FailedProjects []ProjectFailure `json:"failed_projects"`
+	CreatedAt      string           `json:"created_at"`
+	StartedAt      *string          `json:"started_at"`
+	FinishedAt     *string          `json:"finished_at"`
+}
+
+// Finished reports whether the job reached a terminal state.
+func (j *SyncJob) Finished() bool {
+	return j.State == SyncJobSucceeded || j.State == SyncJobPartial || j.State == SyncJobFailed
+}
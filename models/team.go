@@ -0,0 +1,21 @@
+package models
+
+// Team is a group of users who share charts and data tables. Project caches
+// are shared by everyone whose Studio credentials see the project, in a team
+// or not; a team decides who sees a shared chart or data table.
+type Team struct {
+	ID        int          `json:"id"`
+	Name      string       `json:"name"`
+	CreatedBy int          `json:"created_by"` // Always an admin of the team
+	CreatedAt string       `json:"created_at"`
+	Role      Role         `json:"role"` // Role of the requesting user in the team
+	Members   []TeamMember `json:"members,omitempty"`
+}
+
+// TeamMember is a user of a team.
+type TeamMember struct {
+	UserID  int    `json:"user_id"`
+	Email   string `json:"email"`
+	Role    Role   `json:"role"` // Admins manage the team, editors share with it, viewers only see what is shared
+	AddedAt string `json:"added_at"`
+}
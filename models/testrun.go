@@ -0,0 +1,103 @@
+package models
+
+// TestStatus is the outcome of one executed scenario.
+type TestStatus string
+
+const (
+	TestPassed  TestStatus = "passed"
+	TestFailed  TestStatus = "failed"
+	TestSkipped TestStatus = "skipped"
+	TestPending TestStatus = "pending" // Pending or undefined steps
+)
+
+// TestRunSummary counts the results of a test run by status.
+type TestRunSummary struct {
+	Total     int `json:"total"`
+	Passed    int `json:"passed"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Pending   int `json:"pending"`
+	Unmatched int `json:"unmatched"` // Results not linked to a cached scenario
+	Retried   int `json:"retried"`   // Failed attempts that were run again, not counted in Total
+}
+
+// Add counts one result into s.
+func (s *TestRunSummary) Add(result TestResult) {
+	if result.Retried {
+		s.Retried++
+		return
+	}
+	s.Total++
+	switch result.Status {
+	case TestPassed:
+		s.Passed++
+	case TestFailed:
+		s.Failed++
+	case TestSkipped:
+		s.Skipped++
+	default:
+		s.Pending++
+	}
+	if result.ScenarioID == nil {
+		s.Unmatched++
+	}
+}
+
+// TestRun is a test report uploaded from CI.
+type TestRun struct {
+	ID         int            `json:"id"`
+	UserID     int            `json:"user_id"`
+	ProjectID  int            `json:"project_id"`
+	Name       string         `json:"name"`
+	Format     string         `json:"format"`
+	DurationMS int64          `json:"duration_ms"`
+	Summary    TestRunSummary `json:"summary"`
+	CreatedAt  string         `json:"created_at"`
+	Results    []TestResult   `json:"results,omitempty"`
+
+	StudioTestRunID *string `json:"studio_test_run_id"` // Cucumber Studio test run the results were pushed to
+	PushedAt        *string `json:"pushed_at"`
+}
+
+// TestResult is the outcome of one scenario execution. Scenario outlines produce
+// one result per example row, and a scenario retried by the test runner one
+// result per attempt.
+type TestResult struct {
+	ID           int        `json:"id"`
+	RunID        int        `json:"run_id"`
+	ScenarioID   *string    `json:"scenario_id"` // Matched cached scenario, nil if none matched
+	Name         string     `json:"name"`
+	Feature      string     `json:"feature"`
+	URI          string     `json:"uri"`
+	Line         int        `json:"line"`
+	Tags         []string   `json:"tags"` // Without the leading @
+	Status       TestStatus `json:"status"`
+	DurationMS   int64      `json:"duration_ms"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	Attempt      int        `json:"attempt"` // 0 for the first attempt
+	Retried      bool       `json:"retried"` // The attempt failed and the scenario was run again
+}
+
+// StudioPush describes the changes a push of a test run makes to Cucumber
+// Studio, or would make in a dry run.
+type StudioPush struct {
+	DryRun            bool                    `json:"dry_run"`
+	TestRunID         int                     `json:"test_run_id"`
+	StudioTestRunID   string                  `json:"studio_test_run_id"` // Empty in a dry run that would create the Studio test run
+	StudioTestRunName string                  `json:"studio_test_run_name"`
+	CreatedTestRun    bool                    `json:"created_test_run"` // The Studio test run is (or would be) created by this push
+	Updates           []StudioExecutionUpdate `json:"updates"`
+	Unchanged         int                     `json:"unchanged"` // Executions already in the pushed status
+	Missing           []string                `json:"missing"`   // Scenario IDs not executed by the existing Studio test run
+	Unmatched         int                     `json:"unmatched"` // Results not linked to a cached scenario, never pushed
+}
+
+// StudioExecutionUpdate is a change of status of one scenario execution in a Studio test run.
+type StudioExecutionUpdate struct {
+	SnapshotID  string `json:"snapshot_id,omitempty"` // Empty when the test run does not exist yet
+	ScenarioID  string `json:"scenario_id"`
+	Name        string `json:"name"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Description string `json:"description,omitempty"`
+}
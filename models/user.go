@@ -1,12 +1,44 @@
 package models
 
+import (
+	"encoding/json"
+)
+
+// User is an account. Its Cucumber Studio credentials are decrypted in memory
+// and masked whenever the user is serialized.
 type User struct {
-	ID                  int    `json:"id"`
-	Email               string `json:"email"`
-	PasswordHash        string `json:"-"`
-	CucumberClientID    string `json:"cucumber_client_id"`
-	CucumberAccessToken string `json:"cucumber_access_token"`
-	Projects            string `json:"projects"` // Store as JSON string
+	ID                  int     `json:"id"`
+	Email               string  `json:"email"`
+	PasswordHash        string  `json:"-"`
+	CucumberClientID    string  `json:"cucumber_client_id"`
+	CucumberAccessToken string  `json:"cucumber_access_token"`
+	Projects            string  `json:"projects"`       // Store as JSON string
+	AutoSync            bool    `json:"auto_sync"`      // Opted in to background sync
+	LastSyncedAt        *string `json:"last_synced_at"` // Last completed background sync, if any
+	Role                Role    `json:"role"`
+	Disabled            bool    `json:"disabled"` // Disabled accounts cannot log in
+}
+
+// MarshalJSON masks the Cucumber Studio credentials.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User // Without the MarshalJSON method
+	masked := user(u)
+	masked.CucumberClientID = MaskSecret(u.CucumberClientID)
+	masked.CucumberAccessToken = MaskSecret(u.CucumberAccessToken)
+	return json.Marshal(masked)
+}
+
+// MaskSecret hides all but the last four characters of a secret, and all of a
+// short one, without revealing its length.
+func MaskSecret(value string) string {
+	runes := []rune(value)
+	switch {
+	case len(runes) == 0:
+		return ""
+	case len(runes) <= 8:
+		return "********"
+	}
+	return "****" + string(runes[len(runes)-4:])
 }
 
 // Project represents a simplified project with just ID and Name.
@@ -9,6 +9,11 @@ const (
 	ChartTypeLine ChartType = "line"
 )
 
+// Valid reports whether t is one of the ChartType constants.
+func (t ChartType) Valid() bool {
+	return t == ChartTypePie || t == ChartTypeBar || t == ChartTypeLine
+}
+
 // Chart represents a chart configuration saved by a user
 type Chart struct {
 	ID        int       `json:"id"`
@@ -17,6 +22,7 @@ type Chart struct {
 	Config    string    `json:"config"` // JSON string storing chart-specific configuration
 	Query     string    `json:"query"`  // Query parameters used to get the data
 	UserID    int       `json:"user_id"`
+	TeamID    *int      `json:"team_id"` // Team the chart is shared with, if any
 	CreatedAt string    `json:"created_at"`
 	UpdatedAt string    `json:"updated_at"`
 }
@@ -28,6 +34,117 @@ type DataTable struct {
 	Columns   string `json:"columns"` // JSON string storing column configurations
 	Query     string `json:"query"`   // Query parameters used to get the data
 	UserID    int    `json:"user_id"`
+	TeamID    *int   `json:"team_id"` // Team the data table is shared with, if any
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
 }
+
+// ChartDataset is the set of rows a chart query aggregates.
+type ChartDataset string
+
+const (
+	ChartDatasetScenarios   ChartDataset = "scenarios"    // Cached scenarios
+	ChartDatasetTestResults ChartDataset = "test_results" // Final attempts of stored test results
+)
+
+// ChartGroupField is what rows are grouped by.
+type ChartGroupField string
+
+const (
+	ChartGroupByTag    ChartGroupField = "tag"    // Value of the tag with TagKey, or every tag when TagKey is empty
+	ChartGroupByFolder ChartGroupField = "folder" // Folder path
+	ChartGroupByStatus ChartGroupField = "status" // Result status; the latest one for scenarios, "not_run" if none
+	ChartGroupByRun    ChartGroupField = "run"    // Test run, test_results only
+	ChartGroupByDay    ChartGroupField = "day"    // Day of the test run, test_results only
+)
+
+// ChartMetric is the value computed for every group.
+type ChartMetric string
+
+const (
+	ChartMetricCount    ChartMetric = "count"     // Number of rows
+	ChartMetricPassRate ChartMetric = "pass_rate" // Percentage of rows that passed, among those that ran
+)
+
+// ChartGroupBy selects the grouping of a chart query.
+type ChartGroupBy struct {
+	Field  ChartGroupField `json:"field"`
+	TagKey string          `json:"tag_key,omitempty"` // For ChartGroupByTag, e.g. "priority"
+}
+
+// ChartFilters restricts the rows of a chart query. Scenario filters also apply
+// to test results, through the scenario each result matched.
+type ChartFilters struct {
+	FolderID  *int         `json:"folder_id,omitempty"`
+	Recursive bool         `json:"recursive,omitempty"` // Include subfolders of FolderID
+	Tags      []string     `json:"tags,omitempty"`      // "key:value" pairs that must all match
+	TagExpr   string       `json:"tag_expr,omitempty"`  // Cucumber tag expression
+	Keyword   string       `json:"keyword,omitempty"`   // Substring of the scenario name
+	Statuses  []TestStatus `json:"statuses,omitempty"`  // Result statuses to keep
+	Runs      int          `json:"runs,omitempty"`      // Latest test runs to include, test_results only
+}
+
+// ChartQuery is the schema of Chart.Query, e.g.
+//
+//	{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag", "tag_key": "priority"}, "metric": "count"}
+type ChartQuery struct {
+	Dataset   ChartDataset  `json:"dataset"`
+	ProjectID int           `json:"project_id"`
+	Filters   ChartFilters  `json:"filters"`
+	GroupBy   ChartGroupBy  `json:"group_by"`
+	SplitBy   *ChartGroupBy `json:"split_by,omitempty"` // One series per value; not for pie charts
+	Metric    ChartMetric   `json:"metric"`
+}
+
+// ChartData is the result of a chart query. Labels are the categories of a pie
+// or bar chart, or the x axis of a line chart; every series has one value per label.
+type ChartData struct {
+	ChartID int           `json:"chart_id"`
+	Type    ChartType     `json:"type"`
+	Metric  ChartMetric   `json:"metric"`
+	Labels  []string      `json:"labels"`
+	Series  []ChartSeries `json:"series"`
+	Rows    int           `json:"rows"` // Rows aggregated
+}
+
+// ChartSeries is one series of chart values.
+type ChartSeries struct {
+	Name string    `json:"name"`
+	Data []float64 `json:"data"`
+}
+
+// TableColumn is one column of a data table, the schema of DataTable.Columns
+// being a list of them. Which keys are available depends on the dataset:
+// id, name, folder, tags, status and step_count for scenarios; run, day,
+// scenario_id, name, feature, status, duration_ms, error_message and attempt
+// for test results.
+type TableColumn struct {
+	Key    string `json:"key"`
+	Label  string `json:"label,omitempty"` // Defaults to the key
+	Width  int    `json:"width,omitempty"` // In pixels
+	Hidden bool   `json:"hidden,omitempty"`
+}
+
+// TableSort orders the rows of a data table.
+type TableSort struct {
+	Column     string `json:"column"` // Column key
+	Descending bool   `json:"descending,omitempty"`
+}
+
+// TableQuery is the schema of DataTable.Query. Filters are the same as for charts.
+type TableQuery struct {
+	Dataset   ChartDataset `json:"dataset"`
+	ProjectID int          `json:"project_id"`
+	Filters   ChartFilters `json:"filters"`
+	Sort      *TableSort   `json:"sort,omitempty"`
+	Limit     int          `json:"limit,omitempty"`
+}
+
+// TableData is the result of a data table query: one record per row, keyed by
+// column key. Total counts the matching rows before Limit applies.
+type TableData struct {
+	TableID int                      `json:"table_id"`
+	Columns []TableColumn            `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+	Total   int                      `json:"total"`
+}
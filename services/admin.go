@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrLastAdmin is returned when a change would leave the instance without an
+// enabled admin, who alone can undo it.
+var ErrLastAdmin = errors.New("the instance needs at least one enabled admin")
+
+// ListUsers retrieves every account, for administrators.
+func ListUsers() ([]models.User, error) {
+	rows, err := DB.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %v", err)
+		}
+		users = append(users, *user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return users, nil
+}
+
+// UpdateUserAccess changes the role of a user and disables or enables their
+// account; nil leaves a field as it is. Disabling an account also revokes its
+// sessions, so its access tokens stop working at once.
+func UpdateUserAccess(userID int, role *models.Role, disabled *bool) (*models.User, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE users SET role = COALESCE(?, role), disabled = COALESCE(?, disabled) WHERE id = ?",
+		role, disabled, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %v", err)
+	}
+	if err := requireAffected(result, ErrUserNotFound); err != nil {
+		return nil, err
+	}
+	if err := checkAdminLeft(tx); err != nil {
+		return nil, err
+	}
+	if disabled != nil && *disabled {
+		if _, err := tx.Exec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID); err != nil {
+			return nil, fmt.Errorf("failed to revoke sessions: %v", err)
+		}
+	}
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return user, nil
+}
+
+// checkAdminLeft returns ErrLastAdmin when no enabled admin is left.
+func checkAdminLeft(db dbExecutor) error {
+	var left bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE role = ? AND disabled = 0)", models.RoleAdmin).Scan(&left)
+	if err != nil {
+		return fmt.Errorf("failed to query admins: %v", err)
+	}
+	if !left {
+		return ErrLastAdmin
+	}
+	return nil
+}
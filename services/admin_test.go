@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestUpdateUserAccessKeepsAnEnabledAdmin(t *testing.T) {
+	openTestDB(t)
+	first := createTestUser(t, "first@example.com", "c1", "t1")
+	second := createTestUser(t, "second@example.com", "c2", "t2")
+	admin, editor, viewer := models.RoleAdmin, models.RoleEditor, models.RoleViewer
+	yes := true
+
+	// A new instance has no admin until one is appointed.
+	user, err := UpdateUserAccess(first.ID, &admin, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	if user.Role != models.RoleAdmin || user.Disabled {
+		t.Errorf("got %+v, want an enabled admin", user)
+	}
+
+	tests := []struct {
+		name     string
+		role     *models.Role
+		disabled *bool
+	}{
+		{name: "demote the last admin", role: &viewer},
+		{name: "disable the last admin", disabled: &yes},
+		{name: "demote and disable", role: &editor, disabled: &yes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UpdateUserAccess(first.ID, tt.role, tt.disabled); !errors.Is(err, ErrLastAdmin) {
+				t.Errorf("got error %v, want ErrLastAdmin", err)
+			}
+			// The change is rolled back.
+			if got, err := GetUserByID(first.ID); err != nil || got.Role != models.RoleAdmin || got.Disabled {
+				t.Errorf("got %+v, %v; want the admin unchanged", got, err)
+			}
+		})
+	}
+
+	// With another admin, the first one can step down.
+	if _, err := UpdateUserAccess(second.ID, &admin, nil); err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	if user, err := UpdateUserAccess(first.ID, &viewer, nil); err != nil || user.Role != models.RoleViewer {
+		t.Errorf("got %+v, %v; want a viewer", user, err)
+	}
+	if _, err := UpdateUserAccess(first.ID+second.ID, &viewer, nil); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("got error %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestDisablingAUserRevokesTheirSessions(t *testing.T) {
+	openTestDB(t)
+	admin := createTestUser(t, "admin@example.com", "c1", "t1")
+	user := createTestUser(t, "qa@example.com", "c2", "t2")
+	adminRole, yes, no := models.RoleAdmin, true, false
+	if _, err := UpdateUserAccess(admin.ID, &adminRole, nil); err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	newSession := func(userID int) string {
+		t.Helper()
+		session, _, err := CreateSession(userID, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		return session.ID
+	}
+	first, second, adminSession := newSession(user.ID), newSession(user.ID), newSession(admin.ID)
+
+	// A role change alone keeps the sessions.
+	viewer := models.RoleViewer
+	if _, err := UpdateUserAccess(user.ID, &viewer, &no); err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	if err := CheckSession(first, user.ID); err != nil {
+		t.Errorf("got error %v after a role change, want the session kept", err)
+	}
+
+	disabled, err := UpdateUserAccess(user.ID, nil, &yes)
+	if err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	if !disabled.Disabled || disabled.Role != models.RoleViewer {
+		t.Errorf("got %+v, want a disabled viewer", disabled)
+	}
+	for _, id := range []string{first, second} {
+		if err := CheckSession(id, user.ID); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("got error %v, want ErrSessionRevoked", err)
+		}
+	}
+	if err := CheckSession(adminSession, admin.ID); err != nil {
+		t.Errorf("got error %v for another user's session", err)
+	}
+
+	// Enabling the account again does not bring the sessions back.
+	if _, err := UpdateUserAccess(user.ID, nil, &no); err != nil {
+		t.Fatalf("UpdateUserAccess: %v", err)
+	}
+	if err := CheckSession(first, user.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("got error %v after enabling, want ErrSessionRevoked", err)
+	}
+}
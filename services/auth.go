@@ -11,8 +11,50 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// CreateUser creates a new user.
-func CreateUser(email, password, clientID, accessToken string) (*models.User, error) {
+// userColumns is the column list scanned by scanUser.
+const userColumns = "id, email, password_hash, cucumber_client_id, cucumber_access_token, projects, auto_sync, last_synced_at, role, disabled"
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a user selected with userColumns.
+func scanUser(row rowScanner) (*models.User, error) {
+	user := &models.User{}
+	var clientID, accessToken, projects, lastSyncedAt sql.NullString
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &clientID, &accessToken, &projects, &user.AutoSync, &lastSyncedAt, &user.Role, &user.Disabled)
+	if err != nil {
+		return nil, err
+	}
+	if user.CucumberClientID, err = decryptCredential("cucumber_client_id", clientID.String); err != nil {
+		return nil, err
+	}
+	if user.CucumberAccessToken, err = decryptCredential("cucumber_access_token", accessToken.String); err != nil {
+		return nil, err
+	}
+	user.Projects = projects.String
+	if lastSyncedAt.Valid {
+		user.LastSyncedAt = &lastSyncedAt.String
+	}
+	return user, nil
+}
+
+var (
+	// ErrEmailTaken is returned when registering an email that already has an account.
+	ErrEmailTaken = errors.New("email is already registered")
+	// ErrUserNotFound is returned when looking up a user who does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserDisabled is returned when a disabled account logs in or is synced.
+	ErrUserDisabled = errors.New("account is disabled")
+)
+
+// CreateUser creates a new user. The Cucumber Studio credentials are checked
+// with verify once the email is known to be free, and the projects it returns
+// are stored with the user. If verify fails, nothing is saved. verify runs
+// before the user is inserted, so a slow Studio does not hold the database
+// write lock; a registration of the same email in the meantime fails the insert.
+func CreateUser(email, password, clientID, accessToken string, verify CredentialVerifier) (*models.User, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
@@ -24,13 +66,41 @@ func CreateUser(email, password, clientID, accessToken string) (*models.User, er
 		CucumberClientID:    clientID,
 		CucumberAccessToken: accessToken,
 		Projects:            "[]", // Initialize with an empty JSON array
+		Role:                models.RoleEditor,
 	}
 
+	encryptedClientID, encryptedAccessToken, err := encryptCredentials(clientID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail fast on a taken email, before calling Studio
+	var taken bool
+	if err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)", email).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("failed to query user: %v", err)
+	}
+	if taken {
+		return nil, ErrEmailTaken
+	}
+
+	projects, err := verify(user)
+	if err != nil {
+		return nil, err
+	}
+	projectsJSON, err := json.Marshal(projects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal projects to JSON: %v", err)
+	}
+	user.Projects = string(projectsJSON)
+
 	// Insert the user into the database
 	result, err := DB.Exec(
-		"INSERT INTO users (email, password_hash, cucumber_client_id, cucumber_access_token, projects) VALUES (?, ?, ?, ?, ?)",
-		user.Email, user.PasswordHash, user.CucumberClientID, user.CucumberAccessToken, user.Projects,
+		"INSERT INTO users (email, password_hash, cucumber_client_id, cucumber_access_token, projects, role) VALUES (?, ?, ?, ?, ?, ?)",
+		user.Email, user.PasswordHash, encryptedClientID, encryptedAccessToken, user.Projects, user.Role,
 	)
+	if isUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert user: %v", err)
 	}
@@ -41,21 +111,16 @@ func CreateUser(email, password, clientID, accessToken string) (*models.User, er
 		return nil, fmt.Errorf("failed to get last inserted ID: %v", err)
 	}
 	user.ID = int(userID)
-
 	return user, nil
 }
 
-// AuthenticateUser checks the provided email and password.
+// AuthenticateUser checks the provided email and password. Disabled accounts
+// get ErrUserDisabled, once the password is known to be right.
 func AuthenticateUser(email, password string) (*models.User, error) {
-	user := &models.User{}
-	row := DB.QueryRow(
-		"SELECT id, email, password_hash, cucumber_client_id, cucumber_access_token, projects FROM users WHERE email = ?",
-		email,
-	)
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CucumberClientID, &user.CucumberAccessToken, &user.Projects)
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to query user: %v", err)
 	}
@@ -64,21 +129,19 @@ func AuthenticateUser(email, password string) (*models.User, error) {
 	if err != nil {
 		return nil, errors.New("invalid credentials")
 	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
 
 	return user, nil
 }
 
 // GetUserByID retrieves a user by their ID.
 func GetUserByID(userID int) (*models.User, error) {
-	user := &models.User{}
-	row := DB.QueryRow(
-		"SELECT id, email, password_hash, cucumber_client_id, cucumber_access_token, projects FROM users WHERE id = ?",
-		userID,
-	)
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CucumberClientID, &user.CucumberAccessToken, &user.Projects)
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to query user: %v", err)
 	}
@@ -104,14 +167,94 @@ func UpdateUserProjects(user *models.User, projects []models.Project) error {
 	return nil
 }
 
-// UpdateCucumberCredentials updates a user's Cucumber Studio credentials
-func UpdateCucumberCredentials(userID int, clientID, accessToken string) error {
-	_, err := DB.Exec(
-		"UPDATE users SET cucumber_client_id = ?, cucumber_access_token = ? WHERE id = ?",
-		clientID, accessToken, userID,
+// UpdateCucumberCredentials replaces a user's Cucumber Studio credentials once
+// verify accepts them, and stores the projects they give access to. Rejected
+// credentials are not saved.
+func UpdateCucumberCredentials(user *models.User, clientID, accessToken string, verify CredentialVerifier) ([]models.Project, error) {
+	candidate := *user
+	candidate.CucumberClientID = clientID
+	candidate.CucumberAccessToken = accessToken
+	projects, err := verify(&candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedClientID, encryptedAccessToken, err := encryptCredentials(clientID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	projectsJSON, err := json.Marshal(projects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal projects to JSON: %v", err)
+	}
+	_, err = DB.Exec(
+		"UPDATE users SET cucumber_client_id = ?, cucumber_access_token = ?, projects = ? WHERE id = ?",
+		encryptedClientID, encryptedAccessToken, string(projectsJSON), user.ID,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to update cucumber credentials: %v", err)
+		return nil, fmt.Errorf("failed to update cucumber credentials: %v", err)
+	}
+
+	user.CucumberClientID = clientID
+	user.CucumberAccessToken = accessToken
+	user.Projects = string(projectsJSON)
+	return projects, nil
+}
+
+// encryptCredentials seals a pair of Cucumber Studio credentials for storage.
+func encryptCredentials(clientID, accessToken string) (string, string, error) {
+	encryptedClientID, err := encryptCredential("cucumber_client_id", clientID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt cucumber credentials: %v", err)
+	}
+	encryptedAccessToken, err := encryptCredential("cucumber_access_token", accessToken)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt cucumber credentials: %v", err)
+	}
+	return encryptedClientID, encryptedAccessToken, nil
+}
+
+// SetAutoSync opts a user in to or out of background sync.
+func SetAutoSync(userID int, enabled bool) error {
+	_, err := DB.Exec("UPDATE users SET auto_sync = ? WHERE id = ?", enabled, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update auto sync setting: %v", err)
 	}
 	return nil
 }
+
+// GetAutoSyncUsers returns every enabled user who opted in to background sync and has Cucumber Studio credentials.
+func GetAutoSyncUsers() ([]*models.User, error) {
+	rows, err := DB.Query(
+		"SELECT " + userColumns + " FROM users WHERE auto_sync = 1 AND disabled = 0 AND cucumber_client_id <> '' AND cucumber_access_token <> ''",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query auto sync users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %v", err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return users, nil
+}
+
+// GetUserByEmail retrieves a user by their email address.
+func GetUserByEmail(email string) (*models.User, error) {
+	user, err := scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to query user: %v", err)
+	}
+	return user, nil
+}
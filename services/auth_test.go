@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestCreateUserVerifiesBeforeInserting(t *testing.T) {
+	openTestDB(t)
+
+	// Another registration of the same email completes while Studio verifies
+	// the first one, which must then lose the race cleanly.
+	_, err := CreateUser("qa@example.com", "password", "c1", "t1", func(*models.User) ([]models.Project, error) {
+		createTestUser(t, "qa@example.com", "c2", "t2")
+		return []models.Project{{ID: "1", Name: "Shop"}}, nil
+	})
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("got error %v, want ErrEmailTaken", err)
+	}
+
+	users, err := ListUsers()
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].CucumberClientID != "c2" {
+		t.Errorf("got users %+v, want only the one registered during verification", users)
+	}
+}
+
+func TestCreateUserDoesNotVerifyTakenEmails(t *testing.T) {
+	openTestDB(t)
+	createTestUser(t, "qa@example.com", "c1", "t1")
+
+	verified := false
+	_, err := CreateUser("qa@example.com", "password", "c2", "t2", func(*models.User) ([]models.Project, error) {
+		verified = true
+		return nil, nil
+	})
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("got error %v, want ErrEmailTaken", err)
+	}
+	if verified {
+		t.Error("verified the Studio credentials of a taken email")
+	}
+}
+
+func TestCreateUserSavesNothingWhenVerificationFails(t *testing.T) {
+	openTestDB(t)
+
+	rejected := &StudioError{Kind: ErrStudioUnauthorized, StatusCode: 401}
+	_, err := CreateUser("qa@example.com", "password", "c1", "t1", func(*models.User) ([]models.Project, error) {
+		return nil, rejected
+	})
+	if !errors.Is(err, ErrStudioUnauthorized) {
+		t.Fatalf("got error %v, want ErrStudioUnauthorized", err)
+	}
+	if users, err := ListUsers(); err != nil || len(users) != 0 {
+		t.Errorf("got users %+v (%v), want none", users, err)
+	}
+
+	// The email is still free.
+	user := createTestUser(t, "qa@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"})
+	if user.Projects != `[{"id":"1","name":"Shop"}]` {
+		t.Errorf("stored projects %s", user.Projects)
+	}
+}
@@ -0,0 +1,463 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrInvalidChartQuery is wrapped by errors caused by a malformed chart query.
+var ErrInvalidChartQuery = errors.New("invalid chart query")
+
+// DefaultChartRuns is how many recent test runs a test_results query covers by default.
+const DefaultChartRuns = 20
+
+// Labels of rows without a value for the grouping.
+const (
+	chartNoValue = "(none)"
+	chartNotRun  = "not_run"
+)
+
+// chartStatusOrder orders status labels from best to worst.
+var chartStatusOrder = map[string]int{
+	string(models.TestPassed):  0,
+	string(models.TestFailed):  1,
+	string(models.TestPending): 2,
+	string(models.TestSkipped): 3,
+	chartNotRun:                4,
+}
+
+// datasetRow is one row of a chart or data table dataset: a scenario, or the
+// final attempt of a test result.
+type datasetRow struct {
+	scenario *models.Scenario  // Nil for results that matched no cached scenario
+	status   models.TestStatus // Empty for scenarios that never ran
+	runID    int
+	runName  string
+	day      string
+	result   *models.TestResult // Test results only
+}
+
+// chartBucket accumulates the rows of one label and series.
+type chartBucket struct {
+	count, ran, passed int
+}
+
+// ParseChartQuery decodes and checks the query of a chart of the given type.
+func ParseChartQuery(chartType models.ChartType, raw string) (*models.ChartQuery, error) {
+	var q models.ChartQuery
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&q); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChartQuery, err)
+	}
+
+	switch q.Dataset {
+	case models.ChartDatasetScenarios, models.ChartDatasetTestResults:
+	default:
+		return nil, fmt.Errorf("%w: dataset must be scenarios or test_results", ErrInvalidChartQuery)
+	}
+	if q.ProjectID <= 0 {
+		return nil, fmt.Errorf("%w: project_id is required", ErrInvalidChartQuery)
+	}
+	switch q.Metric {
+	case "":
+		q.Metric = models.ChartMetricCount
+	case models.ChartMetricCount, models.ChartMetricPassRate:
+	default:
+		return nil, fmt.Errorf("%w: metric must be count or pass_rate", ErrInvalidChartQuery)
+	}
+	if err := checkChartGroupBy(q.Dataset, q.GroupBy, "group_by"); err != nil {
+		return nil, err
+	}
+	if q.SplitBy != nil {
+		if chartType == models.ChartTypePie {
+			return nil, fmt.Errorf("%w: pie charts have a single series, split_by is not supported", ErrInvalidChartQuery)
+		}
+		if err := checkChartGroupBy(q.Dataset, *q.SplitBy, "split_by"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, status := range q.Filters.Statuses {
+		if _, ok := statusSeverity[status]; !ok {
+			return nil, fmt.Errorf("%w: unknown status %q in filters.statuses", ErrInvalidChartQuery, status)
+		}
+	}
+	if q.Filters.TagExpr != "" {
+		if _, err := ParseTagExpr(q.Filters.TagExpr); err != nil {
+			return nil, fmt.Errorf("%w: filters.tag_expr: %v", ErrInvalidChartQuery, err)
+		}
+	}
+	if q.Filters.Runs < 0 || q.Filters.Runs > MaxFlakyWindow {
+		return nil, fmt.Errorf("%w: filters.runs must be between 0 (the default) and %d", ErrInvalidChartQuery, MaxFlakyWindow)
+	}
+	if q.Dataset == models.ChartDatasetTestResults && q.Filters.Runs == 0 {
+		q.Filters.Runs = DefaultChartRuns
+	}
+	return &q, nil
+}
+
+func checkChartGroupBy(dataset models.ChartDataset, groupBy models.ChartGroupBy, field string) error {
+	switch groupBy.Field {
+	case models.ChartGroupByTag, models.ChartGroupByFolder, models.ChartGroupByStatus:
+	case models.ChartGroupByRun, models.ChartGroupByDay:
+		if dataset != models.ChartDatasetTestResults {
+			return fmt.Errorf("%w: %s.field %s requires the test_results dataset", ErrInvalidChartQuery, field, groupBy.Field)
+		}
+	default:
+		return fmt.Errorf("%w: %s.field must be tag, folder, status, run or day", ErrInvalidChartQuery, field)
+	}
+	if groupBy.TagKey != "" && groupBy.Field != models.ChartGroupByTag {
+		return fmt.Errorf("%w: %s.tag_key only applies to tag grouping", ErrInvalidChartQuery, field)
+	}
+	return nil
+}
+
+// GetChartData runs the query of a saved chart.
+func GetChartData(chartID, userID int) (*models.ChartData, error) {
+	chart, err := GetChart(chartID, userID)
+	if err != nil {
+		return nil, err
+	}
+	q, err := ParseChartQuery(chart.Type, chart.Query)
+	if err != nil {
+		return nil, err
+	}
+	return runSavedChart(chart, q, userID)
+}
+
+// runSavedChart runs the query of a saved chart for a viewer. Test runs are
+// private to whoever uploaded them, so a chart shared with a team shows the
+// test runs of its owner, to members who can also see its project.
+func runSavedChart(chart *models.Chart, q *models.ChartQuery, viewerID int) (*models.ChartData, error) {
+	if err := CheckProjectAccess(viewerID, q.ProjectID); err != nil {
+		return nil, err
+	}
+	data, err := RunChartQuery(q, chart.Type, chart.UserID)
+	if err != nil {
+		return nil, err
+	}
+	data.ChartID = chart.ID
+	return data, nil
+}
+
+// RunChartQuery aggregates the rows of a chart query against the local cache
+// and stored test runs. Labels follow the order of the grouping: chronological
+// for runs and days, best to worst for statuses, alphabetical otherwise.
+func RunChartQuery(q *models.ChartQuery, chartType models.ChartType, userID int) (*models.ChartData, error) {
+	rows, err := queryDatasetRows(q.Dataset, q.ProjectID, userID, q.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths map[string]string
+	if q.GroupBy.Field == models.ChartGroupByFolder || (q.SplitBy != nil && q.SplitBy.Field == models.ChartGroupByFolder) {
+		if paths, err = folderPaths(q.ProjectID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	splitBy := models.ChartGroupBy{}
+	if q.SplitBy != nil {
+		splitBy = *q.SplitBy
+	}
+	buckets := make(map[string]map[string]*chartBucket) // By label, then series
+	runOrder := make(map[string]int)                    // Run labels to run IDs
+	data := &models.ChartData{Type: chartType, Metric: q.Metric}
+	data.Rows = len(rows)
+	for _, row := range rows {
+		series := []string{""}
+		if q.SplitBy != nil {
+			series = chartLabels(row, splitBy, paths)
+		}
+		for _, label := range chartLabels(row, q.GroupBy, paths) {
+			runOrder[label] = row.runID
+			for _, name := range series {
+				runOrder[name] = row.runID
+				if buckets[label] == nil {
+					buckets[label] = make(map[string]*chartBucket)
+				}
+				bucket := buckets[label][name]
+				if bucket == nil {
+					bucket = &chartBucket{}
+					buckets[label][name] = bucket
+				}
+				bucket.count++
+				if row.status != "" {
+					bucket.ran++
+				}
+				if row.status == models.TestPassed {
+					bucket.passed++
+				}
+			}
+		}
+	}
+
+	data.Labels = make([]string, 0, len(buckets))
+	seriesNames := make(map[string]bool)
+	for label, bySeries := range buckets {
+		data.Labels = append(data.Labels, label)
+		for name := range bySeries {
+			seriesNames[name] = true
+		}
+	}
+	sortChartLabels(data.Labels, q.GroupBy.Field, runOrder)
+	names := make([]string, 0, len(seriesNames))
+	for name := range seriesNames {
+		names = append(names, name)
+	}
+	sortChartLabels(names, splitBy.Field, runOrder)
+	if q.SplitBy == nil {
+		names = []string{""}
+	}
+
+	data.Series = make([]models.ChartSeries, 0, len(names))
+	for _, name := range names {
+		series := models.ChartSeries{Name: name, Data: make([]float64, len(data.Labels))}
+		if q.SplitBy == nil {
+			series.Name = string(q.Metric)
+		}
+		for i, label := range data.Labels {
+			bucket := buckets[label][name]
+			if bucket == nil {
+				continue
+			}
+			if q.Metric == models.ChartMetricPassRate {
+				if bucket.ran > 0 {
+					series.Data[i] = math.Round(float64(bucket.passed)*1000/float64(bucket.ran)) / 10
+				}
+			} else {
+				series.Data[i] = float64(bucket.count)
+			}
+		}
+		data.Series = append(data.Series, series)
+	}
+	return data, nil
+}
+
+// queryDatasetRows returns the rows of a dataset that match filters.
+func queryDatasetRows(dataset models.ChartDataset, projectID, userID int, filters models.ChartFilters) ([]datasetRow, error) {
+	if err := CheckProjectAccess(userID, projectID); err != nil {
+		return nil, err
+	}
+	sq := ScenarioQuery{
+		ProjectID: projectID,
+		UserID:    userID,
+		FolderID:  filters.FolderID,
+		Recursive: filters.Recursive,
+		Tags:      filters.Tags,
+		Keyword:   filters.Keyword,
+	}
+	if filters.TagExpr != "" {
+		expr, err := ParseTagExpr(filters.TagExpr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: filters.tag_expr: %v", ErrInvalidChartQuery, err)
+		}
+		sq.TagExpr = expr
+	}
+
+	var rows []datasetRow
+	var err error
+	if dataset == models.ChartDatasetTestResults {
+		rows, err = testResultRows(sq, filters.Runs)
+	} else {
+		rows, err = scenarioRows(sq)
+	}
+	if err != nil || len(filters.Statuses) == 0 {
+		return rows, err
+	}
+
+	statuses := make(map[models.TestStatus]bool, len(filters.Statuses))
+	for _, status := range filters.Statuses {
+		statuses[status] = true
+	}
+	kept := rows[:0]
+	for _, row := range rows {
+		if statuses[row.status] {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+// scenarioRows returns the matching scenarios with the status of their latest execution.
+func scenarioRows(sq ScenarioQuery) ([]datasetRow, error) {
+	where, args := sq.where()
+	scenarios, err := queryScenarios(DB, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := latestScenarioStatuses(sq.ProjectID, sq.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]datasetRow, len(scenarios))
+	for i := range scenarios {
+		rows[i] = datasetRow{scenario: &scenarios[i], status: latest[scenarios[i].ID]}
+	}
+	return rows, nil
+}
+
+// latestScenarioStatuses returns the status of every scenario of a project in
+// the latest test run that executed it.
+func latestScenarioStatuses(projectID, userID int) (map[string]models.TestStatus, error) {
+	rows, err := DB.Query(
+		`SELECT r.run_id, r.scenario_id, r.status
+         FROM test_results r JOIN test_runs tr ON tr.id = r.run_id
+         WHERE tr.project_id = ? AND tr.user_id = ? AND r.scenario_id IS NOT NULL AND r.retried = 0
+         ORDER BY r.run_id, r.position`,
+		projectID, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test results: %v", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]models.TestStatus)
+	runs := make(map[string]int)
+	for rows.Next() {
+		var runID int
+		var scenarioID string
+		var status models.TestStatus
+		if err := rows.Scan(&runID, &scenarioID, &status); err != nil {
+			return nil, fmt.Errorf("failed to scan test result: %v", err)
+		}
+		if runs[scenarioID] == runID && statusSeverity[status] <= statusSeverity[statuses[scenarioID]] {
+			continue
+		}
+		runs[scenarioID] = runID
+		statuses[scenarioID] = status
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return statuses, nil
+}
+
+// testResultRows returns the final attempts of the results of the last runs
+// test runs. With scenario filters, only results of matching scenarios are kept.
+func testResultRows(sq ScenarioQuery, runs int) ([]datasetRow, error) {
+	filtered := sq.FolderID != nil || len(sq.Tags) > 0 || sq.TagExpr != nil || sq.Keyword != ""
+	where, args := sq.where()
+	if !filtered {
+		where, args = "s.project_id = ?", []interface{}{sq.ProjectID}
+	}
+	scenarios, err := queryScenarios(DB, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Scenario, len(scenarios))
+	for i := range scenarios {
+		byID[scenarios[i].ID] = &scenarios[i]
+	}
+
+	rows, err := DB.Query(
+		`SELECT tr.id, tr.name, date(tr.created_at), r.id, r.scenario_id, r.name, r.feature, r.status,
+             r.duration_ms, r.error_message, r.attempt
+         FROM test_results r JOIN test_runs tr ON tr.id = r.run_id
+         WHERE r.run_id IN (SELECT id FROM test_runs WHERE project_id = ? AND user_id = ? ORDER BY id DESC LIMIT ?)
+             AND r.retried = 0
+         ORDER BY tr.id, r.position`,
+		sq.ProjectID, sq.UserID, runs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test results: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]datasetRow, 0)
+	for rows.Next() {
+		row := datasetRow{result: &models.TestResult{}}
+		var scenarioID sql.NullString
+		err := rows.Scan(&row.runID, &row.runName, &row.day, &row.result.ID, &scenarioID, &row.result.Name, &row.result.Feature,
+			&row.status, &row.result.DurationMS, &row.result.ErrorMessage, &row.result.Attempt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan test result: %v", err)
+		}
+		row.result.RunID = row.runID
+		row.result.Status = row.status
+		if scenarioID.Valid {
+			row.result.ScenarioID = &scenarioID.String
+			row.scenario = byID[scenarioID.String]
+		}
+		if filtered && row.scenario == nil {
+			continue
+		}
+		row.runName = strings.TrimSpace(fmt.Sprintf("#%d %s", row.runID, row.runName)) // Run names need not be unique
+		results = append(results, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return results, nil
+}
+
+// chartLabels returns the labels a row counts towards. A scenario counts once
+// per tag when grouping by tag without a key.
+func chartLabels(row datasetRow, groupBy models.ChartGroupBy, paths map[string]string) []string {
+	switch groupBy.Field {
+	case models.ChartGroupByStatus:
+		if row.status == "" {
+			return []string{chartNotRun}
+		}
+		return []string{string(row.status)}
+	case models.ChartGroupByRun:
+		return []string{row.runName}
+	case models.ChartGroupByDay:
+		return []string{row.day}
+	}
+
+	if row.scenario == nil {
+		return []string{chartNoValue}
+	}
+	if groupBy.Field == models.ChartGroupByFolder {
+		if folderPath, ok := paths[strconv.Itoa(row.scenario.FolderID)]; ok {
+			return []string{folderPath}
+		}
+		return []string{unfiledFeature}
+	}
+
+	labels := make([]string, 0, len(row.scenario.Tags))
+	for _, tag := range row.scenario.Tags {
+		switch {
+		case groupBy.TagKey == "":
+			labels = append(labels, strings.TrimPrefix(gherkinTag(tag), "@"))
+		case tag.Key == groupBy.TagKey && tag.Value != "":
+			labels = append(labels, tag.Value)
+		case tag.Key == groupBy.TagKey:
+			labels = append(labels, tag.Key)
+		}
+	}
+	if len(labels) == 0 {
+		return []string{chartNoValue}
+	}
+	return sortedUnique(labels)
+}
+
+// sortChartLabels orders labels for display; rows without a value come last.
+func sortChartLabels(labels []string, field models.ChartGroupField, runOrder map[string]int) {
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if (a == chartNoValue) != (b == chartNoValue) {
+			return b == chartNoValue
+		}
+		switch field {
+		case models.ChartGroupByRun:
+			if runOrder[a] != runOrder[b] {
+				return runOrder[a] < runOrder[b]
+			}
+		case models.ChartGroupByStatus:
+			return chartStatusOrder[a] < chartStatusOrder[b]
+		}
+		return a < b
+	})
+}
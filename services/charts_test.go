@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+func TestParseChartQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		chartType models.ChartType
+		raw       string
+		wantErr   bool
+		wantRuns  int
+	}{
+		{name: "scenarios", chartType: models.ChartTypePie, raw: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`},
+		{name: "default runs", chartType: models.ChartTypeLine, raw: `{"dataset": "test_results", "project_id": 1, "group_by": {"field": "run"}}`, wantRuns: DefaultChartRuns},
+		{name: "explicit runs", chartType: models.ChartTypeLine, raw: `{"dataset": "test_results", "project_id": 1, "group_by": {"field": "day"}, "filters": {"runs": 5}}`, wantRuns: 5},
+		{name: "negative runs", chartType: models.ChartTypeLine, raw: `{"dataset": "test_results", "project_id": 1, "group_by": {"field": "run"}, "filters": {"runs": -1}}`, wantErr: true},
+		{name: "too many runs", chartType: models.ChartTypeLine, raw: fmt.Sprintf(`{"dataset": "test_results", "project_id": 1, "group_by": {"field": "run"}, "filters": {"runs": %d}}`, MaxFlakyWindow+1), wantErr: true},
+		{name: "run grouping of scenarios", chartType: models.ChartTypeBar, raw: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "run"}}`, wantErr: true},
+		{name: "split pie chart", chartType: models.ChartTypePie, raw: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}, "split_by": {"field": "status"}}`, wantErr: true},
+		{name: "tag key without tag grouping", chartType: models.ChartTypeBar, raw: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "folder", "tag_key": "priority"}}`, wantErr: true},
+		{name: "unknown field", chartType: models.ChartTypeBar, raw: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}, "colour": "red"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseChartQuery(tt.chartType, tt.raw)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidChartQuery) {
+					t.Errorf("got error %v, want ErrInvalidChartQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseChartQuery: %v", err)
+			}
+			if q.Metric != models.ChartMetricCount || q.Filters.Runs != tt.wantRuns {
+				t.Errorf("got metric %q and %d runs, want count and %d", q.Metric, q.Filters.Runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestRunChartQuery(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+	if _, err := RefreshFolders(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshFolders: %v", err)
+	}
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	_, err := CreateTestRun(user.ID, 1, "Nightly", "junit-xml", []models.TestResult{
+		{Name: "Login with valid credentials", Status: models.TestPassed},
+		{Name: "Login with a wrong password", Status: models.TestFailed},
+		{Name: "Add an item to the cart", Status: models.TestPassed},
+	})
+	if err != nil {
+		t.Fatalf("CreateTestRun: %v", err)
+	}
+
+	status := models.ChartGroupBy{Field: models.ChartGroupByStatus}
+	tests := []struct {
+		name      string
+		query     models.ChartQuery
+		wantRows  int
+		wantLabel []string
+		want      []models.ChartSeries
+	}{
+		{
+			name:      "tag values, unset last",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: models.ChartGroupBy{Field: models.ChartGroupByTag, TagKey: "priority"}, Metric: models.ChartMetricCount},
+			wantRows:  7,
+			wantLabel: []string{"high", "low", "(none)"},
+			want:      []models.ChartSeries{{Name: "count", Data: []float64{3, 1, 3}}},
+		},
+		{
+			name:      "every tag",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: models.ChartGroupBy{Field: models.ChartGroupByTag}, Metric: models.ChartMetricCount, Filters: models.ChartFilters{TagExpr: "@smoke"}},
+			wantRows:  3,
+			wantLabel: []string{"priority:high", "smoke"},
+			want:      []models.ChartSeries{{Name: "count", Data: []float64{2, 3}}},
+		},
+		{
+			name:      "statuses from best to worst",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: status, Metric: models.ChartMetricCount},
+			wantRows:  7,
+			wantLabel: []string{"passed", "failed", "not_run"},
+			want:      []models.ChartSeries{{Name: "count", Data: []float64{2, 1, 4}}},
+		},
+		{
+			name:      "folders split by status",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: models.ChartGroupBy{Field: models.ChartGroupByFolder}, SplitBy: &status, Metric: models.ChartMetricCount},
+			wantRows:  7,
+			wantLabel: []string{"Web Shop/Authentication", "Web Shop/Checkout", "Web Shop/Checkout/Payments"},
+			want: []models.ChartSeries{
+				{Name: "passed", Data: []float64{1, 1, 0}},
+				{Name: "failed", Data: []float64{1, 0, 0}},
+				{Name: "not_run", Data: []float64{1, 1, 2}},
+			},
+		},
+		{
+			// Scenarios that never ran do not lower the pass rate.
+			name:      "pass rate by folder",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: models.ChartGroupBy{Field: models.ChartGroupByFolder}, Metric: models.ChartMetricPassRate},
+			wantRows:  7,
+			wantLabel: []string{"Web Shop/Authentication", "Web Shop/Checkout", "Web Shop/Checkout/Payments"},
+			want:      []models.ChartSeries{{Name: "pass_rate", Data: []float64{50, 100, 0}}},
+		},
+		{
+			name:      "pass rate is rounded to one decimal",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetTestResults, GroupBy: models.ChartGroupBy{Field: models.ChartGroupByRun}, Metric: models.ChartMetricPassRate, Filters: models.ChartFilters{Runs: 1}},
+			wantRows:  3,
+			wantLabel: []string{"#1 Nightly"},
+			want:      []models.ChartSeries{{Name: "pass_rate", Data: []float64{66.7}}},
+		},
+		{
+			name:      "statuses filter",
+			query:     models.ChartQuery{Dataset: models.ChartDatasetScenarios, GroupBy: status, Metric: models.ChartMetricCount, Filters: models.ChartFilters{Statuses: []models.TestStatus{models.TestFailed}}},
+			wantRows:  1,
+			wantLabel: []string{"failed"},
+			want:      []models.ChartSeries{{Name: "count", Data: []float64{1}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.ProjectID = 1
+			data, err := RunChartQuery(&tt.query, models.ChartTypeBar, user.ID)
+			if err != nil {
+				t.Fatalf("RunChartQuery: %v", err)
+			}
+			if data.Rows != tt.wantRows || !reflect.DeepEqual(data.Labels, tt.wantLabel) || !reflect.DeepEqual(data.Series, tt.want) {
+				t.Errorf("got %d rows, labels %q and series %v, want %d, %q and %v", data.Rows, data.Labels, data.Series, tt.wantRows, tt.wantLabel, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunChartQueryOrdersRunsChronologically(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	for i := 1; i <= 10; i++ {
+		results := []models.TestResult{{Name: "Log in", Status: models.TestPassed}}
+		if i%2 == 0 {
+			results = append(results, models.TestResult{Name: "Pay", Status: models.TestFailed})
+		}
+		if _, err := CreateTestRun(user.ID, 1, "Nightly", "junit-xml", results); err != nil {
+			t.Fatalf("CreateTestRun %d: %v", i, err)
+		}
+	}
+
+	status := models.ChartGroupBy{Field: models.ChartGroupByStatus}
+	q := &models.ChartQuery{
+		Dataset:   models.ChartDatasetTestResults,
+		ProjectID: 1,
+		GroupBy:   models.ChartGroupBy{Field: models.ChartGroupByRun},
+		SplitBy:   &status,
+		Metric:    models.ChartMetricCount,
+		Filters:   models.ChartFilters{Runs: DefaultChartRuns},
+	}
+	data, err := RunChartQuery(q, models.ChartTypeLine, user.ID)
+	if err != nil {
+		t.Fatalf("RunChartQuery: %v", err)
+	}
+	// "#10" sorts before "#2" alphabetically but comes last.
+	wantLabels := make([]string, 10)
+	for i := range wantLabels {
+		wantLabels[i] = fmt.Sprintf("#%d Nightly", i+1)
+	}
+	wantSeries := []models.ChartSeries{
+		{Name: "passed", Data: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{Name: "failed", Data: []float64{0, 1, 0, 1, 0, 1, 0, 1, 0, 1}},
+	}
+	if !reflect.DeepEqual(data.Labels, wantLabels) || !reflect.DeepEqual(data.Series, wantSeries) {
+		t.Errorf("got labels %q and series %v, want %q and %v", data.Labels, data.Series, wantLabels, wantSeries)
+	}
+
+	q.Filters.Runs = 2
+	if data, err = RunChartQuery(q, models.ChartTypeLine, user.ID); err != nil {
+		t.Fatalf("RunChartQuery: %v", err)
+	}
+	if want := []string{"#9 Nightly", "#10 Nightly"}; !reflect.DeepEqual(data.Labels, want) || data.Rows != 3 {
+		t.Errorf("got labels %q over %d rows, want %q over 3", data.Labels, data.Rows, want)
+	}
+
+	other := createTestUser(t, "other@example.com", "c2", "t2", models.Project{ID: "2", Name: "Mobile"})
+	if _, err := RunChartQuery(q, models.ChartTypeLine, other.ID); !errors.Is(err, ErrProjectAccessDenied) {
+		t.Errorf("got error %v, want ErrProjectAccessDenied for a project the user cannot see", err)
+	}
+}
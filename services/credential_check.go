@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrInvalidCucumberCredentials is returned when Cucumber Studio rejects
+// credentials being verified. Other Studio failures (unavailable, rate limited)
+// are returned as they are: they say nothing about the credentials.
+var ErrInvalidCucumberCredentials = errors.New("Cucumber Studio rejected the credentials")
+
+// CredentialVerifier checks Cucumber Studio credentials of a user and returns
+// the projects they give access to.
+type CredentialVerifier func(user *models.User) ([]models.Project, error)
+
+// NewCredentialVerifier returns a CredentialVerifier that lists the projects of
+// the user on Cucumber Studio, the cheapest call that needs valid credentials.
+func NewCredentialVerifier(ctx context.Context, client StudioClient) CredentialVerifier {
+	return func(user *models.User) ([]models.Project, error) {
+		return VerifyCucumberCredentials(ctx, client, user)
+	}
+}
+
+// VerifyCucumberCredentials checks the Cucumber Studio credentials of a user,
+// which need not be saved yet, and returns the projects they give access to.
+func VerifyCucumberCredentials(ctx context.Context, client StudioClient, user *models.User) ([]models.Project, error) {
+	if user.CucumberClientID == "" || user.CucumberAccessToken == "" {
+		return nil, fmt.Errorf("%w: client ID and access token are required", ErrInvalidCucumberCredentials)
+	}
+	projects, err := client.GetProjects(ctx, user)
+	if errors.Is(err, ErrStudioUnauthorized) {
+		return nil, ErrInvalidCucumberCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
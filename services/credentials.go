@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cucumber Studio credentials are stored with envelope encryption: every value
+// is sealed with its own random data key, and the data key is sealed with a key
+// encryption key from CREDENTIALS_KEYS. Rotating the key encryption key only
+// re-seals data keys. Stored values look like
+//
+//	enc:v1:<key id>:<sealed data key>:<sealed value>
+const encryptedCredentialPrefix = "enc:v1:"
+
+// ErrNoCredentialKey is returned when credentials are written before SetCredentialKeys.
+var ErrNoCredentialKey = errors.New("no credential encryption key configured")
+
+// credentialKey is a key encryption key.
+type credentialKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// credentialKeys holds the key encryption keys; the primary one seals new
+// values, the others only open values sealed before a rotation.
+var credentialKeys struct {
+	primary *credentialKey
+	byID    map[string]*credentialKey
+}
+
+// SetCredentialKeys configures the key encryption keys from a list such as
+// "2024-06:<base64>,2023-01:<base64>". Keys are 32 random bytes, base64
+// encoded (e.g. openssl rand -base64 32); an all-zero key, a placeholder
+// rather than a secret, is rejected. The first key is the primary key.
+func SetCredentialKeys(spec string) error {
+	byID := make(map[string]*credentialKey)
+	var primary *credentialKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("credential key %q must be written id:base64key", entry)
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return fmt.Errorf("credential key %s must be 32 bytes, base64 encoded", id)
+		}
+		if bytes.Equal(raw, make([]byte, len(raw))) {
+			return fmt.Errorf("credential key %s is all zeros, generate one with openssl rand -base64 32", id)
+		}
+		if byID[id] != nil {
+			return fmt.Errorf("credential key %s is listed twice", id)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return err
+		}
+		key := &credentialKey{id: id, aead: aead}
+		byID[id] = key
+		if primary == nil {
+			primary = key
+		}
+	}
+	if primary == nil {
+		return errors.New("at least one credential key is required")
+	}
+	credentialKeys.primary = primary
+	credentialKeys.byID = byID
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts plaintext with a random nonce prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// encryptCredential seals the value of a users column. The column name is
+// authenticated, so a client ID cannot be swapped with an access token. Empty
+// values stay empty.
+func encryptCredential(column, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	kek := credentialKeys.primary
+	if kek == nil {
+		return "", ErrNoCredentialKey
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %v", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(aead, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(kek.aead, dataKey, []byte(kek.id))
+	if err != nil {
+		return "", err
+	}
+	return encryptedCredentialPrefix + kek.id + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedValue), nil
+}
+
+// decryptCredential opens a value sealed by encryptCredential. Values stored
+// before encryption was introduced are returned as they are.
+func decryptCredential(column, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedCredentialPrefix) {
+		return stored, nil
+	}
+	_, dataKey, sealedValue, err := openDataKey(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %v", column, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, sealedValue, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %v", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// openDataKey parses a sealed value and opens its data key with the key
+// encryption key it names.
+func openDataKey(stored string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(stored, encryptedCredentialPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed encrypted value")
+	}
+	kek := credentialKeys.byID[parts[0]]
+	if kek == nil {
+		return "", nil, nil, fmt.Errorf("unknown credential key %s", parts[0])
+	}
+	sealedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, errors.New("malformed encrypted value")
+	}
+	sealedValue, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, errors.New("malformed encrypted value")
+	}
+	dataKey, err := open(kek.aead, sealedKey, []byte(kek.id))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return kek.id, dataKey, sealedValue, nil
+}
+
+// rewrapCredential returns a stored value sealed with the primary key: plaintext
+// values are encrypted and data keys sealed with an older key are re-sealed.
+// It reports whether the value changed.
+func rewrapCredential(column, stored string) (string, bool, error) {
+	if stored == "" {
+		return stored, false, nil
+	}
+	if !strings.HasPrefix(stored, encryptedCredentialPrefix) {
+		encrypted, err := encryptCredential(column, stored)
+		return encrypted, err == nil, err
+	}
+	kekID, dataKey, sealedValue, err := openDataKey(stored)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decrypt %s: %v", column, err)
+	}
+	primary := credentialKeys.primary
+	if kekID == primary.id {
+		return stored, false, nil
+	}
+	sealedKey, err := seal(primary.aead, dataKey, []byte(primary.id))
+	if err != nil {
+		return "", false, err
+	}
+	return encryptedCredentialPrefix + primary.id + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedValue), true, nil
+}
+
+// EncryptStoredCredentials brings every stored credential under the primary
+// key: it encrypts rows written before encryption existed and re-seals data keys
+// after a key rotation. Once it has run, older keys can be dropped from
+// CREDENTIALS_KEYS. It returns the number of users updated.
+func EncryptStoredCredentials() (int, error) {
+	if credentialKeys.primary == nil {
+		return 0, ErrNoCredentialKey
+	}
+	rows, err := DB.Query("SELECT id, COALESCE(cucumber_client_id, ''), COALESCE(cucumber_access_token, '') FROM users")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query users: %v", err)
+	}
+	type storedCredentials struct {
+		userID                int
+		clientID, accessToken string
+	}
+	var stale []storedCredentials
+	for rows.Next() {
+		var row storedCredentials
+		if err := rows.Scan(&row.userID, &row.clientID, &row.accessToken); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan user row: %v", err)
+		}
+		stale = append(stale, row)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	rows.Close()
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	updated := 0
+	for _, row := range stale {
+		clientID, clientChanged, err := rewrapCredential("cucumber_client_id", row.clientID)
+		if err != nil {
+			return 0, fmt.Errorf("user %d: %v", row.userID, err)
+		}
+		accessToken, tokenChanged, err := rewrapCredential("cucumber_access_token", row.accessToken)
+		if err != nil {
+			return 0, fmt.Errorf("user %d: %v", row.userID, err)
+		}
+		if !clientChanged && !tokenChanged {
+			continue
+		}
+		_, err = tx.Exec("UPDATE users SET cucumber_client_id = ?, cucumber_access_token = ? WHERE id = ?", clientID, accessToken, row.userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update credentials of user %d: %v", row.userID, err)
+		}
+		updated++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return updated, nil
+}
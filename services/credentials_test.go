@@ -0,0 +1,78 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestCredentialKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSetCredentialKeys(t *testing.T) {
+	key, other := newTestCredentialKey(t), newTestCredentialKey(t)
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{name: "one key", spec: "dev:" + key},
+		{name: "rotated keys", spec: " new:" + key + " , old:" + other + ","},
+		{name: "empty", spec: "", wantErr: "at least one credential key is required"},
+		{name: "missing id", spec: key, wantErr: "must be written id:base64key"},
+		{name: "placeholder", spec: "dev:your-base64-key", wantErr: "must be 32 bytes"},
+		{name: "short key", spec: "dev:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: "must be 32 bytes"},
+		{name: "all-zero key", spec: "dev:" + base64.StdEncoding.EncodeToString(make([]byte, 32)), wantErr: "all zeros"},
+		{name: "all-zero rotated key", spec: "new:" + key + ",old:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", wantErr: "all zeros"},
+		{name: "duplicate id", spec: "dev:" + key + ",dev:" + other, wantErr: "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetCredentialKeys(tt.spec)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("SetCredentialKeys: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialsSurviveKeyRotation(t *testing.T) {
+	oldKey, newKey := newTestCredentialKey(t), newTestCredentialKey(t)
+	if err := SetCredentialKeys("old:" + oldKey); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := encryptCredential("cucumber_access_token", "secret-token")
+	if err != nil {
+		t.Fatalf("encryptCredential: %v", err)
+	}
+	if strings.Contains(stored, "secret-token") || !strings.HasPrefix(stored, encryptedCredentialPrefix+"old:") {
+		t.Fatalf("stored %q, want a value sealed with the old key", stored)
+	}
+
+	if err := SetCredentialKeys("new:" + newKey + ",old:" + oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decryptCredential("cucumber_access_token", stored); err != nil || got != "secret-token" {
+		t.Fatalf("decryptCredential after rotation = %q, %v", got, err)
+	}
+	if _, err := decryptCredential("cucumber_client_id", stored); err == nil {
+		t.Error("a value sealed for one column opened as another")
+	}
+
+	if err := SetCredentialKeys("new:" + newKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptCredential("cucumber_access_token", stored); err == nil {
+		t.Error("a value sealed with a retired key still opened")
+	}
+}
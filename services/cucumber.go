@@ -1,17 +1,106 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"my-cucumber-backend/models"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	cucumberStudioBaseURL = "https://studio.cucumber.io/api"
+	// DefaultCucumberStudioBaseURL is the public Cucumber Studio API.
+	DefaultCucumberStudioBaseURL = "https://studio.cucumber.io/api"
+
+	// cucumberStudioPageSize is the page[size] requested for every list call.
+	cucumberStudioPageSize = 100
+	// cucumberStudioMaxPages guards against a server that keeps returning a next link.
+	cucumberStudioMaxPages = 1000
 )
 
+// StudioClient is the part of the Cucumber Studio API the backend depends on.
+type StudioClient interface {
+	GetProjects(ctx context.Context, user *models.User) ([]models.Project, error)
+	GetFolders(ctx context.Context, user *models.User, projectID int) ([]FolderResponse, error)
+	GetScenarios(ctx context.Context, user *models.User, projectID int) ([]models.Scenario, error)
+	GetTestRuns(ctx context.Context, user *models.User, projectID int) ([]TestRunResponse, error)
+	CreateTestRun(ctx context.Context, user *models.User, projectID int, name string, scenarioIDs []string) (*TestRunResponse, error)
+	GetTestSnapshots(ctx context.Context, user *models.User, projectID int, testRunID string) ([]TestSnapshotResponse, error)
+	CreateTestResult(ctx context.Context, user *models.User, projectID int, testRunID, snapshotID, status, description string) error
+}
+
+// CucumberClientConfig configures a CucumberClient. Zero values fall back to sensible defaults.
+type CucumberClientConfig struct {
+	BaseURL        string        // Defaults to DefaultCucumberStudioBaseURL
+	RequestTimeout time.Duration // Per attempt, defaults to 30s
+	MaxRetries     int           // Retries after the first attempt, defaults to 3; negative disables retries
+	InitialBackoff time.Duration // Defaults to 500ms, doubled on every retry
+	MaxBackoff     time.Duration // Upper bound for a single wait, including Retry-After, defaults to 30s
+	HTTPClient     *http.Client  // Defaults to a new http.Client
+}
+
+// CucumberClient talks to the Cucumber Studio JSON:API.
+type CucumberClient struct {
+	baseURL        *url.URL
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+var _ StudioClient = (*CucumberClient)(nil)
+
+// NewCucumberClient creates a Cucumber Studio client.
+func NewCucumberClient(cfg CucumberClientConfig) (*CucumberClient, error) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultCucumberStudioBaseURL
+	}
+	baseURL, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cucumber Studio base URL: %v", err)
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 30 * time.Second
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
+	}
+
+	return &CucumberClient{
+		baseURL:        baseURL,
+		httpClient:     cfg.HTTPClient,
+		requestTimeout: cfg.RequestTimeout,
+		maxRetries:     cfg.MaxRetries,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+	}, nil
+}
+
+// BaseURL returns the Cucumber Studio API root the client sends requests to.
+func (c *CucumberClient) BaseURL() string {
+	return strings.TrimRight(c.baseURL.String(), "/")
+}
+
 // ProjectResponse represents the structure of a single project in the Cucumber Studio API response.
 type ProjectResponse struct {
 	Type       string `json:"type"`
@@ -22,203 +111,347 @@ type ProjectResponse struct {
 	} `json:"attributes"`
 }
 
-// ProjectsResponse represents the top-level structure of the /projects response.
-type ProjectsResponse struct {
-	Data []ProjectResponse `json:"data"`
-}
-
 // ScenarioResponse represents a single scenario in the Cucumber Studio API response.
 type ScenarioResponse struct {
 	Type       string `json:"type"`
 	ID         string `json:"id"`
 	Attributes struct {
-		Name     string `json:"name"`
-		FolderID int    `json:"folder-id"` // Directly get folder-id
-		// ... other attributes you might need ...
+		Name        string `json:"name"`
+		FolderID    int    `json:"folder-id"` // Directly get folder-id
+		Description string `json:"description"`
+		Definition  string `json:"definition"` // Gherkin source, "Scenario: ..." followed by its steps
+		Datatable   string `json:"datatable"`  // Gherkin table, "| p1 | p2 |" rows
 	} `json:"attributes"`
 	Relationships struct {
-		Tags struct {
-			Data []struct {
-				ID   string `json:"id"`
-				Type string `json:"type"`
-			} `json:"data"`
-		} `json:"tags"`
+		Tags     relationshipResponse `json:"tags"`
+		Datasets relationshipResponse `json:"datasets"`
 	} `json:"relationships"`
 }
 
-// ScenariosResponse is the top-level response for a list of scenarios.
-type ScenariosResponse struct {
-	Data     []ScenarioResponse `json:"data"`
-	Included []struct {         // For included resources (like tags)
-		Type       string `json:"type"`
-		ID         string `json:"id"`
-		Attributes struct {
-			Key   string `json:"key"`
-			Value string `json:"value"`
-		} `json:"attributes"`
-	} `json:"included"`
+// relationshipResponse lists the resources a resource refers to.
+type relationshipResponse struct {
+	Data []struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	} `json:"data"`
 }
 
-// TagResponse represents a tag in the "included" array.
-// We'll define a separate struct for clarity, although it overlaps with the anonymous struct above.
-type TagResponse struct {
-	ID         string `json:"id"`
+// IncludedResource is a related resource in the "included" array. Its attributes
+// depend on the type and are decoded into TagAttributes or DatasetAttributes.
+type IncludedResource struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// TagAttributes are the attributes of an included "tags" resource.
+type TagAttributes struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// DatasetAttributes are the attributes of an included "datasets" resource.
+type DatasetAttributes struct {
+	Name string            `json:"name"`
+	Data map[string]string `json:"data"`
+}
+
+// FolderResponse represents a single folder in the API response.
+type FolderResponse struct {
 	Type       string `json:"type"`
+	ID         string `json:"id"`
 	Attributes struct {
-		Key   string `json:"key"`
-		Value string `json:"value"`
+		Name     string      `json:"name"`
+		ParentID json.Number `json:"parent-id"` // MUST BE *string
+		// ... other attributes ...
 	} `json:"attributes"`
 }
 
-// GetProjects fetches projects from Cucumber Studio for a given user and simplifies the data.
-func GetProjects(user *models.User) ([]models.Project, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", cucumberStudioBaseURL+"/projects", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
+// TestRunResponse is a test run in the Cucumber Studio API response.
+type TestRunResponse struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		Name string `json:"name"`
+	} `json:"attributes"`
+}
 
-	req.Header.Add("Accept", "application/vnd.api+json; version=1")
-	req.Header.Add("access-token", user.CucumberAccessToken)
-	req.Header.Add("client", user.CucumberClientID)
-	req.Header.Add("uid", user.Email)
+// TestSnapshotResponse is the execution of one scenario in a test run.
+type TestSnapshotResponse struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	Attributes struct {
+		Name       string      `json:"name"`
+		Status     string      `json:"status"` // passed, failed, retest, undefined, blocked, skipped or wip
+		ScenarioID json.Number `json:"scenario-id"`
+	} `json:"attributes"`
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch projects: %v", err)
-	}
-	defer resp.Body.Close()
+// listPage is a single page of a JSON:API list response.
+type listPage[T any] struct {
+	Data     []T                `json:"data"`
+	Included []IncludedResource `json:"included"`
+	Links    struct {
+		Next string `json:"next"`
+	} `json:"links"`
+	Meta struct {
+		CurrentPage int `json:"current_page"`
+		TotalPages  int `json:"total_pages"`
+	} `json:"meta"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Cucumber Studio API returned an error: %s", resp.Status)
-	}
+// listResult holds every resource collected while walking a paginated list.
+type listResult[T any] struct {
+	Data     []T
+	Included []IncludedResource
+	Pages    int
+}
 
-	body, err := io.ReadAll(resp.Body)
+// fetchAllPages walks a JSON:API list endpoint, following links.next (or page[number]
+// when the server omits links) until the last page, and merges data and included resources.
+func fetchAllPages[T any](ctx context.Context, c *CucumberClient, user *models.User, path string) (*listResult[T], error) {
+	pageURL, err := c.baseURL.Parse(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("invalid request path %q: %v", path, err)
 	}
+	query := pageURL.Query()
+	query.Set("page[number]", "1")
+	query.Set("page[size]", strconv.Itoa(cucumberStudioPageSize))
+	pageURL.RawQuery = query.Encode()
+
+	result := &listResult[T]{}
+	seenIncluded := make(map[string]bool)
+	seenURLs := make(map[string]bool)
+	pageNumber := 1
+
+	for pageURL != nil {
+		if result.Pages >= cucumberStudioMaxPages {
+			return nil, fmt.Errorf("gave up after %d pages of %s", result.Pages, path)
+		}
+		if seenURLs[pageURL.String()] {
+			break // The server pointed us back at a page we already have.
+		}
+		seenURLs[pageURL.String()] = true
 
-	var projectsResponse ProjectsResponse
-	if err := json.Unmarshal(body, &projectsResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal: %v", err)
-	}
+		body, err := c.get(ctx, user, pageURL.String())
+		if err != nil {
+			return nil, err
+		}
+		var page listPage[T]
+		if err := json.Unmarshal(body, &page); err != nil {
+			log.Printf("Unreadable Cucumber Studio response from %s: %s", pageURL, body)
+			return nil, fmt.Errorf("failed to unmarshal JSON from %s: %v", path, err)
+		}
 
-	// Simplify the data to just ID and Name
-	simplifiedProjects := make([]models.Project, len(projectsResponse.Data))
-	for i, p := range projectsResponse.Data {
-		simplifiedProjects[i] = models.Project{
-			ID:   p.ID,
-			Name: p.Attributes.Name,
+		result.Pages++
+		result.Data = append(result.Data, page.Data...)
+		for _, included := range page.Included {
+			key := included.Type + "/" + included.ID
+			if seenIncluded[key] {
+				continue
+			}
+			seenIncluded[key] = true
+			result.Included = append(result.Included, included)
+		}
+
+		// Decide where the next page lives.
+		switch {
+		case page.Links.Next != "":
+			pageURL, err = pageURL.Parse(page.Links.Next)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next link %q: %v", page.Links.Next, err)
+			}
+		case page.Meta.TotalPages > pageNumber && len(page.Data) > 0:
+			pageNumber++
+			query := pageURL.Query()
+			query.Set("page[number]", strconv.Itoa(pageNumber))
+			pageURL.RawQuery = query.Encode()
+		default:
+			pageURL = nil
 		}
 	}
 
-	return simplifiedProjects, nil
+	log.Printf("Fetched %d resources (%d included) from %s across %d page(s)", len(result.Data), len(result.Included), path, result.Pages)
+	return result, nil
 }
 
-// FolderResponse represents a single folder in the API response.
-type FolderResponse struct {
-	Type       string `json:"type"`
-	ID         string `json:"id"`
-	Attributes struct {
-		Name     string      `json:"name"`
-		ParentID json.Number `json:"parent-id"` // MUST BE *string
-		// ... other attributes ...
-	} `json:"attributes"`
+// get performs a GET against Cucumber Studio, retrying rate-limited and failed
+// attempts with exponential backoff, and returns the response body.
+func (c *CucumberClient) get(ctx context.Context, user *models.User, requestURL string) ([]byte, error) {
+	return c.do(ctx, user, "GET", requestURL, nil)
 }
 
-// FoldersResponse is the top-level response structure for a list of folders.
-type FoldersResponse struct {
-	Data []FolderResponse `json:"data"`
+// do sends a request to Cucumber Studio with retries. Only 429, 5xx and transport
+// errors are retried; every other non-2xx status is returned as a *StudioError.
+// Writes are only retried on 429, since after a 5xx they may have been applied.
+func (c *CucumberClient) do(ctx context.Context, user *models.User, method, requestURL string, payload []byte) ([]byte, error) {
+	backoff := c.initialBackoff
+	for attempt := 0; ; attempt++ {
+		body, err := c.attempt(ctx, user, method, requestURL, payload)
+		if err == nil {
+			return body, nil
+		}
+		if !isRetryable(err) || attempt >= c.maxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if method != http.MethodGet && !errors.Is(err, ErrStudioRateLimited) {
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		var studioErr *StudioError
+		if errors.As(err, &studioErr) {
+			retryAfter = studioErr.RetryAfter
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)) // Up to 50% jitter
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > c.maxBackoff {
+			if retryAfter > c.maxBackoff {
+				return nil, err // Studio asked us to wait longer than we are willing to.
+			}
+			wait = c.maxBackoff
+		}
+		log.Printf("Cucumber Studio request %s %s failed (attempt %d/%d), retrying in %s: %v", method, requestURL, attempt+1, c.maxRetries+1, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
 }
 
-// GetFolders fetches folders from Cucumber Studio for a given project.
-func GetFolders(user *models.User, projectID int) ([]FolderResponse, error) {
-	url := fmt.Sprintf("%s/projects/%d/folders", cucumberStudioBaseURL, projectID)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// attempt performs a single request, bounded by the per-request timeout.
+func (c *CucumberClient) attempt(ctx context.Context, user *models.User, method, requestURL string, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = strings.NewReader(string(payload))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	setStudioHeaders(req, user)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/vnd.api+json")
+	}
 
-	req.Header.Add("Accept", "application/vnd.api+json; version=1")
-	req.Header.Add("access-token", user.CucumberAccessToken)
-	req.Header.Add("client", user.CucumberClientID)
-	req.Header.Add("uid", user.Email)
-
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch folders: %v", err)
+		return nil, &StudioError{Kind: ErrStudioUnavailable, URL: requestURL, Err: err}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body) // Read body for error message
-		return nil, fmt.Errorf("Cucumber Studio API returned an error: %s, Body: %s", resp.Status, string(bodyBytes))
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, &StudioError{Kind: ErrStudioUnavailable, URL: requestURL, Err: fmt.Errorf("failed to read response body: %v", err)}
 	}
 
-	var foldersResponse FoldersResponse
-	if err := json.Unmarshal(body, &foldersResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v\nResponse: %s", err, string(body))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		studioErr := newStudioError(resp.StatusCode, requestURL, body)
+		studioErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, studioErr
 	}
+	return body, nil
+}
 
-	return foldersResponse.Data, nil
+// setStudioHeaders adds the JSON:API and per-user authentication headers Studio expects.
+func setStudioHeaders(req *http.Request, user *models.User) {
+	req.Header.Set("Accept", "application/vnd.api+json; version=1")
+	req.Header.Set("access-token", user.CucumberAccessToken)
+	req.Header.Set("client", user.CucumberClientID)
+	req.Header.Set("uid", user.Email)
 }
 
-// GetScenarios fetches scenarios and their associated tags from Cucumber Studio.
-func GetScenarios(user *models.User, projectID int) ([]models.Scenario, error) {
-	url := fmt.Sprintf("%s/projects/%d/scenarios?include=tags", cucumberStudioBaseURL, projectID)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+// parseRetryAfter understands both forms of the Retry-After header: seconds and an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
 	}
+	return 0
+}
 
-	req.Header.Add("Accept", "application/vnd.api+json; version=1")
-	req.Header.Add("access-token", user.CucumberAccessToken)
-	req.Header.Add("client", user.CucumberClientID)
-	req.Header.Add("uid", user.Email)
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrStudioRateLimited) || errors.Is(err, ErrStudioUnavailable)
+}
 
-	resp, err := client.Do(req)
+// GetProjects fetches projects from Cucumber Studio for a given user and simplifies the data.
+func (c *CucumberClient) GetProjects(ctx context.Context, user *models.User) ([]models.Project, error) {
+	result, err := fetchAllPages[ProjectResponse](ctx, c, user, "projects")
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch scenarios: %v", err)
+		return nil, &studioCallError{Op: "fetch projects", Err: err}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Cucumber Studio API returned an error: %s", resp.Status)
+	// Simplify the data to just ID and Name
+	simplifiedProjects := make([]models.Project, len(result.Data))
+	for i, p := range result.Data {
+		simplifiedProjects[i] = models.Project{
+			ID:   p.ID,
+			Name: p.Attributes.Name,
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	return simplifiedProjects, nil
+}
+
+// GetFolders fetches folders from Cucumber Studio for a given project.
+func (c *CucumberClient) GetFolders(ctx context.Context, user *models.User, projectID int) ([]FolderResponse, error) {
+	result, err := fetchAllPages[FolderResponse](ctx, c, user, fmt.Sprintf("projects/%d/folders", projectID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, &studioCallError{Op: "fetch folders", Err: err}
 	}
+	return result.Data, nil
+}
 
-	var scenariosResponse ScenariosResponse
-	if err := json.Unmarshal(body, &scenariosResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v\nResponse body: %s", err, string(body)) // Include response body for debugging
+// GetScenarios fetches scenarios with their tags, steps and datasets from Cucumber Studio.
+func (c *CucumberClient) GetScenarios(ctx context.Context, user *models.User, projectID int) ([]models.Scenario, error) {
+	result, err := fetchAllPages[ScenarioResponse](ctx, c, user, fmt.Sprintf("projects/%d/scenarios?include=tags,datasets", projectID))
+	if err != nil {
+		return nil, &studioCallError{Op: "fetch scenarios", Err: err}
 	}
 
-	// Create a map to look up tags by ID
+	// Create maps to look up tags and datasets by ID
 	tagMap := make(map[string]models.Tag)
-	for _, includedItem := range scenariosResponse.Included {
-		if includedItem.Type == "tags" {
-			tag := models.Tag{
-				ID:    includedItem.ID,
-				Key:   includedItem.Attributes.Key,
-				Value: includedItem.Attributes.Value,
+	datasetMap := make(map[string]models.Dataset)
+	for _, includedItem := range result.Included {
+		switch includedItem.Type {
+		case "tags":
+			var attributes TagAttributes
+			if err := json.Unmarshal(includedItem.Attributes, &attributes); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal tag %s: %v", includedItem.ID, err)
+			}
+			tagMap[includedItem.ID] = models.Tag{ID: includedItem.ID, Key: attributes.Key, Value: attributes.Value}
+		case "datasets":
+			var attributes DatasetAttributes
+			if err := json.Unmarshal(includedItem.Attributes, &attributes); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal dataset %s: %v", includedItem.ID, err)
 			}
-			tagMap[tag.ID] = tag
+			if attributes.Data == nil {
+				attributes.Data = map[string]string{}
+			}
+			datasetMap[includedItem.ID] = models.Dataset{ID: includedItem.ID, Name: attributes.Name, Data: attributes.Data}
 		}
 	}
 
-	// Build the simplified scenario data
-	scenarios := make([]models.Scenario, 0, len(scenariosResponse.Data))
-	for _, scenarioData := range scenariosResponse.Data {
+	// Build the scenario data
+	scenarios := make([]models.Scenario, 0, len(result.Data))
+	for _, scenarioData := range result.Data {
 		scenario := models.Scenario{
 			ID:        scenarioData.ID,
 			Name:      scenarioData.Attributes.Name,
@@ -233,8 +466,113 @@ func GetScenarios(user *models.User, projectID int) ([]models.Scenario, error) {
 				scenario.Tags = append(scenario.Tags, tag)
 			}
 		}
+
+		details := &models.ScenarioDetails{
+			Description: scenarioData.Attributes.Description,
+			Definition:  scenarioData.Attributes.Definition,
+			Datatable:   parseGherkinTable(scenarioData.Attributes.Datatable),
+			Datasets:    make([]models.Dataset, 0),
+		}
+		details.Steps, err = parseScenarioSteps(details.Definition)
+		if err != nil {
+			// Keep the definition so the scenario can still be read; only the steps are lost.
+			log.Printf("Failed to parse the definition of scenario %s: %v", scenario.ID, err)
+			details.Steps = make([]models.Step, 0)
+		}
+		for _, datasetRelationship := range scenarioData.Relationships.Datasets.Data {
+			if dataset, ok := datasetMap[datasetRelationship.ID]; ok {
+				details.Datasets = append(details.Datasets, dataset)
+			}
+		}
+		scenario.Details = details
+		scenario.StepCount = len(details.Steps)
+
 		scenarios = append(scenarios, scenario)
 	}
 
 	return scenarios, nil
 }
+
+// GetTestRuns fetches the test runs of a project.
+func (c *CucumberClient) GetTestRuns(ctx context.Context, user *models.User, projectID int) ([]TestRunResponse, error) {
+	result, err := fetchAllPages[TestRunResponse](ctx, c, user, fmt.Sprintf("projects/%d/test_runs", projectID))
+	if err != nil {
+		return nil, &studioCallError{Op: "fetch test runs", Err: err}
+	}
+	return result.Data, nil
+}
+
+// CreateTestRun creates a test run executing the given scenarios.
+func (c *CucumberClient) CreateTestRun(ctx context.Context, user *models.User, projectID int, name string, scenarioIDs []string) (*TestRunResponse, error) {
+	var payload struct {
+		Data struct {
+			Attributes struct {
+				Name        string   `json:"name"`
+				ScenarioIDs []string `json:"scenario_ids"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	payload.Data.Attributes.Name = name
+	payload.Data.Attributes.ScenarioIDs = scenarioIDs
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal test run: %v", err)
+	}
+
+	requestURL, err := c.baseURL.Parse(fmt.Sprintf("projects/%d/test_runs", projectID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request path: %v", err)
+	}
+	body, err := c.do(ctx, user, http.MethodPost, requestURL.String(), data)
+	if err != nil {
+		return nil, &studioCallError{Op: "create test run", Err: err}
+	}
+	var response struct {
+		Data TestRunResponse `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		log.Printf("Unreadable Cucumber Studio response from %s: %s", requestURL, body)
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &response.Data, nil
+}
+
+// GetTestSnapshots fetches the scenario executions of a test run.
+func (c *CucumberClient) GetTestSnapshots(ctx context.Context, user *models.User, projectID int, testRunID string) ([]TestSnapshotResponse, error) {
+	path := fmt.Sprintf("projects/%d/test_runs/%s/test_snapshots", projectID, url.PathEscape(testRunID))
+	result, err := fetchAllPages[TestSnapshotResponse](ctx, c, user, path)
+	if err != nil {
+		return nil, &studioCallError{Op: "fetch test snapshots", Err: err}
+	}
+	return result.Data, nil
+}
+
+// CreateTestResult records the outcome of a scenario execution, which becomes the status of its test snapshot.
+func (c *CucumberClient) CreateTestResult(ctx context.Context, user *models.User, projectID int, testRunID, snapshotID, status, description string) error {
+	var payload struct {
+		Data struct {
+			Type       string `json:"type"`
+			Attributes struct {
+				Status      string `json:"status"`
+				Description string `json:"description"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	payload.Data.Type = "test-results"
+	payload.Data.Attributes.Status = status
+	payload.Data.Attributes.Description = description
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal test result: %v", err)
+	}
+
+	requestURL, err := c.baseURL.Parse(fmt.Sprintf("projects/%d/test_runs/%s/test_snapshots/%s/test_results",
+		projectID, url.PathEscape(testRunID), url.PathEscape(snapshotID)))
+	if err != nil {
+		return fmt.Errorf("invalid request path: %v", err)
+	}
+	if _, err := c.do(ctx, user, http.MethodPost, requestURL.String(), data); err != nil {
+		return &studioCallError{Op: "create test result", Err: err}
+	}
+	return nil
+}
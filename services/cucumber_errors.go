@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors for Cucumber Studio failures. Use errors.Is to test for them;
+// the concrete error is a *StudioError carrying the status and response body.
+var (
+	ErrStudioUnauthorized = errors.New("Cucumber Studio rejected the credentials")
+	ErrStudioNotFound     = errors.New("Cucumber Studio resource not found")
+	ErrStudioRateLimited  = errors.New("Cucumber Studio rate limit exceeded")
+	ErrStudioUnavailable  = errors.New("Cucumber Studio is unavailable")
+	ErrStudioBadRequest   = errors.New("Cucumber Studio rejected the request")
+)
+
+// StudioError describes a failed Cucumber Studio call.
+type StudioError struct {
+	Kind       error  // One of the ErrStudio* sentinels
+	StatusCode int    // Zero for transport errors
+	URL        string // Request URL
+	Body       string // Response body, if any
+	Err        error  // Underlying transport error, if any
+
+	RetryAfter time.Duration // Parsed Retry-After header, if Studio sent one
+}
+
+func (e *StudioError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+	case e.Body != "":
+		return fmt.Sprintf("%v (%d), Body: %s", e.Kind, e.StatusCode, e.Body)
+	default:
+		return fmt.Sprintf("%v (%d)", e.Kind, e.StatusCode)
+	}
+}
+
+// Summary describes the failure by its kind and status only. The response body
+// and transport error can echo anything Studio or the network sent back, so
+// they are for server logs, not API clients.
+func (e *StudioError) Summary() string {
+	if e.StatusCode == 0 {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%v (%d)", e.Kind, e.StatusCode)
+}
+
+// studioCallError is a failed Cucumber Studio client call: the operation it
+// attempted and the failure, usually a *StudioError.
+type studioCallError struct {
+	Op  string // What the call did, e.g. "fetch projects"
+	Err error
+}
+
+func (e *studioCallError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *studioCallError) Unwrap() error {
+	return e.Err
+}
+
+// PublicError returns the message of err to show API clients. A Cucumber
+// Studio failure is reported as the call that failed and the Summary of the
+// StudioError, leaving out the response body and transport error.
+func PublicError(err error) string {
+	var studioErr *StudioError
+	if !errors.As(err, &studioErr) {
+		return err.Error()
+	}
+	var callErr *studioCallError
+	if errors.As(err, &callErr) {
+		return "failed to " + callErr.Op + ": " + studioErr.Summary()
+	}
+	return studioErr.Summary()
+}
+
+// Unwrap lets errors.Is match both the sentinel and the transport error.
+func (e *StudioError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// newStudioError classifies a non-2xx Cucumber Studio response.
+func newStudioError(statusCode int, url string, body []byte) *StudioError {
+	var kind error
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = ErrStudioUnauthorized
+	case statusCode == http.StatusNotFound:
+		kind = ErrStudioNotFound
+	case statusCode == http.StatusTooManyRequests:
+		kind = ErrStudioRateLimited
+	case statusCode >= 500:
+		kind = ErrStudioUnavailable
+	default:
+		kind = ErrStudioBadRequest
+	}
+	return &StudioError{Kind: kind, StatusCode: statusCode, URL: url, Body: string(body)}
+}
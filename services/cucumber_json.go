@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// cucumberJSONFeature is a feature of a Cucumber JSON report, as written by the
+// json formatter of Cucumber JVM, Ruby and JS.
+type cucumberJSONFeature struct {
+	URI      string                `json:"uri"`
+	Name     string                `json:"name"`
+	Tags     []cucumberJSONTag     `json:"tags"`
+	Elements []cucumberJSONElement `json:"elements"`
+}
+
+type cucumberJSONElement struct {
+	Type   string             `json:"type"` // "scenario" or "background"
+	Name   string             `json:"name"`
+	Line   int                `json:"line"`
+	Tags   []cucumberJSONTag  `json:"tags"`
+	Before []cucumberJSONStep `json:"before"`
+	Steps  []cucumberJSONStep `json:"steps"`
+	After  []cucumberJSONStep `json:"after"`
+}
+
+type cucumberJSONTag struct {
+	Name string `json:"name"`
+}
+
+// cucumberJSONStep is a step or a hook.
+type cucumberJSONStep struct {
+	Result struct {
+		Status       string `json:"status"`
+		Duration     int64  `json:"duration"` // Nanoseconds
+		ErrorMessage string `json:"error_message"`
+	} `json:"result"`
+}
+
+// cucumberJSONParser reads Cucumber JSON reports. Only the last attempt of a
+// retried scenario appears in them.
+type cucumberJSONParser struct{}
+
+func (cucumberJSONParser) Format() string { return "cucumber-json" }
+
+func (cucumberJSONParser) MediaTypes() []string { return []string{"application/json"} }
+
+// Sniff accepts a JSON array, the top level of a Cucumber JSON report.
+func (cucumberJSONParser) Sniff(head []byte) bool { return firstByte(head) == '[' }
+
+// Parse returns one result per executed scenario, in report order. Steps of a
+// background count towards the scenario that follows it.
+func (cucumberJSONParser) Parse(r io.Reader) ([]models.TestResult, error) {
+	var features []cucumberJSONFeature
+	if err := json.NewDecoder(r).Decode(&features); err != nil {
+		return nil, fmt.Errorf("%w: not a Cucumber JSON report: %v", ErrInvalidTestReport, err)
+	}
+
+	results := make([]models.TestResult, 0)
+	for _, feature := range features {
+		featureTags := cucumberJSONTagNames(feature.Tags)
+		var background []cucumberJSONStep
+		for _, element := range feature.Elements {
+			if element.Type == "background" {
+				background = append(append(append([]cucumberJSONStep{}, element.Before...), element.Steps...), element.After...)
+				continue
+			}
+
+			steps := append(append(append(append([]cucumberJSONStep{}, element.Before...), background...), element.Steps...), element.After...)
+			background = nil
+			outcomes := make([]stepOutcome, len(steps))
+			for i, step := range steps {
+				outcomes[i] = stepOutcome{step.Result.Status, step.Result.Duration, step.Result.ErrorMessage}
+			}
+			status, durationNS, errorMessage := scenarioOutcome(outcomes)
+			results = append(results, models.TestResult{
+				Name:         strings.TrimSpace(element.Name),
+				Feature:      strings.TrimSpace(feature.Name),
+				URI:          feature.URI,
+				Line:         element.Line,
+				Tags:         sortedUnique(append(append([]string{}, featureTags...), cucumberJSONTagNames(element.Tags)...)),
+				Status:       status,
+				DurationMS:   durationNS / 1e6,
+				ErrorMessage: errorMessage,
+			})
+		}
+	}
+	return results, nil
+}
+
+func cucumberJSONTagNames(tags []cucumberJSONTag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, strings.TrimPrefix(tag.Name, "@"))
+	}
+	return names
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"my-cucumber-backend/models"
+
+	messages "github.com/cucumber/messages/go/v21"
+)
+
+// cucumberMessagesParser reads the Cucumber Messages NDJSON stream written by the
+// message formatter of cucumber-js and Cucumber JVM: one JSON envelope per line.
+// Every attempt of a retried scenario becomes its own result.
+type cucumberMessagesParser struct{}
+
+func (cucumberMessagesParser) Format() string { return "cucumber-messages" }
+
+func (cucumberMessagesParser) MediaTypes() []string {
+	return []string{"application/x-ndjson", "application/ndjson", "application/jsonl"}
+}
+
+// Sniff accepts a JSON object, the first envelope of the stream.
+func (cucumberMessagesParser) Sniff(head []byte) bool { return firstByte(head) == '{' }
+
+// Parse pairs every finished test case with its pickle and the results of its steps.
+func (cucumberMessagesParser) Parse(r io.Reader) ([]models.TestResult, error) {
+	features := make(map[string]string) // Feature name by URI
+	lines := make(map[string]int)       // Line of every scenario and example row by AST node ID
+	pickles := make(map[string]*messages.Pickle)
+	testCases := make(map[string]*messages.TestCase)
+	started := make(map[string]*messages.TestCaseStarted)
+	steps := make(map[string][]stepOutcome) // By test case started ID
+
+	results := make([]models.TestResult, 0)
+	decoder := json.NewDecoder(r)
+	for {
+		var envelope messages.Envelope
+		if err := decoder.Decode(&envelope); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: not a Cucumber Messages stream: %v", ErrInvalidTestReport, err)
+		}
+
+		switch {
+		case envelope.GherkinDocument != nil:
+			doc := envelope.GherkinDocument
+			if doc.Feature != nil {
+				features[doc.Uri] = strings.TrimSpace(doc.Feature.Name)
+				indexScenarioLines(doc.Feature.Children, lines)
+			}
+		case envelope.Pickle != nil:
+			pickles[envelope.Pickle.Id] = envelope.Pickle
+		case envelope.TestCase != nil:
+			testCases[envelope.TestCase.Id] = envelope.TestCase
+		case envelope.TestCaseStarted != nil:
+			started[envelope.TestCaseStarted.Id] = envelope.TestCaseStarted
+		case envelope.TestStepFinished != nil:
+			finished := envelope.TestStepFinished
+			if result := finished.TestStepResult; result != nil {
+				steps[finished.TestCaseStartedId] = append(steps[finished.TestCaseStartedId], stepOutcome{
+					status:       string(result.Status),
+					durationNS:   messagesDurationNS(result.Duration),
+					errorMessage: result.Message,
+				})
+			}
+		case envelope.TestCaseFinished != nil:
+			finished := envelope.TestCaseFinished
+			attempt := started[finished.TestCaseStartedId]
+			if attempt == nil {
+				return nil, fmt.Errorf("%w: test case %s finished before it started", ErrInvalidTestReport, finished.TestCaseStartedId)
+			}
+			testCase := testCases[attempt.TestCaseId]
+			if testCase == nil || pickles[testCase.PickleId] == nil {
+				return nil, fmt.Errorf("%w: test case %s has no pickle", ErrInvalidTestReport, attempt.TestCaseId)
+			}
+			pickle := pickles[testCase.PickleId]
+
+			tags := make([]string, 0, len(pickle.Tags))
+			for _, tag := range pickle.Tags {
+				tags = append(tags, strings.TrimPrefix(tag.Name, "@"))
+			}
+			line := 0
+			if len(pickle.AstNodeIds) > 0 {
+				// The last node is the example row for outlines and the scenario otherwise.
+				line = lines[pickle.AstNodeIds[len(pickle.AstNodeIds)-1]]
+			}
+			status, durationNS, errorMessage := scenarioOutcome(steps[finished.TestCaseStartedId])
+			delete(steps, finished.TestCaseStartedId)
+
+			results = append(results, models.TestResult{
+				Name:         strings.TrimSpace(pickle.Name),
+				Feature:      features[pickle.Uri],
+				URI:          pickle.Uri,
+				Line:         line,
+				Tags:         sortedUnique(tags),
+				Status:       status,
+				DurationMS:   durationNS / 1e6,
+				ErrorMessage: errorMessage,
+				Attempt:      int(attempt.Attempt),
+				Retried:      finished.WillBeRetried,
+			})
+		}
+	}
+	return results, nil
+}
+
+// indexScenarioLines records the line of every scenario and example row, including those inside rules.
+func indexScenarioLines(children []*messages.FeatureChild, lines map[string]int) {
+	for _, child := range children {
+		var scenarios []*messages.Scenario
+		if child.Scenario != nil {
+			scenarios = append(scenarios, child.Scenario)
+		}
+		if child.Rule != nil {
+			for _, ruleChild := range child.Rule.Children {
+				if ruleChild.Scenario != nil {
+					scenarios = append(scenarios, ruleChild.Scenario)
+				}
+			}
+		}
+		for _, scenario := range scenarios {
+			lines[scenario.Id] = int(scenario.Location.Line)
+			for _, examples := range scenario.Examples {
+				for _, row := range examples.TableBody {
+					lines[row.Id] = int(row.Location.Line)
+				}
+			}
+		}
+	}
+}
+
+func messagesDurationNS(duration *messages.Duration) int64 {
+	if duration == nil {
+		return 0
+	}
+	return duration.Seconds*1e9 + duration.Nanos
+}
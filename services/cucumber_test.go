@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// testStudioUser carries credentials the fake server accepts until AllowCredentials is called.
+var testStudioUser = &models.User{Email: "qa@example.com", CucumberClientID: "client", CucumberAccessToken: "token"}
+
+// newTestClient returns a client of the fake server that retries without real backoff delays.
+func newTestClient(t *testing.T, studio *fakestudio.Server, maxRetries int) *CucumberClient {
+	t.Helper()
+	client, err := NewCucumberClient(CucumberClientConfig{
+		BaseURL:        studio.URL,
+		RequestTimeout: 5 * time.Second,
+		MaxRetries:     maxRetries,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     3 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewCucumberClient: %v", err)
+	}
+	return client
+}
+
+// requestsTo returns the requests the fake server received for a path.
+func requestsTo(studio *fakestudio.Server, method, path string) []fakestudio.RecordedRequest {
+	var matching []fakestudio.RecordedRequest
+	for _, request := range studio.Requests() {
+		if request.Method == method && request.Path == path {
+			matching = append(matching, request)
+		}
+	}
+	return matching
+}
+
+func TestCucumberClientFollowsNextLinks(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	studio.SetMaxPageSize(2)
+	client := newTestClient(t, studio, -1)
+	fixtures := fakestudio.DefaultFixtures()
+
+	scenarios, err := client.GetScenarios(context.Background(), testStudioUser, 1)
+	if err != nil {
+		t.Fatalf("GetScenarios: %v", err)
+	}
+	if len(scenarios) != len(fixtures.Scenarios["1"]) {
+		t.Fatalf("got %d scenarios, want all %d fixtures", len(scenarios), len(fixtures.Scenarios["1"]))
+	}
+
+	// 7 scenarios at 2 per page: 4 pages, walked in order through links.next.
+	requests := requestsTo(studio, http.MethodGet, "/projects/1/scenarios")
+	if len(requests) != 4 {
+		t.Fatalf("got %d scenario requests, want 4 pages", len(requests))
+	}
+	for i, request := range requests {
+		if got, want := request.Query.Get("page[number]"), strconv.Itoa(i+1); got != want {
+			t.Errorf("request %d asked for page %s, want %s", i, got, want)
+		}
+		if got := request.Query.Get("include"); got != "tags,datasets" {
+			t.Errorf("request %d lost the include parameter: %q", i, got)
+		}
+	}
+
+	// Tags are only included on the page that references them; every scenario
+	// must still get all of its tags once the pages are merged.
+	tagsByID := make(map[string]fakestudio.Tag)
+	for _, tag := range fixtures.Tags["1"] {
+		tagsByID[tag.ID] = tag
+	}
+	byID := make(map[string]models.Scenario)
+	for _, scenario := range scenarios {
+		byID[scenario.ID] = scenario
+	}
+	for _, fixture := range fixtures.Scenarios["1"] {
+		scenario, ok := byID[fixture.ID]
+		if !ok {
+			t.Errorf("scenario %s is missing", fixture.ID)
+			continue
+		}
+		var got, want []string
+		for _, tag := range scenario.Tags {
+			got = append(got, tag.Key+":"+tag.Value)
+		}
+		for _, tagID := range fixture.TagIDs {
+			want = append(want, tagsByID[tagID].Key+":"+tagsByID[tagID].Value)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("scenario %s has tags %v, want %v", fixture.ID, got, want)
+		}
+		if len(scenario.Details.Datasets) != len(fixture.Datasets) {
+			t.Errorf("scenario %s has %d datasets, want %d", fixture.ID, len(scenario.Details.Datasets), len(fixture.Datasets))
+		}
+	}
+}
+
+func TestCucumberClientPaginatesEveryList(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	studio.SetMaxPageSize(1)
+	client := newTestClient(t, studio, -1)
+	fixtures := fakestudio.DefaultFixtures()
+	ctx := context.Background()
+
+	projects, err := client.GetProjects(ctx, testStudioUser)
+	if err != nil {
+		t.Fatalf("GetProjects: %v", err)
+	}
+	if len(projects) != len(fixtures.Projects) {
+		t.Errorf("got %d projects, want %d", len(projects), len(fixtures.Projects))
+	}
+
+	folders, err := client.GetFolders(ctx, testStudioUser, 1)
+	if err != nil {
+		t.Fatalf("GetFolders: %v", err)
+	}
+	if len(folders) != len(fixtures.Folders["1"]) {
+		t.Errorf("got %d folders, want %d", len(folders), len(fixtures.Folders["1"]))
+	}
+	if got := len(requestsTo(studio, http.MethodGet, "/projects/1/folders")); got != len(fixtures.Folders["1"]) {
+		t.Errorf("got %d folder requests, want one per page", got)
+	}
+}
+
+func TestCucumberClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		failures     int
+		retryAfter   string
+		maxRetries   int
+		wantErr      error
+		wantRequests int
+		minWait      time.Duration
+	}{
+		{name: "rate limited, then succeeds", status: 429, failures: 2, maxRetries: 3, wantRequests: 3},
+		{name: "honors Retry-After", status: 429, failures: 1, retryAfter: "1", maxRetries: 3, wantRequests: 2, minWait: time.Second},
+		{name: "server error, then succeeds", status: 503, failures: 2, maxRetries: 3, wantRequests: 3},
+		{name: "gives up after max retries", status: 500, failures: 5, maxRetries: 2, wantErr: ErrStudioUnavailable, wantRequests: 3},
+		{name: "Retry-After beyond max backoff", status: 429, failures: 1, retryAfter: "120", maxRetries: 3, wantErr: ErrStudioRateLimited, wantRequests: 1},
+		{name: "not found is not retried", status: 404, failures: 1, maxRetries: 3, wantErr: ErrStudioNotFound, wantRequests: 1},
+		{name: "bad request is not retried", status: 422, failures: 1, maxRetries: 3, wantErr: ErrStudioBadRequest, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			studio := fakestudio.NewServer()
+			defer studio.Close()
+			studio.FailNext(tt.failures, tt.status, tt.retryAfter)
+			client := newTestClient(t, studio, tt.maxRetries)
+
+			start := time.Now()
+			_, err := client.GetProjects(context.Background(), testStudioUser)
+			elapsed := time.Since(start)
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("GetProjects: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := len(studio.Requests()); got != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", got, tt.wantRequests)
+			}
+			if elapsed < tt.minWait {
+				t.Errorf("retried after %s, want at least %s", elapsed, tt.minWait)
+			}
+		})
+	}
+}
+
+func TestCucumberClientRetryAfterOnError(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	studio.FailNext(1, 429, "7")
+	client := newTestClient(t, studio, -1)
+
+	_, err := client.GetProjects(context.Background(), testStudioUser)
+	var studioErr *StudioError
+	if !errors.As(err, &studioErr) {
+		t.Fatalf("got error %v, want a *StudioError", err)
+	}
+	if studioErr.StatusCode != 429 || studioErr.RetryAfter != 7*time.Second {
+		t.Errorf("got status %d and Retry-After %s, want 429 and 7s", studioErr.StatusCode, studioErr.RetryAfter)
+	}
+}
+
+func TestCucumberClientDoesNotRetryFailedWrites(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, 3)
+	ctx := context.Background()
+
+	// A 5xx on a write may have been applied: retrying could create a second run.
+	studio.FailNext(1, 502, "")
+	if _, err := client.CreateTestRun(ctx, testStudioUser, 1, "CI", []string{"1000"}); !errors.Is(err, ErrStudioUnavailable) {
+		t.Fatalf("got error %v, want ErrStudioUnavailable", err)
+	}
+	if got := len(studio.WriteRequests()); got != 1 {
+		t.Errorf("got %d write requests, want 1", got)
+	}
+
+	// A 429 was refused before doing anything, so it is safe to retry.
+	studio.FailNext(1, 429, "")
+	if _, err := client.CreateTestRun(ctx, testStudioUser, 1, "CI", []string{"1000"}); err != nil {
+		t.Fatalf("CreateTestRun after a 429: %v", err)
+	}
+	if got := len(studio.WriteRequests()); got != 3 {
+		t.Errorf("got %d write requests, want 3", got)
+	}
+	if got := len(studio.TestRuns("1")); got != 2 {
+		t.Errorf("got %d test runs, want the fixture and the created one", got)
+	}
+}
+
+func TestCucumberClientUnauthorized(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	studio.AllowCredentials(fakestudio.Credentials{AccessToken: "other", ClientID: "other", UID: testStudioUser.Email})
+	client := newTestClient(t, studio, 3)
+
+	if _, err := client.GetProjects(context.Background(), testStudioUser); !errors.Is(err, ErrStudioUnauthorized) {
+		t.Fatalf("got error %v, want ErrStudioUnauthorized", err)
+	}
+	if got := len(studio.Requests()); got != 1 {
+		t.Errorf("got %d requests, want 1: rejected credentials are not retried", got)
+	}
+}
+
+func TestPublicErrorHidesStudioBody(t *testing.T) {
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	studio.FailNext(1, 503, "")
+	client := newTestClient(t, studio, -1)
+
+	_, err := client.GetProjects(context.Background(), testStudioUser)
+	if !strings.Contains(err.Error(), `"errors"`) {
+		t.Fatalf("got error %q, want the response body kept for the logs", err)
+	}
+	want := "failed to fetch projects: " + ErrStudioUnavailable.Error() + " (503)"
+	if got := PublicError(err); got != want {
+		t.Errorf("got public error %q, want %q", got, want)
+	}
+	if got := PublicError(fmt.Errorf("failed to get projects: %w", err)); got != want {
+		t.Errorf("got public error %q through another wrapper, want %q", got, want)
+	}
+	if other := errors.New("plain failure"); PublicError(other) != other.Error() {
+		t.Errorf("PublicError changed an error that is not from Studio: %q", PublicError(other))
+	}
+}
+
+func TestCucumberClientKeepsUnreadableBodiesOutOfErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>internal token abc123</html>"))
+	}))
+	defer server.Close()
+	client, err := NewCucumberClient(CucumberClientConfig{BaseURL: server.URL, MaxRetries: -1})
+	if err != nil {
+		t.Fatalf("NewCucumberClient: %v", err)
+	}
+
+	_, err = client.GetProjects(context.Background(), testStudioUser)
+	if err == nil || !strings.Contains(err.Error(), "failed to unmarshal JSON") {
+		t.Fatalf("got error %v, want an unmarshal error", err)
+	}
+	if strings.Contains(err.Error(), "abc123") || strings.Contains(PublicError(err), "abc123") {
+		t.Errorf("error %q repeats the response body", err)
+	}
+}
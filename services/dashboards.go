@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrDashboardNotFound is returned for dashboards that do not exist or belong to another user.
+var ErrDashboardNotFound = errors.New("dashboard not found")
+
+const (
+	// maxDashboardWidgets bounds the layout of a dashboard.
+	maxDashboardWidgets = 50
+	// dashboardRenderConcurrency bounds how many widget queries of one render run at once.
+	dashboardRenderConcurrency = 4
+)
+
+const createDashboardsTableSQL = `
+    CREATE TABLE IF NOT EXISTS dashboards (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        layout TEXT NOT NULL DEFAULT '[]',
+        filters TEXT NOT NULL DEFAULT '{}',
+        user_id INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_dashboards_user ON dashboards (user_id, id);
+`
+
+func initDashboardTables() error {
+	if _, err := DB.Exec(createDashboardsTableSQL); err != nil {
+		return fmt.Errorf("failed to create dashboards table: %v", err)
+	}
+	return nil
+}
+
+const dashboardColumns = `id, name, layout, filters, user_id, created_at, updated_at`
+
+func scanDashboard(row rowScanner) (*models.Dashboard, error) {
+	var dashboard models.Dashboard
+	var layout, filters string
+	err := row.Scan(&dashboard.ID, &dashboard.Name, &layout, &filters, &dashboard.UserID, &dashboard.CreatedAt, &dashboard.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(layout), &dashboard.Layout); err != nil {
+		return nil, fmt.Errorf("failed to decode layout of dashboard %d: %v", dashboard.ID, err)
+	}
+	if err := json.Unmarshal([]byte(filters), &dashboard.Filters); err != nil {
+		return nil, fmt.Errorf("failed to decode filters of dashboard %d: %v", dashboard.ID, err)
+	}
+	return &dashboard, nil
+}
+
+// encodeDashboard returns the JSON stored in the layout and filters columns.
+func encodeDashboard(dashboard *models.Dashboard) (string, string, error) {
+	if dashboard.Layout == nil {
+		dashboard.Layout = make([]models.DashboardWidget, 0)
+	}
+	layout, err := json.Marshal(dashboard.Layout)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode dashboard layout: %v", err)
+	}
+	filters, err := json.Marshal(dashboard.Filters)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode dashboard filters: %v", err)
+	}
+	return string(layout), string(filters), nil
+}
+
+// ValidateDashboard checks a dashboard before it is saved: widgets must fit the
+// grid without overlapping and reference charts and data tables of the user.
+func ValidateDashboard(dashboard *models.Dashboard) error {
+	v := &ValidationError{}
+	validateVisualizationName(v, dashboard.Name)
+	if dashboard.Filters.ProjectID != nil && *dashboard.Filters.ProjectID <= 0 {
+		v.add("filters.project_id", "must be >= 1")
+	}
+	if dashboard.Filters.TagExpr != "" {
+		if _, err := ParseTagExpr(dashboard.Filters.TagExpr); err != nil {
+			v.add("filters.tag_expr", "%v", err)
+		}
+	}
+	if len(dashboard.Layout) > maxDashboardWidgets {
+		v.add("layout", "must have at most %d widgets", maxDashboardWidgets)
+		return v.err()
+	}
+
+	for i, widget := range dashboard.Layout {
+		field := fmt.Sprintf("layout[%d]", i)
+		switch {
+		case widget.W < 1 || widget.H < 1:
+			v.add(field, "w and h must be >= 1")
+		case widget.X < 0 || widget.Y < 0:
+			v.add(field, "x and y must be >= 0")
+		case widget.X+widget.W > models.DashboardGridColumns:
+			v.add(field, "x + w must be <= %d", models.DashboardGridColumns)
+		}
+		for j, other := range dashboard.Layout[:i] {
+			if widget.X < other.X+other.W && other.X < widget.X+widget.W && widget.Y < other.Y+other.H && other.Y < widget.Y+widget.H {
+				v.add(field, "overlaps layout[%d]", j)
+			}
+		}
+
+		var err error
+		switch widget.Kind {
+		case models.WidgetChart:
+			_, err = GetChart(widget.ID, dashboard.UserID)
+		case models.WidgetDataTable:
+			_, err = GetDataTable(widget.ID, dashboard.UserID)
+		default:
+			v.add(field+".kind", "must be %q or %q", models.WidgetChart, models.WidgetDataTable)
+			continue
+		}
+		if errors.Is(err, ErrChartNotFound) || errors.Is(err, ErrDataTableNotFound) {
+			v.add(field+".id", "%s %d not found", strings.ReplaceAll(string(widget.Kind), "_", " "), widget.ID)
+		} else if err != nil {
+			return err
+		}
+	}
+	return v.err()
+}
+
+// CreateDashboard creates a new dashboard
+func CreateDashboard(dashboard *models.Dashboard) error {
+	layout, filters, err := encodeDashboard(dashboard)
+	if err != nil {
+		return err
+	}
+	result, err := DB.Exec(
+		"INSERT INTO dashboards (name, layout, filters, user_id) VALUES (?, ?, ?, ?)",
+		dashboard.Name, layout, filters, dashboard.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create dashboard: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %v", err)
+	}
+	dashboard.ID = int(id)
+	return nil
+}
+
+// GetDashboard retrieves a dashboard of a user
+func GetDashboard(dashboardID, userID int) (*models.Dashboard, error) {
+	dashboard, err := scanDashboard(DB.QueryRow("SELECT "+dashboardColumns+" FROM dashboards WHERE id = ? AND user_id = ?", dashboardID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDashboardNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dashboard: %v", err)
+	}
+	return dashboard, nil
+}
+
+// GetDashboardsByUser retrieves all dashboards of a user
+func GetDashboardsByUser(userID int) ([]models.Dashboard, error) {
+	rows, err := DB.Query("SELECT "+dashboardColumns+" FROM dashboards WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dashboards: %v", err)
+	}
+	defer rows.Close()
+
+	dashboards := make([]models.Dashboard, 0)
+	for rows.Next() {
+		dashboard, err := scanDashboard(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dashboard: %v", err)
+		}
+		dashboards = append(dashboards, *dashboard)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return dashboards, nil
+}
+
+// UpdateDashboard saves every field of a dashboard of a user and bumps its updated_at
+func UpdateDashboard(dashboard *models.Dashboard) error {
+	layout, filters, err := encodeDashboard(dashboard)
+	if err != nil {
+		return err
+	}
+	result, err := DB.Exec(
+		`UPDATE dashboards SET name = ?, layout = ?, filters = ?, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND user_id = ?`,
+		dashboard.Name, layout, filters, dashboard.ID, dashboard.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update dashboard: %v", err)
+	}
+	return requireAffected(result, ErrDashboardNotFound)
+}
+
+// DeleteDashboard deletes a dashboard of a user. The charts and data tables it
+// shows are kept.
+func DeleteDashboard(dashboardID, userID int) error {
+	result, err := DB.Exec("DELETE FROM dashboards WHERE id = ? AND user_id = ?", dashboardID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete dashboard: %v", err)
+	}
+	return requireAffected(result, ErrDashboardNotFound)
+}
+
+// RenderDashboard runs the query of every widget of a dashboard, a few at a
+// time. Non-empty fields of overrides replace the dashboard's saved filters for
+// this render. A widget that fails carries its error; the others still render.
+func RenderDashboard(ctx context.Context, dashboardID, userID int, overrides models.DashboardFilters) (*models.DashboardRender, error) {
+	dashboard, err := GetDashboard(dashboardID, userID)
+	if err != nil {
+		return nil, err
+	}
+	filters := dashboard.Filters
+	if overrides.ProjectID != nil {
+		filters.ProjectID = overrides.ProjectID
+	}
+	if overrides.TagExpr != "" {
+		filters.TagExpr = overrides.TagExpr
+	}
+	if filters.TagExpr != "" {
+		if _, err := ParseTagExpr(filters.TagExpr); err != nil {
+			return nil, fmt.Errorf("%w: tag_expr: %v", ErrInvalidChartQuery, err)
+		}
+	}
+
+	render := &models.DashboardRender{
+		DashboardID: dashboard.ID,
+		Name:        dashboard.Name,
+		Filters:     filters,
+		Widgets:     make([]models.RenderedWidget, len(dashboard.Layout)),
+	}
+	sem := make(chan struct{}, dashboardRenderConcurrency)
+	var wg sync.WaitGroup
+	for i, widget := range dashboard.Layout {
+		wg.Add(1)
+		go func(i int, widget models.DashboardWidget) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				render.Widgets[i] = renderDashboardWidget(widget, filters, userID)
+			case <-ctx.Done():
+				render.Widgets[i] = models.RenderedWidget{DashboardWidget: widget, Error: ctx.Err().Error()}
+			}
+		}(i, widget)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return render, nil
+}
+
+// renderDashboardWidget renders one widget; tests replace it to observe a render.
+var renderDashboardWidget = renderWidget
+
+func renderWidget(widget models.DashboardWidget, filters models.DashboardFilters, userID int) models.RenderedWidget {
+	rendered := models.RenderedWidget{DashboardWidget: widget}
+	var err error
+	switch widget.Kind {
+	case models.WidgetChart:
+		rendered.Name, rendered.Chart, err = renderChartWidget(widget.ID, filters, userID)
+	case models.WidgetDataTable:
+		rendered.Name, rendered.Table, err = renderTableWidget(widget.ID, filters, userID)
+	default:
+		err = fmt.Errorf("unknown widget kind %q", widget.Kind)
+	}
+	if err != nil {
+		rendered.Error = err.Error()
+	}
+	return rendered
+}
+
+func renderChartWidget(chartID int, filters models.DashboardFilters, userID int) (string, *models.ChartData, error) {
+	chart, err := GetChart(chartID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	q, err := ParseChartQuery(chart.Type, chart.Query)
+	if err != nil {
+		return chart.Name, nil, err
+	}
+	applyDashboardFilters(&q.ProjectID, &q.Filters, filters)
+	data, err := runSavedChart(chart, q, userID)
+	if err != nil {
+		return chart.Name, nil, err
+	}
+	return chart.Name, data, nil
+}
+
+func renderTableWidget(tableID int, filters models.DashboardFilters, userID int) (string, *models.TableData, error) {
+	table, err := GetDataTable(tableID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	q, err := ParseTableQuery(table.Query)
+	if err != nil {
+		return table.Name, nil, err
+	}
+	columns, err := ParseTableColumns(q.Dataset, table.Columns)
+	if err != nil {
+		return table.Name, nil, err
+	}
+	applyDashboardFilters(&q.ProjectID, &q.Filters, filters)
+	data, err := runSavedTable(table, q, columns, userID)
+	if err != nil {
+		return table.Name, nil, err
+	}
+	return table.Name, data, nil
+}
+
+// applyDashboardFilters narrows the query of a widget. Folders belong to a
+// project, so a widget moved to another project loses its folder filter.
+func applyDashboardFilters(projectID *int, widgetFilters *models.ChartFilters, filters models.DashboardFilters) {
+	if filters.ProjectID != nil && *filters.ProjectID != *projectID {
+		*projectID = *filters.ProjectID
+		widgetFilters.FolderID = nil
+		widgetFilters.Recursive = false
+	}
+	if filters.TagExpr != "" {
+		if widgetFilters.TagExpr == "" {
+			widgetFilters.TagExpr = filters.TagExpr
+		} else {
+			widgetFilters.TagExpr = "(" + widgetFilters.TagExpr + ") and (" + filters.TagExpr + ")"
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"my-cucumber-backend/models"
+)
+
+func TestApplyDashboardFilters(t *testing.T) {
+	folder := 10
+	one, two := 1, 2
+	tests := []struct {
+		name          string
+		filters       models.DashboardFilters
+		widget        models.ChartFilters
+		wantProjectID int
+		wantFilters   models.ChartFilters
+	}{
+		{name: "no dashboard filters", widget: models.ChartFilters{FolderID: &folder, Recursive: true, TagExpr: "@smoke"}, wantProjectID: 1, wantFilters: models.ChartFilters{FolderID: &folder, Recursive: true, TagExpr: "@smoke"}},
+		{name: "same project keeps the folder", filters: models.DashboardFilters{ProjectID: &one}, widget: models.ChartFilters{FolderID: &folder, Recursive: true}, wantProjectID: 1, wantFilters: models.ChartFilters{FolderID: &folder, Recursive: true}},
+		{name: "other project drops the folder", filters: models.DashboardFilters{ProjectID: &two}, widget: models.ChartFilters{FolderID: &folder, Recursive: true, Keyword: "cart"}, wantProjectID: 2, wantFilters: models.ChartFilters{Keyword: "cart"}},
+		{name: "tag expression of the dashboard only", filters: models.DashboardFilters{TagExpr: "@smoke"}, wantProjectID: 1, wantFilters: models.ChartFilters{TagExpr: "@smoke"}},
+		{name: "tag expressions are AND-ed", filters: models.DashboardFilters{TagExpr: "@smoke or @wip"}, widget: models.ChartFilters{TagExpr: "not @slow"}, wantProjectID: 1, wantFilters: models.ChartFilters{TagExpr: "(not @slow) and (@smoke or @wip)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projectID, filters := 1, tt.widget
+			applyDashboardFilters(&projectID, &filters, tt.filters)
+			if projectID != tt.wantProjectID || !reflect.DeepEqual(filters, tt.wantFilters) {
+				t.Errorf("got project %d and %+v, want %d and %+v", projectID, filters, tt.wantProjectID, tt.wantFilters)
+			}
+		})
+	}
+}
+
+func TestRenderDashboard(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"}, models.Project{ID: "2", Name: "Mobile"})
+	for projectID, scenarios := range map[int][]models.Scenario{
+		1: {
+			{ID: "1000", Name: "Log in", FolderID: 10, Tags: []models.Tag{{Key: "smoke"}}},
+			{ID: "1001", Name: "Pay", FolderID: 11, Tags: []models.Tag{{Key: "regression"}}},
+		},
+		2: {{ID: "2000", Name: "Open the app", FolderID: 20, Tags: []models.Tag{{Key: "smoke"}}}},
+	} {
+		for _, scenario := range scenarios {
+			if err := CreateScenario(&scenario, projectID, user.ID); err != nil {
+				t.Fatalf("CreateScenario: %v", err)
+			}
+		}
+	}
+
+	tags := &models.Chart{Name: "Tags", Type: models.ChartTypeBar, Config: "{}", UserID: user.ID,
+		Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`}
+	deleted := &models.Chart{Name: "Gone", Type: models.ChartTypeBar, Config: "{}", UserID: user.ID,
+		Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "status"}}`}
+	for _, chart := range []*models.Chart{tags, deleted} {
+		if err := CreateChart(chart); err != nil {
+			t.Fatalf("CreateChart: %v", err)
+		}
+	}
+	table := &models.DataTable{Name: "Scenarios", Columns: `[{"key": "id"}]`, UserID: user.ID,
+		Query: `{"dataset": "scenarios", "project_id": 1, "filters": {"folder_id": 10}}`}
+	if err := CreateDataTable(table); err != nil {
+		t.Fatalf("CreateDataTable: %v", err)
+	}
+	dashboard := &models.Dashboard{Name: "Overview", UserID: user.ID, Layout: []models.DashboardWidget{
+		{Kind: models.WidgetChart, ID: tags.ID, W: 6, H: 4},
+		{Kind: models.WidgetChart, ID: deleted.ID, X: 6, W: 6, H: 4},
+		{Kind: models.WidgetDataTable, ID: table.ID, Y: 4, W: 12, H: 4},
+	}}
+	if err := CreateDashboard(dashboard); err != nil {
+		t.Fatalf("CreateDashboard: %v", err)
+	}
+	if err := DeleteChart(deleted.ID, user.ID); err != nil {
+		t.Fatalf("DeleteChart: %v", err)
+	}
+	ctx := context.Background()
+
+	// The deleted chart fails alone; the other widgets still render.
+	render, err := RenderDashboard(ctx, dashboard.ID, user.ID, models.DashboardFilters{})
+	if err != nil {
+		t.Fatalf("RenderDashboard: %v", err)
+	}
+	if len(render.Widgets) != 3 {
+		t.Fatalf("got %d widgets, want 3", len(render.Widgets))
+	}
+	chart, failed, rows := render.Widgets[0], render.Widgets[1], render.Widgets[2]
+	if chart.Error != "" || chart.Name != "Tags" || chart.Chart == nil || !reflect.DeepEqual(chart.Chart.Labels, []string{"regression", "smoke"}) {
+		t.Errorf("got chart widget %+v, want the tags of project 1", chart)
+	}
+	if failed.Error != ErrChartNotFound.Error() || failed.Chart != nil || failed.ID != deleted.ID || failed.X != 6 {
+		t.Errorf("got deleted chart widget %+v, want its layout and a not found error", failed)
+	}
+	if rows.Error != "" || rows.Table == nil || rows.Table.Total != 1 {
+		t.Errorf("got table widget %+v, want the one scenario of folder 10", rows)
+	}
+
+	// Overrides replace the saved filters; another project drops the table's folder.
+	two := 2
+	render, err = RenderDashboard(ctx, dashboard.ID, user.ID, models.DashboardFilters{ProjectID: &two, TagExpr: "@smoke"})
+	if err != nil {
+		t.Fatalf("RenderDashboard: %v", err)
+	}
+	if render.Filters.ProjectID == nil || *render.Filters.ProjectID != 2 || render.Filters.TagExpr != "@smoke" {
+		t.Errorf("got filters %+v, want the overrides", render.Filters)
+	}
+	if data := render.Widgets[0].Chart; data == nil || data.Rows != 1 || !reflect.DeepEqual(data.Labels, []string{"smoke"}) {
+		t.Errorf("got chart %+v, want the smoke scenario of project 2", data)
+	}
+	if data := render.Widgets[2].Table; data == nil || data.Total != 1 || data.Rows[0]["id"] != "2000" {
+		t.Errorf("got table %+v, want scenario 2000 despite the folder filter of project 1", data)
+	}
+
+	if _, err := RenderDashboard(ctx, dashboard.ID, user.ID, models.DashboardFilters{TagExpr: "@smoke and"}); !errors.Is(err, ErrInvalidChartQuery) {
+		t.Errorf("got error %v, want ErrInvalidChartQuery for an invalid tag expression", err)
+	}
+	other := createTestUser(t, "other@example.com", "c2", "t2", models.Project{ID: "1", Name: "Shop"})
+	if _, err := RenderDashboard(ctx, dashboard.ID, other.ID, models.DashboardFilters{}); !errors.Is(err, ErrDashboardNotFound) {
+		t.Errorf("got error %v, want ErrDashboardNotFound for another user's dashboard", err)
+	}
+}
+
+// blockingWidgets replaces the widget renderer with one that blocks until
+// release is closed and records how many widgets rendered at once.
+type blockingWidgets struct {
+	release chan struct{}
+
+	mu                      sync.Mutex
+	running, maxSeen, calls int
+}
+
+func stubWidgetRenderer(t *testing.T) *blockingWidgets {
+	t.Helper()
+	b := &blockingWidgets{release: make(chan struct{})}
+	old := renderDashboardWidget
+	t.Cleanup(func() { renderDashboardWidget = old })
+	renderDashboardWidget = func(widget models.DashboardWidget, _ models.DashboardFilters, _ int) models.RenderedWidget {
+		b.mu.Lock()
+		b.running++
+		b.calls++
+		if b.running > b.maxSeen {
+			b.maxSeen = b.running
+		}
+		b.mu.Unlock()
+		<-b.release
+		b.mu.Lock()
+		b.running--
+		b.mu.Unlock()
+		return models.RenderedWidget{DashboardWidget: widget, Name: "rendered"}
+	}
+	return b
+}
+
+// waitRunning waits until n widgets render at once.
+func (b *blockingWidgets) waitRunning(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		running := b.running
+		b.mu.Unlock()
+		if running == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d widgets are rendering, want %d", running, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func createWideDashboard(t *testing.T, widgets int) (*models.Dashboard, int) {
+	t.Helper()
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	dashboard := &models.Dashboard{Name: "Wide", UserID: user.ID}
+	for i := 0; i < widgets; i++ {
+		dashboard.Layout = append(dashboard.Layout, models.DashboardWidget{Kind: models.WidgetChart, ID: i + 1, Y: i, W: 1, H: 1})
+	}
+	if err := CreateDashboard(dashboard); err != nil {
+		t.Fatalf("CreateDashboard: %v", err)
+	}
+	return dashboard, user.ID
+}
+
+func TestRenderDashboardBoundsConcurrency(t *testing.T) {
+	dashboard, userID := createWideDashboard(t, 3*dashboardRenderConcurrency)
+	widgets := stubWidgetRenderer(t)
+
+	done := make(chan *models.DashboardRender)
+	go func() {
+		render, err := RenderDashboard(context.Background(), dashboard.ID, userID, models.DashboardFilters{})
+		if err != nil {
+			t.Errorf("RenderDashboard: %v", err)
+		}
+		done <- render
+	}()
+	widgets.waitRunning(t, dashboardRenderConcurrency)
+	time.Sleep(20 * time.Millisecond) // Give an extra widget the chance to start
+	close(widgets.release)
+	render := <-done
+
+	if widgets.maxSeen != dashboardRenderConcurrency {
+		t.Errorf("%d widgets rendered at once, want %d", widgets.maxSeen, dashboardRenderConcurrency)
+	}
+	if render == nil || len(render.Widgets) != len(dashboard.Layout) {
+		t.Fatalf("got render %+v, want every widget", render)
+	}
+	for i, widget := range render.Widgets {
+		if widget.Name != "rendered" || widget.Y != i {
+			t.Errorf("widget %d is %+v, want rendered in layout order", i, widget)
+		}
+	}
+}
+
+func TestRenderDashboardStopsWhenCanceled(t *testing.T) {
+	dashboard, userID := createWideDashboard(t, 2*dashboardRenderConcurrency)
+	widgets := stubWidgetRenderer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := RenderDashboard(ctx, dashboard.ID, userID, models.DashboardFilters{})
+		errs <- err
+	}()
+	widgets.waitRunning(t, dashboardRenderConcurrency)
+	cancel()
+	// Waiting widgets give up; running ones finish before RenderDashboard returns.
+	time.Sleep(20 * time.Millisecond)
+	close(widgets.release)
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if widgets.calls != dashboardRenderConcurrency {
+		t.Errorf("%d widgets rendered, want only the %d started before canceling", widgets.calls, dashboardRenderConcurrency)
+	}
+}
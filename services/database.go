@@ -2,17 +2,26 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3" // Import the SQLite driver
+	"github.com/mattn/go-sqlite3" // Import the SQLite driver
 )
 
 var DB *sql.DB // Exported database connection
 
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx, so helpers can run inside or outside a transaction.
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // InitializeDB initializes the database connection and creates all necessary tables.
 func InitializeDB(dbPath string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", dbPath)
+	DB, err = sql.Open("sqlite3", withSQLiteOptions(dbPath))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -39,7 +48,6 @@ func InitializeDB(dbPath string) error {
             name TEXT NOT NULL,
             folder_id INTEGER NOT NULL,
             project_id INTEGER NOT NULL,
-            tags TEXT,
             user_id INTEGER NOT NULL,
             FOREIGN KEY (user_id) REFERENCES users(id)
         );
@@ -49,6 +57,26 @@ func InitializeDB(dbPath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create scenarios table: %v", err)
 	}
+	// Scenarios are cached once per project, for every user with access to it.
+	if _, err := DB.Exec(`
+        DROP INDEX IF EXISTS idx_scenarios_project_user;
+        CREATE INDEX IF NOT EXISTS idx_scenarios_project ON scenarios (project_id, folder_id);
+    `); err != nil {
+		return fmt.Errorf("failed to create scenarios index: %v", err)
+	}
+	if _, err := DB.Exec(createTagTablesSQL); err != nil {
+		return fmt.Errorf("failed to create tag tables: %v", err)
+	}
+	if err := migrateScenarioTagsColumn(); err != nil {
+		return err
+	}
+	if err := initScenarioDetailTables(); err != nil {
+		return err
+	}
+	if err := initSearchIndex(); err != nil {
+		return err
+	}
+
 	createFoldersTableSQL := `
         CREATE TABLE IF NOT EXISTS folders (
             id TEXT PRIMARY KEY,
@@ -63,6 +91,9 @@ func InitializeDB(dbPath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create folders table:%v", err)
 	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_folders_project ON folders (project_id)"); err != nil {
+		return fmt.Errorf("failed to create folders index: %v", err)
+	}
 
 	// Create charts table
 	createChartsTableSQL := `
@@ -99,9 +130,145 @@ func InitializeDB(dbPath string) error {
 		return fmt.Errorf("failed to create data_tables table: %v", err)
 	}
 
+	// Background sync opt-in and bookkeeping
+	if err := addColumnIfMissing("users", "auto_sync", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "last_synced_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// Roles and disabled accounts
+	if err := addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'editor'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing("users", "disabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	createProjectSyncsTableSQL := `
+        CREATE TABLE IF NOT EXISTS project_syncs (
+            user_id INTEGER NOT NULL,
+            project_id INTEGER NOT NULL,
+            last_attempt_at DATETIME,
+            last_synced_at DATETIME,
+            last_error TEXT NOT NULL DEFAULT '',
+            added INTEGER NOT NULL DEFAULT 0,
+            updated INTEGER NOT NULL DEFAULT 0,
+            removed INTEGER NOT NULL DEFAULT 0,
+            unchanged INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY (user_id, project_id),
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        );
+    `
+	if _, err := DB.Exec(createProjectSyncsTableSQL); err != nil {
+		return fmt.Errorf("failed to create project_syncs table: %v", err)
+	}
+
+	createSyncJobsTableSQL := `
+        CREATE TABLE IF NOT EXISTS sync_jobs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL,
+            project_id INTEGER,
+            state TEXT NOT NULL,
+            projects_total INTEGER NOT NULL DEFAULT 0,
+            projects_done INTEGER NOT NULL DEFAULT 0,
+            added INTEGER NOT NULL DEFAULT 0,
+            updated INTEGER NOT NULL DEFAULT 0,
+            removed INTEGER NOT NULL DEFAULT 0,
+            unchanged INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            failed_projects TEXT NOT NULL DEFAULT '[]',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            started_at DATETIME,
+            finished_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        );
+        CREATE INDEX IF NOT EXISTS idx_sync_jobs_user ON sync_jobs (user_id, id);
+    `
+	if _, err := DB.Exec(createSyncJobsTableSQL); err != nil {
+		return fmt.Errorf("failed to create sync_jobs table: %v", err)
+	}
+	if err := addColumnIfMissing("sync_jobs", "failed_projects", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+
+	if err := initTestRunTables(); err != nil {
+		return err
+	}
+	if err := initDashboardTables(); err != nil {
+		return err
+	}
+	if err := initSessionTables(); err != nil {
+		return err
+	}
+	if err := initTeamTables(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// withSQLiteOptions makes concurrent writers (HTTP handlers and the background
+// scheduler) wait for the lock instead of failing with "database is locked".
+func withSQLiteOptions(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return dbPath + separator + "_busy_timeout=5000&_txlock=immediate"
+}
+
+// isUniqueViolation reports whether err is a failed UNIQUE constraint, e.g. a
+// concurrent insert of the same key.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// addColumnIfMissing adds a column to an existing table. CREATE TABLE IF NOT EXISTS
+// leaves databases created by older versions untouched, so new columns go through here.
+func addColumnIfMissing(table, column, definition string) error {
+	exists, err := columnExists(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %v", table, column, err)
+	}
 	return nil
 }
 
+// columnExists reports whether table has a column with the given name.
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, fmt.Errorf("failed to scan %s table info: %v", table, err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 // CloseDB closes the database connection.
 func CloseDB() {
 	if DB != nil {
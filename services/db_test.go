@@ -0,0 +1,45 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+// openTestDB points DB at a fresh database for the duration of a test. Tests
+// that use it share the global DB and must not run in parallel.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	openTestDBAt(t, filepath.Join(t.TempDir(), "test.db"))
+}
+
+// openTestDBAt is openTestDB for an existing database file, e.g. one in an older schema.
+func openTestDBAt(t *testing.T, path string) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCredentialKeys("test:" + base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatalf("SetCredentialKeys: %v", err)
+	}
+	if err := InitializeDB(path); err != nil {
+		t.Fatalf("InitializeDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+}
+
+// createTestUser registers a user whose Studio credentials see the given projects.
+func createTestUser(t *testing.T, email, clientID, accessToken string, projects ...models.Project) *models.User {
+	t.Helper()
+	user, err := CreateUser(email, "password", clientID, accessToken, func(*models.User) ([]models.Project, error) {
+		return projects, nil
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return user
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"my-cucumber-backend/models"
+
+	messages "github.com/cucumber/messages/go/v21"
+)
+
+// maxFeatureFileSize bounds how much of a single .feature file is read, so a
+// crafted archive cannot exhaust memory.
+const maxFeatureFileSize = 5 << 20
+
+// localScenario is a scenario found in a .feature file.
+type localScenario struct {
+	name       string
+	folderPath string
+	file       string
+	line       int
+	tags       []string // Without the leading @, sorted
+}
+
+// DiffFeatureFiles parses every .feature file in fsys and compares its scenarios
+// with the cached scenarios of a project. Files are expected where ExportFeatures
+// puts them: the scenarios of folder "Web Shop/Checkout" in "Web Shop/Checkout.feature".
+// Scenarios are matched by folder path and name; tags are compared as @key:value.
+func DiffFeatureFiles(fsys fs.FS, projectID, userID int) (*models.DriftReport, error) {
+	report := &models.DriftReport{
+		OnlyInStudio:  make([]models.DriftScenario, 0),
+		OnlyInCode:    make([]models.DriftScenario, 0),
+		TagMismatches: make([]models.TagDrift, 0),
+		ParseErrors:   make([]models.FeatureParseError, 0),
+	}
+
+	local, parseErrors, err := parseFeatureFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	report.ParseErrors = append(report.ParseErrors, parseErrors...)
+
+	scenarios, err := GetScenariosByProjectID(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := folderPaths(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Studio scenarios by folder path and name, in ID order so duplicates pair up predictably.
+	sort.Slice(scenarios, func(i, j int) bool { return lessScenarioID(scenarios[i].ID, scenarios[j].ID) })
+	studio := make(map[string][]models.Scenario)
+	studioPath := func(scenario models.Scenario) string {
+		if folderPath, ok := paths[strconv.Itoa(scenario.FolderID)]; ok {
+			return folderPath
+		}
+		return unfiledFeature
+	}
+	for _, scenario := range scenarios {
+		key := driftKey(studioPath(scenario), scenario.Name)
+		studio[key] = append(studio[key], scenario)
+	}
+
+	matched := make(map[string]bool)
+	for _, code := range local {
+		key := driftKey(code.folderPath, code.name)
+		candidates := studio[key]
+		if len(candidates) == 0 {
+			report.OnlyInCode = append(report.OnlyInCode, models.DriftScenario{
+				Name:       code.name,
+				FolderPath: code.folderPath,
+				File:       code.file,
+				Line:       code.line,
+				Tags:       code.tags,
+			})
+			continue
+		}
+		match := candidates[0]
+		studio[key] = candidates[1:]
+		matched[match.ID] = true
+
+		studioTags := scenarioTagNames(match.Tags)
+		missingInCode := tagDifference(studioTags, code.tags)
+		missingInStudio := tagDifference(code.tags, studioTags)
+		if len(missingInCode) == 0 && len(missingInStudio) == 0 {
+			report.InSync++
+			continue
+		}
+		report.TagMismatches = append(report.TagMismatches, models.TagDrift{
+			ScenarioID:      match.ID,
+			Name:            match.Name,
+			FolderPath:      code.folderPath,
+			File:            code.file,
+			Line:            code.line,
+			StudioTags:      studioTags,
+			CodeTags:        code.tags,
+			MissingInCode:   missingInCode,
+			MissingInStudio: missingInStudio,
+		})
+	}
+
+	for _, scenario := range scenarios {
+		if matched[scenario.ID] {
+			continue
+		}
+		report.OnlyInStudio = append(report.OnlyInStudio, models.DriftScenario{
+			ScenarioID: scenario.ID,
+			Name:       scenario.Name,
+			FolderPath: studioPath(scenario),
+			Tags:       scenarioTagNames(scenario.Tags),
+		})
+	}
+	return report, nil
+}
+
+func driftKey(folderPath, name string) string {
+	return folderPath + "\x00" + strings.TrimSpace(name)
+}
+
+// lessScenarioID orders numeric Studio IDs numerically and anything else as text.
+func lessScenarioID(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// scenarioTagNames renders tags the way they appear in exported .feature files, without the @.
+func scenarioTagNames(tags []models.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, strings.TrimPrefix(gherkinTag(tag), "@"))
+	}
+	return sortedUnique(names)
+}
+
+// tagDifference returns the tags in a that are not in b.
+func tagDifference(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, tag := range b {
+		inB[tag] = true
+	}
+	diff := make([]string, 0)
+	for _, tag := range a {
+		if !inB[tag] {
+			diff = append(diff, tag)
+		}
+	}
+	return diff
+}
+
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// parseFeatureFiles reads the scenarios of every .feature file in fsys. Files that
+// fail to parse are reported and skipped; only I/O errors abort the walk.
+func parseFeatureFiles(fsys fs.FS) ([]localScenario, []models.FeatureParseError, error) {
+	var scenarios []localScenario
+	var parseErrors []models.FeatureParseError
+
+	err := fs.WalkDir(fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// Skip metadata folders such as .git or the __MACOSX folder of zips made on macOS.
+			if filePath != "." && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "__MACOSX") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(entry.Name(), ".feature") {
+			return nil
+		}
+
+		file, err := fsys.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", filePath, err)
+		}
+		source, err := io.ReadAll(io.LimitReader(file, maxFeatureFileSize+1))
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", filePath, err)
+		}
+		if len(source) > maxFeatureFileSize {
+			parseErrors = append(parseErrors, models.FeatureParseError{File: filePath, Error: "file is larger than 5 MB"})
+			return nil
+		}
+
+		doc, err := parseGherkin(string(bytes.TrimPrefix(source, []byte("\xef\xbb\xbf"))))
+		if err != nil {
+			parseErrors = append(parseErrors, models.FeatureParseError{File: filePath, Error: err.Error()})
+			return nil
+		}
+		if doc.Feature == nil {
+			return nil // Only comments
+		}
+
+		folderPath := strings.TrimSuffix(filePath, path.Ext(filePath))
+		featureTags := gherkinTagNames(doc.Feature.Tags)
+		for _, child := range doc.Feature.Children {
+			switch {
+			case child.Scenario != nil:
+				scenarios = append(scenarios, newLocalScenario(child.Scenario, folderPath, filePath, featureTags))
+			case child.Rule != nil:
+				ruleTags := append(append([]string{}, featureTags...), gherkinTagNames(child.Rule.Tags)...)
+				for _, ruleChild := range child.Rule.Children {
+					if ruleChild.Scenario != nil {
+						scenarios = append(scenarios, newLocalScenario(ruleChild.Scenario, folderPath, filePath, ruleTags))
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read feature files: %v", err)
+	}
+	return scenarios, parseErrors, nil
+}
+
+// newLocalScenario converts a parsed scenario. Scenarios inherit the tags of their feature and rule.
+func newLocalScenario(scenario *messages.Scenario, folderPath, file string, inheritedTags []string) localScenario {
+	tags := append(append([]string{}, inheritedTags...), gherkinTagNames(scenario.Tags)...)
+	return localScenario{
+		name:       strings.TrimSpace(scenario.Name),
+		folderPath: folderPath,
+		file:       file,
+		line:       int(scenario.Location.Line),
+		tags:       sortedUnique(tags),
+	}
+}
+
+func gherkinTagNames(tags []*messages.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, strings.TrimPrefix(tag.Name, "@"))
+	}
+	return names
+}
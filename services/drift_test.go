@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+func TestDiffFeatureFiles(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+	if _, err := RefreshFolders(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshFolders: %v", err)
+	}
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+
+	code := fstest.MapFS{
+		"Web Shop/Authentication.feature": {Data: []byte(`@priority:high
+Feature: Authentication
+
+  @smoke
+  Scenario: Login with valid credentials
+    Given a customer
+
+  @regression
+  Scenario Outline: Login with a wrong password
+    Given a customer "<email>"
+
+    Examples:
+      | email           |
+      | bob@example.com |
+
+  Scenario: Sign up
+    Given a visitor
+`)},
+		"Web Shop/Checkout.feature": {Data: []byte("\xef\xbb\xbf" + `Feature: Checkout
+
+  @smoke @wip
+  Scenario: Add an item to the cart
+    Given a cart
+
+  Rule: Removing items
+    @regression
+    Scenario: Remove an item from the cart
+      Given a cart with a mug
+`)},
+		"Web Shop/Checkout/Payments.feature":   {Data: []byte("Feature: Payments\n  Scenario: Pay\n    Given a cart\n  this is not Gherkin\n")},
+		".git/Web Shop/Stale.feature":          {Data: []byte("Feature: Stale\n  Scenario: Old\n")},
+		"__MACOSX/Web Shop/._Checkout.feature": {Data: []byte("\x00\x05\x16\x07")},
+		"README.md":                            {Data: []byte("# Features\n")},
+	}
+
+	report, err := DiffFeatureFiles(code, 1, user.ID)
+	if err != nil {
+		t.Fatalf("DiffFeatureFiles: %v", err)
+	}
+
+	// 1000 and 1001 match, the feature's tag included.
+	if report.InSync != 2 {
+		t.Errorf("got %d scenarios in sync, want 2", report.InSync)
+	}
+	wantOnlyInCode := []models.DriftScenario{{
+		Name: "Sign up", FolderPath: "Web Shop/Authentication", File: "Web Shop/Authentication.feature", Line: 16,
+		Tags: []string{"priority:high"},
+	}}
+	if !reflect.DeepEqual(report.OnlyInCode, wantOnlyInCode) {
+		t.Errorf("only in code: %+v, want %+v", report.OnlyInCode, wantOnlyInCode)
+	}
+	var onlyInStudio []string
+	for _, scenario := range report.OnlyInStudio {
+		onlyInStudio = append(onlyInStudio, scenario.ScenarioID)
+	}
+	// Payments.feature does not parse, so its scenarios count as missing from code.
+	if want := []string{"1002", "1005", "1006"}; !reflect.DeepEqual(onlyInStudio, want) {
+		t.Errorf("only in Studio: %v, want %v", onlyInStudio, want)
+	}
+	wantMismatches := []models.TagDrift{
+		{
+			ScenarioID: "1003", Name: "Add an item to the cart", FolderPath: "Web Shop/Checkout",
+			File: "Web Shop/Checkout.feature", Line: 4,
+			StudioTags: []string{"smoke"}, CodeTags: []string{"smoke", "wip"},
+			MissingInCode: []string{}, MissingInStudio: []string{"wip"},
+		},
+		{
+			ScenarioID: "1004", Name: "Remove an item from the cart", FolderPath: "Web Shop/Checkout",
+			File: "Web Shop/Checkout.feature", Line: 9,
+			StudioTags: []string{"regression", "wip"}, CodeTags: []string{"regression"},
+			MissingInCode: []string{"wip"}, MissingInStudio: []string{},
+		},
+	}
+	if !reflect.DeepEqual(report.TagMismatches, wantMismatches) {
+		t.Errorf("tag mismatches: %+v, want %+v", report.TagMismatches, wantMismatches)
+	}
+	if len(report.ParseErrors) != 1 || report.ParseErrors[0].File != "Web Shop/Checkout/Payments.feature" {
+		t.Errorf("parse errors: %+v, want one for Payments.feature", report.ParseErrors)
+	}
+	if !report.HasDrift() {
+		t.Error("HasDrift is false for a report with differences")
+	}
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"my-cucumber-backend/models"
+)
+
+// unfiledFeature holds exported scenarios whose folder is not in the local cache.
+const unfiledFeature = "Unfiled"
+
+// FeatureFile is a rendered .feature file, Path being relative to the export root.
+type FeatureFile struct {
+	Path      string
+	Content   string
+	Scenarios int // Number of scenarios in the file
+}
+
+// ExportFeatures renders the scenarios matching q as Gherkin, one feature per
+// folder. Files are laid out like the folder hierarchy: a folder "Checkout"
+// under "Web Shop" becomes "Web Shop/Checkout.feature". Scenarios whose steps
+// have not been synced are exported with their name and tags only.
+// Pagination fields of q are ignored.
+func ExportFeatures(q ScenarioQuery) ([]FeatureFile, error) {
+	q.Limit, q.Offset, q.Cursor = 0, 0, ""
+	page, err := SearchScenarios(q)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := folderPaths(q.ProjectID, q.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	type feature struct {
+		name      string
+		scenarios []string
+	}
+	features := make(map[string]*feature)
+	for _, scenario := range page.Scenarios {
+		folderPath, ok := paths[strconv.Itoa(scenario.FolderID)]
+		if !ok {
+			folderPath = unfiledFeature
+		}
+		f := features[folderPath]
+		if f == nil {
+			f = &feature{name: path.Base(folderPath)}
+			features[folderPath] = f
+		}
+
+		details, err := loadScenarioDetails(DB, scenario.ID)
+		if err != nil {
+			return nil, err
+		}
+		f.scenarios = append(f.scenarios, renderGherkinScenario(scenario, details))
+	}
+
+	files := make([]FeatureFile, 0, len(features))
+	for folderPath, f := range features {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Feature: %s\n", f.name)
+		for _, scenario := range f.scenarios {
+			b.WriteString("\n")
+			b.WriteString(scenario)
+		}
+		files = append(files, FeatureFile{Path: folderPath + ".feature", Content: b.String(), Scenarios: len(f.scenarios)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// WriteFeatureZip writes feature files to w as a zip archive.
+func WriteFeatureZip(w io.Writer, files []FeatureFile) error {
+	archive := zip.NewWriter(w)
+	now := time.Now()
+	for _, file := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: file.Path, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to the archive: %v", file.Path, err)
+		}
+		if _, err := io.WriteString(entry, file.Content); err != nil {
+			return fmt.Errorf("failed to write %s to the archive: %v", file.Path, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish the archive: %v", err)
+	}
+	return nil
+}
+
+// folderPaths maps every folder of a project to its slash-separated path in
+// the hierarchy returned by GetFoldersHierarchy, e.g. "Web Shop/Checkout".
+// Folder names are made safe for file systems, and siblings with the same
+// name are told apart by their ID.
+func folderPaths(projectID, userID int) (map[string]string, error) {
+	roots, err := GetFoldersHierarchy(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]string)
+	var walk func(parent string, folders []models.Folder)
+	walk = func(parent string, folders []models.Folder) {
+		sort.Slice(folders, func(i, j int) bool { return folders[i].ID < folders[j].ID })
+		used := make(map[string]bool)
+		for _, folder := range folders {
+			name := safeFileName(folder.Name)
+			if name == "" {
+				name = "folder-" + folder.ID
+			}
+			if used[strings.ToLower(name)] {
+				name = fmt.Sprintf("%s (%s)", name, folder.ID)
+			}
+			used[strings.ToLower(name)] = true
+
+			folderPath := path.Join(parent, name)
+			paths[folder.ID] = folderPath
+			walk(folderPath, folder.Children)
+		}
+	}
+	walk("", roots)
+	return paths, nil
+}
+
+// safeFileName replaces characters that are not allowed in file names on common systems.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
+
+// renderGherkinScenario renders a scenario, indented for use inside a Feature.
+func renderGherkinScenario(scenario models.Scenario, details *models.ScenarioDetails) string {
+	var b strings.Builder
+
+	if len(scenario.Tags) > 0 {
+		tags := make([]string, len(scenario.Tags))
+		for i, tag := range scenario.Tags {
+			tags[i] = gherkinTag(tag)
+		}
+		fmt.Fprintf(&b, "  %s\n", strings.Join(tags, " "))
+	}
+
+	outline := len(details.Datatable) > 1
+	keyword := "Scenario"
+	if outline {
+		keyword = "Scenario Outline"
+	}
+	fmt.Fprintf(&b, "  %s: %s\n", keyword, scenario.Name)
+
+	if description := strings.TrimSpace(details.Description); description != "" {
+		for _, line := range strings.Split(description, "\n") {
+			fmt.Fprintf(&b, "    %s\n", strings.TrimSpace(line))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(details.Steps) == 0 {
+		b.WriteString("    # Steps have not been synced from Cucumber Studio\n")
+	}
+	for _, step := range details.Steps {
+		fmt.Fprintf(&b, "    %s %s\n", step.Keyword, step.Text)
+		writeGherkinTable(&b, "      ", step.DataTable)
+		if step.DocString != "" {
+			delimiter := `"""`
+			if strings.Contains(step.DocString, delimiter) {
+				delimiter = "```"
+			}
+			fmt.Fprintf(&b, "      %s\n", delimiter)
+			for _, line := range strings.Split(step.DocString, "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+			fmt.Fprintf(&b, "      %s\n", delimiter)
+		}
+	}
+
+	if outline {
+		b.WriteString("\n    Examples:\n")
+		writeGherkinTable(&b, "      ", details.Datatable)
+	}
+	return b.String()
+}
+
+// gherkinTag renders a tag as @key or @key:value. Gherkin tags end at
+// whitespace, so spaces are replaced by underscores.
+func gherkinTag(tag models.Tag) string {
+	text := tag.Key
+	if tag.Value != "" {
+		text += ":" + tag.Value
+	}
+	return "@" + strings.Join(strings.Fields(text), "_")
+}
+
+// writeGherkinTable writes rows as a Gherkin table with aligned columns.
+func writeGherkinTable(b *strings.Builder, indent string, rows [][]string) {
+	if len(rows) == 0 {
+		return
+	}
+	var widths []int
+	escaped := make([][]string, len(rows))
+	for i, row := range rows {
+		escaped[i] = make([]string, len(row))
+		for j, cell := range row {
+			cell = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", `\n`).Replace(cell)
+			escaped[i][j] = cell
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := len([]rune(cell)); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+	for _, row := range escaped {
+		b.WriteString(indent + "|")
+		for j, width := range widths {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			fmt.Fprintf(b, " %s%s |", cell, strings.Repeat(" ", width-len([]rune(cell))))
+		}
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+func TestExportFeatures(t *testing.T) {
+	openTestDB(t)
+	fixtures := fakestudio.DefaultFixtures()
+	fixtures.Scenarios["1"] = append(fixtures.Scenarios["1"], fakestudio.Scenario{ID: "1100", Name: "Archived scenario", FolderID: 99})
+	studio := fakestudio.NewServerWithFixtures(fixtures)
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+	if _, err := RefreshFolders(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshFolders: %v", err)
+	}
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+
+	files, err := ExportFeatures(ScenarioQuery{ProjectID: 1, UserID: user.ID})
+	if err != nil {
+		t.Fatalf("ExportFeatures: %v", err)
+	}
+	// Folders 11 and 12 are under 10 "Web Shop", 13 under 12; folder 99 is not cached.
+	wantFiles := map[string]int{
+		"Unfiled.feature":                    1,
+		"Web Shop/Authentication.feature":    3,
+		"Web Shop/Checkout.feature":          2,
+		"Web Shop/Checkout/Payments.feature": 2,
+	}
+	byPath := make(map[string]FeatureFile)
+	for _, file := range files {
+		byPath[file.Path] = file
+		if file.Scenarios != wantFiles[file.Path] {
+			t.Errorf("%s has %d scenarios, want %d", file.Path, file.Scenarios, wantFiles[file.Path])
+		}
+	}
+	if len(files) != len(wantFiles) {
+		t.Fatalf("exported %d files, want %v", len(files), wantFiles)
+	}
+
+	wantPayments := `Feature: Payments
+
+  @smoke @priority:high
+  Scenario: Pay with a credit card
+    Card payments are authorized by the payment gateway.
+
+    Given a cart worth "42.00"
+    When the customer pays with the card "4242 4242 4242 4242"
+    Then the order is confirmed
+
+  Scenario: Pay with a gift card
+    # Steps have not been synced from Cucumber Studio
+`
+	if got := byPath["Web Shop/Checkout/Payments.feature"].Content; got != wantPayments {
+		t.Errorf("Payments.feature is\n%s\nwant\n%s", got, wantPayments)
+	}
+
+	authentication := byPath["Web Shop/Authentication.feature"].Content
+	for _, want := range []string{
+		"  Scenario Outline: Login with a wrong password\n",
+		"    Examples:\n" +
+			"      | name           | email           | password |\n" +
+			"      | empty password | bob@example.com |          |\n" +
+			"      | wrong password | bob@example.com | hunter2  |\n",
+		"      \"\"\"\n      Follow the link below to choose a new password.\n      \"\"\"\n",
+	} {
+		if !strings.Contains(authentication, want) {
+			t.Errorf("Authentication.feature lacks %q:\n%s", want, authentication)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := WriteFeatureZip(&archive, files); err != nil {
+		t.Fatalf("WriteFeatureZip: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	if len(reader.File) != len(files) {
+		t.Fatalf("archive has %d entries, want %d", len(reader.File), len(files))
+	}
+	for i, entry := range reader.File {
+		if entry.Name != files[i].Path {
+			t.Errorf("entry %d is %s, want %s", i, entry.Name, files[i].Path)
+		}
+		f, err := entry.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", entry.Name, err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(content) != files[i].Content {
+			t.Errorf("entry %s holds %q (%v), want the rendered feature", entry.Name, content, err)
+		}
+	}
+}
+
+func TestFolderPathsAreSafeAndUnique(t *testing.T) {
+	openTestDB(t)
+	fixtures := fakestudio.DefaultFixtures()
+	root := "10"
+	fixtures.Folders["1"] = []fakestudio.Folder{
+		{ID: "10", Name: "Web: Shop"},
+		{ID: "11", Name: "Cart", ParentID: &root},
+		{ID: "12", Name: "cart", ParentID: &root},
+		{ID: "13", Name: "..", ParentID: &root},
+	}
+	studio := fakestudio.NewServerWithFixtures(fixtures)
+	defer studio.Close()
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	if _, err := RefreshFolders(context.Background(), newTestClient(t, studio, -1), user, 1); err != nil {
+		t.Fatalf("RefreshFolders: %v", err)
+	}
+
+	paths, err := folderPaths(1, user.ID)
+	if err != nil {
+		t.Fatalf("folderPaths: %v", err)
+	}
+	want := map[string]string{
+		"10": "Web_ Shop",
+		"11": "Web_ Shop/Cart",
+		"12": "Web_ Shop/cart (12)",
+		"13": "Web_ Shop/folder-13",
+	}
+	for id, wantPath := range want {
+		if paths[id] != wantPath {
+			t.Errorf("folder %s has path %q, want %q", id, paths[id], wantPath)
+		}
+	}
+}
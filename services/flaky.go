@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"my-cucumber-backend/models"
+)
+
+// Weights of the flakiness components. Flips between passing and failing are
+// the strongest signal, retries that hide a failure the next, and unstable
+// durations only hint at timing-dependent behaviour.
+const (
+	flipWeight      = 0.5
+	retryPassWeight = 0.3
+	durationWeight  = 0.2
+)
+
+// MaxFlakyWindow bounds how many recent runs FindFlakyScenarios looks at.
+const MaxFlakyWindow = 200
+
+// FlakyReport ranks the scenarios of a project by flakiness, most flaky first.
+type FlakyReport struct {
+	Scenarios []models.FlakyScenario `json:"data"`
+	Total     int                    `json:"total"`
+	Runs      int                    `json:"runs"` // Test runs analyzed
+}
+
+// FindFlakyScenarios scores every cached scenario of a project that ran in the
+// last window test runs and returns the limit highest scores above zero.
+// Results that matched no cached scenario are ignored.
+func FindFlakyScenarios(projectID, userID, window, limit int) (*FlakyReport, error) {
+	if window <= 0 || window > MaxFlakyWindow {
+		window = MaxFlakyWindow
+	}
+	if err := CheckProjectAccess(userID, projectID); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(
+		`SELECT tr.id, tr.name, tr.created_at, r.scenario_id, r.status, r.duration_ms, r.error_message, r.retried
+         FROM test_results r JOIN test_runs tr ON tr.id = r.run_id
+         WHERE r.run_id IN (SELECT id FROM test_runs WHERE project_id = ? AND user_id = ? ORDER BY id DESC LIMIT ?)
+             AND r.scenario_id IS NOT NULL
+         ORDER BY tr.id, r.position`,
+		projectID, userID, window,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test results: %v", err)
+	}
+	defer rows.Close()
+
+	history := make(map[string][]*models.FlakyRun) // By scenario ID, oldest run first
+	runIDs := make(map[int]bool)
+	for rows.Next() {
+		var run models.FlakyRun
+		var scenarioID, errorMessage string
+		var status models.TestStatus
+		var durationMS int64
+		var retried bool
+		err := rows.Scan(&run.RunID, &run.RunName, &run.CreatedAt, &scenarioID, &status, &durationMS, &errorMessage, &retried)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan test result: %v", err)
+		}
+		runIDs[run.RunID] = true
+
+		runs := history[scenarioID]
+		if len(runs) == 0 || runs[len(runs)-1].RunID != run.RunID {
+			run.Status = models.TestSkipped
+			runs = append(runs, &run)
+			history[scenarioID] = runs
+		}
+		addFlakyAttempt(runs[len(runs)-1], status, durationMS, errorMessage, retried)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	report := &FlakyReport{Scenarios: make([]models.FlakyScenario, 0), Runs: len(runIDs)}
+	if len(history) == 0 {
+		return report, nil
+	}
+
+	ids := make([]interface{}, 0, len(history))
+	for id := range history {
+		ids = append(ids, id)
+	}
+	scenarios, err := queryScenarios(DB, "s.id IN ("+placeholders(len(ids))+") AND s.project_id = ?",
+		append(ids, projectID)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scenario := range scenarios {
+		flaky := scoreFlakiness(history[scenario.ID])
+		if flaky.Score == 0 {
+			continue
+		}
+		flaky.Scenario = scenario
+		report.Scenarios = append(report.Scenarios, flaky)
+	}
+	sort.Slice(report.Scenarios, func(i, j int) bool {
+		a, b := report.Scenarios[i], report.Scenarios[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return lessScenarioID(a.Scenario.ID, b.Scenario.ID)
+	})
+	report.Total = len(report.Scenarios)
+	if limit > 0 && len(report.Scenarios) > limit {
+		report.Scenarios = report.Scenarios[:limit]
+	}
+	return report, nil
+}
+
+// addFlakyAttempt folds one result into the scenario's outcome for its run.
+// Retried attempts only count towards Attempts; of the final attempts, a
+// failure outranks a pass, which outranks skipped and pending ones.
+func addFlakyAttempt(run *models.FlakyRun, status models.TestStatus, durationMS int64, errorMessage string, retried bool) {
+	run.Attempts++
+	if retried {
+		run.PassedRetry = true // Cleared by scoreFlakiness unless the run passed
+		return
+	}
+	run.DurationMS += durationMS
+	switch {
+	case status == models.TestFailed:
+		if run.Status != models.TestFailed {
+			run.ErrorMessage = errorMessage
+		}
+		run.Status = models.TestFailed
+	case status == models.TestPassed && run.Status != models.TestFailed:
+		run.Status = models.TestPassed
+	case run.Status == models.TestSkipped:
+		run.Status = status
+	}
+}
+
+// scoreFlakiness computes the flakiness components over runs, oldest first.
+// Runs where the scenario was only skipped or pending do not count.
+func scoreFlakiness(runs []*models.FlakyRun) models.FlakyScenario {
+	flaky := models.FlakyScenario{Runs: make([]models.FlakyRun, 0, len(runs))}
+	var flips, retryPasses int
+	var previous models.TestStatus
+	var durations []float64
+	for _, run := range runs {
+		if run.Status != models.TestPassed {
+			run.PassedRetry = false // Retried, but never passed
+		}
+		flaky.Runs = append(flaky.Runs, *run)
+		if run.Status != models.TestPassed && run.Status != models.TestFailed {
+			continue
+		}
+
+		flaky.Executions++
+		if previous != "" && run.Status != previous {
+			flips++
+		}
+		previous = run.Status
+		if run.PassedRetry {
+			retryPasses++
+		}
+		if run.Status == models.TestPassed {
+			durations = append(durations, float64(run.DurationMS))
+		}
+	}
+
+	if flaky.Executions > 1 {
+		flaky.FlipRate = float64(flips) / float64(flaky.Executions-1)
+	}
+	if flaky.Executions > 0 {
+		flaky.RetryPassRate = float64(retryPasses) / float64(flaky.Executions)
+	}
+	flaky.DurationCV = math.Min(coefficientOfVariation(durations), 1)
+	flaky.Score = round3(flipWeight*flaky.FlipRate + retryPassWeight*flaky.RetryPassRate + durationWeight*flaky.DurationCV)
+	flaky.FlipRate = round3(flaky.FlipRate)
+	flaky.RetryPassRate = round3(flaky.RetryPassRate)
+	flaky.DurationCV = round3(flaky.DurationCV)
+	return flaky
+}
+
+// coefficientOfVariation is the standard deviation of values relative to their
+// mean, or 0 for fewer than two values or a zero mean.
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(squares/float64(len(values))) / mean
+}
+
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
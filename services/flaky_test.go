@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestScoreFlakiness(t *testing.T) {
+	passed := func(durationMS int64) *models.FlakyRun {
+		return &models.FlakyRun{Status: models.TestPassed, DurationMS: durationMS}
+	}
+	failed := func() *models.FlakyRun { return &models.FlakyRun{Status: models.TestFailed} }
+
+	tests := []struct {
+		name           string
+		runs           []*models.FlakyRun
+		wantScore      float64
+		wantExecutions int
+	}{
+		{name: "stable", runs: []*models.FlakyRun{passed(100), passed(100), passed(100)}, wantScore: 0, wantExecutions: 3},
+		{name: "always failing", runs: []*models.FlakyRun{failed(), failed()}, wantScore: 0, wantExecutions: 2},
+		// Every execution flips: flip rate 1.
+		{name: "alternating", runs: []*models.FlakyRun{passed(100), failed(), passed(100), failed()}, wantScore: 0.5, wantExecutions: 4},
+		// One pass in three needed a retry: retry pass rate 1/3.
+		{name: "passed on retry", runs: []*models.FlakyRun{passed(100), {Status: models.TestPassed, DurationMS: 100, PassedRetry: true}, passed(100)}, wantScore: 0.1, wantExecutions: 3},
+		{name: "retried but failed", runs: []*models.FlakyRun{{Status: models.TestFailed, PassedRetry: true}, failed()}, wantScore: 0, wantExecutions: 2},
+		// Durations 100 and 300: mean 200, standard deviation 100.
+		{name: "unstable duration", runs: []*models.FlakyRun{passed(100), passed(300)}, wantScore: 0.1, wantExecutions: 2},
+		// The skipped run neither counts as an execution nor breaks the flip.
+		{name: "skipped runs", runs: []*models.FlakyRun{passed(100), {Status: models.TestSkipped}, failed()}, wantScore: 0.5, wantExecutions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := scoreFlakiness(tt.runs)
+			if flaky.Score != tt.wantScore || flaky.Executions != tt.wantExecutions {
+				t.Errorf("got score %v over %d executions, want %v over %d", flaky.Score, flaky.Executions, tt.wantScore, tt.wantExecutions)
+			}
+			if len(flaky.Runs) != len(tt.runs) {
+				t.Errorf("got %d runs, want all %d", len(flaky.Runs), len(tt.runs))
+			}
+		})
+	}
+}
+
+func TestFindFlakyScenarios(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	for _, scenario := range []models.Scenario{
+		{ID: "1000", Name: "Log in", FolderID: 1},
+		{ID: "1001", Name: "Pay", FolderID: 1},
+		{ID: "1002", Name: "Search", FolderID: 1},
+	} {
+		if err := CreateScenario(&scenario, 1, user.ID); err != nil {
+			t.Fatalf("CreateScenario: %v", err)
+		}
+	}
+
+	runs := [][]models.TestResult{
+		{
+			{Name: "Log in", Status: models.TestPassed, DurationMS: 100},
+			{Name: "Pay", Status: models.TestPassed, DurationMS: 100},
+			{Name: "Search", Status: models.TestPassed, DurationMS: 100},
+		},
+		{
+			{Name: "Log in", Status: models.TestFailed, ErrorMessage: "timeout"},
+			{Name: "Pay", Status: models.TestFailed, Retried: true},
+			{Name: "Pay", Status: models.TestPassed, Attempt: 1, DurationMS: 100},
+			{Name: "Search", Status: models.TestPassed, DurationMS: 100},
+		},
+		{
+			{Name: "Log in", Status: models.TestPassed, DurationMS: 100},
+			{Name: "Pay", Status: models.TestPassed, DurationMS: 100},
+			{Name: "Search", Status: models.TestPassed, DurationMS: 100},
+			{Name: "Sign up", Status: models.TestFailed},
+		},
+	}
+	for i, results := range runs {
+		if _, err := CreateTestRun(user.ID, 1, "Nightly", "junit-xml", results); err != nil {
+			t.Fatalf("CreateTestRun %d: %v", i, err)
+		}
+	}
+
+	report, err := FindFlakyScenarios(1, user.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("FindFlakyScenarios: %v", err)
+	}
+	// Log in flips twice in three runs; Pay needed a retry once; Search is stable.
+	if report.Runs != 3 || report.Total != 2 || len(report.Scenarios) != 2 {
+		t.Fatalf("got %d of %d scenarios over %d runs, want 2 over 3", len(report.Scenarios), report.Total, report.Runs)
+	}
+	login, pay := report.Scenarios[0], report.Scenarios[1]
+	if login.Scenario.ID != "1000" || login.Score != 0.5 || login.FlipRate != 1 {
+		t.Errorf("got %+v first, want Log in with score 0.5", login)
+	}
+	if pay.Scenario.ID != "1001" || pay.Score != 0.1 || pay.RetryPassRate != 0.333 || pay.Runs[1].Attempts != 2 {
+		t.Errorf("got %+v second, want Pay with score 0.1 and 2 attempts in the second run", pay)
+	}
+	if login.Runs[1].ErrorMessage != "timeout" {
+		t.Errorf("got runs %+v, want the failure message kept", login.Runs)
+	}
+
+	limited, err := FindFlakyScenarios(1, user.ID, 0, 1)
+	if err != nil {
+		t.Fatalf("FindFlakyScenarios: %v", err)
+	}
+	if limited.Total != 2 || len(limited.Scenarios) != 1 {
+		t.Errorf("limit 1 returned %d of %d scenarios, want 1 of 2", len(limited.Scenarios), limited.Total)
+	}
+
+	// The latest run alone shows no flakiness.
+	latest, err := FindFlakyScenarios(1, user.ID, 1, 0)
+	if err != nil {
+		t.Fatalf("FindFlakyScenarios: %v", err)
+	}
+	if latest.Runs != 1 || len(latest.Scenarios) != 0 {
+		t.Errorf("window 1 found %d flaky scenarios over %d runs, want none over 1", len(latest.Scenarios), latest.Runs)
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,13 +9,18 @@ import (
 	"my-cucumber-backend/models"
 )
 
-// CreateFolder inserts a new folder into the database.
+// CreateFolder inserts a new folder into the database. userID is the user whose
+// refresh cached it; the folder is visible to everyone with access to the project.
 func CreateFolder(folder *models.Folder, projectID, userID int) error {
+	return createFolder(DB, folder, projectID, userID)
+}
+
+func createFolder(db dbExecutor, folder *models.Folder, projectID, userID int) error {
 	var parentID *string // Use a pointer for nullable parent_id
 	if folder.ParentID != nil {
 		parentID = folder.ParentID
 	}
-	_, err := DB.Exec(
+	_, err := db.Exec(
 		"INSERT INTO folders (id, name, parent_id, project_id, user_id) VALUES (?, ?, ?, ?, ?)",
 		folder.ID, folder.Name, parentID, projectID, userID,
 	)
@@ -24,32 +30,47 @@ func CreateFolder(folder *models.Folder, projectID, userID int) error {
 	return nil
 }
 
-// RefreshFolders fetches folders from Cucumber Studio, deletes existing folders for the project,
-// and inserts the new folders.
-func RefreshFolders(user *models.User, projectID int) error {
-	log.Printf("Refreshing folders for project ID: %d, user ID: %s", projectID, user.ID)
+// RefreshFolders fetches folders from Cucumber Studio and applies the difference to the
+// local cache in a single transaction, returning what was added, updated and removed.
+// Like scenarios, the folders of a project are cached once for every user.
+func RefreshFolders(ctx context.Context, client StudioClient, user *models.User, projectID int) (*models.RefreshSummary, error) {
+	log.Printf("Refreshing folders for project ID: %d, user ID: %d", projectID, user.ID)
 
 	// 1. Fetch latest folders from Cucumber Studio
-	folders, err := GetFolders(user, projectID)
+	folders, err := client.GetFolders(ctx, user, projectID)
 	if err != nil {
-		log.Printf("Error fetching folders from Cucumber Studio: %v", err)         // Log the specific error
-		return fmt.Errorf("failed to fetch folders from Cucumber Studio: %w", err) // Wrap the error
+		log.Printf("Error fetching folders from Cucumber Studio: %v", err)              // Log the specific error
+		return nil, fmt.Errorf("failed to fetch folders from Cucumber Studio: %w", err) // Wrap the error
 	}
-	log.Println("Successfully fetched folders from Cucumber Studio")
+	log.Printf("Successfully fetched %d folders from Cucumber Studio", len(folders))
 
 	userID := user.ID
 
-	// 2. Delete existing folders for this project and user
-	err = DeleteFoldersByProjectID(projectID, userID)
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// 2. Load the cached folders, keyed by ID
+	cached, err := getFoldersByProjectIDTx(tx, projectID)
 	if err != nil {
-		log.Printf("Error deleting existing folders: %v", err) // Log the specific error
-		// Consider not returning here; log the error but try to insert new ones.
-		// return fmt.Errorf("failed to delete existing folders: %w", err)
+		return nil, err
+	}
+	existing := make(map[string]models.Folder, len(cached))
+	for _, folder := range cached {
+		existing[folder.ID] = folder
 	}
-	log.Println("Successfully deleted existing folders (if any)")
 
-	// 3. Insert the new folders.
+	// 3. Upsert what changed
+	summary := &models.RefreshSummary{}
+	seen := make(map[string]bool, len(folders))
 	for _, folderData := range folders {
+		if seen[folderData.ID] {
+			continue
+		}
+		seen[folderData.ID] = true
+
 		var parentIDPtr *string
 		if folderData.Attributes.ParentID != "" {
 			parentIDStr := string(folderData.Attributes.ParentID)
@@ -62,22 +83,63 @@ func RefreshFolders(user *models.User, projectID int) error {
 			ParentID: parentIDPtr,
 		}
 
-		err = CreateFolder(&folder, projectID, userID)
-		if err != nil {
-			log.Printf("Error creating folder (ID: %s): %v", folder.ID, err)          // Log the specific error
-			return fmt.Errorf("failed to create folder (ID: %s): %w", folder.ID, err) // Wrap error
+		old, ok := existing[folder.ID]
+		switch {
+		case !ok:
+			if err := createFolder(tx, &folder, projectID, userID); err != nil {
+				log.Printf("Error creating folder (ID: %s): %v", folder.ID, err)               // Log the specific error
+				return nil, fmt.Errorf("failed to create folder (ID: %s): %w", folder.ID, err) // Wrap error
+			}
+			summary.Added++
+		case old.Name != folder.Name || !sameParent(old.ParentID, folder.ParentID):
+			_, err := tx.Exec(
+				"UPDATE folders SET name = ?, parent_id = ? WHERE id = ? AND project_id = ?",
+				folder.Name, folder.ParentID, folder.ID, projectID,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update folder (ID: %s): %v", folder.ID, err)
+			}
+			summary.Updated++
+		default:
+			summary.Unchanged++
 		}
-		log.Printf("Folder (id: %s) created successfully", folder.ID)
 	}
-	log.Println("Successfully refreshed folders")
-	return nil
+
+	// 4. Remove folders that no longer exist in Studio
+	for id := range existing {
+		if seen[id] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM folders WHERE id = ? AND project_id = ?", id, projectID); err != nil {
+			return nil, fmt.Errorf("failed to delete folder (ID: %s): %v", id, err)
+		}
+		summary.Removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit folder refresh: %v", err)
+	}
+	log.Printf("Successfully refreshed folders: %+v", *summary)
+	return summary, nil
 }
 
-// getFoldersByProjectID retrieves all folders for a given project and user from the *local database*.
-func getFoldersByProjectID(projectID, userID int) ([]models.Folder, error) {
-	rows, err := DB.Query(
-		"SELECT id, name, parent_id FROM folders WHERE project_id = ? AND user_id = ?",
-		projectID, userID,
+// sameParent compares two nullable parent IDs.
+func sameParent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// getFoldersByProjectID retrieves all folders for a given project from the *local database*.
+func getFoldersByProjectID(projectID int) ([]models.Folder, error) {
+	return getFoldersByProjectIDTx(DB, projectID)
+}
+
+func getFoldersByProjectIDTx(db dbExecutor, projectID int) ([]models.Folder, error) {
+	rows, err := db.Query(
+		"SELECT id, name, parent_id FROM folders WHERE project_id = ?",
+		projectID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query folders: %v", err)
@@ -102,9 +164,12 @@ func getFoldersByProjectID(projectID, userID int) ([]models.Folder, error) {
 	return folders, nil
 }
 
-// GetFoldersHierarchy builds the hierarchical folder structure.
+// GetFoldersHierarchy builds the hierarchical folder structure of a project the user can access.
 func GetFoldersHierarchy(projectID, userID int) ([]models.Folder, error) {
-	allFolders, err := getFoldersByProjectID(projectID, userID)
+	if err := CheckProjectAccess(userID, projectID); err != nil {
+		return nil, err
+	}
+	allFolders, err := getFoldersByProjectID(projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -143,9 +208,9 @@ func getChildFolders(parentID string, folderMap map[string]*models.Folder) []mod
 	return children
 }
 
-// DeleteFoldersByProjectID deletes all folders associated with a project and user.
-func DeleteFoldersByProjectID(projectID, userID int) error {
-	_, err := DB.Exec("DELETE FROM folders WHERE project_id = ? and user_id = ?", projectID, userID)
+// DeleteFoldersByProjectID deletes the cached folders of a project, for every user.
+func DeleteFoldersByProjectID(projectID int) error {
+	_, err := DB.Exec("DELETE FROM folders WHERE project_id = ?", projectID)
 	if err != nil {
 		return fmt.Errorf("failed to delete folders: %v", err)
 	}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// junitXMLParser reads JUnit XML reports, as written by the junit formatter of
+// Cucumber and by most other test runners. Every <testcase> is a scenario whose
+// name is the scenario name and whose classname is the feature name. JUnit has
+// no tags, so results are matched to scenarios by name only.
+type junitXMLParser struct{}
+
+func (junitXMLParser) Format() string { return "junit-xml" }
+
+func (junitXMLParser) MediaTypes() []string { return []string{"application/xml", "text/xml"} }
+
+// Sniff accepts an XML document.
+func (junitXMLParser) Sniff(head []byte) bool { return firstByte(head) == '<' }
+
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	File      string         `xml:"file,attr"`
+	Line      int            `xml:"line,attr"`
+	Time      string         `xml:"time,attr"` // Seconds
+	Failure   *junitProblem  `xml:"failure"`
+	Error     *junitProblem  `xml:"error"`
+	Skipped   *junitProblem  `xml:"skipped"`
+	Flaky     []junitProblem `xml:",any"` // Surefire's flakyFailure, flakyError, rerunFailure and rerunError
+}
+
+type junitProblem struct {
+	XMLName    xml.Name
+	Message    string `xml:"message,attr"`
+	Text       string `xml:",chardata"`
+	StackTrace string `xml:"stackTrace"`
+}
+
+func (p *junitProblem) message() string {
+	if p.Message != "" {
+		return p.Message
+	}
+	if text := strings.TrimSpace(p.Text); text != "" {
+		return text
+	}
+	return strings.TrimSpace(p.StackTrace)
+}
+
+// Parse returns one result per <testcase>, at any depth of <testsuite> nesting.
+// Attempts recorded by Maven Surefire's rerunFailingTestsCount come out as
+// separate results, the failed ones marked as retried.
+func (junitXMLParser) Parse(r io.Reader) ([]models.TestResult, error) {
+	results := make([]models.TestResult, 0)
+	var suites []string // Names of the enclosing <testsuite> elements
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: not a JUnit XML report: %v", ErrInvalidTestReport, err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "testsuite":
+				suites = append(suites, xmlAttr(element, "name"))
+			case "testcase":
+				var testCase junitTestCase
+				if err := decoder.DecodeElement(&testCase, &element); err != nil {
+					return nil, fmt.Errorf("%w: not a JUnit XML report: %v", ErrInvalidTestReport, err)
+				}
+				suite := ""
+				if len(suites) > 0 {
+					suite = suites[len(suites)-1]
+				}
+				results = append(results, junitResults(testCase, suite)...)
+			}
+		case xml.EndElement:
+			if element.Name.Local == "testsuite" && len(suites) > 0 {
+				suites = suites[:len(suites)-1]
+			}
+		}
+	}
+	return results, nil
+}
+
+// junitResults converts a test case into its attempts, the final one last.
+func junitResults(testCase junitTestCase, suite string) []models.TestResult {
+	base := models.TestResult{
+		Name:    strings.TrimSpace(testCase.Name),
+		Feature: strings.TrimSpace(testCase.ClassName),
+		URI:     testCase.File,
+		Line:    testCase.Line,
+		Tags:    []string{},
+	}
+	if base.Feature == "" {
+		base.Feature = strings.TrimSpace(suite)
+	}
+
+	var results []models.TestResult
+	for _, problem := range testCase.Flaky {
+		switch problem.XMLName.Local {
+		case "flakyFailure", "flakyError", "rerunFailure", "rerunError":
+			attempt := base
+			attempt.Status = models.TestFailed
+			attempt.ErrorMessage = problem.message()
+			results = append(results, attempt)
+		}
+	}
+
+	final := base
+	if seconds, err := strconv.ParseFloat(strings.ReplaceAll(testCase.Time, ",", ""), 64); err == nil {
+		final.DurationMS = int64(seconds * 1000)
+	}
+	switch {
+	case testCase.Failure != nil:
+		final.Status = models.TestFailed
+		final.ErrorMessage = testCase.Failure.message()
+	case testCase.Error != nil:
+		final.Status = models.TestFailed
+		final.ErrorMessage = testCase.Error.message()
+	case testCase.Skipped != nil:
+		final.Status = models.TestSkipped
+	default:
+		final.Status = models.TestPassed
+	}
+
+	if final.Status == models.TestFailed && len(results) > 0 {
+		// Surefire reports the first failure on <failure> and the reruns after it.
+		results = append([]models.TestResult{final}, results...)
+	} else {
+		results = append(results, final)
+	}
+	for i := range results {
+		results[i].Attempt = i
+		results[i].Retried = i < len(results)-1
+	}
+	return results
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"my-cucumber-backend/models"
+)
+
+// The scenario and folder caches are shared: there is one copy of a project,
+// whoever refreshed it. Who may read it is decided by the projects each user's
+// Cucumber Studio credentials can see, as stored by the last project refresh.
+
+// ErrProjectAccessDenied is returned when a user's Cucumber Studio credentials
+// cannot see a project.
+var ErrProjectAccessDenied = errors.New("project is not visible to your Cucumber Studio credentials")
+
+// CheckProjectAccess returns nil if the project is among the projects of the user.
+func CheckProjectAccess(userID, projectID int) error {
+	var projects string
+	err := DB.QueryRow("SELECT COALESCE(projects, '') FROM users WHERE id = ?", userID).Scan(&projects)
+	if err != nil {
+		return fmt.Errorf("failed to query user projects: %v", err)
+	}
+	ids, err := projectIDs(projects)
+	if err != nil {
+		return err
+	}
+	if !ids[projectID] {
+		return ErrProjectAccessDenied
+	}
+	return nil
+}
+
+// projectIDs parses the projects JSON stored for a user.
+func projectIDs(projectsJSON string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	if projectsJSON == "" {
+		return ids, nil
+	}
+	var projects []models.Project
+	if err := json.Unmarshal([]byte(projectsJSON), &projects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user projects: %v", err)
+	}
+	for _, project := range projects {
+		if id, err := strconv.Atoi(project.ID); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
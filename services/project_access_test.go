@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestCheckProjectAccess(t *testing.T) {
+	openTestDB(t)
+	shop := createTestUser(t, "shop@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"}, models.Project{ID: "not-a-number", Name: "Legacy"})
+	none := createTestUser(t, "none@example.com", "c2", "t2")
+	unsynced := createTestUser(t, "unsynced@example.com", "c3", "t3")
+	corrupt := createTestUser(t, "corrupt@example.com", "c4", "t4")
+	if _, err := DB.Exec("UPDATE users SET projects = NULL WHERE id = ?", unsynced.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DB.Exec("UPDATE users SET projects = '{' WHERE id = ?", corrupt.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    int
+		projectID int
+		want      error
+	}{
+		{name: "own project", userID: shop.ID, projectID: 1},
+		{name: "project of someone else", userID: shop.ID, projectID: 2, want: ErrProjectAccessDenied},
+		{name: "no projects", userID: none.ID, projectID: 1, want: ErrProjectAccessDenied},
+		{name: "projects never synced", userID: unsynced.ID, projectID: 1, want: ErrProjectAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckProjectAccess(tt.userID, tt.projectID); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// A broken projects column is a server error, not a denial.
+	if err := CheckProjectAccess(corrupt.ID, 1); err == nil || errors.Is(err, ErrProjectAccessDenied) {
+		t.Errorf("got error %v, want a decoding error", err)
+	}
+}
+
+func TestProjectCachesFollowStudioProjects(t *testing.T) {
+	openTestDB(t)
+	owner := createTestUser(t, "owner@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"})
+	colleague := createTestUser(t, "colleague@example.com", "c2", "t2", models.Project{ID: "1", Name: "Shop"})
+	stranger := createTestUser(t, "stranger@example.com", "c3", "t3", models.Project{ID: "2", Name: "Mobile"})
+	scenario := models.Scenario{ID: "1000", Name: "Log in", FolderID: 10}
+	if err := CreateScenario(&scenario, 1, owner.ID); err != nil {
+		t.Fatalf("CreateScenario: %v", err)
+	}
+
+	// The cache refreshed by one user serves everyone who sees the project.
+	scenarios, err := GetScenariosByProjectID(1, colleague.ID)
+	if err != nil || len(scenarios) != 1 {
+		t.Errorf("colleague got %d scenarios, %v; want the cached one", len(scenarios), err)
+	}
+
+	denied := map[string]func() error{
+		"GetScenariosByProjectID": func() error { _, err := GetScenariosByProjectID(1, stranger.ID); return err },
+		"SearchScenarios":         func() error { _, err := SearchScenarios(ScenarioQuery{ProjectID: 1, UserID: stranger.ID}); return err },
+		"GetFoldersHierarchy":     func() error { _, err := GetFoldersHierarchy(1, stranger.ID); return err },
+		"FindFlakyScenarios":      func() error { _, err := FindFlakyScenarios(1, stranger.ID, 0, 0); return err },
+	}
+	for name, call := range denied {
+		if err := call(); !errors.Is(err, ErrProjectAccessDenied) {
+			t.Errorf("%s: got error %v, want ErrProjectAccessDenied", name, err)
+		}
+	}
+	if _, err := GetScenarioByID("1000", stranger.ID); !errors.Is(err, ErrScenarioNotFound) {
+		t.Errorf("GetScenarioByID: got error %v, want ErrScenarioNotFound", err)
+	}
+
+	// Access follows the projects stored by the latest refresh.
+	if err := UpdateUserProjects(stranger, []models.Project{{ID: "1", Name: "Shop"}, {ID: "2", Name: "Mobile"}}); err != nil {
+		t.Fatalf("UpdateUserProjects: %v", err)
+	}
+	if _, err := GetScenariosByProjectID(1, stranger.ID); err != nil {
+		t.Errorf("got error %v once the project is visible", err)
+	}
+	if err := UpdateUserProjects(colleague, []models.Project{}); err != nil {
+		t.Fatalf("UpdateUserProjects: %v", err)
+	}
+	if _, err := GetScenariosByProjectID(1, colleague.ID); !errors.Is(err, ErrProjectAccessDenied) {
+		t.Errorf("got error %v once the project is gone, want ErrProjectAccessDenied", err)
+	}
+}
+
+func TestSharedChartNeedsTheProject(t *testing.T) {
+	openTestDB(t)
+	owner := createTestUser(t, "owner@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"})
+	member := createTestUser(t, "member@example.com", "c2", "t2", models.Project{ID: "2", Name: "Mobile"})
+	team := &models.Team{Name: "QA", CreatedBy: owner.ID}
+	if err := CreateTeam(team); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if _, err := AddTeamMember(team.ID, owner.ID, member.Email, models.RoleViewer); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+	chart := &models.Chart{Name: "Tags", Type: models.ChartTypeBar, Config: "{}", UserID: owner.ID, TeamID: &team.ID,
+		Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`}
+	if err := CreateChart(chart); err != nil {
+		t.Fatalf("CreateChart: %v", err)
+	}
+
+	if _, err := GetChartData(chart.ID, owner.ID); err != nil {
+		t.Errorf("owner: got error %v", err)
+	}
+	// The member sees the chart but not the data of a project their credentials cannot see.
+	if _, err := GetChart(chart.ID, member.ID); err != nil {
+		t.Errorf("member: GetChart got error %v", err)
+	}
+	if _, err := GetChartData(chart.ID, member.ID); !errors.Is(err, ErrProjectAccessDenied) {
+		t.Errorf("member: got error %v, want ErrProjectAccessDenied", err)
+	}
+}
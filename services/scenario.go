@@ -1,7 +1,11 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"my-cucumber-backend/models"
@@ -9,28 +13,46 @@ import (
 	"strings"
 )
 
-// CreateScenario creates a scenario record.
+// CreateScenario creates a scenario record. userID is the user whose refresh
+// cached it; the scenario is visible to everyone with access to the project.
 func CreateScenario(scenario *models.Scenario, projectID, userID int) error {
-	tagsJSON, err := json.Marshal(scenario.Tags)
+	tx, err := DB.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to marshal tags to JSON: %v", err)
+		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	_, err = DB.Exec(
-		"INSERT INTO scenarios (id, name, folder_id, project_id, tags, user_id) VALUES (?, ?, ?, ?, ?, ?)",
-		scenario.ID, scenario.Name, scenario.FolderID, projectID, string(tagsJSON), userID,
+	if err := createScenario(tx, scenario, projectID, userID); err != nil {
+		return err
+	}
+	if err := reindexScenario(tx, scenario.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createScenario(db dbExecutor, scenario *models.Scenario, projectID, userID int) error {
+	_, err := db.Exec(
+		"INSERT INTO scenarios (id, name, folder_id, project_id, user_id) VALUES (?, ?, ?, ?, ?)",
+		scenario.ID, scenario.Name, scenario.FolderID, projectID, userID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert scenario: %v", err)
 	}
-	return nil
+	if err := setScenarioTags(db, scenario.ID, scenario.Tags); err != nil {
+		return err
+	}
+	return setScenarioDetails(db, scenario.ID, scenario.Details)
 }
 
-// GetScenariosByProjectID retrieves all scenarios for a given project and user.
-func GetScenariosByProjectID(projectID, userID int) ([]models.Scenario, error) {
-	rows, err := DB.Query(
-		"SELECT id, name, folder_id, project_id, tags FROM scenarios WHERE project_id = ? AND user_id = ?",
-		projectID, userID,
+// queryScenarios runs a SELECT over scenarios s (with a WHERE clause and optional
+// ordering) and returns the matching scenarios with their tags and step counts.
+func queryScenarios(db dbExecutor, where string, args ...interface{}) ([]models.Scenario, error) {
+	rows, err := db.Query(
+		`SELECT s.id, s.name, s.folder_id, s.project_id,
+             (SELECT COUNT(*) FROM scenario_steps st WHERE st.scenario_id = s.id)
+         FROM scenarios s WHERE `+where,
+		args...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query scenarios: %v", err)
@@ -40,240 +62,456 @@ func GetScenariosByProjectID(projectID, userID int) ([]models.Scenario, error) {
 	scenarios := make([]models.Scenario, 0)
 	for rows.Next() {
 		var scenario models.Scenario
-		var tagsJSON string
-		if err := rows.Scan(&scenario.ID, &scenario.Name, &scenario.FolderID, &scenario.ProjectID, &tagsJSON); err != nil {
+		if err := rows.Scan(&scenario.ID, &scenario.Name, &scenario.FolderID, &scenario.ProjectID, &scenario.StepCount); err != nil {
 			return nil, fmt.Errorf("failed to scan scenario row: %v", err)
 		}
-
-		if err := json.Unmarshal([]byte(tagsJSON), &scenario.Tags); err != nil {
-			// Log the error, but maybe don't fail the entire operation.
-			log.Printf("Error unmarshaling tags for scenario %s: %v", scenario.ID, err)
-			scenario.Tags = []models.Tag{} // Set to empty to avoid returning garbage data
-		}
 		scenarios = append(scenarios, scenario)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error during rows iteration: %v", err)
 	}
+	rows.Close()
+
+	if err := attachTags(db, scenarios); err != nil {
+		return nil, err
+	}
 	return scenarios, nil
 }
 
-// GetScenariosByTags retrieves scenarios matching ALL provided tags for a given project and user.
-func GetScenariosByTags(projectID, userID int, tags []string) ([]models.Scenario, error) {
-	if len(tags) == 0 {
-		return []models.Scenario{}, nil
+// GetScenariosByProjectID retrieves all scenarios of a project the user can access.
+func GetScenariosByProjectID(projectID, userID int) ([]models.Scenario, error) {
+	if err := CheckProjectAccess(userID, projectID); err != nil {
+		return nil, err
 	}
+	return queryScenarios(DB, "s.project_id = ?", projectID)
+}
 
-	// Build the query to match both key and value
-	query := `
-        SELECT DISTINCT s.id, s.name, s.folder_id, s.project_id, s.tags
-        FROM scenarios s
-        WHERE s.project_id = ? AND s.user_id = ?
-    `
-	args := []interface{}{projectID, userID}
+// ScenarioSort is a sort key accepted by SearchScenarios.
+type ScenarioSort string
 
-	// For each tag in format "key:value", add a condition
-	for _, tag := range tags {
-		parts := strings.Split(tag, ":")
-		if len(parts) != 2 {
-			continue
-		}
-		// Add JSON path condition to match key and value pair
-		query += ` AND s.tags LIKE ?`
-		// Look for both key and value in the JSON
-		args = append(args, fmt.Sprintf(`%%"key":"%s","value":"%s"%%`, parts[0], parts[1]))
-	}
+const (
+	SortByName   ScenarioSort = "name"
+	SortByFolder ScenarioSort = "folder"
+	SortByID     ScenarioSort = "id"
+)
 
-	log.Printf("Query: %s, Args: %v", query, args) // Add logging for debugging
+// scenarioSortColumns maps sort keys to the SQL expression they order by.
+// Every sort is made total with s.id as the tie-breaker.
+var scenarioSortColumns = map[ScenarioSort]string{
+	SortByName:   "s.name",
+	SortByFolder: "s.folder_id",
+	SortByID:     "CAST(s.id AS INTEGER)",
+}
 
-	rows, err := DB.Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query scenarios by tags: %v", err)
-	}
-	defer rows.Close()
+// MaxScenarioPageSize caps ScenarioQuery.Limit.
+const MaxScenarioPageSize = 1000
+
+// ScenarioQuery combines every scenario filter. Zero values mean "no filter".
+type ScenarioQuery struct {
+	ProjectID int
+	UserID    int // Must have access to the project
+	FolderID  *int
+	Recursive bool     // Include scenarios of every subfolder of FolderID
+	Tags      []string // "key:value" pairs that must all match
+	TagExpr   TagExpr  // Cucumber tag expression
+	Keyword   string   // Substring of the scenario name
+
+	Sort       ScenarioSort // Defaults to SortByID
+	Descending bool
+	Limit      int    // Zero returns every match
+	Offset     int    // Ignored when Cursor is set
+	Cursor     string // Opaque cursor from a previous ScenarioPage.NextCursor
+}
 
-	scenarios := make([]models.Scenario, 0)
-	for rows.Next() {
-		var scenario models.Scenario
-		var tagsJSON string
+// ScenarioPage is one page of SearchScenarios results.
+type ScenarioPage struct {
+	Scenarios  []models.Scenario `json:"data"`
+	Total      int               `json:"total"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+	NextOffset *int              `json:"next_offset"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
 
-		if err := rows.Scan(&scenario.ID, &scenario.Name, &scenario.FolderID, &scenario.ProjectID, &tagsJSON); err != nil {
-			return nil, fmt.Errorf("failed to scan scenario row: %v", err)
-		}
+// ErrInvalidScenarioQuery is wrapped by SearchScenarios errors caused by bad input.
+var ErrInvalidScenarioQuery = errors.New("invalid scenario query")
+
+// scenarioCursor is the position after the last scenario of a page.
+type scenarioCursor struct {
+	Sort  ScenarioSort `json:"s"`
+	Desc  bool         `json:"d"`
+	Value interface{}  `json:"v"`
+	ID    string       `json:"id"`
+}
 
-		if err := json.Unmarshal([]byte(tagsJSON), &scenario.Tags); err != nil {
-			log.Printf("Error unmarshaling tags for scenario %s: %v", scenario.ID, err)
-			scenario.Tags = []models.Tag{}
+// where builds the filter shared by the count and page queries.
+func (q *ScenarioQuery) where() (string, []interface{}) {
+	conds := []string{"s.project_id = ?"}
+	args := []interface{}{q.ProjectID}
+
+	if q.FolderID != nil {
+		if q.Recursive {
+			conds = append(conds, `s.folder_id IN (
+                WITH RECURSIVE subtree(id) AS (
+                    SELECT id FROM folders WHERE id = ? AND project_id = ?
+                    UNION
+                    SELECT f.id FROM folders f JOIN subtree ON f.parent_id = subtree.id
+                    WHERE f.project_id = ?
+                )
+                SELECT CAST(id AS INTEGER) FROM subtree
+                UNION SELECT ?)`)
+			args = append(args, strconv.Itoa(*q.FolderID), q.ProjectID, q.ProjectID, *q.FolderID)
+		} else {
+			conds = append(conds, "s.folder_id = ?")
+			args = append(args, *q.FolderID)
 		}
+	}
 
-		// Filter scenarios to ensure ALL provided tags match
-		if matchesAllTags(scenario.Tags, tags) {
-			scenarios = append(scenarios, scenario)
+	for _, tag := range q.Tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			continue
 		}
+		conds = append(conds, `EXISTS (
+            SELECT 1 FROM scenario_tags st JOIN tags t ON t.id = st.tag_id
+            WHERE st.scenario_id = s.id AND t.key = ? AND t.value = ?)`)
+		args = append(args, key, value)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %v", err)
+
+	if q.TagExpr != nil {
+		cond, exprArgs := tagExprSQL(q.TagExpr)
+		conds = append(conds, cond)
+		args = append(args, exprArgs...)
 	}
 
-	return scenarios, nil
+	if q.Keyword != "" {
+		conds = append(conds, `s.name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q.Keyword)+"%")
+	}
+
+	return strings.Join(conds, " AND "), args
 }
 
-// matchesAllTags checks if a scenario's tags match all the provided tag filters
-func matchesAllTags(scenarioTags []models.Tag, tagFilters []string) bool {
-	for _, filter := range tagFilters {
-		found := false
-		// Split the filter into key and value
-		parts := strings.Split(filter, ":")
-		if len(parts) != 2 {
-			continue // Skip invalid filters
-		}
-		filterKey := parts[0]
-		filterValue := parts[1]
-
-		// Check if any tag matches both key and value
-		for _, tag := range scenarioTags {
-			if tag.Key == filterKey && tag.Value == filterValue {
-				found = true
-				break
-			}
+// escapeLike escapes LIKE wildcards so a keyword matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SearchScenarios returns one page of the scenarios matching every filter in q.
+func SearchScenarios(q ScenarioQuery) (*ScenarioPage, error) {
+	if q.Sort == "" {
+		q.Sort = SortByID
+	}
+	sortColumn, ok := scenarioSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: sort must be one of name, folder or id", ErrInvalidScenarioQuery)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalidScenarioQuery)
+	}
+	if q.Limit > MaxScenarioPageSize {
+		q.Limit = MaxScenarioPageSize
+	}
+	if err := CheckProjectAccess(q.UserID, q.ProjectID); err != nil {
+		return nil, err
+	}
+
+	where, args := q.where()
+
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM scenarios s WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count scenarios: %v", err)
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	pageWhere, pageArgs := where, append([]interface{}{}, args...)
+	if q.Cursor != "" {
+		cursor, err := decodeScenarioCursor(q.Cursor)
+		if err != nil {
+			return nil, err
 		}
-		if !found {
-			return false // If any filter doesn't match, return false
+		if cursor.Sort != q.Sort || cursor.Desc != q.Descending {
+			return nil, fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidScenarioQuery)
 		}
+		pageWhere += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND s.id %[2]s ?))", sortColumn, compare)
+		pageArgs = append(pageArgs, cursor.Value, cursor.Value, cursor.ID)
+		q.Offset = 0
 	}
-	return true
-}
 
-// GetScenariosByFolderID retrieves scenarios within a specific folder.
-func GetScenariosByFolderID(projectID, userID, folderID int) ([]models.Scenario, error) {
-	rows, err := DB.Query(
-		"SELECT id, name, folder_id, project_id, tags FROM scenarios WHERE project_id = ? AND user_id = ? AND folder_id = ?",
-		projectID, userID, folderID,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query scenarios by folder ID: %v", err)
+	query := fmt.Sprintf("%s ORDER BY %s %s, s.id %s", pageWhere, sortColumn, direction, direction)
+	if q.Limit > 0 {
+		// Fetch one extra row to know whether another page follows.
+		query += " LIMIT ? OFFSET ?"
+		pageArgs = append(pageArgs, q.Limit+1, q.Offset)
+	} else if q.Offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		pageArgs = append(pageArgs, q.Offset)
 	}
-	defer rows.Close()
 
-	scenarios := make([]models.Scenario, 0)
-	for rows.Next() {
-		var scenario models.Scenario
-		var tagsJSON string
-		if err := rows.Scan(&scenario.ID, &scenario.Name, &scenario.FolderID, &scenario.ProjectID, &tagsJSON); err != nil {
-			return nil, fmt.Errorf("failed to scan scenario row: %v", err)
-		}
+	scenarios, err := queryScenarios(DB, query, pageArgs...)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := json.Unmarshal([]byte(tagsJSON), &scenario.Tags); err != nil {
-			log.Printf("Error unmarshaling tags for scenario %s: %v", scenario.ID, err)
-			scenario.Tags = []models.Tag{}
+	page := &ScenarioPage{Total: total, Limit: q.Limit, Offset: q.Offset}
+	if q.Limit > 0 && len(scenarios) > q.Limit {
+		scenarios = scenarios[:q.Limit]
+		next := q.Offset + q.Limit
+		if q.Cursor == "" {
+			page.NextOffset = &next
 		}
-		scenarios = append(scenarios, scenario)
+		last := scenarios[len(scenarios)-1]
+		page.NextCursor = encodeScenarioCursor(scenarioCursor{
+			Sort:  q.Sort,
+			Desc:  q.Descending,
+			Value: scenarioSortValue(last, q.Sort),
+			ID:    last.ID,
+		})
 	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	page.Scenarios = scenarios
+	return page, nil
+}
+
+// scenarioSortValue returns the value of the sort column for a scenario, as compared in SQL.
+func scenarioSortValue(scenario models.Scenario, sort ScenarioSort) interface{} {
+	switch sort {
+	case SortByName:
+		return scenario.Name
+	case SortByFolder:
+		return scenario.FolderID
+	default:
+		id, _ := strconv.Atoi(scenario.ID)
+		return id
 	}
+}
 
-	return scenarios, nil
+func encodeScenarioCursor(cursor scenarioCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
-// GetScenariosByName retrieves scenarios containing a keyword in their name.
-func GetScenariosByName(projectID, userID int, keyword string) ([]models.Scenario, error) {
-	rows, err := DB.Query(
-		"SELECT id, name, folder_id, project_id, tags FROM scenarios WHERE project_id = ? AND user_id = ? AND name LIKE ?",
-		projectID, userID, "%"+keyword+"%",
-	)
+func decodeScenarioCursor(encoded string) (*scenarioCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query scenarios by name: %v", err)
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidScenarioQuery)
 	}
-	defer rows.Close()
-
-	scenarios := make([]models.Scenario, 0)
-	for rows.Next() {
-		var scenario models.Scenario
-		var tagsJSON string
-		if err := rows.Scan(&scenario.ID, &scenario.Name, &scenario.FolderID, &scenario.ProjectID, &tagsJSON); err != nil {
-			return nil, fmt.Errorf("failed to scan scenario row: %v", err)
-		}
-
-		if err := json.Unmarshal([]byte(tagsJSON), &scenario.Tags); err != nil {
-			log.Printf("Error unmarshaling tags for scenario %s: %v", scenario.ID, err)
-			scenario.Tags = []models.Tag{}
-		}
-		scenarios = append(scenarios, scenario)
+	var cursor scenarioCursor
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Keep integer sort values integral
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidScenarioQuery)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	if number, ok := cursor.Value.(json.Number); ok {
+		if n, err := number.Int64(); err == nil {
+			cursor.Value = n
+		} else {
+			cursor.Value = number.String()
+		}
 	}
-	return scenarios, nil
+	return &cursor, nil
 }
 
-// DeleteScenariosByProjectID deletes all scenarios associated with a project and user.
-func DeleteScenariosByProjectID(projectID, userID int) error {
-	_, err := DB.Exec("DELETE FROM scenarios WHERE project_id = ? and user_id = ?", projectID, userID)
+// DeleteScenariosByProjectID deletes the cached scenarios of a project, for every user.
+func DeleteScenariosByProjectID(projectID int) error {
+	for _, table := range []string{"scenario_tags", "scenario_steps", "scenario_datasets"} {
+		_, err := DB.Exec(
+			"DELETE FROM "+table+" WHERE scenario_id IN (SELECT id FROM scenarios WHERE project_id = ?)",
+			projectID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete from %s: %v", table, err)
+		}
+	}
+	_, err := DB.Exec("DELETE FROM scenarios WHERE project_id = ?", projectID)
 	if err != nil {
 		return fmt.Errorf("failed to delete scenarios: %v", err)
 	}
-	return nil
+	return reindexProject(DB, projectID)
 }
 
-// RefreshScenarios fetches and updates scenarios from Cucumber Studio.
-func RefreshScenarios(user *models.User, projectID int) ([]models.Scenario, error) {
+// RefreshScenarios fetches scenarios from Cucumber Studio and applies the difference to the
+// local cache in a single transaction: new scenarios are inserted, changed ones updated and
+// vanished ones removed. Nothing is written if any step fails. The cache of a project is
+// shared, so a refresh by any user with access updates it for all of them.
+func RefreshScenarios(ctx context.Context, client StudioClient, user *models.User, projectID int) ([]models.Scenario, *models.RefreshSummary, error) {
 	// 1. Fetch latest scenarios from Cucumber Studio
-	scenarios, err := GetScenarios(user, projectID) // Use existing function
+	scenarios, err := client.GetScenarios(ctx, user, projectID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// 2. Delete existing scenarios for this project and user
-	err = DeleteScenariosByProjectID(projectID, user.ID) // user.ID is already an int
+	tx, err := DB.BeginTx(ctx, nil)
 	if err != nil {
-		// Log the error.  Consider whether to continue or abort.
-		log.Printf("Failed to delete existing scenarios for project %d, user %d: %v", projectID, user.ID, err)
-		// return nil, err  // Option: Abort on deletion failure
+		return nil, nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// 2. Load what we have cached, keyed by ID
+	existing, err := cachedScenariosByID(tx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes, err := cachedDetailHashes(tx, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 3. Upsert what changed
+	summary := &models.RefreshSummary{}
+	seen := make(map[string]bool, len(scenarios))
+	for i := range scenarios {
+		scenario := &scenarios[i]
+		if seen[scenario.ID] {
+			continue // Duplicates can appear when pages shift during a fetch
+		}
+		seen[scenario.ID] = true
+		scenario.Tags = dedupeTags(scenario.Tags)
+
+		old, ok := existing[scenario.ID]
+		switch {
+		case !ok:
+			if err := createScenario(tx, scenario, projectID, user.ID); err != nil {
+				return nil, nil, fmt.Errorf("failed to create scenario %s: %w", scenario.ID, err)
+			}
+			summary.Added++
+		case old.Name != scenario.Name || old.FolderID != scenario.FolderID || !sameTags(old.Tags, scenario.Tags) ||
+			(scenario.Details != nil && hashes[scenario.ID] != detailsHash(scenario.Details)):
+			_, err := tx.Exec(
+				"UPDATE scenarios SET name = ?, folder_id = ? WHERE id = ? AND project_id = ?",
+				scenario.Name, scenario.FolderID, scenario.ID, projectID,
+			)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to update scenario %s: %v", scenario.ID, err)
+			}
+			if err := setScenarioTags(tx, scenario.ID, scenario.Tags); err != nil {
+				return nil, nil, err
+			}
+			if err := setScenarioDetails(tx, scenario.ID, scenario.Details); err != nil {
+				return nil, nil, err
+			}
+			summary.Updated++
+		default:
+			summary.Unchanged++
+		}
+	}
+
+	// 4. Remove scenarios that no longer exist in Studio
+	for id := range existing {
+		if seen[id] {
+			continue
+		}
+		if err := deleteScenarioTags(tx, id); err != nil {
+			return nil, nil, err
+		}
+		if err := deleteScenarioDetails(tx, id); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM scenarios WHERE id = ? AND project_id = ?", id, projectID); err != nil {
+			return nil, nil, fmt.Errorf("failed to delete scenario %s: %v", id, err)
+		}
+		summary.Removed++
+	}
+	if summary.Updated > 0 || summary.Removed > 0 {
+		if err := deleteOrphanTags(tx); err != nil {
+			return nil, nil, err
+		}
+	}
+	if summary.Added > 0 || summary.Updated > 0 || summary.Removed > 0 {
+		if err := reindexProject(tx, projectID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit scenario refresh: %v", err)
 	}
+	log.Printf("Refreshed scenarios for project %d, user %d: %+v", projectID, user.ID, *summary)
+	return scenarios, summary, nil
+}
 
-	// 3. Insert the new scenarios.
+// cachedScenariosByID loads the cached scenarios of a project, with their tags, keyed by ID.
+func cachedScenariosByID(db dbExecutor, projectID int) (map[string]models.Scenario, error) {
+	scenarios, err := queryScenarios(db, "s.project_id = ?", projectID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]models.Scenario, len(scenarios))
 	for _, scenario := range scenarios {
-		err = CreateScenario(&scenario, projectID, user.ID) // user.ID is already an int
-		if err != nil {
-			// Log and decide how to handle individual errors (e.g., continue or abort)
-			log.Printf("Failed to create scenario: %v", err)
-			return nil, err // Option: Abort if a single scenario fails to insert
+		existing[scenario.ID] = scenario
+	}
+	return existing, nil
+}
+
+// sameTags reports whether two tag lists are identical, in order.
+func sameTags(a, b []models.Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if tagID(a[i]) != tagID(b[i]) || a[i].Key != b[i].Key || a[i].Value != b[i].Value {
+			return false
 		}
 	}
-	return scenarios, nil
+	return true
 }
 
+// RefreshProgressFunc is called after each project of a multi-project refresh
+// with the number of projects done so far, the total, the running summary and
+// the projects that failed so far.
+type RefreshProgressFunc func(done, total int, summary models.RefreshSummary, failed []models.ProjectFailure)
+
 // RefreshAllScenarios fetches and updates scenarios from all associated projects.
-func RefreshAllScenarios(user *models.User) ([]models.Scenario, error) {
+// A project that fails does not stop the others: it is reported in the returned
+// failures, and an error is only returned when no project could be refreshed.
+// progress may be nil.
+func RefreshAllScenarios(ctx context.Context, client StudioClient, user *models.User, progress RefreshProgressFunc) ([]models.Scenario, *models.RefreshSummary, []models.ProjectFailure, error) {
 	// 1. Get all associated projects
-	projects, err := GetProjects(user)
+	projects, err := client.GetProjects(ctx, user)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get projects: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to get projects: %w", err)
+	}
+	if err := UpdateUserProjects(user, projects); err != nil { // Keep project access current
+		return nil, nil, nil, err
 	}
 
 	// 2. Refresh scenarios for each project
 	var allScenarios []models.Scenario
-	for _, project := range projects {
+	summary := &models.RefreshSummary{}
+	failed := make([]models.ProjectFailure, 0)
+	refreshed := 0
+	for i, project := range projects {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, err
+		}
 		projectID, err := strconv.Atoi(project.ID)
 		if err != nil {
 			log.Printf("Invalid project ID %s: %v", project.ID, err)
 			continue
 		}
 
-		scenarios, err := RefreshScenarios(user, projectID)
+		scenarios, projectSummary, err := RefreshScenarios(ctx, client, user, projectID)
 		if err != nil {
-			// Log the error but continue with other projects
+			// Record the error but continue with other projects
 			log.Printf("Failed to refresh scenarios for project %d: %v", projectID, err)
-			continue
+			failed = append(failed, models.ProjectFailure{ProjectID: projectID, Error: PublicError(err)})
+		} else {
+			refreshed++
+			summary.Add(*projectSummary)
+			allScenarios = append(allScenarios, scenarios...)
 		}
 
-		allScenarios = append(allScenarios, scenarios...)
+		if progress != nil {
+			progress(i+1, len(projects), *summary, failed)
+		}
 	}
 
-	if len(allScenarios) == 0 && len(projects) > 0 {
-		return nil, fmt.Errorf("failed to refresh scenarios for any project")
+	if refreshed == 0 && len(projects) > 0 {
+		return nil, nil, failed, fmt.Errorf("failed to refresh scenarios for any project")
 	}
 
-	return allScenarios, nil
+	return allScenarios, summary, failed, nil
 }
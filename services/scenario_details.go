@@ -0,0 +1,378 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"my-cucumber-backend/models"
+
+	gherkin "github.com/cucumber/gherkin/go/v26"
+	messages "github.com/cucumber/messages/go/v21"
+)
+
+// ErrScenarioNotFound is returned when a scenario does not exist or belongs to another user.
+var ErrScenarioNotFound = errors.New("scenario not found")
+
+// createScenarioDetailTablesSQL stores the steps and datasets of scenarios.
+// Description, definition and datatable live on the scenarios row itself.
+const createScenarioDetailTablesSQL = `
+    CREATE TABLE IF NOT EXISTS scenario_steps (
+        scenario_id TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        keyword TEXT NOT NULL,
+        text TEXT NOT NULL,
+        action_word TEXT NOT NULL,
+        parameters TEXT NOT NULL DEFAULT '[]',
+        data_table TEXT,
+        doc_string TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (scenario_id, position),
+        FOREIGN KEY (scenario_id) REFERENCES scenarios(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_scenario_steps_action_word ON scenario_steps (action_word);
+
+    CREATE TABLE IF NOT EXISTS scenario_datasets (
+        scenario_id TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        data TEXT NOT NULL DEFAULT '{}',
+        PRIMARY KEY (scenario_id, position),
+        FOREIGN KEY (scenario_id) REFERENCES scenarios(id)
+    );
+`
+
+// initScenarioDetailTables adds the detail columns to scenarios and creates the step and dataset tables.
+func initScenarioDetailTables() error {
+	columns := []struct{ name, definition string }{
+		{"description", "TEXT NOT NULL DEFAULT ''"},
+		{"definition", "TEXT NOT NULL DEFAULT ''"},
+		{"datatable", "TEXT NOT NULL DEFAULT '[]'"},
+		{"details_hash", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, column := range columns {
+		if err := addColumnIfMissing("scenarios", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := DB.Exec(createScenarioDetailTablesSQL); err != nil {
+		return fmt.Errorf("failed to create scenario detail tables: %v", err)
+	}
+	return nil
+}
+
+// detailsHash fingerprints scenario details so a refresh only rewrites scenarios whose content changed.
+func detailsHash(details *models.ScenarioDetails) string {
+	if details == nil {
+		return ""
+	}
+	data, _ := json.Marshal(details)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// setScenarioDetails replaces the stored description, definition, datatable, steps and datasets of a scenario.
+func setScenarioDetails(db dbExecutor, scenarioID string, details *models.ScenarioDetails) error {
+	if details == nil {
+		return nil
+	}
+	if err := deleteScenarioDetails(db, scenarioID); err != nil {
+		return err
+	}
+
+	datatable, err := json.Marshal(details.Datatable)
+	if err != nil {
+		return fmt.Errorf("failed to encode datatable of scenario %s: %v", scenarioID, err)
+	}
+	_, err = db.Exec(
+		"UPDATE scenarios SET description = ?, definition = ?, datatable = ?, details_hash = ? WHERE id = ?",
+		details.Description, details.Definition, string(datatable), detailsHash(details), scenarioID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update details of scenario %s: %v", scenarioID, err)
+	}
+
+	for position, step := range details.Steps {
+		parameters, err := json.Marshal(step.Parameters)
+		if err != nil {
+			return fmt.Errorf("failed to encode step parameters of scenario %s: %v", scenarioID, err)
+		}
+		var dataTable interface{} // NULL when the step has no table
+		if step.DataTable != nil {
+			encoded, err := json.Marshal(step.DataTable)
+			if err != nil {
+				return fmt.Errorf("failed to encode step table of scenario %s: %v", scenarioID, err)
+			}
+			dataTable = string(encoded)
+		}
+		_, err = db.Exec(
+			`INSERT INTO scenario_steps (scenario_id, position, keyword, text, action_word, parameters, data_table, doc_string)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			scenarioID, position, step.Keyword, step.Text, step.ActionWord, string(parameters), dataTable, step.DocString,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert step of scenario %s: %v", scenarioID, err)
+		}
+	}
+
+	for position, dataset := range details.Datasets {
+		data, err := json.Marshal(dataset.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encode dataset of scenario %s: %v", scenarioID, err)
+		}
+		_, err = db.Exec(
+			"INSERT INTO scenario_datasets (scenario_id, position, id, name, data) VALUES (?, ?, ?, ?, ?)",
+			scenarioID, position, dataset.ID, dataset.Name, string(data),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert dataset of scenario %s: %v", scenarioID, err)
+		}
+	}
+	return nil
+}
+
+// deleteScenarioDetails removes the steps and datasets of a scenario.
+func deleteScenarioDetails(db dbExecutor, scenarioID string) error {
+	if _, err := db.Exec("DELETE FROM scenario_steps WHERE scenario_id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to delete steps of scenario %s: %v", scenarioID, err)
+	}
+	if _, err := db.Exec("DELETE FROM scenario_datasets WHERE scenario_id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to delete datasets of scenario %s: %v", scenarioID, err)
+	}
+	return nil
+}
+
+// cachedDetailHashes returns the details hash of every cached scenario of a project, keyed by ID.
+func cachedDetailHashes(db dbExecutor, projectID int) (map[string]string, error) {
+	rows, err := db.Query("SELECT id, details_hash FROM scenarios WHERE project_id = ?", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scenario details hashes: %v", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan scenario details hash: %v", err)
+		}
+		hashes[id] = hash
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return hashes, nil
+}
+
+// GetScenarioByID retrieves a scenario with its tags and full details. Scenarios
+// of projects the user cannot access are reported as not found.
+func GetScenarioByID(scenarioID string, userID int) (*models.Scenario, error) {
+	scenarios, err := queryScenarios(DB, "s.id = ?", scenarioID)
+	if err != nil {
+		return nil, err
+	}
+	if len(scenarios) == 0 {
+		return nil, ErrScenarioNotFound
+	}
+	scenario := scenarios[0]
+	if err := CheckProjectAccess(userID, scenario.ProjectID); err != nil {
+		if errors.Is(err, ErrProjectAccessDenied) {
+			return nil, ErrScenarioNotFound
+		}
+		return nil, err
+	}
+
+	details, err := loadScenarioDetails(DB, scenarioID)
+	if err != nil {
+		return nil, err
+	}
+	scenario.Details = details
+	return &scenario, nil
+}
+
+// loadScenarioDetails reads the stored details of a scenario.
+func loadScenarioDetails(db dbExecutor, scenarioID string) (*models.ScenarioDetails, error) {
+	details := &models.ScenarioDetails{
+		Steps:    make([]models.Step, 0),
+		Datasets: make([]models.Dataset, 0),
+	}
+
+	var datatable string
+	err := db.QueryRow("SELECT description, definition, datatable FROM scenarios WHERE id = ?", scenarioID).
+		Scan(&details.Description, &details.Definition, &datatable)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScenarioNotFound
+		}
+		return nil, fmt.Errorf("failed to query scenario details: %v", err)
+	}
+	if err := json.Unmarshal([]byte(datatable), &details.Datatable); err != nil {
+		return nil, fmt.Errorf("failed to decode datatable of scenario %s: %v", scenarioID, err)
+	}
+
+	rows, err := db.Query(
+		`SELECT keyword, text, action_word, parameters, data_table, doc_string
+         FROM scenario_steps WHERE scenario_id = ? ORDER BY position`,
+		scenarioID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scenario steps: %v", err)
+	}
+	for rows.Next() {
+		var step models.Step
+		var parameters string
+		var dataTable sql.NullString
+		if err := rows.Scan(&step.Keyword, &step.Text, &step.ActionWord, &parameters, &dataTable, &step.DocString); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan scenario step: %v", err)
+		}
+		if err := json.Unmarshal([]byte(parameters), &step.Parameters); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to decode step parameters of scenario %s: %v", scenarioID, err)
+		}
+		if dataTable.Valid {
+			if err := json.Unmarshal([]byte(dataTable.String), &step.DataTable); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to decode step table of scenario %s: %v", scenarioID, err)
+			}
+		}
+		details.Steps = append(details.Steps, step)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	rows, err = db.Query("SELECT id, name, data FROM scenario_datasets WHERE scenario_id = ? ORDER BY position", scenarioID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scenario datasets: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dataset models.Dataset
+		var data string
+		if err := rows.Scan(&dataset.ID, &dataset.Name, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan scenario dataset: %v", err)
+		}
+		if err := json.Unmarshal([]byte(data), &dataset.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode dataset of scenario %s: %v", scenarioID, err)
+		}
+		details.Datasets = append(details.Datasets, dataset)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return details, nil
+}
+
+// quotedParameter matches a double-quoted action word argument, allowing \" inside.
+var quotedParameter = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// parseScenarioSteps extracts the steps of a Studio scenario definition. Definitions
+// normally start with "Scenario:" or "Scenario Outline:"; bare step lists are accepted too.
+func parseScenarioSteps(definition string) ([]models.Step, error) {
+	steps := make([]models.Step, 0)
+	if strings.TrimSpace(definition) == "" {
+		return steps, nil
+	}
+
+	// Studio definitions are a single scenario, not a feature file.
+	doc, err := parseGherkin("Feature: Studio scenario\n" + definition)
+	if err != nil || len(doc.Feature.Children) == 0 {
+		doc, err = parseGherkin("Feature: Studio scenario\nScenario: Studio scenario\n" + definition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, child := range doc.Feature.Children {
+		if child.Scenario == nil {
+			continue
+		}
+		for _, step := range child.Scenario.Steps {
+			steps = append(steps, convertGherkinStep(step))
+		}
+		break
+	}
+	return steps, nil
+}
+
+func parseGherkin(source string) (*messages.GherkinDocument, error) {
+	ids := &messages.Incrementing{}
+	return gherkin.ParseGherkinDocument(strings.NewReader(source), ids.NewId)
+}
+
+// convertGherkinStep turns a parsed step into a models.Step, naming its quoted arguments p1, p2, ...
+func convertGherkinStep(step *messages.Step) models.Step {
+	converted := models.Step{
+		Keyword:    strings.TrimSpace(step.Keyword),
+		Text:       step.Text,
+		Parameters: make([]models.StepParameter, 0),
+	}
+	converted.ActionWord = quotedParameter.ReplaceAllStringFunc(step.Text, func(quoted string) string {
+		value := quotedParameter.FindStringSubmatch(quoted)[1]
+		name := fmt.Sprintf("p%d", len(converted.Parameters)+1)
+		converted.Parameters = append(converted.Parameters, models.StepParameter{
+			Name:  name,
+			Value: strings.ReplaceAll(value, `\"`, `"`),
+		})
+		return `"` + name + `"`
+	})
+	if step.DataTable != nil {
+		converted.DataTable = make([][]string, 0, len(step.DataTable.Rows))
+		for _, row := range step.DataTable.Rows {
+			cells := make([]string, len(row.Cells))
+			for i, cell := range row.Cells {
+				cells[i] = cell.Value
+			}
+			converted.DataTable = append(converted.DataTable, cells)
+		}
+	}
+	if step.DocString != nil {
+		converted.DocString = step.DocString.Content
+	}
+	return converted
+}
+
+// parseGherkinTable parses "| a | b |" rows into cells, unescaping \|, \\ and \n like Gherkin does.
+func parseGherkinTable(table string) [][]string {
+	rows := make([][]string, 0)
+	for _, line := range strings.Split(table, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			continue
+		}
+		var cells []string
+		var cell strings.Builder
+		for i := 1; i < len(line); i++ {
+			switch {
+			case line[i] == '\\' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case 'n':
+					cell.WriteByte('\n')
+				case '|', '\\':
+					cell.WriteByte(line[i])
+				default:
+					cell.WriteByte('\\')
+					cell.WriteByte(line[i])
+				}
+			case line[i] == '|':
+				cells = append(cells, strings.TrimSpace(cell.String()))
+				cell.Reset()
+			default:
+				cell.WriteByte(line[i])
+			}
+		}
+		if cells != nil {
+			rows = append(rows, cells)
+		}
+	}
+	return rows
+}
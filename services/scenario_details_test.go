@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+func TestRefreshScenariosSyncsDetails(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+
+	outline, err := GetScenarioByID("1001", user.ID)
+	if err != nil {
+		t.Fatalf("GetScenarioByID: %v", err)
+	}
+	details := outline.Details
+	if details.Description != "Sign-in is refused and the password field is cleared." {
+		t.Errorf("got description %q", details.Description)
+	}
+	wantFirstStep := models.Step{
+		Keyword:    "Given",
+		Text:       `a customer "<email>" with password "secret"`,
+		ActionWord: `a customer "p1" with password "p2"`,
+		Parameters: []models.StepParameter{{Name: "p1", Value: "<email>"}, {Name: "p2", Value: "secret"}},
+	}
+	if len(details.Steps) != 4 || !reflect.DeepEqual(details.Steps[0], wantFirstStep) {
+		t.Errorf("got steps %+v, want 4 starting with %+v", details.Steps, wantFirstStep)
+	}
+	if details.Steps[3].Keyword != "And" || len(details.Steps[3].Parameters) != 0 {
+		t.Errorf("got last step %+v, want an And step without parameters", details.Steps[3])
+	}
+	wantDatatable := [][]string{
+		{"name", "email", "password"},
+		{"empty password", "bob@example.com", ""},
+		{"wrong password", "bob@example.com", "hunter2"},
+	}
+	if !reflect.DeepEqual(details.Datatable, wantDatatable) {
+		t.Errorf("got datatable %v, want %v", details.Datatable, wantDatatable)
+	}
+	wantDatasets := []models.Dataset{
+		{ID: "5000", Name: "empty password", Data: map[string]string{"email": "bob@example.com", "password": ""}},
+		{ID: "5001", Name: "wrong password", Data: map[string]string{"email": "bob@example.com", "password": "hunter2"}},
+	}
+	if !reflect.DeepEqual(details.Datasets, wantDatasets) {
+		t.Errorf("got datasets %+v, want %+v", details.Datasets, wantDatasets)
+	}
+
+	// Step arguments: a data table and a doc string.
+	cart, err := GetScenarioByID("1003", user.ID)
+	if err != nil {
+		t.Fatalf("GetScenarioByID: %v", err)
+	}
+	wantTable := [][]string{{"product", "price"}, {"Mug", "8.50"}, {"Teapot", "24.00"}}
+	if !reflect.DeepEqual(cart.Details.Steps[0].DataTable, wantTable) {
+		t.Errorf("got step table %v, want %v", cart.Details.Steps[0].DataTable, wantTable)
+	}
+	reset, err := GetScenarioByID("1002", user.ID)
+	if err != nil {
+		t.Fatalf("GetScenarioByID: %v", err)
+	}
+	if got := reset.Details.Steps[2].DocString; got != "Follow the link below to choose a new password." {
+		t.Errorf("got doc string %q", got)
+	}
+
+	// Changing only the content in Studio updates the cached steps and datasets.
+	fixtures := fakestudio.DefaultFixtures()
+	changed := &fixtures.Scenarios["1"][1]
+	changed.Definition = "Scenario: Login with a wrong password\n  When the customer logs in with \"bob@example.com\" and \"hunter2\"\n  Then the error \"Invalid credentials\" is shown"
+	changed.Datatable = ""
+	changed.Datasets = nil
+	studio.SetFixtures(fixtures)
+
+	_, summary, err := RefreshScenarios(ctx, client, user, 1)
+	if err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	if *summary != (models.RefreshSummary{Updated: 1, Unchanged: 6}) {
+		t.Errorf("refresh reported %+v, want 1001 updated", *summary)
+	}
+	outline, err = GetScenarioByID("1001", user.ID)
+	if err != nil {
+		t.Fatalf("GetScenarioByID: %v", err)
+	}
+	if details := outline.Details; len(details.Steps) != 2 || details.Steps[0].ActionWord != `the customer logs in with "p1" and "p2"` ||
+		len(details.Datasets) != 0 || len(details.Datatable) != 0 {
+		t.Errorf("got details %+v, want the 2 new steps and no datasets", details)
+	}
+	if outline.StepCount != 2 {
+		t.Errorf("got step count %d, want 2", outline.StepCount)
+	}
+}
+
+func TestGetScenarioByIDHidesOtherProjects(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	owner := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	other := createTestUser(t, "other@example.com", "c2", "t2", models.Project{ID: "2", Name: "Mobile"})
+
+	if _, _, err := RefreshScenarios(context.Background(), client, owner, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	if _, err := GetScenarioByID("1000", other.ID); !errors.Is(err, ErrScenarioNotFound) {
+		t.Errorf("got error %v, want ErrScenarioNotFound for a scenario of another project", err)
+	}
+	if _, err := GetScenarioByID("9999", owner.ID); !errors.Is(err, ErrScenarioNotFound) {
+		t.Errorf("got error %v, want ErrScenarioNotFound", err)
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// createPagingScenarios caches scenarios whose names and folders repeat, so that
+// most of them tie on the name and folder sort keys. IDs of different lengths
+// make the id tie-breaker compare as text ("10" < "9").
+func createPagingScenarios(t *testing.T, userID int) {
+	t.Helper()
+	names := []string{"Checkout", "Login", "Checkout", "Search"}
+	for i, id := range []string{"9", "10", "11", "100", "2", "30", "31", "300", "7", "8", "80"} {
+		scenario := models.Scenario{ID: id, Name: names[i%len(names)], FolderID: 1 + i%2}
+		if err := CreateScenario(&scenario, 1, userID); err != nil {
+			t.Fatalf("CreateScenario: %v", err)
+		}
+	}
+}
+
+func scenarioIDs(scenarios []models.Scenario) string {
+	ids := make([]string, len(scenarios))
+	for i, scenario := range scenarios {
+		ids[i] = scenario.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearchScenariosCursorWithTies(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	createPagingScenarios(t, user.ID)
+
+	for _, sort := range []ScenarioSort{SortByName, SortByFolder, SortByID} {
+		for _, descending := range []bool{false, true} {
+			query := ScenarioQuery{ProjectID: 1, UserID: user.ID, Sort: sort, Descending: descending}
+			all, err := SearchScenarios(query)
+			if err != nil {
+				t.Fatalf("SearchScenarios(%s): %v", sort, err)
+			}
+			want := scenarioIDs(all.Scenarios)
+
+			for limit := 1; limit <= 4; limit++ {
+				t.Run(fmt.Sprintf("%s desc=%v limit=%d", sort, descending, limit), func(t *testing.T) {
+					// Walking the cursors must return every scenario exactly once,
+					// in the unpaginated order, even across runs of equal keys.
+					var walked []models.Scenario
+					query := query
+					query.Limit = limit
+					for pages := 0; ; pages++ {
+						if pages > len(all.Scenarios) {
+							t.Fatalf("cursor walk did not end, got %s so far", scenarioIDs(walked))
+						}
+						page, err := SearchScenarios(query)
+						if err != nil {
+							t.Fatalf("SearchScenarios: %v", err)
+						}
+						if page.Total != len(all.Scenarios) {
+							t.Errorf("page reports %d scenarios in total, want %d", page.Total, len(all.Scenarios))
+						}
+						walked = append(walked, page.Scenarios...)
+						if page.NextCursor == "" {
+							break
+						}
+						query.Cursor = page.NextCursor
+					}
+					if got := scenarioIDs(walked); got != want {
+						t.Errorf("walked %s, want %s", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestSearchScenariosRejectsForeignCursors(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	createPagingScenarios(t, user.ID)
+
+	page, err := SearchScenarios(ScenarioQuery{ProjectID: 1, UserID: user.ID, Sort: SortByName, Limit: 2})
+	if err != nil {
+		t.Fatalf("SearchScenarios: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query ScenarioQuery
+	}{
+		{name: "other sort key", query: ScenarioQuery{Sort: SortByFolder, Cursor: page.NextCursor}},
+		{name: "other direction", query: ScenarioQuery{Sort: SortByName, Descending: true, Cursor: page.NextCursor}},
+		{name: "not base64", query: ScenarioQuery{Sort: SortByName, Cursor: "not a cursor!"}},
+		{name: "not JSON", query: ScenarioQuery{Sort: SortByName, Cursor: "bm90IGpzb24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.ProjectID, tt.query.UserID, tt.query.Limit = 1, user.ID, 2
+			if _, err := SearchScenarios(tt.query); !errors.Is(err, ErrInvalidScenarioQuery) {
+				t.Errorf("got error %v, want ErrInvalidScenarioQuery", err)
+			}
+		})
+	}
+}
+
+func TestScenarioCursorRoundTrip(t *testing.T) {
+	tests := []scenarioCursor{
+		{Sort: SortByName, Value: "Checkout", ID: "10"},
+		{Sort: SortByFolder, Desc: true, Value: int64(2), ID: "9"},
+		{Sort: SortByID, Value: int64(9007199254740993), ID: "9007199254740993"},
+	}
+	for _, want := range tests {
+		got, err := decodeScenarioCursor(encodeScenarioCursor(want))
+		if err != nil {
+			t.Fatalf("decodeScenarioCursor: %v", err)
+		}
+		if *got != want {
+			t.Errorf("round trip gave %#v, want %#v", *got, want)
+		}
+	}
+}
+
+// cachedScenarioNames returns the cached scenarios of project 1 as ID=name pairs.
+func cachedScenarioNames(t *testing.T, userID int) string {
+	t.Helper()
+	scenarios, err := GetScenariosByProjectID(1, userID)
+	if err != nil {
+		t.Fatalf("GetScenariosByProjectID: %v", err)
+	}
+	pairs := make([]string, len(scenarios))
+	for i, scenario := range scenarios {
+		pairs[i] = scenario.ID + "=" + scenario.Name
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func TestRefreshScenariosSummary(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+
+	_, summary, err := RefreshScenarios(ctx, client, user, 1)
+	if err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	if *summary != (models.RefreshSummary{Added: 7}) {
+		t.Errorf("first refresh reported %+v, want 7 added", *summary)
+	}
+
+	// Rename 1003, drop 1004, add 1007, and have Studio list a tag of 1005 twice.
+	fixtures := fakestudio.DefaultFixtures()
+	var scenarios []fakestudio.Scenario
+	for _, scenario := range fixtures.Scenarios["1"] {
+		switch scenario.ID {
+		case "1003":
+			scenario.Name = "Add a product to the cart"
+		case "1004":
+			continue
+		case "1005":
+			scenario.TagIDs = append(scenario.TagIDs, scenario.TagIDs[0])
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	fixtures.Scenarios["1"] = append(scenarios, fakestudio.Scenario{ID: "1007", Name: "Pay with PayPal", FolderID: 13})
+	studio.SetFixtures(fixtures)
+
+	_, summary, err = RefreshScenarios(ctx, client, user, 1)
+	if err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	if want := (models.RefreshSummary{Added: 1, Updated: 1, Removed: 1, Unchanged: 5}); *summary != want {
+		t.Errorf("second refresh reported %+v, want %+v", *summary, want)
+	}
+
+	// The repeated tag is stored once and does not count as a change again.
+	refreshed, summary, err := RefreshScenarios(ctx, client, user, 1)
+	if err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	if *summary != (models.RefreshSummary{Unchanged: 7}) {
+		t.Errorf("third refresh reported %+v, want 7 unchanged", *summary)
+	}
+	for _, scenario := range refreshed {
+		if scenario.ID == "1005" && len(scenario.Tags) != 2 {
+			t.Errorf("scenario 1005 has tags %+v, want its 2 distinct tags", scenario.Tags)
+		}
+	}
+	tags, err := loadScenarioTags(DB, []string{"1005"})
+	if err != nil {
+		t.Fatalf("loadScenarioTags: %v", err)
+	}
+	if len(tags["1005"]) != 2 {
+		t.Errorf("cached tags of 1005 are %+v, want 2", tags["1005"])
+	}
+}
+
+func TestRefreshScenariosRollsBackOnFailure(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	ctx := context.Background()
+
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	before := cachedScenarioNames(t, user.ID)
+
+	// The refresh renames 1000 and removes 1004, but inserting 1007 fails:
+	// the cache must stay as it was.
+	fixtures := fakestudio.DefaultFixtures()
+	fixtures.Scenarios["1"][0].Name = "Log in"
+	fixtures.Scenarios["1"] = append(fixtures.Scenarios["1"][:4], fixtures.Scenarios["1"][5:]...)
+	fixtures.Scenarios["1"] = append(fixtures.Scenarios["1"], fakestudio.Scenario{ID: "1007", Name: "Pay with PayPal", FolderID: 13})
+	studio.SetFixtures(fixtures)
+	if _, err := DB.Exec(`CREATE TRIGGER fail_1007 BEFORE INSERT ON scenarios WHEN NEW.id = '1007'
+                          BEGIN SELECT RAISE(ABORT, 'insert failed'); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := RefreshScenarios(ctx, client, user, 1); err == nil || !strings.Contains(err.Error(), "insert failed") {
+		t.Fatalf("got error %v, want the failed insert", err)
+	}
+	if after := cachedScenarioNames(t, user.ID); after != before {
+		t.Errorf("cache after the failed refresh is %s, want it unchanged: %s", after, before)
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"my-cucumber-backend/models"
+)
+
+// SchedulerConfig configures the background sync.
+type SchedulerConfig struct {
+	Interval    time.Duration // Time between sync cycles; zero or negative disables the scheduler
+	Jitter      time.Duration // Random extra delay added to every interval, so instances don't sync in lockstep
+	Concurrency int           // Projects synced in parallel, defaults to 1
+}
+
+// Scheduler periodically syncs the projects, folders and scenarios of every
+// user who opted in to background sync.
+type Scheduler struct {
+	client StudioClient
+	cfg    SchedulerConfig
+}
+
+// NewScheduler creates a scheduler that syncs through client.
+func NewScheduler(client StudioClient, cfg SchedulerConfig) *Scheduler {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Jitter < 0 {
+		cfg.Jitter = 0
+	}
+	return &Scheduler{client: client, cfg: cfg}
+}
+
+// Start runs sync cycles in the background until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	if s.cfg.Interval <= 0 {
+		log.Println("Background sync disabled")
+		return
+	}
+	log.Printf("Background sync every %s (+ up to %s jitter), %d project(s) at a time", s.cfg.Interval, s.cfg.Jitter, s.cfg.Concurrency)
+
+	go func() {
+		for {
+			timer := time.NewTimer(s.nextDelay())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if err := s.RunOnce(ctx); err != nil {
+				log.Printf("Background sync failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) nextDelay() time.Duration {
+	delay := s.cfg.Interval
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.cfg.Jitter)))
+	}
+	return delay
+}
+
+// RunOnce syncs every opted-in user's projects once and waits for the cycle to finish.
+// Project caches are shared, so a project seen by several users is synced once,
+// with the credentials of the first of them that Studio accepts for it.
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	users, err := GetAutoSyncUsers()
+	if err != nil {
+		return err
+	}
+
+	// The users who can see each project, in the order their credentials are tried.
+	candidates := make(map[int][]*models.User)
+	var projectIDs []int
+	var fetched []*models.User
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+
+		projects, err := s.client.GetProjects(ctx, user)
+		if err != nil {
+			log.Printf("Background sync: failed to fetch projects for user %d: %v", user.ID, err)
+			continue
+		}
+		if err := UpdateUserProjects(user, projects); err != nil {
+			log.Printf("Background sync: failed to update projects for user %d: %v", user.ID, err)
+			continue
+		}
+		fetched = append(fetched, user)
+
+		for _, project := range projects {
+			projectID, err := strconv.Atoi(project.ID)
+			if err != nil {
+				log.Printf("Invalid project ID %s: %v", project.ID, err)
+				continue
+			}
+			if _, ok := candidates[projectID]; !ok {
+				projectIDs = append(projectIDs, projectID)
+			}
+			candidates[projectID] = append(candidates[projectID], user)
+		}
+	}
+
+	sem := make(chan struct{}, s.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, projectID := range projectIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(projectID int, users []*models.User) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			s.syncSharedProject(ctx, projectID, users)
+		}(projectID, candidates[projectID])
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Stamp the users once all of their projects have been attempted.
+	for _, user := range fetched {
+		if _, err := DB.Exec("UPDATE users SET last_synced_at = CURRENT_TIMESTAMP WHERE id = ?", user.ID); err != nil {
+			log.Printf("Background sync: failed to stamp user %d: %v", user.ID, err)
+		}
+	}
+	return nil
+}
+
+// syncSharedProject syncs a project with the credentials of each of users in
+// turn until one succeeds. A failure tied to the credentials, e.g. a token that
+// lost access to the project, falls back to the next user; Studio being down or
+// rate limiting us would fail for all of them, so it ends the attempt.
+func (s *Scheduler) syncSharedProject(ctx context.Context, projectID int, users []*models.User) {
+	for _, user := range users {
+		_, _, err := SyncProject(ctx, s.client, user, projectID)
+		if err == nil {
+			return
+		}
+		log.Printf("Background sync: project %d of user %d failed: %v", projectID, user.ID, err)
+		if ctx.Err() != nil || errors.Is(err, ErrStudioUnavailable) || errors.Is(err, ErrStudioRateLimited) {
+			return
+		}
+	}
+}
+
+// SyncProject refreshes the folders and scenarios of one project and records the outcome in project_syncs.
+func SyncProject(ctx context.Context, client StudioClient, user *models.User, projectID int) (*models.RefreshSummary, *models.RefreshSummary, error) {
+	folders, err := RefreshFolders(ctx, client, user, projectID)
+	if err != nil {
+		recordProjectSync(user.ID, projectID, nil, err)
+		return nil, nil, err
+	}
+	_, scenarios, err := RefreshScenarios(ctx, client, user, projectID)
+	recordProjectSync(user.ID, projectID, scenarios, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, scenarios, nil
+}
+
+// recordProjectSync stores the result of a project sync. Failures keep the previous
+// last_synced_at and summary so the UI can still show when data was last fresh.
+func recordProjectSync(userID, projectID int, summary *models.RefreshSummary, syncErr error) {
+	var err error
+	if syncErr != nil {
+		_, err = DB.Exec(`
+            INSERT INTO project_syncs (user_id, project_id, last_attempt_at, last_error)
+            VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+            ON CONFLICT (user_id, project_id) DO UPDATE SET
+                last_attempt_at = excluded.last_attempt_at,
+                last_error = excluded.last_error`,
+			userID, projectID, PublicError(syncErr),
+		)
+	} else {
+		_, err = DB.Exec(`
+            INSERT INTO project_syncs (user_id, project_id, last_attempt_at, last_synced_at, last_error, added, updated, removed, unchanged)
+            VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, '', ?, ?, ?, ?)
+            ON CONFLICT (user_id, project_id) DO UPDATE SET
+                last_attempt_at = excluded.last_attempt_at,
+                last_synced_at = excluded.last_synced_at,
+                last_error = '',
+                added = excluded.added,
+                updated = excluded.updated,
+                removed = excluded.removed,
+                unchanged = excluded.unchanged`,
+			userID, projectID, summary.Added, summary.Updated, summary.Removed, summary.Unchanged,
+		)
+	}
+	if err != nil {
+		log.Printf("Failed to record sync of project %d for user %d: %v", projectID, userID, err)
+	}
+}
+
+// GetProjectSyncs returns the sync status of every synced project the user can
+// access: the latest sync of its shared cache, whoever ran it. Successful syncs
+// take precedence, so a sync that fell back to another user after the first
+// one failed shows as synced.
+func GetProjectSyncs(userID int) ([]models.ProjectSync, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	accessible, err := projectIDs(user.Projects)
+	if err != nil {
+		return nil, err
+	}
+	syncs := make([]models.ProjectSync, 0)
+	if len(accessible) == 0 {
+		return syncs, nil
+	}
+	ids := make([]interface{}, 0, len(accessible))
+	for id := range accessible {
+		ids = append(ids, id)
+	}
+
+	rows, err := DB.Query(
+		`SELECT project_id, last_attempt_at, last_synced_at, last_error, added, updated, removed, unchanged
+		 FROM project_syncs WHERE project_id IN (`+placeholders(len(ids))+`)
+		 ORDER BY project_id, last_synced_at DESC, last_attempt_at DESC`,
+		ids...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project syncs: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ps models.ProjectSync
+		var lastAttemptAt, lastSyncedAt sql.NullString
+		if err := rows.Scan(
+			&ps.ProjectID, &lastAttemptAt, &lastSyncedAt, &ps.LastError,
+			&ps.Summary.Added, &ps.Summary.Updated, &ps.Summary.Removed, &ps.Summary.Unchanged,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan project sync: %v", err)
+		}
+		if lastAttemptAt.Valid {
+			ps.LastAttemptAt = &lastAttemptAt.String
+		}
+		if lastSyncedAt.Valid {
+			ps.LastSyncedAt = &lastSyncedAt.String
+		}
+		if len(syncs) > 0 && syncs[len(syncs)-1].ProjectID == ps.ProjectID {
+			continue // An older or failed sync of the same project, by another user
+		}
+		syncs = append(syncs, ps)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return syncs, nil
+}
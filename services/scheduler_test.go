@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// folderFailingClient fails GetFolders, the first call of a project sync, for one user.
+type folderFailingClient struct {
+	StudioClient
+	email string
+	err   error
+}
+
+func (c folderFailingClient) GetFolders(ctx context.Context, user *models.User, projectID int) ([]FolderResponse, error) {
+	if user.Email == c.email {
+		return nil, c.err
+	}
+	return c.StudioClient.GetFolders(ctx, user, projectID)
+}
+
+// syncedBy returns the users who last synced a project successfully and those who failed to.
+func syncedBy(t *testing.T, projectID int) (synced, failed []int) {
+	t.Helper()
+	rows, err := DB.Query("SELECT user_id, last_synced_at IS NOT NULL, last_error FROM project_syncs WHERE project_id = ? ORDER BY user_id", projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int
+		var ok bool
+		var lastError string
+		if err := rows.Scan(&userID, &ok, &lastError); err != nil {
+			t.Fatal(err)
+		}
+		if ok && lastError == "" {
+			synced = append(synced, userID)
+		} else {
+			failed = append(failed, userID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return synced, failed
+}
+
+func TestSchedulerSyncsSharedProjectsOnce(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+
+	first := createTestUser(t, "first@example.com", "c1", "t1")
+	second := createTestUser(t, "second@example.com", "c2", "t2")
+	for _, user := range []*models.User{first, second} {
+		if err := SetAutoSync(user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scheduler := NewScheduler(newTestClient(t, studio, -1), SchedulerConfig{Concurrency: 2})
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	for _, projectID := range []int{1, 2} {
+		synced, failed := syncedBy(t, projectID)
+		if len(synced) != 1 || synced[0] != first.ID || len(failed) != 0 {
+			t.Errorf("project %d synced by %v, failed for %v; want synced once, by user %d", projectID, synced, failed, first.ID)
+		}
+	}
+	if got := len(requestsTo(studio, http.MethodGet, "/projects/1/scenarios")); got != 1 {
+		t.Errorf("project 1 scenarios fetched %d times, want once", got)
+	}
+}
+
+func TestSchedulerFallsBackToTheNextUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantFallback bool
+	}{
+		{name: "rejected credentials", err: &StudioError{Kind: ErrStudioUnauthorized, StatusCode: 403}, wantFallback: true},
+		{name: "project gone for the user", err: &StudioError{Kind: ErrStudioNotFound, StatusCode: 404}, wantFallback: true},
+		{name: "Studio unavailable", err: &StudioError{Kind: ErrStudioUnavailable, StatusCode: 503}, wantFallback: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			studio := fakestudio.NewServer()
+			defer studio.Close()
+
+			first := createTestUser(t, "first@example.com", "c1", "t1")
+			second := createTestUser(t, "second@example.com", "c2", "t2")
+			for _, user := range []*models.User{first, second} {
+				if err := SetAutoSync(user.ID, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := folderFailingClient{StudioClient: newTestClient(t, studio, -1), email: first.Email, err: tt.err}
+			if err := NewScheduler(client, SchedulerConfig{Concurrency: 1}).RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+
+			synced, failed := syncedBy(t, 1)
+			if len(failed) != 1 || failed[0] != first.ID {
+				t.Errorf("project 1 failed for %v, want user %d", failed, first.ID)
+			}
+			if tt.wantFallback && (len(synced) != 1 || synced[0] != second.ID) {
+				t.Errorf("project 1 synced by %v, want a fallback to user %d", synced, second.ID)
+			}
+			if !tt.wantFallback && len(synced) != 0 {
+				t.Errorf("project 1 synced by %v, want no fallback while Studio is down", synced)
+			}
+		})
+	}
+}
+
+func TestGetProjectSyncsAfterFallback(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+
+	shop := models.Project{ID: "1", Name: "Shop"}
+	first := createTestUser(t, "first@example.com", "c1", "t1", shop)
+	second := createTestUser(t, "second@example.com", "c2", "t2", shop)
+	for _, user := range []*models.User{first, second} {
+		if err := SetAutoSync(user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejected := &StudioError{Kind: ErrStudioUnauthorized, StatusCode: 403}
+	client := folderFailingClient{StudioClient: newTestClient(t, studio, -1), email: first.Email, err: rejected}
+	if err := NewScheduler(client, SchedulerConfig{Concurrency: 1}).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	// Both attempts happen within the same second; either user must see the
+	// fallback's success, not the first user's failure.
+	for _, user := range []*models.User{first, second} {
+		syncs, err := GetProjectSyncs(user.ID)
+		if err != nil {
+			t.Fatalf("GetProjectSyncs: %v", err)
+		}
+		if len(syncs) == 0 || syncs[0].ProjectID != 1 || (len(syncs) > 1 && syncs[1].ProjectID == 1) {
+			t.Fatalf("got syncs %+v, want project 1 once", syncs)
+		}
+		if sync := syncs[0]; sync.LastSyncedAt == nil || sync.LastError != "" || sync.Summary.Added == 0 {
+			t.Errorf("user %d sees sync %+v, want the successful fallback", user.ID, sync)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+
+	"my-cucumber-backend/models"
+)
+
+// The full-text index needs SQLite's FTS5 module, which go-sqlite3 only
+// compiles in with the sqlite_fts5 build tag:
+//
+//	go build -tags sqlite_fts5
+//
+// The Makefile sets it. Without it everything else works, but the server logs
+// a warning at startup and SearchScenarioText returns ErrSearchUnavailable.
+var searchAvailable bool
+
+// SearchAvailable reports whether the opened database supports full-text search.
+func SearchAvailable() bool {
+	return searchAvailable
+}
+
+// ErrSearchUnavailable is returned by SearchScenarioText when SQLite lacks FTS5.
+var ErrSearchUnavailable = errors.New("full-text search is unavailable: the server was built without SQLite FTS5 (build it with make to enable search)")
+
+// ErrInvalidSearchQuery is returned when a search query has no searchable terms.
+var ErrInvalidSearchQuery = errors.New("search query must contain at least one word")
+
+// createSearchIndexSQL indexes the searchable text of every scenario. The
+// scenario, user and project IDs are stored alongside but not tokenized.
+const createSearchIndexSQL = `
+    CREATE VIRTUAL TABLE IF NOT EXISTS scenarios_fts USING fts5(
+        scenario_id UNINDEXED,
+        user_id UNINDEXED,
+        project_id UNINDEXED,
+        name,
+        description,
+        steps,
+        tokenize = 'unicode61 remove_diacritics 2'
+    )
+`
+
+// searchSourceSQL selects the indexed columns of scenarios s, in scenarios_fts column order.
+// Steps are indexed as one "Keyword text" line each.
+const searchSourceSQL = `
+    SELECT s.id, s.user_id, s.project_id, s.name, s.description,
+        COALESCE((SELECT group_concat(line, char(10)) FROM (
+            SELECT keyword || ' ' || text AS line FROM scenario_steps
+            WHERE scenario_id = s.id ORDER BY position)), '')
+    FROM scenarios s`
+
+// Column positions and bm25 weights: a hit in the name outranks one in the description,
+// which outranks one in the steps.
+const (
+	searchNameColumn = 3
+	searchRankSQL    = "bm25(scenarios_fts, 0, 0, 0, 10.0, 3.0, 1.0)"
+)
+
+// highlight() and snippet() wrap matches in these control characters, which
+// survive HTML escaping, and markHighlights turns them into <mark> tags.
+const (
+	highlightOpen  = "\x02"
+	highlightClose = "\x03"
+)
+
+var highlightMarker = strings.NewReplacer(highlightOpen, "<mark>", highlightClose, "</mark>")
+
+// markHighlights HTML-escapes text returned by highlight() or snippet() and
+// marks its matches with <mark></mark>.
+func markHighlights(text string) string {
+	return highlightMarker.Replace(html.EscapeString(text))
+}
+
+// initSearchIndex creates the full-text index and rebuilds it if it is out of
+// step with the scenarios table, e.g. on first start after an upgrade.
+func initSearchIndex() error {
+	// CREATE ... IF NOT EXISTS succeeds without FTS5 when the table already exists,
+	// so the module is only known to work once the table has been read.
+	var indexed, scenarios int
+	_, err := DB.Exec(createSearchIndexSQL)
+	if err == nil {
+		err = DB.QueryRow("SELECT COUNT(*) FROM scenarios_fts").Scan(&indexed)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Println("SQLite was built without FTS5; full-text scenario search is unavailable")
+			return nil
+		}
+		return fmt.Errorf("failed to create scenarios_fts table: %v", err)
+	}
+	searchAvailable = true
+
+	if err := DB.QueryRow("SELECT COUNT(*) FROM scenarios").Scan(&scenarios); err != nil {
+		return fmt.Errorf("failed to count scenarios: %v", err)
+	}
+	if indexed == scenarios {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin search index rebuild: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM scenarios_fts"); err != nil {
+		return fmt.Errorf("failed to clear search index: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO scenarios_fts " + searchSourceSQL); err != nil {
+		return fmt.Errorf("failed to rebuild search index: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit search index rebuild: %v", err)
+	}
+	log.Printf("Rebuilt the search index for %d scenarios", scenarios)
+	return nil
+}
+
+// reindexScenario refreshes the indexed text of one scenario, or drops it from
+// the index if the scenario no longer exists.
+func reindexScenario(db dbExecutor, scenarioID string) error {
+	if !searchAvailable {
+		return nil
+	}
+	if _, err := db.Exec("DELETE FROM scenarios_fts WHERE scenario_id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to unindex scenario %s: %v", scenarioID, err)
+	}
+	if _, err := db.Exec("INSERT INTO scenarios_fts "+searchSourceSQL+" WHERE s.id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to index scenario %s: %v", scenarioID, err)
+	}
+	return nil
+}
+
+// reindexProject refreshes the indexed text of every scenario of a project in one pass.
+func reindexProject(db dbExecutor, projectID int) error {
+	if !searchAvailable {
+		return nil
+	}
+	if _, err := db.Exec("DELETE FROM scenarios_fts WHERE project_id = ?", projectID); err != nil {
+		return fmt.Errorf("failed to unindex scenarios of project %d: %v", projectID, err)
+	}
+	_, err := db.Exec("INSERT INTO scenarios_fts "+searchSourceSQL+" WHERE s.project_id = ?", projectID)
+	if err != nil {
+		return fmt.Errorf("failed to index scenarios of project %d: %v", projectID, err)
+	}
+	return nil
+}
+
+// ScenarioSearchHit is a scenario matching a full-text search.
+type ScenarioSearchHit struct {
+	Scenario      models.Scenario `json:"scenario"`
+	Rank          float64         `json:"rank"`           // bm25 score, lower is better
+	NameHighlight string          `json:"name_highlight"` // HTML-escaped name with matches wrapped in <mark></mark>
+	Snippet       string          `json:"snippet"`        // Best matching fragment of any column, escaped and marked the same way
+}
+
+// ScenarioSearchResults is one page of full-text search hits, best first.
+type ScenarioSearchResults struct {
+	Hits       []ScenarioSearchHit `json:"data"`
+	Total      int                 `json:"total"`
+	Limit      int                 `json:"limit"`
+	Offset     int                 `json:"offset"`
+	NextOffset *int                `json:"next_offset"`
+}
+
+// SearchScenarioText searches the names, descriptions and steps of a project's
+// scenarios. Every word of text must match; the last one also matches as a
+// prefix, and "double quoted" words must appear as a phrase.
+func SearchScenarioText(projectID, userID int, text string, limit, offset int) (*ScenarioSearchResults, error) {
+	if !searchAvailable {
+		return nil, ErrSearchUnavailable
+	}
+	match := ftsMatchQuery(text)
+	if match == "" {
+		return nil, ErrInvalidSearchQuery
+	}
+	if limit <= 0 || limit > MaxScenarioPageSize {
+		limit = MaxScenarioPageSize
+	}
+	if err := CheckProjectAccess(userID, projectID); err != nil {
+		return nil, err
+	}
+
+	const where = "scenarios_fts MATCH ? AND project_id = ?"
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM scenarios_fts WHERE "+where, match, projectID).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count search results: %v", err)
+	}
+
+	rows, err := DB.Query(
+		fmt.Sprintf(`SELECT scenario_id, %[1]s,
+                 highlight(scenarios_fts, %[2]d, char(2), char(3)),
+                 snippet(scenarios_fts, -1, char(2), char(3), '…', 16)
+             FROM scenarios_fts WHERE %[3]s
+             ORDER BY %[1]s, scenario_id LIMIT ? OFFSET ?`, searchRankSQL, searchNameColumn, where),
+		match, projectID, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search scenarios: %v", err)
+	}
+	defer rows.Close()
+
+	hits := make([]ScenarioSearchHit, 0)
+	ids := make([]interface{}, 0)
+	for rows.Next() {
+		var hit ScenarioSearchHit
+		if err := rows.Scan(&hit.Scenario.ID, &hit.Rank, &hit.NameHighlight, &hit.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %v", err)
+		}
+		hit.NameHighlight = markHighlights(hit.NameHighlight)
+		hit.Snippet = markHighlights(hit.Snippet)
+		hits = append(hits, hit)
+		ids = append(ids, hit.Scenario.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	if len(ids) > 0 {
+		scenarios, err := queryScenarios(DB, "s.id IN ("+placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[string]models.Scenario, len(scenarios))
+		for _, scenario := range scenarios {
+			byID[scenario.ID] = scenario
+		}
+		for i := range hits {
+			hits[i].Scenario = byID[hits[i].Scenario.ID]
+		}
+	}
+
+	results := &ScenarioSearchResults{Hits: hits, Total: total, Limit: limit, Offset: offset}
+	if next := offset + len(hits); len(hits) > 0 && next < total {
+		results.NextOffset = &next
+	}
+	return results, nil
+}
+
+// ftsMatchQuery turns user input into an FTS5 query. Every word and "quoted
+// phrase" becomes a quoted FTS5 string, so operators and punctuation in the
+// input are searched literally instead of being parsed as query syntax.
+func ftsMatchQuery(text string) string {
+	var terms []string
+	lastIsWord := false
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimSpace(text) {
+		if text[0] == '"' {
+			phrase, rest, _ := strings.Cut(text[1:], `"`)
+			text = rest
+			if strings.IndexFunc(phrase, isSearchable) >= 0 {
+				terms = append(terms, ftsString(phrase))
+				lastIsWord = false
+			}
+			continue
+		}
+		end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+		if strings.IndexFunc(word, isSearchable) >= 0 {
+			terms = append(terms, ftsString(word))
+			lastIsWord = true
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	if lastIsWord {
+		// Match the word being typed as a prefix, for search-as-you-type.
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+func ftsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// isSearchable reports whether r is part of a token for the unicode61 tokenizer.
+func isSearchable(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
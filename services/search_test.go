@@ -0,0 +1,132 @@
+//go:build sqlite_fts5
+
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// newSearchTestProject caches the scenarios of project 1 from the fake
+// server, with extra scenarios added to the fixtures.
+func newSearchTestProject(t *testing.T, extra ...fakestudio.Scenario) *models.User {
+	t.Helper()
+	openTestDB(t)
+	if !SearchAvailable() {
+		t.Fatal("SQLite lacks FTS5 despite the sqlite_fts5 build tag")
+	}
+	fixtures := fakestudio.DefaultFixtures()
+	fixtures.Scenarios["1"] = append(fixtures.Scenarios["1"], extra...)
+	studio := fakestudio.NewServerWithFixtures(fixtures)
+	t.Cleanup(studio.Close)
+
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	if _, _, err := RefreshScenarios(context.Background(), newTestClient(t, studio, -1), user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	return user
+}
+
+func TestSearchScenarioTextEscapesHighlights(t *testing.T) {
+	user := newSearchTestProject(t, fakestudio.Scenario{
+		ID: "1100", Name: `Login as <img src=x onerror="alert(1)">`, FolderID: 11,
+		Description: "Names & descriptions are <b>user</b> input.",
+	})
+
+	results, err := SearchScenarioText(1, user.ID, "onerror", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchScenarioText: %v", err)
+	}
+	if len(results.Hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(results.Hits))
+	}
+	hit := results.Hits[0]
+	if want := `Login as &lt;img src=x <mark>onerror</mark>=&#34;alert(1)&#34;&gt;`; hit.NameHighlight != want {
+		t.Errorf("got name highlight %q, want %q", hit.NameHighlight, want)
+	}
+	if strings.Contains(hit.Snippet, "<img") || !strings.Contains(hit.Snippet, "<mark>onerror</mark>") {
+		t.Errorf("snippet %q is not escaped and marked", hit.Snippet)
+	}
+	if hit.Scenario.Name != `Login as <img src=x onerror="alert(1)">` {
+		t.Errorf("the scenario name %q was escaped too", hit.Scenario.Name)
+	}
+
+	results, err = SearchScenarioText(1, user.ID, "user input", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchScenarioText: %v", err)
+	}
+	if len(results.Hits) != 1 || !strings.Contains(results.Hits[0].Snippet, "&amp; descriptions are &lt;b&gt;<mark>user</mark>&lt;/b&gt; <mark>input</mark>") {
+		t.Errorf("got hits %+v, want the description snippet escaped", results.Hits)
+	}
+}
+
+func TestSearchScenarioTextRanksNameHitsFirst(t *testing.T) {
+	user := newSearchTestProject(t,
+		fakestudio.Scenario{ID: "1100", Name: "Check out as a guest", FolderID: 12, Description: "Buying without a password works too."},
+		fakestudio.Scenario{ID: "1101", Name: "Change the password", FolderID: 11},
+	)
+
+	results, err := SearchScenarioText(1, user.ID, "password", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchScenarioText: %v", err)
+	}
+	// Three names contain the word, 1100 has it in its description and 1000
+	// only in its steps.
+	var ids []string
+	for _, hit := range results.Hits {
+		ids = append(ids, hit.Scenario.ID)
+	}
+	if len(ids) != 5 || ids[3] != "1100" || ids[4] != "1000" {
+		t.Fatalf("got hits %v, want the three name matches, then 1100, then 1000", ids)
+	}
+	for i := 1; i < len(results.Hits); i++ {
+		if results.Hits[i].Rank < results.Hits[i-1].Rank {
+			t.Errorf("hit %d ranks %f, better than the hit before it (%f)", i, results.Hits[i].Rank, results.Hits[i-1].Rank)
+		}
+	}
+}
+
+func TestSearchScenarioTextPaginates(t *testing.T) {
+	user := newSearchTestProject(t)
+
+	all, err := SearchScenarioText(1, user.ID, "the", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchScenarioText: %v", err)
+	}
+	if all.Total < 3 || all.NextOffset != nil {
+		t.Fatalf("got %d of %d hits with next offset %v, want at least 3 on one page", len(all.Hits), all.Total, all.NextOffset)
+	}
+
+	var walked []string
+	offset := 0
+	for pages := 0; ; pages++ {
+		if pages > all.Total {
+			t.Fatalf("pagination did not end, got %v so far", walked)
+		}
+		page, err := SearchScenarioText(1, user.ID, "the", 2, offset)
+		if err != nil {
+			t.Fatalf("SearchScenarioText: %v", err)
+		}
+		if page.Total != all.Total || page.Limit != 2 || page.Offset != offset {
+			t.Errorf("page at %d reports total %d, limit %d, offset %d", offset, page.Total, page.Limit, page.Offset)
+		}
+		for _, hit := range page.Hits {
+			walked = append(walked, hit.Scenario.ID)
+		}
+		if page.NextOffset == nil {
+			break
+		}
+		offset = *page.NextOffset
+	}
+	var want []string
+	for _, hit := range all.Hits {
+		want = append(want, hit.Scenario.ID)
+	}
+	if strings.Join(walked, ",") != strings.Join(want, ",") {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"my-cucumber-backend/models"
+)
+
+var (
+	// ErrSessionNotFound is returned for sessions that do not exist or belong to another user.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionRevoked is returned for sessions ended by a logout or revocation.
+	ErrSessionRevoked = errors.New("session has been revoked")
+	// ErrSessionExpired is returned for sessions whose refresh token was not used
+	// in time, or that reached their maximum age.
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrInvalidRefreshToken is returned for refresh tokens that match no session.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is used after it was
+	// rotated, a sign it was stolen. The session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// refreshTokenTTL is how long a session survives without a refresh.
+var refreshTokenTTL = 30 * 24 * time.Hour
+
+// SetRefreshTokenTTL sets how long a session survives without a refresh.
+func SetRefreshTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		refreshTokenTTL = ttl
+	}
+}
+
+// sessionMaxAge is how long a session survives however often it is refreshed.
+var sessionMaxAge = 90 * 24 * time.Hour
+
+// SetSessionMaxAge sets how long a session survives however often it is
+// refreshed. It applies to sessions created from then on.
+func SetSessionMaxAge(maxAge time.Duration) {
+	if maxAge > 0 {
+		sessionMaxAge = maxAge
+	}
+}
+
+// sqliteTime formats times the way CURRENT_TIMESTAMP does, so they compare with it.
+const sqliteTime = "2006-01-02 15:04:05"
+
+const createSessionsTableSQL = `
+    CREATE TABLE IF NOT EXISTS sessions (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        refresh_token_hash TEXT NOT NULL UNIQUE,
+        previous_token_hash TEXT,
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip TEXT NOT NULL DEFAULT '',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL,
+        revoked_at DATETIME,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+    CREATE INDEX IF NOT EXISTS idx_sessions_previous_token ON sessions (previous_token_hash);
+`
+
+// initSessionTables creates the sessions table and adds the columns introduced
+// since: the absolute deadline of a session, which sessions started before it
+// existed get from their creation time.
+func initSessionTables() error {
+	if _, err := DB.Exec(createSessionsTableSQL); err != nil {
+		return fmt.Errorf("failed to create sessions table: %v", err)
+	}
+	if err := addColumnIfMissing("sessions", "absolute_expires_at", "DATETIME"); err != nil {
+		return err
+	}
+	maxAge := sqliteModifier(sessionMaxAge)
+	_, err := DB.Exec(
+		`UPDATE sessions SET absolute_expires_at = datetime(created_at, ?),
+             expires_at = MIN(expires_at, datetime(created_at, ?))
+         WHERE absolute_expires_at IS NULL`,
+		maxAge, maxAge,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set session deadlines: %v", err)
+	}
+	return nil
+}
+
+// sqliteModifier formats a duration as a datetime() modifier, e.g. "+3600 seconds".
+func sqliteModifier(d time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int64(d/time.Second))
+}
+
+// sessionExpiry returns when a session refreshed now expires: after the refresh
+// token TTL, but never past the session's absolute deadline.
+func sessionExpiry(deadline time.Time) string {
+	expiresAt := time.Now().UTC().Add(refreshTokenTTL)
+	if expiresAt.After(deadline) {
+		expiresAt = deadline
+	}
+	return expiresAt.Format(sqliteTime)
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_used_at, expires_at, absolute_expires_at, revoked_at`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullString
+	err := row.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.AbsoluteExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.String
+	}
+	return &session, nil
+}
+
+// CreateSession starts a session for a user who just logged in and returns it
+// with its refresh token. Sessions of the user that ended a while ago are cleaned up.
+func CreateSession(userID int, userAgent, ip string) (*models.Session, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = DB.Exec(
+		`DELETE FROM sessions WHERE user_id = ?
+             AND (revoked_at < datetime('now', '-30 days') OR expires_at < datetime('now', '-30 days'))`,
+		userID,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to clean up sessions: %v", err)
+	}
+	deadline := time.Now().UTC().Add(sessionMaxAge)
+	_, err = DB.Exec(
+		`INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip, expires_at, absolute_expires_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, userID, hashToken(refreshToken), userAgent, ip, sessionExpiry(deadline), deadline.Format(sqliteTime),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	session, err := GetSession(id, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, refreshToken, nil
+}
+
+// RotateSession exchanges a refresh token for a new one and extends its session,
+// up to the absolute deadline set when the session was created. Presenting a
+// token that was already exchanged revokes the session, so whoever holds the
+// newer token (the owner or a thief) has to log in again.
+func RotateSession(refreshToken, userAgent, ip string) (*models.Session, string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	hash := hashToken(refreshToken)
+	session, err := scanSession(tx.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE refresh_token_hash = ?", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		result, err := tx.Exec(
+			"UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE previous_token_hash = ? AND revoked_at IS NULL", hash,
+		)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to revoke session: %v", err)
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			if err := tx.Commit(); err != nil {
+				return nil, "", fmt.Errorf("failed to commit transaction: %v", err)
+			}
+			return nil, "", ErrRefreshTokenReused
+		}
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query session: %v", err)
+	}
+	if err := checkSessionActive(tx, session.ID); err != nil {
+		return nil, "", err
+	}
+	var deadline time.Time
+	if err := tx.QueryRow("SELECT absolute_expires_at FROM sessions WHERE id = ?", session.ID).Scan(&deadline); err != nil {
+		return nil, "", fmt.Errorf("failed to query session deadline: %v", err)
+	}
+
+	newToken, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = tx.Exec(
+		`UPDATE sessions SET refresh_token_hash = ?, previous_token_hash = ?, user_agent = ?, ip = ?,
+             last_used_at = CURRENT_TIMESTAMP, expires_at = ?
+         WHERE id = ?`,
+		hashToken(newToken), hash, userAgent, ip, sessionExpiry(deadline), session.ID,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	session, err = GetSession(session.ID, session.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, newToken, nil
+}
+
+// CheckSession returns nil if a session of the user is neither revoked nor expired.
+func CheckSession(sessionID string, userID int) error {
+	var owner int
+	err := DB.QueryRow("SELECT user_id FROM sessions WHERE id = ?", sessionID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query session: %v", err)
+	}
+	return checkSessionActive(DB, sessionID)
+}
+
+func checkSessionActive(db dbExecutor, sessionID string) error {
+	var revoked, expired bool
+	err := db.QueryRow(
+		"SELECT revoked_at IS NOT NULL, expires_at <= CURRENT_TIMESTAMP FROM sessions WHERE id = ?", sessionID,
+	).Scan(&revoked, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query session: %v", err)
+	}
+	switch {
+	case revoked:
+		return ErrSessionRevoked
+	case expired:
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// GetSession retrieves a session of a user.
+func GetSession(sessionID string, userID int) (*models.Session, error) {
+	session, err := scanSession(DB.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session: %v", err)
+	}
+	return session, nil
+}
+
+// GetActiveSessions lists the sessions of a user that are neither revoked nor
+// expired, most recently used first.
+func GetActiveSessions(userID int) ([]models.Session, error) {
+	rows, err := DB.Query(
+		"SELECT "+sessionColumns+` FROM sessions
+         WHERE user_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+         ORDER BY last_used_at DESC, created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]models.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of a user. Revoking a revoked session is a no-op.
+func RevokeSession(sessionID string, userID int) error {
+	result, err := DB.Exec(
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = ? AND user_id = ?",
+		sessionID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	return requireAffected(result, ErrSessionNotFound)
+}
+
+// RevokeSessionByRefreshToken ends the session a refresh token belongs to.
+func RevokeSessionByRefreshToken(refreshToken string) error {
+	result, err := DB.Exec(
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE refresh_token_hash = ?",
+		hashToken(refreshToken),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	return requireAffected(result, ErrInvalidRefreshToken)
+}
+
+// RevokeOtherSessions ends every active session of a user except keepID (which
+// may be empty to end them all) and returns how many were ended.
+func RevokeOtherSessions(userID int, keepID string) (int, error) {
+	result, err := DB.Exec(
+		"UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		userID, keepID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %v", err)
+	}
+	return int(revoked), nil
+}
+
+// randomToken returns n random bytes, URL-safe base64 encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a refresh token for storage. Tokens are random, so a fast
+// hash is enough: there is nothing to brute-force.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
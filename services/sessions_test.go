@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// setSessionLifetimes changes the refresh token TTL and session maximum age for one test.
+func setSessionLifetimes(t *testing.T, ttl, maxAge time.Duration) {
+	t.Helper()
+	oldTTL, oldMaxAge := refreshTokenTTL, sessionMaxAge
+	t.Cleanup(func() { refreshTokenTTL, sessionMaxAge = oldTTL, oldMaxAge })
+	SetRefreshTokenTTL(ttl)
+	SetSessionMaxAge(maxAge)
+}
+
+func parseSessionTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("unexpected session time %q: %v", value, err)
+	}
+	return parsed
+}
+
+func TestRotateSessionStopsAtTheAbsoluteDeadline(t *testing.T) {
+	openTestDB(t)
+	setSessionLifetimes(t, time.Hour, 3*time.Hour)
+	user := createTestUser(t, "qa@example.com", "client", "token")
+
+	session, token, err := CreateSession(user.ID, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	deadline := parseSessionTime(t, session.AbsoluteExpiresAt)
+	if until := time.Until(deadline); until < 3*time.Hour-time.Minute || until > 3*time.Hour+time.Minute {
+		t.Errorf("session ends in %s, want 3h", until)
+	}
+	if until := time.Until(parseSessionTime(t, session.ExpiresAt)); until > time.Hour+time.Minute {
+		t.Errorf("refresh token expires in %s, want 1h", until)
+	}
+
+	// Move the deadline closer than the refresh token TTL: rotating must not
+	// extend the session past it, however often it happens.
+	if _, err := DB.Exec("UPDATE sessions SET absolute_expires_at = datetime('now', '+30 minutes') WHERE id = ?", session.ID); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		session, token, err = RotateSession(token, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("RotateSession %d: %v", i, err)
+		}
+		if session.ExpiresAt != session.AbsoluteExpiresAt {
+			t.Errorf("rotation %d set expires_at %s, want the deadline %s", i, session.ExpiresAt, session.AbsoluteExpiresAt)
+		}
+	}
+
+	// Past the deadline the refresh token no longer works.
+	if _, err := DB.Exec(
+		"UPDATE sessions SET expires_at = datetime('now', '-1 second'), absolute_expires_at = datetime('now', '-1 second') WHERE id = ?",
+		session.ID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RotateSession(token, "test", "127.0.0.1"); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("got error %v, want ErrSessionExpired", err)
+	}
+	if err := CheckSession(session.ID, user.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("got error %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestCreateSessionCapsTheRefreshTokenAtTheMaxAge(t *testing.T) {
+	openTestDB(t)
+	setSessionLifetimes(t, 24*time.Hour, time.Hour)
+	user := createTestUser(t, "qa@example.com", "client", "token")
+
+	session, _, err := CreateSession(user.ID, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if session.ExpiresAt != session.AbsoluteExpiresAt {
+		t.Errorf("refresh token expires at %s, after the session deadline %s", session.ExpiresAt, session.AbsoluteExpiresAt)
+	}
+}
+
+func TestInitSessionTablesSetsDeadlinesOfOlderSessions(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token")
+
+	// A session created 100 days ago, before sessions had a deadline, and kept
+	// alive by refreshes since.
+	_, err := DB.Exec(
+		`INSERT INTO sessions (id, user_id, refresh_token_hash, created_at, expires_at)
+         VALUES ('old', ?, 'hash', datetime('now', '-100 days'), datetime('now', '+20 days'))`,
+		user.ID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initSessionTables(); err != nil {
+		t.Fatalf("initSessionTables: %v", err)
+	}
+
+	session, err := GetSession("old", user.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.ExpiresAt != session.AbsoluteExpiresAt {
+		t.Errorf("older session expires at %s, want its deadline %s", session.ExpiresAt, session.AbsoluteExpiresAt)
+	}
+	if err := CheckSession("old", user.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("got error %v, want ErrSessionExpired for a session older than the max age", err)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrNothingToPush is returned when no result of a test run matched a cached scenario.
+var ErrNothingToPush = errors.New("no result of the test run matched a cached scenario")
+
+// maxStudioDescription bounds the error message sent along with a failed execution.
+const maxStudioDescription = 2000
+
+// studioStatuses maps result statuses onto Cucumber Studio execution statuses.
+var studioStatuses = map[models.TestStatus]string{
+	models.TestPassed:  "passed",
+	models.TestFailed:  "failed",
+	models.TestSkipped: "skipped",
+	models.TestPending: "wip",
+}
+
+// statusSeverity ranks the final attempts of a scenario that ran more than
+// once in a run (an outline). The most severe status wins, like in scenarioOutcome.
+var statusSeverity = map[models.TestStatus]int{models.TestPassed: 0, models.TestSkipped: 1, models.TestPending: 2, models.TestFailed: 3}
+
+// scenarioExecution is the combined outcome of a scenario in a test run.
+type scenarioExecution struct {
+	scenarioID   string
+	name         string
+	status       models.TestStatus
+	errorMessage string
+}
+
+// PushTestRun sets the execution statuses of a Cucumber Studio test run from a
+// stored test run. The Studio test run is the one the run was pushed to before,
+// otherwise one with the same name, otherwise a new one executing every matched
+// scenario. Executions already in the right status are left alone. With dryRun
+// nothing is written, neither to Studio nor locally.
+func PushTestRun(ctx context.Context, client StudioClient, user *models.User, runID int, dryRun bool) (*models.StudioPush, error) {
+	run, err := GetTestRun(runID, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	executions := scenarioExecutions(run.Results)
+	if len(executions) == 0 {
+		return nil, ErrNothingToPush
+	}
+
+	push := &models.StudioPush{
+		DryRun:            dryRun,
+		TestRunID:         run.ID,
+		StudioTestRunName: run.Name,
+		Updates:           make([]models.StudioExecutionUpdate, 0),
+		Missing:           make([]string, 0),
+		Unmatched:         run.Summary.Unmatched,
+	}
+	if push.StudioTestRunName == "" {
+		push.StudioTestRunName = fmt.Sprintf("Test run #%d", run.ID)
+	}
+
+	studioRuns, err := client.GetTestRuns(ctx, user, run.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	var target *TestRunResponse
+	for i := range studioRuns {
+		if run.StudioTestRunID != nil && studioRuns[i].ID == *run.StudioTestRunID {
+			target = &studioRuns[i]
+			break
+		}
+		if target == nil && studioRuns[i].Attributes.Name == push.StudioTestRunName {
+			target = &studioRuns[i]
+		}
+	}
+
+	if target == nil {
+		push.CreatedTestRun = true
+		if dryRun {
+			for _, execution := range executions {
+				push.Updates = append(push.Updates, executionUpdate(execution, "", "undefined"))
+			}
+			return push, nil
+		}
+		scenarioIDs := make([]string, len(executions))
+		for i, execution := range executions {
+			scenarioIDs[i] = execution.scenarioID
+		}
+		if target, err = client.CreateTestRun(ctx, user, run.ProjectID, push.StudioTestRunName, scenarioIDs); err != nil {
+			return nil, err
+		}
+	}
+	push.StudioTestRunID = target.ID
+	push.StudioTestRunName = target.Attributes.Name
+
+	snapshots, err := client.GetTestSnapshots(ctx, user, run.ProjectID, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	byScenario := make(map[string]TestSnapshotResponse, len(snapshots))
+	for _, snapshot := range snapshots {
+		byScenario[snapshot.Attributes.ScenarioID.String()] = snapshot
+	}
+	for _, execution := range executions {
+		snapshot, ok := byScenario[execution.scenarioID]
+		if !ok {
+			push.Missing = append(push.Missing, execution.scenarioID)
+			continue
+		}
+		update := executionUpdate(execution, snapshot.ID, snapshot.Attributes.Status)
+		if update.From == update.To {
+			push.Unchanged++
+			continue
+		}
+		push.Updates = append(push.Updates, update)
+	}
+	if dryRun {
+		return push, nil
+	}
+
+	for _, update := range push.Updates {
+		if err := client.CreateTestResult(ctx, user, run.ProjectID, target.ID, update.SnapshotID, update.To, update.Description); err != nil {
+			return nil, err
+		}
+	}
+	_, err = DB.Exec("UPDATE test_runs SET studio_test_run_id = ?, pushed_at = CURRENT_TIMESTAMP WHERE id = ?", target.ID, run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record the push of test run %d: %v", run.ID, err)
+	}
+	return push, nil
+}
+
+// scenarioExecutions combines the final attempts of every matched scenario,
+// in scenario ID order, ranked by statusSeverity.
+func scenarioExecutions(results []models.TestResult) []scenarioExecution {
+	byScenario := make(map[string]*scenarioExecution)
+	for _, result := range results {
+		if result.ScenarioID == nil || result.Retried {
+			continue
+		}
+		execution := byScenario[*result.ScenarioID]
+		if execution == nil {
+			execution = &scenarioExecution{scenarioID: *result.ScenarioID, name: result.Name, status: result.Status, errorMessage: result.ErrorMessage}
+			byScenario[*result.ScenarioID] = execution
+			continue
+		}
+		if statusSeverity[result.Status] > statusSeverity[execution.status] {
+			execution.status = result.Status
+			execution.errorMessage = result.ErrorMessage
+		}
+	}
+
+	executions := make([]scenarioExecution, 0, len(byScenario))
+	for _, execution := range byScenario {
+		executions = append(executions, *execution)
+	}
+	sort.Slice(executions, func(i, j int) bool { return lessScenarioID(executions[i].scenarioID, executions[j].scenarioID) })
+	return executions
+}
+
+func executionUpdate(execution scenarioExecution, snapshotID, from string) models.StudioExecutionUpdate {
+	description := execution.errorMessage
+	if runes := []rune(description); len(runes) > maxStudioDescription {
+		description = string(runes[:maxStudioDescription]) + "…"
+	}
+	return models.StudioExecutionUpdate{
+		SnapshotID:  snapshotID,
+		ScenarioID:  execution.scenarioID,
+		Name:        execution.name,
+		From:        from,
+		To:          studioStatuses[execution.status],
+		Description: description,
+	}
+}
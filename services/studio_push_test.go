@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// newPushTestRun caches the scenarios of project 1 from the fake server and
+// stores a test run of the given results, linked to them by name.
+func newPushTestRun(t *testing.T, client StudioClient, name string, results []models.TestResult) (*models.User, *models.TestRun) {
+	t.Helper()
+	user := createTestUser(t, testStudioUser.Email, testStudioUser.CucumberClientID, testStudioUser.CucumberAccessToken, models.Project{ID: "1", Name: "Shop"})
+	if _, _, err := RefreshScenarios(context.Background(), client, user, 1); err != nil {
+		t.Fatalf("RefreshScenarios: %v", err)
+	}
+	run, err := CreateTestRun(user.ID, 1, name, "junit-xml", results)
+	if err != nil {
+		t.Fatalf("CreateTestRun: %v", err)
+	}
+	return user, run
+}
+
+// pushedUpdates lists the scenario, from and to status of every update.
+func pushedUpdates(push *models.StudioPush) [][3]string {
+	updates := make([][3]string, len(push.Updates))
+	for i, update := range push.Updates {
+		updates[i] = [3]string{update.ScenarioID, update.From, update.To}
+	}
+	return updates
+}
+
+func TestPushTestRunCreatesStudioTestRun(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	ctx := context.Background()
+
+	user, run := newPushTestRun(t, client, "Nightly", []models.TestResult{
+		{Name: "Add an item to the cart", Status: models.TestPassed},
+		{Name: "Pay with a credit card", Status: models.TestFailed, ErrorMessage: "card declined"},
+		{Name: "Pay with a gift card", Status: models.TestFailed, ErrorMessage: "timed out", Retried: true},
+		{Name: "Pay with a gift card", Status: models.TestPassed, Attempt: 1},
+		{Name: "Pay with cash", Status: models.TestPassed},
+	})
+	wantUpdates := [][3]string{
+		{"1003", "undefined", "passed"},
+		{"1005", "undefined", "failed"},
+		{"1006", "undefined", "passed"},
+	}
+
+	dryRun, err := PushTestRun(ctx, client, user, run.ID, true)
+	if err != nil {
+		t.Fatalf("PushTestRun(dry run): %v", err)
+	}
+	if !dryRun.DryRun || !dryRun.CreatedTestRun || dryRun.StudioTestRunID != "" || dryRun.Unmatched != 1 {
+		t.Errorf("dry run reported %+v, want a test run to create and 1 unmatched result", dryRun)
+	}
+	if got := pushedUpdates(dryRun); !reflect.DeepEqual(got, wantUpdates) {
+		t.Errorf("dry run would push %v, want %v", got, wantUpdates)
+	}
+	if writes := studio.WriteRequests(); len(writes) != 0 {
+		t.Fatalf("dry run sent %d writes to Studio: %+v", len(writes), writes)
+	}
+	if stored, err := GetTestRun(run.ID, user.ID, false); err != nil || stored.StudioTestRunID != nil || stored.PushedAt != nil {
+		t.Errorf("dry run recorded a push locally: %+v, %v", stored, err)
+	}
+
+	push, err := PushTestRun(ctx, client, user, run.ID, false)
+	if err != nil {
+		t.Fatalf("PushTestRun: %v", err)
+	}
+	if !push.CreatedTestRun || push.StudioTestRunID == "" || push.StudioTestRunName != "Nightly" {
+		t.Errorf("push reported %+v, want a created test run named Nightly", push)
+	}
+	if got := pushedUpdates(push); !reflect.DeepEqual(got, wantUpdates) {
+		t.Errorf("pushed %v, want %v", got, wantUpdates)
+	}
+	if got := len(requestsTo(studio, http.MethodPost, "/projects/1/test_runs")); got != 1 {
+		t.Errorf("created %d Studio test runs, want 1", got)
+	}
+	if got, want := len(studio.WriteRequests()), 1+len(wantUpdates); got != want {
+		t.Errorf("sent %d writes, want the test run and one result per linked scenario: %d", got, want)
+	}
+
+	runs := studio.TestRuns("1")
+	created := runs[len(runs)-1]
+	if len(runs) != 2 || created.ID != push.StudioTestRunID || created.Name != "Nightly" {
+		t.Fatalf("Studio has test runs %+v, want the fixture and Nightly", runs)
+	}
+	results := make(map[string][]fakestudio.TestResult)
+	for _, snapshot := range created.Snapshots {
+		results[snapshot.ScenarioID] = snapshot.Results
+	}
+	wantResults := map[string][]fakestudio.TestResult{
+		"1003": {{Status: "passed"}},
+		"1005": {{Status: "failed", Description: "card declined"}},
+		"1006": {{Status: "passed"}},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("Studio received results %+v, want %+v", results, wantResults)
+	}
+
+	stored, err := GetTestRun(run.ID, user.ID, false)
+	if err != nil {
+		t.Fatalf("GetTestRun: %v", err)
+	}
+	if stored.StudioTestRunID == nil || *stored.StudioTestRunID != push.StudioTestRunID || stored.PushedAt == nil {
+		t.Errorf("stored run %+v does not record the push to %s", stored, push.StudioTestRunID)
+	}
+
+	// Pushing again reuses the test run and has nothing left to change.
+	writes := len(studio.WriteRequests())
+	again, err := PushTestRun(ctx, client, user, run.ID, false)
+	if err != nil {
+		t.Fatalf("PushTestRun(again): %v", err)
+	}
+	if again.CreatedTestRun || again.StudioTestRunID != push.StudioTestRunID || len(again.Updates) != 0 || again.Unchanged != 3 {
+		t.Errorf("second push reported %+v, want 3 unchanged executions of %s", again, push.StudioTestRunID)
+	}
+	if got := len(studio.WriteRequests()); got != writes {
+		t.Errorf("second push sent %d writes, want none", got-writes)
+	}
+}
+
+func TestPushTestRunUpdatesExistingStudioTestRun(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+	ctx := context.Background()
+
+	// The fixture run executes 1000 (passed), 1001 (failed) and 1002 (undefined).
+	user, run := newPushTestRun(t, client, "Sprint 12 regression", []models.TestResult{
+		{Name: "Login with valid credentials", Status: models.TestPassed},
+		{Name: "Login with a wrong password", Status: models.TestPassed},
+		{Name: "Reset a forgotten password", Status: models.TestPending},
+		{Name: "Add an item to the cart", Status: models.TestPassed},
+	})
+	wantUpdates := [][3]string{
+		{"1001", "failed", "passed"},
+		{"1002", "undefined", "wip"},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		push, err := PushTestRun(ctx, client, user, run.ID, dryRun)
+		if err != nil {
+			t.Fatalf("PushTestRun(dry run %v): %v", dryRun, err)
+		}
+		if push.CreatedTestRun || push.StudioTestRunID != "700" || push.Unchanged != 1 || !reflect.DeepEqual(push.Missing, []string{"1003"}) {
+			t.Errorf("dry run %v reported %+v, want test run 700 with 1 unchanged and 1003 missing", dryRun, push)
+		}
+		if got := pushedUpdates(push); !reflect.DeepEqual(got, wantUpdates) {
+			t.Errorf("dry run %v pushed %v, want %v", dryRun, got, wantUpdates)
+		}
+		if dryRun && len(studio.WriteRequests()) != 0 {
+			t.Fatalf("dry run sent writes to Studio: %+v", studio.WriteRequests())
+		}
+	}
+
+	writes := studio.WriteRequests()
+	if len(writes) != len(wantUpdates) {
+		t.Fatalf("sent %d writes, want one result per changed execution: %+v", len(writes), writes)
+	}
+	for _, write := range writes {
+		if write.Method != http.MethodPost || write.Path == "/projects/1/test_runs" {
+			t.Errorf("unexpected write %s %s", write.Method, write.Path)
+		}
+	}
+	statuses := make(map[string]string)
+	for _, snapshot := range studio.TestRuns("1")[0].Snapshots {
+		statuses[snapshot.ScenarioID] = snapshot.Status
+	}
+	if want := map[string]string{"1000": "passed", "1001": "passed", "1002": "wip"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Studio test run has statuses %v, want %v", statuses, want)
+	}
+}
+
+func TestPushTestRunWithoutMatchedResults(t *testing.T) {
+	openTestDB(t)
+	studio := fakestudio.NewServer()
+	defer studio.Close()
+	client := newTestClient(t, studio, -1)
+
+	user, run := newPushTestRun(t, client, "Nightly", []models.TestResult{
+		{Name: "Pay with cash", Status: models.TestPassed},
+	})
+	if _, err := PushTestRun(context.Background(), client, user, run.ID, false); !errors.Is(err, ErrNothingToPush) {
+		t.Fatalf("got error %v, want ErrNothingToPush", err)
+	}
+	if writes := studio.WriteRequests(); len(writes) != 0 {
+		t.Errorf("sent %d writes to Studio, want none", len(writes))
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrSyncJobNotFound is returned when a job does not exist or belongs to another user.
+var ErrSyncJobNotFound = errors.New("sync job not found")
+
+// SyncJobRunner runs sync jobs in the background, persists their progress in
+// sync_jobs and notifies subscribers of every change.
+type SyncJobRunner struct {
+	client StudioClient
+	slots  chan struct{}
+
+	mu          sync.Mutex
+	subscribers map[int]map[chan models.SyncJob]struct{}
+}
+
+// NewSyncJobRunner creates a runner executing at most concurrency jobs at once.
+// Jobs left queued or running by a previous process are marked as failed.
+func NewSyncJobRunner(client StudioClient, concurrency int) (*SyncJobRunner, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	_, err := DB.Exec(
+		"UPDATE sync_jobs SET state = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE state IN (?, ?)",
+		models.SyncJobFailed, "interrupted by a server restart", models.SyncJobQueued, models.SyncJobRunning,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean up interrupted sync jobs: %v", err)
+	}
+	return &SyncJobRunner{
+		client:      client,
+		slots:       make(chan struct{}, concurrency),
+		subscribers: make(map[int]map[chan models.SyncJob]struct{}),
+	}, nil
+}
+
+// Enqueue persists a new job and starts it in the background. projectID is
+// required for scenario and folder jobs and ignored for SyncJobAll.
+func (r *SyncJobRunner) Enqueue(user *models.User, kind models.SyncJobKind, projectID *int) (*models.SyncJob, error) {
+	switch kind {
+	case models.SyncJobScenarios, models.SyncJobFolders:
+		if projectID == nil {
+			return nil, fmt.Errorf("project_id is required for %s jobs", kind)
+		}
+	case models.SyncJobAll:
+		projectID = nil
+	default:
+		return nil, fmt.Errorf("unknown sync job kind %q", kind)
+	}
+
+	result, err := DB.Exec(
+		"INSERT INTO sync_jobs (user_id, kind, project_id, state) VALUES (?, ?, ?, ?)",
+		user.ID, kind, projectID, models.SyncJobQueued,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync job: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %v", err)
+	}
+
+	job, err := GetSyncJob(int(id), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs outlive the request that created them.
+	go r.run(context.Background(), user, *job)
+	return job, nil
+}
+
+func (r *SyncJobRunner) run(ctx context.Context, user *models.User, job models.SyncJob) {
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	job.State = models.SyncJobRunning
+	if job.Kind == models.SyncJobAll {
+		job.ProjectsTotal = 0
+	} else {
+		job.ProjectsTotal = 1
+	}
+	r.update(&job, "started_at = CURRENT_TIMESTAMP")
+
+	var err error
+	switch job.Kind {
+	case models.SyncJobScenarios:
+		var summary *models.RefreshSummary
+		_, summary, err = RefreshScenarios(ctx, r.client, user, *job.ProjectID)
+		if err == nil {
+			job.Summary = *summary
+			job.ProjectsDone = 1
+		}
+	case models.SyncJobFolders:
+		var summary *models.RefreshSummary
+		summary, err = RefreshFolders(ctx, r.client, user, *job.ProjectID)
+		if err == nil {
+			job.Summary = *summary
+			job.ProjectsDone = 1
+		}
+	case models.SyncJobAll:
+		_, _, _, err = RefreshAllScenarios(ctx, r.client, user, func(done, total int, summary models.RefreshSummary, failed []models.ProjectFailure) {
+			job.ProjectsDone = done
+			job.ProjectsTotal = total
+			job.Summary = summary
+			job.FailedProjects = failed
+			r.update(&job, "")
+		})
+	}
+
+	switch {
+	case err != nil:
+		log.Printf("Sync job %d failed: %v", job.ID, err)
+		job.State = models.SyncJobFailed
+		job.Error = PublicError(err)
+	case len(job.FailedProjects) > 0:
+		log.Printf("Sync job %d failed for %d of %d projects", job.ID, len(job.FailedProjects), job.ProjectsTotal)
+		job.State = models.SyncJobPartial
+		job.Error = fmt.Sprintf("failed to refresh %d of %d projects", len(job.FailedProjects), job.ProjectsTotal)
+	default:
+		job.State = models.SyncJobSucceeded
+	}
+	r.update(&job, "finished_at = CURRENT_TIMESTAMP")
+}
+
+// update persists the job's progress, optionally setting an extra column, and notifies subscribers.
+func (r *SyncJobRunner) update(job *models.SyncJob, extra string) {
+	failedJSON, err := json.Marshal(job.FailedProjects)
+	if err != nil {
+		log.Printf("Failed to marshal failed projects of sync job %d: %v", job.ID, err)
+		failedJSON = []byte("[]")
+	}
+
+	query := `UPDATE sync_jobs SET state = ?, projects_total = ?, projects_done = ?,
+              added = ?, updated = ?, removed = ?, unchanged = ?, error = ?, failed_projects = ?`
+	if extra != "" {
+		query += ", " + extra
+	}
+	query += " WHERE id = ?"
+
+	_, err = DB.Exec(query,
+		job.State, job.ProjectsTotal, job.ProjectsDone,
+		job.Summary.Added, job.Summary.Updated, job.Summary.Removed, job.Summary.Unchanged,
+		job.Error, string(failedJSON), job.ID,
+	)
+	if err != nil {
+		log.Printf("Failed to update sync job %d: %v", job.ID, err)
+	}
+
+	// Re-read so subscribers see the timestamps set by the database.
+	if stored, err := GetSyncJob(job.ID, job.UserID); err == nil {
+		*job = *stored
+	}
+	r.publish(*job)
+}
+
+// Subscribe returns a channel receiving the job's state after every change,
+// and a function to stop receiving. Only the latest state is buffered.
+func (r *SyncJobRunner) Subscribe(jobID int) (<-chan models.SyncJob, func()) {
+	ch := make(chan models.SyncJob, 1)
+
+	r.mu.Lock()
+	if r.subscribers[jobID] == nil {
+		r.subscribers[jobID] = make(map[chan models.SyncJob]struct{})
+	}
+	r.subscribers[jobID][ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subscribers[jobID], ch)
+		if len(r.subscribers[jobID]) == 0 {
+			delete(r.subscribers, jobID)
+		}
+	}
+}
+
+func (r *SyncJobRunner) publish(job models.SyncJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range r.subscribers[job.ID] {
+		// Drop a stale state nobody has read yet; only the latest one matters.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- job
+	}
+}
+
+const syncJobColumns = `id, user_id, kind, project_id, state, projects_total, projects_done,
+    added, updated, removed, unchanged, error, failed_projects, created_at, started_at, finished_at`
+
+func scanSyncJob(row rowScanner) (*models.SyncJob, error) {
+	var job models.SyncJob
+	var projectID sql.NullInt64
+	var failedJSON string
+	var startedAt, finishedAt sql.NullString
+	err := row.Scan(
+		&job.ID, &job.UserID, &job.Kind, &projectID, &job.State, &job.ProjectsTotal, &job.ProjectsDone,
+		&job.Summary.Added, &job.Summary.Updated, &job.Summary.Removed, &job.Summary.Unchanged,
+		&job.Error, &failedJSON, &job.CreatedAt, &startedAt, &finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(failedJSON), &job.FailedProjects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal failed projects: %v", err)
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		job.ProjectID = &id
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.String
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.String
+	}
+	return &job, nil
+}
+
+// GetSyncJob retrieves a sync job owned by userID.
+func GetSyncJob(jobID, userID int) (*models.SyncJob, error) {
+	job, err := scanSyncJob(DB.QueryRow("SELECT "+syncJobColumns+" FROM sync_jobs WHERE id = ? AND user_id = ?", jobID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSyncJobNotFound
+		}
+		return nil, fmt.Errorf("failed to query sync job: %v", err)
+	}
+	return job, nil
+}
+
+// GetSyncJobsByUser retrieves the most recent sync jobs of a user, newest first.
+func GetSyncJobsByUser(userID, limit int) ([]models.SyncJob, error) {
+	rows, err := DB.Query("SELECT "+syncJobColumns+" FROM sync_jobs WHERE user_id = ? ORDER BY id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]models.SyncJob, 0)
+	for rows.Next() {
+		job, err := scanSyncJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sync job: %v", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"my-cucumber-backend/fakestudio"
+	"my-cucumber-backend/models"
+)
+
+// projectFailingClient fails GetScenarios for some projects.
+type projectFailingClient struct {
+	StudioClient
+	failing map[int]error
+}
+
+func (c projectFailingClient) GetScenarios(ctx context.Context, user *models.User, projectID int) ([]models.Scenario, error) {
+	if err, ok := c.failing[projectID]; ok {
+		return nil, err
+	}
+	return c.StudioClient.GetScenarios(ctx, user, projectID)
+}
+
+// waitForSyncJob follows a job until it finishes.
+func waitForSyncJob(t *testing.T, runner *SyncJobRunner, job *models.SyncJob) models.SyncJob {
+	t.Helper()
+	updates, stop := runner.Subscribe(job.ID)
+	defer stop()
+
+	// The job may have finished before the subscription.
+	if current, err := GetSyncJob(job.ID, job.UserID); err == nil && current.Finished() {
+		return *current
+	}
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case current := <-updates:
+			if current.Finished() {
+				return current
+			}
+		case <-timeout:
+			t.Fatalf("sync job %d did not finish", job.ID)
+		}
+	}
+}
+
+func TestSyncJobAllRecordsFailedProjects(t *testing.T) {
+	tests := []struct {
+		name      string
+		failing   map[int]error
+		wantState models.SyncJobState
+		wantError string
+	}{
+		{name: "every project refreshed", wantState: models.SyncJobSucceeded},
+		{
+			name:      "one project failed",
+			failing:   map[int]error{2: &StudioError{Kind: ErrStudioNotFound, StatusCode: 404, Body: "secret"}},
+			wantState: models.SyncJobPartial,
+			wantError: "failed to refresh 1 of 2 projects",
+		},
+		{
+			name: "every project failed",
+			failing: map[int]error{
+				1: &StudioError{Kind: ErrStudioUnavailable, StatusCode: 503},
+				2: &StudioError{Kind: ErrStudioNotFound, StatusCode: 404, Body: "secret"},
+			},
+			wantState: models.SyncJobFailed,
+			wantError: "failed to refresh scenarios for any project",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			studio := fakestudio.NewServer()
+			defer studio.Close()
+			user := createTestUser(t, "qa@example.com", "client", "token")
+
+			client := projectFailingClient{StudioClient: newTestClient(t, studio, -1), failing: tt.failing}
+			runner, err := NewSyncJobRunner(client, 1)
+			if err != nil {
+				t.Fatalf("NewSyncJobRunner: %v", err)
+			}
+			job, err := runner.Enqueue(user, models.SyncJobAll, nil)
+			if err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+
+			done := waitForSyncJob(t, runner, job)
+			if done.State != tt.wantState || done.Error != tt.wantError {
+				t.Errorf("job ended %s with error %q, want %s with %q", done.State, done.Error, tt.wantState, tt.wantError)
+			}
+			if done.ProjectsDone != 2 || done.ProjectsTotal != 2 {
+				t.Errorf("job did %d of %d projects, want 2 of 2", done.ProjectsDone, done.ProjectsTotal)
+			}
+			if len(done.FailedProjects) != len(tt.failing) {
+				t.Fatalf("got failed projects %v, want %d", done.FailedProjects, len(tt.failing))
+			}
+			for _, failure := range done.FailedProjects {
+				studioErr := tt.failing[failure.ProjectID].(*StudioError)
+				if want := studioErr.Summary(); failure.Error != want {
+					t.Errorf("project %d failed with %q, want %q without the response body", failure.ProjectID, failure.Error, want)
+				}
+			}
+
+			// What subscribers saw is what was stored.
+			stored, err := GetSyncJob(job.ID, user.ID)
+			if err != nil {
+				t.Fatalf("GetSyncJob: %v", err)
+			}
+			if stored.State != done.State || len(stored.FailedProjects) != len(done.FailedProjects) {
+				t.Errorf("stored job %s with %v, want %s with %v", stored.State, stored.FailedProjects, done.State, done.FailedProjects)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrInvalidTableQuery is wrapped by errors caused by a malformed data table query or columns.
+var ErrInvalidTableQuery = errors.New("invalid data table query")
+
+// tableColumnKeys lists the columns available for each dataset.
+var tableColumnKeys = map[models.ChartDataset][]string{
+	models.ChartDatasetScenarios:   {"id", "name", "folder", "tags", "status", "step_count"},
+	models.ChartDatasetTestResults: {"run", "day", "scenario_id", "name", "feature", "status", "duration_ms", "error_message", "attempt"},
+}
+
+// MaxTableRows caps TableQuery.Limit, which defaults to it.
+const MaxTableRows = 1000
+
+// ParseTableQuery decodes and checks the query of a data table.
+func ParseTableQuery(raw string) (*models.TableQuery, error) {
+	var q models.TableQuery
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&q); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTableQuery, err)
+	}
+
+	keys, ok := tableColumnKeys[q.Dataset]
+	if !ok {
+		return nil, fmt.Errorf("%w: dataset must be scenarios or test_results", ErrInvalidTableQuery)
+	}
+	if q.ProjectID <= 0 {
+		return nil, fmt.Errorf("%w: project_id is required", ErrInvalidTableQuery)
+	}
+	if q.Sort != nil && !containsString(keys, q.Sort.Column) {
+		return nil, fmt.Errorf("%w: sort.column must be one of %s", ErrInvalidTableQuery, strings.Join(keys, ", "))
+	}
+	if q.Filters.TagExpr != "" {
+		if _, err := ParseTagExpr(q.Filters.TagExpr); err != nil {
+			return nil, fmt.Errorf("%w: filters.tag_expr: %v", ErrInvalidTableQuery, err)
+		}
+	}
+	if q.Limit < 0 || q.Limit > MaxTableRows {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidTableQuery, MaxTableRows)
+	}
+	if q.Limit == 0 {
+		q.Limit = MaxTableRows
+	}
+	if q.Dataset == models.ChartDatasetTestResults && q.Filters.Runs == 0 {
+		q.Filters.Runs = DefaultChartRuns
+	}
+	return &q, nil
+}
+
+// ParseTableColumns decodes the columns of a data table and checks that they
+// are available for dataset.
+func ParseTableColumns(dataset models.ChartDataset, raw string) ([]models.TableColumn, error) {
+	var columns []models.TableColumn
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&columns); err != nil {
+		return nil, fmt.Errorf("%w: columns: %v", ErrInvalidTableQuery, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%w: at least one column is required", ErrInvalidTableQuery)
+	}
+	keys := tableColumnKeys[dataset]
+	for i, column := range columns {
+		if !containsString(keys, column.Key) {
+			return nil, fmt.Errorf("%w: columns[%d].key %q is not available for the %s dataset", ErrInvalidTableQuery, i, column.Key, dataset)
+		}
+	}
+	return columns, nil
+}
+
+// GetTableData runs the query of a saved data table.
+func GetTableData(tableID, userID int) (*models.TableData, error) {
+	table, err := GetDataTable(tableID, userID)
+	if err != nil {
+		return nil, err
+	}
+	q, err := ParseTableQuery(table.Query)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := ParseTableColumns(q.Dataset, table.Columns)
+	if err != nil {
+		return nil, err
+	}
+	return runSavedTable(table, q, columns, userID)
+}
+
+// runSavedTable runs the query of a saved data table for a viewer; like a
+// shared chart, a shared data table shows the test runs of its owner.
+func runSavedTable(table *models.DataTable, q *models.TableQuery, columns []models.TableColumn, viewerID int) (*models.TableData, error) {
+	if err := CheckProjectAccess(viewerID, q.ProjectID); err != nil {
+		return nil, err
+	}
+	data, err := RunTableQuery(q, columns, table.UserID)
+	if err != nil {
+		return nil, err
+	}
+	data.TableID = table.ID
+	return data, nil
+}
+
+// RunTableQuery returns the rows of a data table query with the value of every
+// column, sorted by q.Sort (dataset order otherwise) and cut at q.Limit.
+func RunTableQuery(q *models.TableQuery, columns []models.TableColumn, userID int) (*models.TableData, error) {
+	rows, err := queryDatasetRows(q.Dataset, q.ProjectID, userID, q.Filters)
+	if err != nil {
+		if errors.Is(err, ErrInvalidChartQuery) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTableQuery, err)
+		}
+		return nil, err
+	}
+
+	var paths map[string]string
+	for _, column := range columns {
+		if column.Key == "folder" || (q.Sort != nil && q.Sort.Column == "folder") {
+			if paths, err = folderPaths(q.ProjectID, userID); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if q.Sort != nil {
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, b := tableSortValue(rows[i], q.Sort.Column, paths), tableSortValue(rows[j], q.Sort.Column, paths)
+			if q.Sort.Descending {
+				return lessTableValue(b, a)
+			}
+			return lessTableValue(a, b)
+		})
+	}
+	data := &models.TableData{Columns: columns, Rows: make([]map[string]interface{}, 0), Total: len(rows)}
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+	for _, row := range rows {
+		record := make(map[string]interface{}, len(columns))
+		for _, column := range columns {
+			record[column.Key] = tableValue(row, column.Key, paths)
+		}
+		data.Rows = append(data.Rows, record)
+	}
+	return data, nil
+}
+
+// tableValue returns the value of a column for a row; nil when the row has none,
+// such as the folder of a result that matched no cached scenario.
+func tableValue(row datasetRow, key string, paths map[string]string) interface{} {
+	switch key {
+	case "status":
+		if row.status == "" {
+			return chartNotRun
+		}
+		return string(row.status)
+	case "run":
+		return row.runName
+	case "day":
+		return row.day
+	}
+	if row.result != nil {
+		switch key {
+		case "name":
+			return row.result.Name
+		case "scenario_id":
+			if row.result.ScenarioID == nil {
+				return nil
+			}
+			return *row.result.ScenarioID
+		case "feature":
+			return row.result.Feature
+		case "duration_ms":
+			return row.result.DurationMS
+		case "error_message":
+			return row.result.ErrorMessage
+		case "attempt":
+			return row.result.Attempt
+		}
+	}
+	if row.scenario == nil {
+		return nil
+	}
+	switch key {
+	case "id":
+		return row.scenario.ID
+	case "name":
+		return row.scenario.Name
+	case "folder":
+		if folderPath, ok := paths[strconv.Itoa(row.scenario.FolderID)]; ok {
+			return folderPath
+		}
+		return unfiledFeature
+	case "tags":
+		return scenarioTagNames(row.scenario.Tags)
+	case "step_count":
+		return row.scenario.StepCount
+	}
+	return nil
+}
+
+// tableSortValue is the value rows are sorted by: runs sort by ID and statuses
+// from best to worst, like chart labels.
+func tableSortValue(row datasetRow, key string, paths map[string]string) interface{} {
+	switch key {
+	case "run":
+		return row.runID
+	case "status":
+		return chartStatusOrder[tableValue(row, key, paths).(string)]
+	}
+	return tableValue(row, key, paths)
+}
+
+// lessTableValue orders column values; nil sorts last and numeric strings such
+// as scenario IDs numerically.
+func lessTableValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a != nil
+	}
+	switch a := a.(type) {
+	case int:
+		return a < b.(int)
+	case int64:
+		return a < b.(int64)
+	case string:
+		return lessScenarioID(a, b.(string))
+	case []string:
+		return strings.Join(a, ",") < strings.Join(b.([]string), ",")
+	}
+	return false
+}
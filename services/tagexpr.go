@@ -0,0 +1,356 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"my-cucumber-backend/models"
+)
+
+// TagExpr is a parsed Cucumber tag expression such as
+// "(@smoke or @regression) and not @wip".
+//
+// Tags are written @key or @key:value. A bare @key matches any tag with that key,
+// whatever its value. Keys and values may use * (any run of characters) and
+// ? (any single character) as wildcards, e.g. @priority:hi* or @jira:*.
+type TagExpr interface {
+	// Matches reports whether a scenario with the given tags satisfies the expression.
+	Matches(tags []models.Tag) bool
+	String() string
+}
+
+// TagExprSyntaxError describes an invalid tag expression.
+type TagExprSyntaxError struct {
+	Expr    string
+	Pos     int // Byte offset in Expr
+	Message string
+}
+
+func (e *TagExprSyntaxError) Error() string {
+	return fmt.Sprintf("invalid tag expression at position %d: %s", e.Pos+1, e.Message)
+}
+
+// ParseTagExpr parses a tag expression. Operator precedence is not > and > or.
+func ParseTagExpr(expr string) (TagExpr, error) {
+	tokens, err := tokenizeTagExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &tagExprParser{expr: expr, tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, &TagExprSyntaxError{Expr: expr, Pos: 0, Message: "expression is empty"}
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	return node, nil
+}
+
+type tagExprNot struct{ operand TagExpr }
+
+func (n tagExprNot) Matches(tags []models.Tag) bool { return !n.operand.Matches(tags) }
+func (n tagExprNot) String() string                 { return "not " + n.operand.String() }
+
+type tagExprAnd struct{ left, right TagExpr }
+
+func (n tagExprAnd) Matches(tags []models.Tag) bool {
+	return n.left.Matches(tags) && n.right.Matches(tags)
+}
+func (n tagExprAnd) String() string { return "(" + n.left.String() + " and " + n.right.String() + ")" }
+
+type tagExprOr struct{ left, right TagExpr }
+
+func (n tagExprOr) Matches(tags []models.Tag) bool {
+	return n.left.Matches(tags) || n.right.Matches(tags)
+}
+func (n tagExprOr) String() string { return "(" + n.left.String() + " or " + n.right.String() + ")" }
+
+// tagExprTag matches a single tag. An empty value pattern with hasValue false matches any value.
+type tagExprTag struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func (n tagExprTag) Matches(tags []models.Tag) bool {
+	for _, tag := range tags {
+		if !wildcardMatch(n.key, tag.Key) {
+			continue
+		}
+		if !n.hasValue || wildcardMatch(n.value, tag.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n tagExprTag) String() string {
+	if n.hasValue {
+		return "@" + n.key + ":" + n.value
+	}
+	return "@" + n.key
+}
+
+// wildcardMatch matches s against a pattern using * and ? wildcards, case-sensitively.
+func wildcardMatch(pattern, s string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == s
+	}
+	p, str := []rune(pattern), []rune(s)
+	pi, si := 0, 0
+	starPi, starSi := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			starPi, starSi = pi, si
+			pi++
+		case starPi >= 0:
+			// Let the last * swallow one more character and retry.
+			starSi++
+			pi, si = starPi+1, starSi
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTag
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type tagExprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t tagExprToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenTag:
+		return "tag " + t.text
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func tokenizeTagExpr(expr string) ([]tagExprToken, error) {
+	var tokens []tagExprToken
+	i := 0
+	for i < len(expr) {
+		r := rune(expr[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, tagExprToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, tagExprToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		default:
+			start := i
+			for i < len(expr) && !unicode.IsSpace(rune(expr[i])) && expr[i] != '(' && expr[i] != ')' {
+				if expr[i] == '\\' && i+1 < len(expr) {
+					i++ // Escaped character, e.g. "\ " or "\("
+				}
+				i++
+			}
+			word := expr[start:i]
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, tagExprToken{kind: tokenAnd, text: word, pos: start})
+			case "or":
+				tokens = append(tokens, tagExprToken{kind: tokenOr, text: word, pos: start})
+			case "not":
+				tokens = append(tokens, tagExprToken{kind: tokenNot, text: word, pos: start})
+			default:
+				if !strings.HasPrefix(word, "@") {
+					return nil, &TagExprSyntaxError{Expr: expr, Pos: start, Message: fmt.Sprintf("tags must start with @, got %q", word)}
+				}
+				tokens = append(tokens, tagExprToken{kind: tokenTag, text: word, pos: start})
+			}
+		}
+	}
+	tokens = append(tokens, tagExprToken{kind: tokenEOF, pos: len(expr)})
+	return tokens, nil
+}
+
+type tagExprParser struct {
+	expr   string
+	tokens []tagExprToken
+	pos    int
+}
+
+func (p *tagExprParser) peek() tagExprToken { return p.tokens[p.pos] }
+
+func (p *tagExprParser) next() tagExprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *tagExprParser) errorf(tok tagExprToken, format string, args ...interface{}) error {
+	return &TagExprSyntaxError{Expr: p.expr, Pos: tok.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses: and-expr { "or" and-expr }
+func (p *tagExprParser) parseOr() (TagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = tagExprOr{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary { "and" unary }
+func (p *tagExprParser) parseAnd() (TagExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = tagExprAnd{left, right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: "not" unary | "(" or-expr ")" | tag
+func (p *tagExprParser) parseUnary() (TagExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNot:
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagExprNot{operand}, nil
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, "expected \")\" to close \"(\" at position %d, got %s", tok.pos+1, closing)
+		}
+		return inner, nil
+	case tokenTag:
+		return p.parseTag(tok)
+	default:
+		return nil, p.errorf(tok, "expected a tag, \"not\" or \"(\", got %s", tok)
+	}
+}
+
+func (p *tagExprParser) parseTag(tok tagExprToken) (TagExpr, error) {
+	body := unescapeTag(tok.text[1:])
+	if body == "" {
+		return nil, p.errorf(tok, "tag name is missing after @")
+	}
+	key, value, hasValue := strings.Cut(body, ":")
+	if key == "" {
+		return nil, p.errorf(tok, "tag %s has an empty key", tok.text)
+	}
+	return tagExprTag{key: key, value: value, hasValue: hasValue}, nil
+}
+
+func unescapeTag(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// FilterScenariosByTagExpr returns the scenarios whose tags satisfy expr.
+func FilterScenariosByTagExpr(scenarios []models.Scenario, expr TagExpr) []models.Scenario {
+	filtered := make([]models.Scenario, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		if expr.Matches(scenario.Tags) {
+			filtered = append(filtered, scenario)
+		}
+	}
+	return filtered
+}
+
+// tagExprSQL compiles a tag expression into a SQL condition over scenarios aliased
+// as s, using the scenario_tags and tags tables.
+func tagExprSQL(expr TagExpr) (string, []interface{}) {
+	switch n := expr.(type) {
+	case tagExprNot:
+		cond, args := tagExprSQL(n.operand)
+		return "NOT (" + cond + ")", args
+	case tagExprAnd:
+		left, leftArgs := tagExprSQL(n.left)
+		right, rightArgs := tagExprSQL(n.right)
+		return "(" + left + " AND " + right + ")", append(leftArgs, rightArgs...)
+	case tagExprOr:
+		left, leftArgs := tagExprSQL(n.left)
+		right, rightArgs := tagExprSQL(n.right)
+		return "(" + left + " OR " + right + ")", append(leftArgs, rightArgs...)
+	case tagExprTag:
+		cond := "EXISTS (SELECT 1 FROM scenario_tags st JOIN tags t ON t.id = st.tag_id WHERE st.scenario_id = s.id AND "
+		keyCond, keyArg := wildcardSQL("t.key", n.key)
+		cond += keyCond
+		args := []interface{}{keyArg}
+		if n.hasValue {
+			valueCond, valueArg := wildcardSQL("t.value", n.value)
+			cond += " AND " + valueCond
+			args = append(args, valueArg)
+		}
+		return cond + ")", args
+	default:
+		panic(fmt.Sprintf("unknown tag expression node %T", expr))
+	}
+}
+
+// wildcardSQL compares column with a pattern: "=" for plain text so the
+// (key, value) index is used, GLOB when the pattern has wildcards.
+func wildcardSQL(column, pattern string) (string, interface{}) {
+	if !strings.ContainsAny(pattern, "*?") {
+		return column + " = ?", pattern
+	}
+	// GLOB also treats [ specially; [[] is a class matching a literal [.
+	return column + " GLOB ?", strings.ReplaceAll(pattern, "[", "[[]")
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+// tagExprScenarios cover plain tags, key:value tags, a value with a [ and no tags at all.
+var tagExprScenarios = []models.Scenario{
+	{ID: "s1", Name: "smoke, high priority", Tags: []models.Tag{{Key: "smoke"}, {Key: "priority", Value: "high"}}},
+	{ID: "s2", Name: "regression, low priority", Tags: []models.Tag{{Key: "regression"}, {Key: "priority", Value: "low"}}},
+	{ID: "s3", Name: "smoke and regression, wip", Tags: []models.Tag{{Key: "smoke"}, {Key: "regression"}, {Key: "wip"}}},
+	{ID: "s4", Name: "ticketed", Tags: []models.Tag{{Key: "jira", Value: "QA-12"}, {Key: "note", Value: "[draft]"}}},
+	{ID: "s5", Name: "untagged"},
+}
+
+func TestParseTagExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "@smoke", want: "@smoke"},
+		{expr: "@priority:high", want: "@priority:high"},
+		{expr: "@a and @b or @c", want: "((@a and @b) or @c)"},
+		{expr: "@a or @b and @c", want: "(@a or (@b and @c))"},
+		{expr: "@a and (@b or @c)", want: "(@a and (@b or @c))"},
+		{expr: "@a or @b or @c", want: "((@a or @b) or @c)"},
+		{expr: "not @a and @b", want: "(not @a and @b)"},
+		{expr: "not (@a and @b)", want: "not (@a and @b)"},
+		{expr: "not not @a", want: "not not @a"},
+		{expr: "@a AND NOT @b", want: "(@a and not @b)"},
+		{expr: "((@a))", want: "@a"},
+		{expr: "@jira:*", want: "@jira:*"},
+		{expr: "@prio*:h?gh", want: "@prio*:h?gh"},
+		{expr: `@my\ tag`, want: "@my tag"},
+		{expr: `@a\(b\)`, want: "@a(b)"},
+		{expr: "@url:http://example.com", want: "@url:http://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseTagExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseTagExpr: %v", err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("parsed as %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTagExprSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantPos int
+	}{
+		{expr: "", wantPos: 0},
+		{expr: "   ", wantPos: 0},
+		{expr: "smoke", wantPos: 0},
+		{expr: "@a and", wantPos: 6},
+		{expr: "@a or or @b", wantPos: 6},
+		{expr: "and @a", wantPos: 0},
+		{expr: "@a @b", wantPos: 3},
+		{expr: "(@a or @b", wantPos: 9},
+		{expr: "@a)", wantPos: 2},
+		{expr: "()", wantPos: 1},
+		{expr: "not", wantPos: 3},
+		{expr: "@", wantPos: 0},
+		{expr: "@a and @:high", wantPos: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseTagExpr(tt.expr)
+			var syntaxErr *TagExprSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("got error %v, want a *TagExprSyntaxError", err)
+			}
+			if syntaxErr.Pos != tt.wantPos {
+				t.Errorf("error at %d (%v), want %d", syntaxErr.Pos, err, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"smoke", "smoke", true},
+		{"smoke", "Smoke", false},
+		{"smoke", "smoker", false},
+		{"smo*", "smoke", true},
+		{"*oke", "smoke", true},
+		{"*", "", true},
+		{"s*e", "se", true},
+		{"s?oke", "smoke", true},
+		{"s?oke", "soke", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"QA-*", "QA-12", true},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestTagExprSQL checks that the SQL compilation of an expression selects the
+// same scenarios as matching it in memory.
+func TestTagExprSQL(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token")
+	for i := range tagExprScenarios {
+		if err := CreateScenario(&tagExprScenarios[i], 1, user.ID); err != nil {
+			t.Fatalf("CreateScenario: %v", err)
+		}
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "@smoke", want: []string{"s1", "s3"}},
+		{expr: "@priority", want: []string{"s1", "s2"}},
+		{expr: "@priority:high", want: []string{"s1"}},
+		{expr: "@smoke or @regression and @wip", want: []string{"s1", "s3"}},
+		{expr: "(@smoke or @regression) and @wip", want: []string{"s3"}},
+		{expr: "@smoke and not @wip", want: []string{"s1"}},
+		{expr: "not @smoke", want: []string{"s2", "s4", "s5"}},
+		{expr: "not (@smoke or @regression)", want: []string{"s4", "s5"}},
+		{expr: "@priority:*", want: []string{"s1", "s2"}},
+		{expr: "@priority:?ow", want: []string{"s2"}},
+		{expr: "@jira:QA-*", want: []string{"s4"}},
+		{expr: "@*", want: []string{"s1", "s2", "s3", "s4"}},
+		{expr: "@note:[*", want: []string{"s4"}},
+		{expr: "@Smoke", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseTagExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseTagExpr: %v", err)
+			}
+
+			var inMemory []string
+			for _, scenario := range FilterScenariosByTagExpr(tagExprScenarios, expr) {
+				inMemory = append(inMemory, scenario.ID)
+			}
+			if !reflect.DeepEqual(inMemory, tt.want) {
+				t.Errorf("matched %v in memory, want %v", inMemory, tt.want)
+			}
+
+			cond, args := tagExprSQL(expr)
+			scenarios, err := queryScenarios(DB, "s.project_id = 1 AND "+cond, args...)
+			if err != nil {
+				t.Fatalf("query %s: %v", cond, err)
+			}
+			var inSQL []string
+			for _, scenario := range scenarios {
+				inSQL = append(inSQL, scenario.ID)
+			}
+			sort.Strings(inSQL)
+			if !reflect.DeepEqual(inSQL, tt.want) {
+				t.Errorf("matched %v in SQL (%s %v), want %v", inSQL, cond, args, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardSQL(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		wantCond string
+		wantArg  interface{}
+	}{
+		{pattern: "smoke", wantCond: "t.key = ?", wantArg: "smoke"},
+		{pattern: "[draft]", wantCond: "t.key = ?", wantArg: "[draft]"},
+		{pattern: "smo*", wantCond: "t.key GLOB ?", wantArg: "smo*"},
+		{pattern: "[d*", wantCond: "t.key GLOB ?", wantArg: "[[]d*"},
+	}
+	for _, tt := range tests {
+		cond, arg := wildcardSQL("t.key", tt.pattern)
+		if cond != tt.wantCond || arg != tt.wantArg {
+			t.Errorf("wildcardSQL(%q) = %q, %v, want %q, %v", tt.pattern, cond, arg, tt.wantCond, tt.wantArg)
+		}
+	}
+}
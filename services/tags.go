@@ -0,0 +1,227 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// createTagTablesSQL stores scenario tags in their own tables instead of a JSON
+// blob, so tag filters are indexed lookups rather than LIKE scans.
+const createTagTablesSQL = `
+    CREATE TABLE IF NOT EXISTS tags (
+        id TEXT PRIMARY KEY,
+        key TEXT NOT NULL,
+        value TEXT NOT NULL DEFAULT ''
+    );
+    CREATE INDEX IF NOT EXISTS idx_tags_key_value ON tags (key, value);
+
+    CREATE TABLE IF NOT EXISTS scenario_tags (
+        scenario_id TEXT NOT NULL,
+        tag_id TEXT NOT NULL,
+        position INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (scenario_id, tag_id),
+        FOREIGN KEY (scenario_id) REFERENCES scenarios(id),
+        FOREIGN KEY (tag_id) REFERENCES tags(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_scenario_tags_tag ON scenario_tags (tag_id, scenario_id);
+`
+
+// sqliteMaxParams keeps IN (...) lists well below SQLite's bound parameter limit.
+const sqliteMaxParams = 500
+
+// tagID returns the Cucumber Studio ID of a tag, or a stable substitute for
+// tags stored without one.
+func tagID(tag models.Tag) string {
+	if tag.ID != "" {
+		return tag.ID
+	}
+	return "local:" + tag.Key + ":" + tag.Value
+}
+
+// dedupeTags drops repeats of a tag, keeping the first. Studio can list a tag
+// twice on a scenario; the cache links it once.
+func dedupeTags(tags []models.Tag) []models.Tag {
+	seen := make(map[string]bool, len(tags))
+	deduped := make([]models.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if id := tagID(tag); !seen[id] {
+			seen[id] = true
+			deduped = append(deduped, tag)
+		}
+	}
+	return deduped
+}
+
+// setScenarioTags replaces the tags of a scenario, upserting the tags themselves.
+func setScenarioTags(db dbExecutor, scenarioID string, tags []models.Tag) error {
+	if _, err := db.Exec("DELETE FROM scenario_tags WHERE scenario_id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to clear tags of scenario %s: %v", scenarioID, err)
+	}
+	for position, tag := range tags {
+		id := tagID(tag)
+		_, err := db.Exec(
+			`INSERT INTO tags (id, key, value) VALUES (?, ?, ?)
+             ON CONFLICT (id) DO UPDATE SET key = excluded.key, value = excluded.value
+             WHERE key <> excluded.key OR value <> excluded.value`,
+			id, tag.Key, tag.Value,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to upsert tag %s: %v", id, err)
+		}
+		_, err = db.Exec(
+			"INSERT OR IGNORE INTO scenario_tags (scenario_id, tag_id, position) VALUES (?, ?, ?)",
+			scenarioID, id, position,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to tag scenario %s: %v", scenarioID, err)
+		}
+	}
+	return nil
+}
+
+// deleteScenarioTags removes the tag links of a scenario.
+func deleteScenarioTags(db dbExecutor, scenarioID string) error {
+	if _, err := db.Exec("DELETE FROM scenario_tags WHERE scenario_id = ?", scenarioID); err != nil {
+		return fmt.Errorf("failed to delete tags of scenario %s: %v", scenarioID, err)
+	}
+	return nil
+}
+
+// deleteOrphanTags removes tags no scenario refers to any more.
+func deleteOrphanTags(db dbExecutor) error {
+	if _, err := db.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM scenario_tags)"); err != nil {
+		return fmt.Errorf("failed to delete unused tags: %v", err)
+	}
+	return nil
+}
+
+// loadScenarioTags returns the tags of the given scenarios, in Studio order, keyed by scenario ID.
+func loadScenarioTags(db dbExecutor, scenarioIDs []string) (map[string][]models.Tag, error) {
+	tags := make(map[string][]models.Tag, len(scenarioIDs))
+	for start := 0; start < len(scenarioIDs); start += sqliteMaxParams {
+		end := start + sqliteMaxParams
+		if end > len(scenarioIDs) {
+			end = len(scenarioIDs)
+		}
+		chunk := scenarioIDs[start:end]
+
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := db.Query(
+			`SELECT st.scenario_id, t.id, t.key, t.value
+             FROM scenario_tags st JOIN tags t ON t.id = st.tag_id
+             WHERE st.scenario_id IN (`+placeholders(len(chunk))+`)
+             ORDER BY st.scenario_id, st.position`,
+			args...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query scenario tags: %v", err)
+		}
+		for rows.Next() {
+			var scenarioID string
+			var tag models.Tag
+			if err := rows.Scan(&scenarioID, &tag.ID, &tag.Key, &tag.Value); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan scenario tag: %v", err)
+			}
+			tags[scenarioID] = append(tags[scenarioID], tag)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error during rows iteration: %v", err)
+		}
+	}
+	return tags, nil
+}
+
+// attachTags fills in the Tags of every scenario.
+func attachTags(db dbExecutor, scenarios []models.Scenario) error {
+	ids := make([]string, len(scenarios))
+	for i, scenario := range scenarios {
+		ids[i] = scenario.ID
+	}
+	tags, err := loadScenarioTags(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range scenarios {
+		scenarios[i].Tags = tags[scenarios[i].ID]
+		if scenarios[i].Tags == nil {
+			scenarios[i].Tags = []models.Tag{}
+		}
+	}
+	return nil
+}
+
+// placeholders returns "?, ?, ..." with n placeholders.
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// migrateScenarioTagsColumn moves tags out of the legacy scenarios.tags JSON column
+// into the tags and scenario_tags tables, then drops the column.
+func migrateScenarioTagsColumn() error {
+	exists, err := columnExists("scenarios", "tags")
+	if err != nil || !exists {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin tags migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, tags FROM scenarios")
+	if err != nil {
+		return fmt.Errorf("failed to read legacy scenario tags: %v", err)
+	}
+	legacy := make(map[string][]models.Tag)
+	for rows.Next() {
+		var id string
+		var tagsJSON sql.NullString
+		if err := rows.Scan(&id, &tagsJSON); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan legacy scenario tags: %v", err)
+		}
+		if !tagsJSON.Valid || tagsJSON.String == "" {
+			continue
+		}
+		var tags []models.Tag
+		if err := json.Unmarshal([]byte(tagsJSON.String), &tags); err != nil {
+			log.Printf("Skipping unreadable tags of scenario %s during migration: %v", id, err)
+			continue
+		}
+		legacy[id] = tags
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("error during rows iteration: %v", err)
+	}
+
+	for id, tags := range legacy {
+		if err := setScenarioTags(tx, id, tags); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("ALTER TABLE scenarios DROP COLUMN tags"); err != nil {
+		return fmt.Errorf("failed to drop scenarios.tags column: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tags migration: %v", err)
+	}
+	log.Printf("Migrated tags of %d scenarios to the tags table", len(legacy))
+	return nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestInitializeDBMigratesLegacyTags(t *testing.T) {
+	// A database from before tags had their own tables, with the tags of each
+	// scenario in a JSON column.
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`
+        CREATE TABLE scenarios (
+            id TEXT PRIMARY KEY,
+            name TEXT NOT NULL,
+            folder_id INTEGER NOT NULL,
+            project_id INTEGER NOT NULL,
+            tags TEXT,
+            user_id INTEGER NOT NULL
+        );
+        INSERT INTO scenarios (id, name, folder_id, project_id, tags, user_id) VALUES
+            ('1000', 'Login with valid credentials', 11, 1, '[{"id":"100","key":"smoke","value":""},{"id":"102","key":"priority","value":"high"}]', 1),
+            ('1001', 'Login with a wrong password', 11, 1, '[{"id":"102","key":"priority","value":"high"}]', 1),
+            ('1002', 'Pay with a gift card', 13, 1, NULL, 1),
+            ('1003', 'Unreadable tags', 13, 1, 'not json', 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	openTestDBAt(t, path)
+
+	if exists, err := columnExists("scenarios", "tags"); err != nil || exists {
+		t.Fatalf("scenarios.tags still exists (%v)", err)
+	}
+	tags, err := loadScenarioTags(DB, []string{"1000", "1001", "1002", "1003"})
+	if err != nil {
+		t.Fatalf("loadScenarioTags: %v", err)
+	}
+	smoke := models.Tag{ID: "100", Key: "smoke"}
+	high := models.Tag{ID: "102", Key: "priority", Value: "high"}
+	want := map[string][]models.Tag{
+		"1000": {smoke, high},
+		"1001": {high},
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("migrated tags %+v, want %+v", tags, want)
+	}
+
+	var distinct int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM tags").Scan(&distinct); err != nil {
+		t.Fatal(err)
+	}
+	if distinct != 2 {
+		t.Errorf("tags table has %d rows, want one per distinct tag: 2", distinct)
+	}
+
+	// Running the migrations again leaves the normalized tags alone.
+	if err := migrateScenarioTagsColumn(); err != nil {
+		t.Fatalf("migrateScenarioTagsColumn: %v", err)
+	}
+	if again, err := loadScenarioTags(DB, []string{"1000", "1001"}); err != nil || !reflect.DeepEqual(again, want) {
+		t.Errorf("tags after a second migration are %+v (%v), want %+v", again, err, want)
+	}
+}
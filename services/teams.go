@@ -0,0 +1,352 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+var (
+	// ErrTeamNotFound is returned for teams that do not exist or the user is not a member of.
+	ErrTeamNotFound = errors.New("team not found")
+	// ErrTeamRoleDenied is returned when the role of a member in a team does not
+	// allow an action, e.g. a viewer adding members.
+	ErrTeamRoleDenied = errors.New("your role in the team does not allow this")
+	// ErrTeamMemberNotFound is returned when removing a user who is not a member.
+	ErrTeamMemberNotFound = errors.New("team member not found")
+	// ErrAlreadyTeamMember is returned when adding a user who is already a member.
+	ErrAlreadyTeamMember = errors.New("user is already a member of the team")
+	// ErrTeamCreatorCannotLeave is returned when the creator is removed from their team.
+	ErrTeamCreatorCannotLeave = errors.New("the creator of a team cannot leave it, delete the team instead")
+	// ErrTeamCreatorRole is returned when changing the role of the creator, who stays an admin.
+	ErrTeamCreatorRole = errors.New("the creator of a team is always one of its admins")
+)
+
+// maxTeamName bounds team names.
+const maxTeamName = 200
+
+const createTeamTablesSQL = `
+    CREATE TABLE IF NOT EXISTS teams (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        created_by INTEGER NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (created_by) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS team_members (
+        team_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (team_id, user_id),
+        FOREIGN KEY (team_id) REFERENCES teams(id),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_team_members_user ON team_members (user_id, team_id);
+`
+
+// initTeamTables creates the team tables and lets charts and data tables be
+// shared with a team.
+func initTeamTables() error {
+	if _, err := DB.Exec(createTeamTablesSQL); err != nil {
+		return fmt.Errorf("failed to create team tables: %v", err)
+	}
+	if err := addColumnIfMissing("team_members", "role", "TEXT NOT NULL DEFAULT 'editor'"); err != nil {
+		return err
+	}
+	// Members added before roles existed default to editors, their creators are admins.
+	if _, err := DB.Exec(
+		"UPDATE team_members SET role = ? WHERE role <> ? AND user_id = (SELECT created_by FROM teams WHERE teams.id = team_members.team_id)",
+		models.RoleAdmin, models.RoleAdmin,
+	); err != nil {
+		return fmt.Errorf("failed to promote team creators: %v", err)
+	}
+	for _, table := range []string{"charts", "data_tables"} {
+		if err := addColumnIfMissing(table, "team_id", "INTEGER REFERENCES teams(id)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleToUserSQL matches the charts or data tables a user owns or that are
+// shared with one of their teams. It takes the user ID twice.
+const visibleToUserSQL = "(user_id = ? OR team_id IN (SELECT team_id FROM team_members WHERE user_id = ?))"
+
+// ValidateTeam checks a team before it is created.
+func ValidateTeam(team *models.Team) error {
+	v := &ValidationError{}
+	switch {
+	case strings.TrimSpace(team.Name) == "":
+		v.add("name", "is required")
+	case len([]rune(team.Name)) > maxTeamName:
+		v.add("name", "must be at most %d characters", maxTeamName)
+	}
+	return v.err()
+}
+
+// CreateTeam creates a team with its creator as the first member, and admin.
+func CreateTeam(team *models.Team) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO teams (name, created_by) VALUES (?, ?)", team.Name, team.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to create team: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert id: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO team_members (team_id, user_id, role) VALUES (?, ?, ?)", id, team.CreatedBy, models.RoleAdmin); err != nil {
+		return fmt.Errorf("failed to add team member: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	team.ID = int(id)
+	return nil
+}
+
+// teamColumns selects a team and the role of the member it is joined with.
+const teamColumns = `t.id, t.name, t.created_by, t.created_at, m.role`
+
+func scanTeam(row rowScanner) (*models.Team, error) {
+	var team models.Team
+	if err := row.Scan(&team.ID, &team.Name, &team.CreatedBy, &team.CreatedAt, &team.Role); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// GetTeam retrieves a team of a user with its members.
+func GetTeam(teamID, userID int) (*models.Team, error) {
+	team, err := scanTeam(DB.QueryRow(
+		"SELECT "+teamColumns+" FROM teams t JOIN team_members m ON m.team_id = t.id WHERE t.id = ? AND m.user_id = ?",
+		teamID, userID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTeamNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query team: %v", err)
+	}
+
+	rows, err := DB.Query(
+		`SELECT m.user_id, u.email, m.role, m.added_at FROM team_members m JOIN users u ON u.id = m.user_id
+         WHERE m.team_id = ? ORDER BY m.added_at, m.user_id`,
+		teamID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query team members: %v", err)
+	}
+	defer rows.Close()
+
+	team.Members = make([]models.TeamMember, 0)
+	for rows.Next() {
+		var member models.TeamMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan team member: %v", err)
+		}
+		team.Members = append(team.Members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return team, nil
+}
+
+// GetTeamsByUser retrieves the teams a user is a member of, without their members.
+func GetTeamsByUser(userID int) ([]models.Team, error) {
+	rows, err := DB.Query(
+		"SELECT "+teamColumns+" FROM teams t JOIN team_members m ON m.team_id = t.id WHERE m.user_id = ? ORDER BY t.id",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query teams: %v", err)
+	}
+	defer rows.Close()
+
+	teams := make([]models.Team, 0)
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan team: %v", err)
+		}
+		teams = append(teams, *team)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return teams, nil
+}
+
+// DeleteTeam deletes a team the user is an admin of. Its charts and data tables
+// go back to being private to their owners.
+func DeleteTeam(teamID, userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTeamPermission(tx, teamID, userID, models.PermissionAdmin); err != nil {
+		return err
+	}
+	for _, table := range []string{"charts", "data_tables"} {
+		if _, err := tx.Exec("UPDATE "+table+" SET team_id = NULL WHERE team_id = ?", teamID); err != nil {
+			return fmt.Errorf("failed to unshare %s: %v", table, err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM team_members WHERE team_id = ?", teamID); err != nil {
+		return fmt.Errorf("failed to delete team members: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM teams WHERE id = ?", teamID); err != nil {
+		return fmt.Errorf("failed to delete team: %v", err)
+	}
+	return tx.Commit()
+}
+
+// AddTeamMember adds the user with the given email to a team adminID is an
+// admin of, with a role in the team.
+func AddTeamMember(teamID, adminID int, email string, role models.Role) (*models.TeamMember, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTeamPermission(tx, teamID, adminID, models.PermissionAdmin); err != nil {
+		return nil, err
+	}
+	member := models.TeamMember{Email: email, Role: role}
+	err = tx.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&member.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user: %v", err)
+	}
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO team_members (team_id, user_id, role) VALUES (?, ?, ?)", teamID, member.UserID, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add team member: %v", err)
+	}
+	if err := requireAffected(result, ErrAlreadyTeamMember); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow("SELECT added_at FROM team_members WHERE team_id = ? AND user_id = ?", teamID, member.UserID).Scan(&member.AddedAt); err != nil {
+		return nil, fmt.Errorf("failed to query team member: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return &member, nil
+}
+
+// RemoveTeamMember removes a member from a team. Admins can remove anyone but
+// the creator; other members can only leave. The charts and data tables the
+// member shared with the team become private again.
+func RemoveTeamMember(teamID, actorID, memberID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var createdBy int
+	var actorRole models.Role
+	err = tx.QueryRow(
+		"SELECT t.created_by, m.role FROM teams t JOIN team_members m ON m.team_id = t.id WHERE t.id = ? AND m.user_id = ?",
+		teamID, actorID,
+	).Scan(&createdBy, &actorRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTeamNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query team: %v", err)
+	}
+	switch {
+	case memberID == createdBy:
+		return ErrTeamCreatorCannotLeave
+	case actorID != memberID && !actorRole.Can(models.PermissionAdmin):
+		return ErrTeamRoleDenied
+	}
+
+	result, err := tx.Exec("DELETE FROM team_members WHERE team_id = ? AND user_id = ?", teamID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove team member: %v", err)
+	}
+	if err := requireAffected(result, ErrTeamMemberNotFound); err != nil {
+		return err
+	}
+	for _, table := range []string{"charts", "data_tables"} {
+		if _, err := tx.Exec("UPDATE "+table+" SET team_id = NULL WHERE team_id = ? AND user_id = ?", teamID, memberID); err != nil {
+			return fmt.Errorf("failed to unshare %s: %v", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SetTeamMemberRole changes the role of a member of a team adminID is an admin of.
+func SetTeamMemberRole(teamID, adminID, memberID int, role models.Role) (*models.TeamMember, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTeamPermission(tx, teamID, adminID, models.PermissionAdmin); err != nil {
+		return nil, err
+	}
+	var createdBy int
+	if err := tx.QueryRow("SELECT created_by FROM teams WHERE id = ?", teamID).Scan(&createdBy); err != nil {
+		return nil, fmt.Errorf("failed to query team: %v", err)
+	}
+	if memberID == createdBy && role != models.RoleAdmin {
+		return nil, ErrTeamCreatorRole
+	}
+
+	result, err := tx.Exec("UPDATE team_members SET role = ? WHERE team_id = ? AND user_id = ?", role, teamID, memberID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update team member: %v", err)
+	}
+	if err := requireAffected(result, ErrTeamMemberNotFound); err != nil {
+		return nil, err
+	}
+	member := models.TeamMember{UserID: memberID}
+	err = tx.QueryRow(
+		"SELECT u.email, m.role, m.added_at FROM team_members m JOIN users u ON u.id = m.user_id WHERE m.team_id = ? AND m.user_id = ?",
+		teamID, memberID,
+	).Scan(&member.Email, &member.Role, &member.AddedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query team member: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return &member, nil
+}
+
+// checkTeamPermission returns nil if the role of the user in the team grants a
+// permission, ErrTeamRoleDenied if it does not and ErrTeamNotFound if they are
+// not a member.
+func checkTeamPermission(db dbExecutor, teamID, userID int, permission models.Permission) error {
+	var role models.Role
+	err := db.QueryRow("SELECT role FROM team_members WHERE team_id = ? AND user_id = ?", teamID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTeamNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query team member: %v", err)
+	}
+	if !role.Can(permission) {
+		return ErrTeamRoleDenied
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+// visibleChartNames returns the names of the charts a user sees.
+func visibleChartNames(t *testing.T, userID int) []string {
+	t.Helper()
+	charts, err := GetChartsByUser(userID)
+	if err != nil {
+		t.Fatalf("GetChartsByUser: %v", err)
+	}
+	names := make([]string, len(charts))
+	for i, chart := range charts {
+		names[i] = chart.Name
+	}
+	return names
+}
+
+func TestTeamsOnlySeeTheirCharts(t *testing.T) {
+	openTestDB(t)
+	project := models.Project{ID: "1", Name: "Shop"}
+	alice := createTestUser(t, "alice@example.com", "c1", "t1", project)
+	member := createTestUser(t, "member@example.com", "c2", "t2", project)
+	bob := createTestUser(t, "bob@example.com", "c3", "t3", project)
+	web := &models.Team{Name: "Web", CreatedBy: alice.ID}
+	mobile := &models.Team{Name: "Mobile", CreatedBy: bob.ID}
+	for _, team := range []*models.Team{web, mobile} {
+		if err := CreateTeam(team); err != nil {
+			t.Fatalf("CreateTeam: %v", err)
+		}
+	}
+	if _, err := AddTeamMember(web.ID, alice.ID, member.Email, models.RoleViewer); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+
+	query := `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`
+	for _, chart := range []*models.Chart{
+		{Name: "Alice private", UserID: alice.ID},
+		{Name: "Web shared", UserID: alice.ID, TeamID: &web.ID},
+		{Name: "Mobile shared", UserID: bob.ID, TeamID: &mobile.ID},
+	} {
+		chart.Type, chart.Config, chart.Query = models.ChartTypeBar, "{}", query
+		if err := CreateChart(chart); err != nil {
+			t.Fatalf("CreateChart(%s): %v", chart.Name, err)
+		}
+	}
+	table := &models.DataTable{Name: "Mobile table", Columns: `[{"key": "id"}]`, Query: `{"dataset": "scenarios", "project_id": 1}`, UserID: bob.ID, TeamID: &mobile.ID}
+	if err := CreateDataTable(table); err != nil {
+		t.Fatalf("CreateDataTable: %v", err)
+	}
+
+	want := map[*models.User][]string{
+		alice:  {"Alice private", "Web shared"},
+		member: {"Web shared"},
+		bob:    {"Mobile shared"},
+	}
+	for user, wantNames := range want {
+		if names := visibleChartNames(t, user.ID); !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("%s sees charts %q, want %q", user.Email, names, wantNames)
+		}
+	}
+	for _, user := range []*models.User{alice, member} {
+		if tables, err := GetDataTablesByUser(user.ID); err != nil || len(tables) != 0 {
+			t.Errorf("%s sees %d data tables (%v), want none of the other team", user.Email, len(tables), err)
+		}
+	}
+
+	// Leaving the team hides what it shares.
+	if err := RemoveTeamMember(web.ID, alice.ID, member.ID); err != nil {
+		t.Fatalf("RemoveTeamMember: %v", err)
+	}
+	if names := visibleChartNames(t, member.ID); len(names) != 0 {
+		t.Errorf("member still sees charts %q after leaving the team", names)
+	}
+}
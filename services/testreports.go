@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrInvalidTestReport is returned when an uploaded test report cannot be parsed.
+var ErrInvalidTestReport = errors.New("invalid test report")
+
+// TestReportParser reads one test report format into test results.
+type TestReportParser interface {
+	// Format names the format in the format query parameter and on stored runs.
+	Format() string
+	// MediaTypes lists the content types that suggest this format.
+	MediaTypes() []string
+	// Sniff reports whether a report starting with head looks like this format.
+	Sniff(head []byte) bool
+	// Parse returns one result per executed scenario attempt, in report order.
+	Parse(r io.Reader) ([]models.TestResult, error)
+}
+
+// testReportParsers are tried in order when sniffing a report.
+var testReportParsers = []TestReportParser{
+	cucumberJSONParser{},
+	cucumberMessagesParser{},
+	junitXMLParser{},
+}
+
+// RegisterTestReportParser adds support for another report format. Parsers
+// registered later are sniffed last.
+func RegisterTestReportParser(parser TestReportParser) {
+	testReportParsers = append(testReportParsers, parser)
+}
+
+// TestReportFormats lists the names of the supported formats.
+func TestReportFormats() []string {
+	formats := make([]string, len(testReportParsers))
+	for i, parser := range testReportParsers {
+		formats[i] = parser.Format()
+	}
+	return formats
+}
+
+// ParseTestReport parses a report in the given format, or detects the format
+// when it is empty: parsers whose media types match contentType are sniffed
+// first, then all others. A content type alone decides only when no parser
+// recognizes the content. It returns the name of the format used.
+func ParseTestReport(format, contentType string, report []byte) (string, []models.TestResult, error) {
+	// Reports saved by Windows tools often start with a byte order mark, which
+	// the JSON decoder rejects.
+	report = bytes.TrimPrefix(report, utf8BOM)
+	parser, err := testReportParser(format, contentType, report)
+	if err != nil {
+		return "", nil, err
+	}
+	results, err := parser.Parse(bytes.NewReader(report))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(results) == 0 {
+		return "", nil, fmt.Errorf("%w: the report contains no scenarios", ErrInvalidTestReport)
+	}
+	return parser.Format(), results, nil
+}
+
+func testReportParser(format, contentType string, report []byte) (TestReportParser, error) {
+	if format != "" {
+		for _, parser := range testReportParsers {
+			if parser.Format() == format {
+				return parser, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown format %q, expected one of %s", ErrInvalidTestReport, format, strings.Join(TestReportFormats(), ", "))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var byMediaType, others []TestReportParser
+	for _, parser := range testReportParsers {
+		if containsString(parser.MediaTypes(), mediaType) {
+			byMediaType = append(byMediaType, parser)
+		} else {
+			others = append(others, parser)
+		}
+	}
+
+	head := report
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	for _, parser := range append(byMediaType, others...) {
+		if parser.Sniff(head) {
+			return parser, nil
+		}
+	}
+	if len(byMediaType) > 0 {
+		return byMediaType[0], nil
+	}
+	return nil, fmt.Errorf("%w: unrecognized format, expected one of %s", ErrInvalidTestReport, strings.Join(TestReportFormats(), ", "))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// firstByte returns the first byte of a report after a byte order mark and
+// whitespace, or 0 if there is none.
+func firstByte(head []byte) byte {
+	head = bytes.TrimLeftFunc(bytes.TrimPrefix(head, utf8BOM), unicode.IsSpace)
+	if len(head) == 0 {
+		return 0
+	}
+	return head[0]
+}
+
+// stepOutcome is the result of one step or hook.
+type stepOutcome struct {
+	status       string
+	durationNS   int64
+	errorMessage string
+}
+
+// scenarioOutcome combines step and hook results into the scenario's status,
+// total duration and the error message of the first failure. Like Cucumber
+// itself, the most severe status wins: failed, then pending, then skipped.
+func scenarioOutcome(steps []stepOutcome) (models.TestStatus, int64, string) {
+	var durationNS int64
+	var errorMessage string
+	failed, pending, skipped := false, false, false
+	for _, step := range steps {
+		durationNS += step.durationNS
+		switch normalizeTestStatus(step.status) {
+		case models.TestFailed:
+			if !failed {
+				errorMessage = step.errorMessage
+			}
+			failed = true
+		case models.TestPending:
+			pending = true
+		case models.TestSkipped:
+			skipped = true
+		}
+	}
+
+	switch {
+	case failed:
+		return models.TestFailed, durationNS, errorMessage
+	case pending:
+		return models.TestPending, durationNS, ""
+	case skipped:
+		return models.TestSkipped, durationNS, ""
+	default:
+		return models.TestPassed, durationNS, ""
+	}
+}
+
+// normalizeTestStatus maps the statuses used by Cucumber implementations onto TestStatus.
+// Undefined steps count as pending and ambiguous ones as failed.
+func normalizeTestStatus(status string) models.TestStatus {
+	switch strings.ToLower(status) {
+	case "passed":
+		return models.TestPassed
+	case "failed", "ambiguous":
+		return models.TestFailed
+	case "pending", "undefined":
+		return models.TestPending
+	default:
+		return models.TestSkipped
+	}
+}
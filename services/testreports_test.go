@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func readTestReport(t *testing.T, name string) []byte {
+	t.Helper()
+	report, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestParseTestReport(t *testing.T) {
+	tests := []struct {
+		file       string
+		wantFormat string
+		want       []models.TestResult
+	}{
+		{
+			// Backgrounds and hooks count towards their scenario; the first
+			// failure wins over a failing after hook.
+			file:       "cucumber.json",
+			wantFormat: "cucumber-json",
+			want: []models.TestResult{
+				{Name: "Login with valid credentials", Feature: "Login", URI: "features/login.feature", Line: 8, Tags: []string{"auth", "smoke"}, Status: models.TestPassed, DurationMS: 1500},
+				{
+					Name: "Login with a wrong password", Feature: "Login", URI: "features/login.feature", Line: 13, Tags: []string{"auth", "regression"},
+					Status: models.TestFailed, DurationMS: 1005,
+					ErrorMessage: "org.opentest4j.AssertionFailedError: expected: <401> but was: <500>\n\tat LoginSteps.logIn(LoginSteps.java:42)",
+				},
+				{Name: "Reset a forgotten password", Feature: "Password reset", URI: "features/password_reset.feature", Line: 9, Tags: []string{"regression"}, Status: models.TestPending, DurationMS: 90},
+				{Name: "Reset with an expired link", Feature: "Password reset", URI: "features/password_reset.feature", Line: 12, Tags: []string{"wip"}, Status: models.TestSkipped},
+			},
+		},
+		{
+			// Every attempt of a retried scenario is a result; outline rows
+			// and scenarios inside rules get their own line.
+			file:       "cucumber-messages.ndjson",
+			wantFormat: "cucumber-messages",
+			want: []models.TestResult{
+				{
+					Name: "Add an item", Feature: "Cart", URI: "features/cart.feature", Line: 5, Tags: []string{"cart", "smoke"},
+					Status: models.TestFailed, DurationMS: 2505, ErrorMessage: "TimeoutError: the cart did not update within 2500ms",
+					Attempt: 0, Retried: true,
+				},
+				{Name: "Add an item", Feature: "Cart", URI: "features/cart.feature", Line: 5, Tags: []string{"cart", "smoke"}, Status: models.TestPassed, DurationMS: 1200, Attempt: 1},
+				{Name: "Remove socks", Feature: "Cart", URI: "features/cart.feature", Line: 16, Tags: []string{"cart"}, Status: models.TestPassed, DurationMS: 510},
+				{Name: "Remove shoes", Feature: "Cart", URI: "features/cart.feature", Line: 17, Tags: []string{"cart"}, Status: models.TestPending, DurationMS: 300},
+				{
+					Name: "Check out as a guest", Feature: "Cart", URI: "features/cart.feature", Line: 21, Tags: []string{"cart"},
+					Status: models.TestFailed, ErrorMessage: `Multiple step definitions match "I check out without an account"`,
+				},
+			},
+		},
+		{
+			// Surefire reruns come out as separate attempts, the failed ones retried.
+			file:       "junit.xml",
+			wantFormat: "junit-xml",
+			want: []models.TestResult{
+				{Name: "Pay with a credit card", Feature: "Checkout", URI: "features/checkout.feature", Line: 5, Tags: []string{}, Status: models.TestPassed, DurationMS: 2750},
+				{Name: "Pay with a gift card", Feature: "Checkout", Tags: []string{}, Status: models.TestFailed, DurationMS: 640, ErrorMessage: "expected balance 0.00 but was 5.00"},
+				{Name: "Pay by bank transfer", Feature: "Checkout", Tags: []string{}, Status: models.TestFailed, DurationMS: 1500, ErrorMessage: "java.net.SocketTimeoutException: bank sandbox timed out"},
+				{Name: "Pay with crypto", Feature: "Checkout", Tags: []string{}, Status: models.TestSkipped},
+				{Name: "Suggest as I type", Feature: "Search suggestions", Tags: []string{}, Status: models.TestFailed, ErrorMessage: "suggestions arrived after 3s", Attempt: 0, Retried: true},
+				{Name: "Suggest as I type", Feature: "Search suggestions", Tags: []string{}, Status: models.TestPassed, DurationMS: 1234500, Attempt: 1},
+				{Name: "Search by SKU", Feature: "Search", Tags: []string{}, Status: models.TestFailed, DurationMS: 25000, ErrorMessage: "no result for SKU 42", Attempt: 0, Retried: true},
+				{Name: "Search by SKU", Feature: "Search", Tags: []string{}, Status: models.TestFailed, ErrorMessage: "no result for SKU 42 on rerun", Attempt: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			format, results, err := ParseTestReport("", "", readTestReport(t, tt.file))
+			if err != nil {
+				t.Fatalf("ParseTestReport: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("detected format %s, want %s", format, tt.wantFormat)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d: %+v", len(results), len(tt.want), results)
+			}
+			for i := range tt.want {
+				if !reflect.DeepEqual(results[i], tt.want[i]) {
+					t.Errorf("result %d:\n got %+v\nwant %+v", i, results[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTestReportDetectsFormat(t *testing.T) {
+	cucumberJSON := readTestReport(t, "cucumber.json")
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		report      []byte
+		wantFormat  string
+		wantErr     bool
+	}{
+		{name: "JSON array", report: cucumberJSON, wantFormat: "cucumber-json"},
+		{name: "NDJSON stream", report: readTestReport(t, "cucumber-messages.ndjson"), wantFormat: "cucumber-messages"},
+		{name: "XML document", report: readTestReport(t, "junit.xml"), wantFormat: "junit-xml"},
+		{name: "byte order mark and blank lines", report: append([]byte("\xef\xbb\xbf\n\n  "), cucumberJSON...), wantFormat: "cucumber-json"},
+		{name: "content beats a wrong content type", contentType: "application/xml", report: cucumberJSON, wantFormat: "cucumber-json"},
+		{name: "content type with parameters", contentType: "application/x-ndjson; charset=utf-8", report: readTestReport(t, "cucumber-messages.ndjson"), wantFormat: "cucumber-messages"},
+		{name: "explicit format", format: "cucumber-json", contentType: "text/xml", report: cucumberJSON, wantFormat: "cucumber-json"},
+		{name: "content type decides unrecognized content", contentType: "text/xml", report: []byte("TAP version 13"), wantErr: true},
+		{name: "unrecognized content", contentType: "text/plain", report: []byte("TAP version 13"), wantErr: true},
+		{name: "empty report", report: []byte{}, wantErr: true},
+		{name: "unknown format", format: "tap", report: cucumberJSON, wantErr: true},
+		{name: "explicit format of other content", format: "junit-xml", report: cucumberJSON, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, _, err := ParseTestReport(tt.format, tt.contentType, tt.report)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTestReport) {
+					t.Fatalf("got format %q and error %v, want ErrInvalidTestReport", format, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTestReport: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("detected format %s, want %s", format, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestParseTestReportRejectsMalformedReports(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		report string
+	}{
+		{name: "truncated Cucumber JSON", format: "cucumber-json", report: `[{"name": "Login", "elements": [{"name": "Lo`},
+		{name: "Cucumber JSON object", format: "cucumber-json", report: `{"name": "Login"}`},
+		{name: "Cucumber JSON without scenarios", format: "cucumber-json", report: `[{"name": "Login", "elements": []}]`},
+		{name: "truncated envelope", format: "cucumber-messages", report: `{"meta":{"protocolVersion":"21.0.1"}}` + "\n" + `{"pickle":{"id":"p1",`},
+		{name: "test case finished before it started", format: "cucumber-messages", report: `{"testCaseFinished":{"testCaseStartedId":"a1","willBeRetried":false}}`},
+		{
+			name:   "test case without a pickle",
+			format: "cucumber-messages",
+			report: `{"testCase":{"id":"tc1","pickleId":"p1","testSteps":[]}}` + "\n" +
+				`{"testCaseStarted":{"id":"a1","testCaseId":"tc1","attempt":0}}` + "\n" +
+				`{"testCaseFinished":{"testCaseStartedId":"a1","willBeRetried":false}}`,
+		},
+		{name: "messages without test cases", format: "cucumber-messages", report: `{"meta":{"protocolVersion":"21.0.1"}}`},
+		{name: "unclosed test case", format: "junit-xml", report: `<testsuite name="Login"><testcase name="Login with valid credentials">`},
+		{name: "mismatched tags", format: "junit-xml", report: `<testsuite name="Login"><testcase name="a"></testsuite></testcase>`},
+		{name: "JUnit without test cases", format: "junit-xml", report: `<testsuites><testsuite name="Login" tests="0"/></testsuites>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Sniffing picks the same parser, so both paths must reject the report.
+			for _, format := range []string{tt.format, ""} {
+				if _, results, err := ParseTestReport(format, "", []byte(tt.report)); !errors.Is(err, ErrInvalidTestReport) {
+					t.Errorf("format %q: got %d results and error %v, want ErrInvalidTestReport", format, len(results), err)
+				}
+			}
+		})
+	}
+}
+
+func TestScenarioOutcome(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []stepOutcome
+		wantStatus  models.TestStatus
+		wantMessage string
+	}{
+		{name: "no steps", wantStatus: models.TestPassed},
+		{name: "all passed", steps: []stepOutcome{{status: "passed"}, {status: "PASSED"}}, wantStatus: models.TestPassed},
+		{name: "skipped", steps: []stepOutcome{{status: "passed"}, {status: "skipped"}}, wantStatus: models.TestSkipped},
+		{name: "unknown status counts as skipped", steps: []stepOutcome{{status: "unknown"}}, wantStatus: models.TestSkipped},
+		{name: "undefined is pending", steps: []stepOutcome{{status: "undefined"}, {status: "skipped"}}, wantStatus: models.TestPending},
+		{name: "pending beats skipped", steps: []stepOutcome{{status: "skipped"}, {status: "pending"}}, wantStatus: models.TestPending},
+		{name: "ambiguous is failed", steps: []stepOutcome{{status: "ambiguous", errorMessage: "two matches"}}, wantStatus: models.TestFailed, wantMessage: "two matches"},
+		{
+			name:       "first failure wins",
+			steps:      []stepOutcome{{status: "pending"}, {status: "failed", errorMessage: "first"}, {status: "failed", errorMessage: "second"}},
+			wantStatus: models.TestFailed, wantMessage: "first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.steps {
+				tt.steps[i].durationNS = 1_500_000
+			}
+			status, durationNS, message := scenarioOutcome(tt.steps)
+			if status != tt.wantStatus || message != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", status, message, tt.wantStatus, tt.wantMessage)
+			}
+			if want := int64(len(tt.steps)) * 1_500_000; durationNS != want {
+				t.Errorf("got duration %dns, want %dns", durationNS, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"my-cucumber-backend/models"
+)
+
+// ErrTestRunNotFound is returned when a test run does not exist or belongs to another user.
+var ErrTestRunNotFound = errors.New("test run not found")
+
+// createTestRunTablesSQL stores uploaded test reports and the result of every
+// scenario they executed. scenario_id is NULL for results that matched no cached scenario.
+const createTestRunTablesSQL = `
+    CREATE TABLE IF NOT EXISTS test_runs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        project_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        format TEXT NOT NULL,
+        duration_ms INTEGER NOT NULL DEFAULT 0,
+        total INTEGER NOT NULL DEFAULT 0,
+        passed INTEGER NOT NULL DEFAULT 0,
+        failed INTEGER NOT NULL DEFAULT 0,
+        skipped INTEGER NOT NULL DEFAULT 0,
+        pending INTEGER NOT NULL DEFAULT 0,
+        unmatched INTEGER NOT NULL DEFAULT 0,
+        retried INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_test_runs_project_user ON test_runs (project_id, user_id, id);
+
+    CREATE TABLE IF NOT EXISTS test_results (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id INTEGER NOT NULL,
+        position INTEGER NOT NULL,
+        scenario_id TEXT,
+        name TEXT NOT NULL,
+        feature TEXT NOT NULL DEFAULT '',
+        uri TEXT NOT NULL DEFAULT '',
+        line INTEGER NOT NULL DEFAULT 0,
+        tags TEXT NOT NULL DEFAULT '[]',
+        status TEXT NOT NULL,
+        duration_ms INTEGER NOT NULL DEFAULT 0,
+        error_message TEXT NOT NULL DEFAULT '',
+        attempt INTEGER NOT NULL DEFAULT 0,
+        retried INTEGER NOT NULL DEFAULT 0,
+        FOREIGN KEY (run_id) REFERENCES test_runs(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_test_results_run ON test_results (run_id, position);
+    CREATE INDEX IF NOT EXISTS idx_test_results_scenario ON test_results (scenario_id);
+`
+
+// initTestRunTables creates the test run tables and adds the columns introduced
+// since: retries, and the Cucumber Studio test run results were pushed to.
+func initTestRunTables() error {
+	if _, err := DB.Exec(createTestRunTablesSQL); err != nil {
+		return fmt.Errorf("failed to create test run tables: %v", err)
+	}
+	columns := []struct{ table, name, definition string }{
+		{"test_runs", "retried", "INTEGER NOT NULL DEFAULT 0"},
+		{"test_results", "attempt", "INTEGER NOT NULL DEFAULT 0"},
+		{"test_results", "retried", "INTEGER NOT NULL DEFAULT 0"},
+		{"test_runs", "studio_test_run_id", "TEXT"},
+		{"test_runs", "pushed_at", "DATETIME"},
+	}
+	for _, column := range columns {
+		if err := addColumnIfMissing(column.table, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTestRun links results to the cached scenarios of a project and stores
+// them as a new run. Results are matched by name; see matchTestResults.
+func CreateTestRun(userID, projectID int, name, format string, results []models.TestResult) (*models.TestRun, error) {
+	scenarios, err := GetScenariosByProjectID(projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	matchTestResults(results, scenarios)
+
+	run := models.TestRun{UserID: userID, ProjectID: projectID, Name: name, Format: format}
+	for _, result := range results {
+		run.Summary.Add(result)
+		run.DurationMS += result.DurationMS
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO test_runs (user_id, project_id, name, format, duration_ms,
+             total, passed, failed, skipped, pending, unmatched, retried)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, projectID, name, format, run.DurationMS,
+		run.Summary.Total, run.Summary.Passed, run.Summary.Failed, run.Summary.Skipped, run.Summary.Pending,
+		run.Summary.Unmatched, run.Summary.Retried,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert test run: %v", err)
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %v", err)
+	}
+
+	stmt, err := tx.Prepare(
+		`INSERT INTO test_results (run_id, position, scenario_id, name, feature, uri, line, tags, status,
+             duration_ms, error_message, attempt, retried)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare test result insert: %v", err)
+	}
+	defer stmt.Close()
+	for i, result := range results {
+		tags, err := json.Marshal(result.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tags: %v", err)
+		}
+		_, err = stmt.Exec(runID, i, result.ScenarioID, result.Name, result.Feature, result.URI, result.Line,
+			string(tags), result.Status, result.DurationMS, result.ErrorMessage, result.Attempt, result.Retried)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert test result: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit test run: %v", err)
+	}
+	return GetTestRun(int(runID), userID, false)
+}
+
+// matchTestResults sets the ScenarioID of every result that names a cached
+// scenario. When several scenarios share a name, the one whose tags all appear
+// on the result wins, the most specific first; a lone scenario with that name
+// matches even if its tags have drifted.
+func matchTestResults(results []models.TestResult, scenarios []models.Scenario) {
+	type candidate struct {
+		id   string
+		tags []string
+	}
+	byName := make(map[string][]candidate)
+	sort.Slice(scenarios, func(i, j int) bool { return lessScenarioID(scenarios[i].ID, scenarios[j].ID) })
+	for _, scenario := range scenarios {
+		name := strings.TrimSpace(scenario.Name)
+		byName[name] = append(byName[name], candidate{id: scenario.ID, tags: scenarioTagNames(scenario.Tags)})
+	}
+
+	for i := range results {
+		candidates := byName[results[i].Name]
+		var best *candidate
+		for j := range candidates {
+			if len(tagDifference(candidates[j].tags, results[i].Tags)) > 0 {
+				continue
+			}
+			if best == nil || len(candidates[j].tags) > len(best.tags) {
+				best = &candidates[j]
+			}
+		}
+		if best == nil && len(candidates) == 1 {
+			best = &candidates[0]
+		}
+		if best != nil {
+			id := best.id
+			results[i].ScenarioID = &id
+		}
+	}
+}
+
+const testRunColumns = `id, user_id, project_id, name, format, duration_ms,
+    total, passed, failed, skipped, pending, unmatched, retried, created_at, studio_test_run_id, pushed_at`
+
+func scanTestRun(row rowScanner) (*models.TestRun, error) {
+	var run models.TestRun
+	var studioTestRunID, pushedAt sql.NullString
+	err := row.Scan(
+		&run.ID, &run.UserID, &run.ProjectID, &run.Name, &run.Format, &run.DurationMS,
+		&run.Summary.Total, &run.Summary.Passed, &run.Summary.Failed, &run.Summary.Skipped,
+		&run.Summary.Pending, &run.Summary.Unmatched, &run.Summary.Retried, &run.CreatedAt,
+		&studioTestRunID, &pushedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if studioTestRunID.Valid {
+		run.StudioTestRunID = &studioTestRunID.String
+	}
+	if pushedAt.Valid {
+		run.PushedAt = &pushedAt.String
+	}
+	return &run, nil
+}
+
+// GetTestRun retrieves a test run owned by userID, with its results if withResults is set.
+func GetTestRun(runID, userID int, withResults bool) (*models.TestRun, error) {
+	run, err := scanTestRun(DB.QueryRow("SELECT "+testRunColumns+" FROM test_runs WHERE id = ? AND user_id = ?", runID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTestRunNotFound
+		}
+		return nil, fmt.Errorf("failed to query test run: %v", err)
+	}
+	if withResults {
+		if run.Results, err = getTestResults(run.ID); err != nil {
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// GetTestRuns retrieves the most recent test runs of a project, newest first.
+func GetTestRuns(projectID, userID, limit int) ([]models.TestRun, error) {
+	rows, err := DB.Query(
+		"SELECT "+testRunColumns+" FROM test_runs WHERE project_id = ? AND user_id = ? ORDER BY id DESC LIMIT ?",
+		projectID, userID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := make([]models.TestRun, 0)
+	for rows.Next() {
+		run, err := scanTestRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan test run: %v", err)
+		}
+		runs = append(runs, *run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return runs, nil
+}
+
+func getTestResults(runID int) ([]models.TestResult, error) {
+	rows, err := DB.Query(
+		`SELECT id, run_id, scenario_id, name, feature, uri, line, tags, status, duration_ms, error_message,
+             attempt, retried
+         FROM test_results WHERE run_id = ? ORDER BY position`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query test results: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.TestResult, 0)
+	for rows.Next() {
+		var result models.TestResult
+		var scenarioID sql.NullString
+		var tags string
+		err := rows.Scan(&result.ID, &result.RunID, &scenarioID, &result.Name, &result.Feature, &result.URI,
+			&result.Line, &tags, &result.Status, &result.DurationMS, &result.ErrorMessage, &result.Attempt, &result.Retried)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan test result: %v", err)
+		}
+		if scenarioID.Valid {
+			result.ScenarioID = &scenarioID.String
+		}
+		if err := json.Unmarshal([]byte(tags), &result.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags: %v", err)
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
+	return results, nil
+}
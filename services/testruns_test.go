@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+func TestMatchTestResults(t *testing.T) {
+	smoke := models.Tag{Key: "smoke"}
+	high := models.Tag{Key: "priority", Value: "high"}
+	scenarios := []models.Scenario{
+		{ID: "10", Name: "Check out", Tags: []models.Tag{smoke, high}},
+		{ID: "9", Name: "Check out", Tags: []models.Tag{smoke}},
+		{ID: "11", Name: "Check out"},
+		{ID: "20", Name: "Log in", Tags: []models.Tag{{Key: "regression"}}},
+		{ID: "30", Name: "Pay", Tags: []models.Tag{{Key: "card"}}},
+		{ID: "31", Name: "Pay", Tags: []models.Tag{{Key: "cash"}}},
+		{ID: "40", Name: "  Log out  "},
+	}
+
+	tests := []struct {
+		name   string
+		result models.TestResult
+		want   string // Empty for no match
+	}{
+		{name: "most specific tags win", result: models.TestResult{Name: "Check out", Tags: []string{"smoke", "priority:high", "nightly"}}, want: "10"},
+		{name: "tags of one candidate", result: models.TestResult{Name: "Check out", Tags: []string{"smoke"}}, want: "9"},
+		{name: "untagged candidate", result: models.TestResult{Name: "Check out"}, want: "11"},
+		{name: "lone scenario with drifted tags", result: models.TestResult{Name: "Log in", Tags: []string{"smoke"}}, want: "20"},
+		{name: "equally specific candidates", result: models.TestResult{Name: "Pay", Tags: []string{"card", "cash"}}, want: "30"},
+		{name: "no candidate's tags", result: models.TestResult{Name: "Pay", Tags: []string{"voucher"}}},
+		{name: "cached name is trimmed", result: models.TestResult{Name: "Log out"}, want: "40"},
+		{name: "unknown name", result: models.TestResult{Name: "Sign up"}},
+		{name: "names are case-sensitive", result: models.TestResult{Name: "check out"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := []models.TestResult{tt.result}
+			matchTestResults(results, append([]models.Scenario(nil), scenarios...))
+			got := ""
+			if results[0].ScenarioID != nil {
+				got = *results[0].ScenarioID
+			}
+			if got != tt.want {
+				t.Errorf("matched scenario %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTestRunLinksResults(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "qa@example.com", "client", "token", models.Project{ID: "1", Name: "Shop"})
+	for _, scenario := range []models.Scenario{
+		{ID: "1000", Name: "Log in", FolderID: 1, Tags: []models.Tag{{Key: "smoke"}}},
+		{ID: "1001", Name: "Pay", FolderID: 1},
+	} {
+		if err := CreateScenario(&scenario, 1, user.ID); err != nil {
+			t.Fatalf("CreateScenario: %v", err)
+		}
+	}
+
+	run, err := CreateTestRun(user.ID, 1, "Nightly", "cucumber-json", []models.TestResult{
+		{Name: "Log in", Tags: []string{"smoke"}, Status: models.TestPassed, DurationMS: 120},
+		{Name: "Pay", Status: models.TestFailed, ErrorMessage: "declined", Retried: true, DurationMS: 300},
+		{Name: "Pay", Status: models.TestPassed, Attempt: 1, DurationMS: 280},
+		{Name: "Sign up", Status: models.TestSkipped},
+	})
+	if err != nil {
+		t.Fatalf("CreateTestRun: %v", err)
+	}
+	wantSummary := models.TestRunSummary{Total: 3, Passed: 2, Skipped: 1, Unmatched: 1, Retried: 1}
+	if run.DurationMS != 700 || run.Summary != wantSummary {
+		t.Errorf("got %dms and summary %+v, want 700ms and %+v", run.DurationMS, run.Summary, wantSummary)
+	}
+
+	stored, err := GetTestRun(run.ID, user.ID, true)
+	if err != nil {
+		t.Fatalf("GetTestRun: %v", err)
+	}
+	var linked []string
+	for _, result := range stored.Results {
+		if result.ScenarioID == nil {
+			linked = append(linked, "")
+		} else {
+			linked = append(linked, *result.ScenarioID)
+		}
+	}
+	if want := []string{"1000", "1001", "1001", ""}; !reflect.DeepEqual(linked, want) {
+		t.Errorf("results are linked to %q, want %q", linked, want)
+	}
+	if !stored.Results[1].Retried || stored.Results[2].Attempt != 1 || stored.Results[1].ErrorMessage != "declined" {
+		t.Errorf("retry details were not stored: %+v", stored.Results[1:3])
+	}
+
+	other := createTestUser(t, "other@example.com", "c2", "t2", models.Project{ID: "1", Name: "Shop"})
+	if _, err := GetTestRun(run.ID, other.ID, false); !errors.Is(err, ErrTestRunNotFound) {
+		t.Errorf("got error %v, want ErrTestRunNotFound for another user's run", err)
+	}
+}
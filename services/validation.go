@@ -0,0 +1,151 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFiles holds the JSON schemas of the JSON documents stored in chart and
+// data table fields.
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+var (
+	chartConfigSchema  = mustCompileSchema("chart_config.json")
+	chartQuerySchema   = mustCompileSchema("chart_query.json")
+	tableColumnsSchema = mustCompileSchema("table_columns.json")
+	tableQuerySchema   = mustCompileSchema("table_query.json")
+)
+
+// FieldError describes why one field of a request is invalid. Fields inside
+// JSON documents are addressed like query.group_by.field or columns[0].key.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns e if any field is invalid, nil otherwise.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func mustCompileSchema(name string) *jsonschema.Schema {
+	compiler := jsonschema.NewCompiler()
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := compiler.AddResource(schemaURL(entry.Name()), bytes.NewReader(data)); err != nil {
+			panic(fmt.Sprintf("schema %s: %v", entry.Name(), err))
+		}
+	}
+	return compiler.MustCompile(schemaURL(name))
+}
+
+func schemaURL(name string) string {
+	return "https://my-cucumber-backend.local/schemas/" + name
+}
+
+// validateJSONField checks that raw is a JSON document matching schema and
+// reports every mismatch as an error on field. It returns whether raw is valid.
+func validateJSONField(v *ValidationError, field string, schema *jsonschema.Schema, raw string) bool {
+	if strings.TrimSpace(raw) == "" {
+		v.add(field, "is required")
+		return false
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		v.add(field, "must be a JSON document: %v", err)
+		return false
+	}
+	if decoder.More() {
+		v.add(field, "must be a single JSON document")
+		return false
+	}
+
+	err := schema.Validate(doc)
+	var schemaErr *jsonschema.ValidationError
+	if err == nil {
+		return true
+	}
+	if !errors.As(err, &schemaErr) {
+		v.add(field, "%v", err)
+		return false
+	}
+	leaves := schemaLeafErrors(schemaErr)
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].InstanceLocation < leaves[j].InstanceLocation })
+	seen := make(map[FieldError]bool)
+	for _, leaf := range leaves {
+		fieldErr := FieldError{Field: field + instancePath(leaf.InstanceLocation), Message: leaf.Message}
+		if !seen[fieldErr] {
+			seen[fieldErr] = true
+			v.Fields = append(v.Fields, fieldErr)
+		}
+	}
+	return false
+}
+
+// schemaLeafErrors returns the most specific causes of a schema validation error.
+func schemaLeafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, schemaLeafErrors(cause)...)
+	}
+	return leaves
+}
+
+// instancePath converts a JSON pointer such as /columns/0/key to .columns[0].key.
+func instancePath(pointer string) string {
+	if pointer == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, segment := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		segment = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+		if _, err := strconv.Atoi(segment); err == nil {
+			b.WriteString("[" + segment + "]")
+		} else {
+			b.WriteString("." + segment)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package services
+
+import "testing"
+
+func TestInstancePath(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    string
+	}{
+		{pointer: "", want: ""},
+		{pointer: "/group_by/field", want: ".group_by.field"},
+		{pointer: "/0/key", want: "[0].key"},
+		{pointer: "/colors/12", want: ".colors[12]"},
+		{pointer: "/a~1b/c~0d", want: ".a/b.c~d"},
+	}
+	for _, tt := range tests {
+		if got := instancePath(tt.pointer); got != tt.want {
+			t.Errorf("instancePath(%q) = %q, want %q", tt.pointer, got, tt.want)
+		}
+	}
+}
+
+func TestValidationErrorListsEveryField(t *testing.T) {
+	v := &ValidationError{}
+	if v.err() != nil {
+		t.Fatal("an empty ValidationError is an error")
+	}
+	v.add("name", "is required")
+	v.add("columns[0].key", "must be one of %d keys", 13)
+	want := "validation failed: name: is required; columns[0].key: must be one of 13 keys"
+	if err := v.err(); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
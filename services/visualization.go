@@ -1,17 +1,91 @@
 package services
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"my-cucumber-backend/models"
+	"strings"
 )
 
+var (
+	// ErrChartNotFound is returned for charts that do not exist or are not visible to the user.
+	ErrChartNotFound = errors.New("chart not found")
+	// ErrDataTableNotFound is returned for data tables that do not exist or are not visible to the user.
+	ErrDataTableNotFound = errors.New("data table not found")
+	// ErrNotVisualizationOwner is returned when a team member changes a chart or
+	// data table shared with them.
+	ErrNotVisualizationOwner = errors.New("only the owner can change a shared chart or data table")
+)
+
+// maxVisualizationName bounds chart and data table names.
+const maxVisualizationName = 200
+
+// ValidateChart checks a chart before it is saved. Config and Query must match
+// their JSON schemas; an empty Config is replaced by an empty object.
+func ValidateChart(chart *models.Chart) error {
+	v := &ValidationError{}
+	validateVisualizationName(v, chart.Name)
+	if !chart.Type.Valid() {
+		v.add("type", "must be one of %q, %q or %q", models.ChartTypePie, models.ChartTypeBar, models.ChartTypeLine)
+	}
+	if strings.TrimSpace(chart.Config) == "" {
+		chart.Config = "{}"
+	}
+	validateJSONField(v, "config", chartConfigSchema, chart.Config)
+	if validateJSONField(v, "query", chartQuerySchema, chart.Query) && chart.Type.Valid() {
+		if _, err := ParseChartQuery(chart.Type, chart.Query); err != nil {
+			v.add("query", "%s", strings.TrimPrefix(err.Error(), ErrInvalidChartQuery.Error()+": "))
+		}
+	}
+	return v.err()
+}
+
+// ValidateDataTable checks a data table before it is saved. Columns and Query
+// must match their JSON schemas, and every column must exist in the dataset.
+func ValidateDataTable(table *models.DataTable) error {
+	v := &ValidationError{}
+	validateVisualizationName(v, table.Name)
+	columnsValid := validateJSONField(v, "columns", tableColumnsSchema, table.Columns)
+	if validateJSONField(v, "query", tableQuerySchema, table.Query) {
+		q, err := ParseTableQuery(table.Query)
+		if err != nil {
+			v.add("query", "%s", strings.TrimPrefix(err.Error(), ErrInvalidTableQuery.Error()+": "))
+		} else if columnsValid {
+			var columns []models.TableColumn
+			if err := json.Unmarshal([]byte(table.Columns), &columns); err != nil {
+				v.add("columns", "%v", err)
+			}
+			for i, column := range columns {
+				if !containsString(tableColumnKeys[q.Dataset], column.Key) {
+					v.add(fmt.Sprintf("columns[%d].key", i), "is not available for the %s dataset", q.Dataset)
+				}
+			}
+		}
+	}
+	return v.err()
+}
+
+func validateVisualizationName(v *ValidationError, name string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		v.add("name", "is required")
+	case len([]rune(name)) > maxVisualizationName:
+		v.add("name", "must be at most %d characters", maxVisualizationName)
+	}
+}
+
 // CreateChart creates a new chart configuration
 func CreateChart(chart *models.Chart) error {
+	if err := checkShareTeam(chart.TeamID, chart.UserID); err != nil {
+		return err
+	}
 	result, err := DB.Exec(
-		`INSERT INTO charts (name, type, config, query, user_id) 
-		 VALUES (?, ?, ?, ?, ?)`,
+		`INSERT INTO charts (name, type, config, query, user_id, team_id) 
+		 VALUES (?, ?, ?, ?, ?, ?)`,
 		chart.Name, chart.Type, chart.Config, chart.Query,
-		chart.UserID,
+		chart.UserID, chart.TeamID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create chart: %v", err)
@@ -25,24 +99,72 @@ func CreateChart(chart *models.Chart) error {
 	return nil
 }
 
-// GetChartsByUser retrieves all charts for a user
+// GetChart retrieves a chart of a user or shared with one of their teams
+func GetChart(chartID, userID int) (*models.Chart, error) {
+	var chart models.Chart
+	err := DB.QueryRow(
+		`SELECT id, name, type, config, query, user_id, team_id,
+		 created_at, updated_at FROM charts WHERE id = ? AND `+visibleToUserSQL,
+		chartID, userID, userID,
+	).Scan(
+		&chart.ID, &chart.Name, &chart.Type, &chart.Config,
+		&chart.Query, &chart.UserID, &chart.TeamID,
+		&chart.CreatedAt, &chart.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChartNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chart: %v", err)
+	}
+	return &chart, nil
+}
+
+// UpdateChart saves every field of a chart of a user, including the team it is
+// shared with, and bumps its updated_at
+func UpdateChart(chart *models.Chart) error {
+	if err := checkShareTeam(chart.TeamID, chart.UserID); err != nil {
+		return err
+	}
+	result, err := DB.Exec(
+		`UPDATE charts SET name = ?, type = ?, config = ?, query = ?, team_id = ?, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND user_id = ?`,
+		chart.Name, chart.Type, chart.Config, chart.Query, chart.TeamID,
+		chart.ID, chart.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update chart: %v", err)
+	}
+	return requireOwned(result, "charts", chart.ID, chart.UserID, ErrChartNotFound)
+}
+
+// DeleteChart deletes a chart of a user
+func DeleteChart(chartID, userID int) error {
+	result, err := DB.Exec("DELETE FROM charts WHERE id = ? AND user_id = ?", chartID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete chart: %v", err)
+	}
+	return requireOwned(result, "charts", chartID, userID, ErrChartNotFound)
+}
+
+// GetChartsByUser retrieves all charts of a user and those shared with their teams
 func GetChartsByUser(userID int) ([]models.Chart, error) {
 	rows, err := DB.Query(
-		`SELECT id, name, type, config, query, user_id, 
-		 created_at, updated_at FROM charts WHERE user_id = ?`,
-		userID,
+		`SELECT id, name, type, config, query, user_id, team_id,
+		 created_at, updated_at FROM charts WHERE `+visibleToUserSQL+` ORDER BY id`,
+		userID, userID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query charts: %v", err)
 	}
 	defer rows.Close()
 
-	var charts []models.Chart
+	charts := make([]models.Chart, 0)
 	for rows.Next() {
 		var chart models.Chart
 		err := rows.Scan(
 			&chart.ID, &chart.Name, &chart.Type, &chart.Config,
-			&chart.Query, &chart.UserID,
+			&chart.Query, &chart.UserID, &chart.TeamID,
 			&chart.CreatedAt, &chart.UpdatedAt,
 		)
 		if err != nil {
@@ -50,15 +172,21 @@ func GetChartsByUser(userID int) ([]models.Chart, error) {
 		}
 		charts = append(charts, chart)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
 	return charts, nil
 }
 
 // CreateDataTable creates a new data table configuration
 func CreateDataTable(table *models.DataTable) error {
+	if err := checkShareTeam(table.TeamID, table.UserID); err != nil {
+		return err
+	}
 	result, err := DB.Exec(
-		`INSERT INTO data_tables (name, columns, query, user_id) 
-		 VALUES (?, ?, ?, ?)`,
-		table.Name, table.Columns, table.Query, table.UserID,
+		`INSERT INTO data_tables (name, columns, query, user_id, team_id) 
+		 VALUES (?, ?, ?, ?, ?)`,
+		table.Name, table.Columns, table.Query, table.UserID, table.TeamID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create data table: %v", err)
@@ -72,29 +200,128 @@ func CreateDataTable(table *models.DataTable) error {
 	return nil
 }
 
-// GetDataTablesByUser retrieves all data tables for a user
+// GetDataTable retrieves a data table of a user or shared with one of their teams
+func GetDataTable(tableID, userID int) (*models.DataTable, error) {
+	var table models.DataTable
+	err := DB.QueryRow(
+		`SELECT id, name, columns, query, user_id, team_id,
+		 created_at, updated_at FROM data_tables WHERE id = ? AND `+visibleToUserSQL,
+		tableID, userID, userID,
+	).Scan(
+		&table.ID, &table.Name, &table.Columns, &table.Query,
+		&table.UserID, &table.TeamID, &table.CreatedAt, &table.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDataTableNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data table: %v", err)
+	}
+	return &table, nil
+}
+
+// UpdateDataTable saves every field of a data table of a user, including the
+// team it is shared with, and bumps its updated_at
+func UpdateDataTable(table *models.DataTable) error {
+	if err := checkShareTeam(table.TeamID, table.UserID); err != nil {
+		return err
+	}
+	result, err := DB.Exec(
+		`UPDATE data_tables SET name = ?, columns = ?, query = ?, team_id = ?, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND user_id = ?`,
+		table.Name, table.Columns, table.Query, table.TeamID, table.ID, table.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update data table: %v", err)
+	}
+	return requireOwned(result, "data_tables", table.ID, table.UserID, ErrDataTableNotFound)
+}
+
+// DeleteDataTable deletes a data table of a user
+func DeleteDataTable(tableID, userID int) error {
+	result, err := DB.Exec("DELETE FROM data_tables WHERE id = ? AND user_id = ?", tableID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete data table: %v", err)
+	}
+	return requireOwned(result, "data_tables", tableID, userID, ErrDataTableNotFound)
+}
+
+// GetDataTablesByUser retrieves all data tables of a user and those shared with their teams
 func GetDataTablesByUser(userID int) ([]models.DataTable, error) {
 	rows, err := DB.Query(
-		`SELECT id, name, columns, query, user_id, 
-		 created_at, updated_at FROM data_tables WHERE user_id = ?`,
-		userID,
+		`SELECT id, name, columns, query, user_id, team_id,
+		 created_at, updated_at FROM data_tables WHERE `+visibleToUserSQL+` ORDER BY id`,
+		userID, userID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query data tables: %v", err)
 	}
 	defer rows.Close()
 
-	var tables []models.DataTable
+	tables := make([]models.DataTable, 0)
 	for rows.Next() {
 		var table models.DataTable
 		err := rows.Scan(
 			&table.ID, &table.Name, &table.Columns, &table.Query,
-			&table.UserID, &table.CreatedAt, &table.UpdatedAt,
+			&table.UserID, &table.TeamID, &table.CreatedAt, &table.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan data table: %v", err)
 		}
 		tables = append(tables, table)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %v", err)
+	}
 	return tables, nil
 }
+
+// checkShareTeam reports a validation error unless the user is an editor or an
+// admin of the team a chart or data table is shared with.
+func checkShareTeam(teamID *int, userID int) error {
+	if teamID == nil {
+		return nil
+	}
+	err := checkTeamPermission(DB, *teamID, userID, models.PermissionWrite)
+	switch {
+	case errors.Is(err, ErrTeamNotFound):
+		v := &ValidationError{}
+		v.add("team_id", "must be a team you are a member of")
+		return v.err()
+	case errors.Is(err, ErrTeamRoleDenied):
+		v := &ValidationError{}
+		v.add("team_id", "must be a team you are an editor or an admin of")
+		return v.err()
+	}
+	return err
+}
+
+// requireOwned is requireAffected for statements restricted to the owner of a
+// chart or data table: when no row changed because the user only sees the
+// record through a team, it returns ErrNotVisualizationOwner.
+func requireOwned(result sql.Result, table string, id, userID int, notFound error) error {
+	err := requireAffected(result, notFound)
+	if !errors.Is(err, notFound) {
+		return err
+	}
+	var shared bool
+	if err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = ? AND "+visibleToUserSQL+")", id, userID, userID).Scan(&shared); err != nil {
+		return fmt.Errorf("failed to query %s: %v", table, err)
+	}
+	if shared {
+		return ErrNotVisualizationOwner
+	}
+	return notFound
+}
+
+// requireAffected returns notFound when a statement changed no row.
+func requireAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %v", err)
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
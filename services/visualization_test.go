@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"my-cucumber-backend/models"
+)
+
+// validationFields returns the invalid fields of a ValidationError, or nil for nil.
+func validationFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var v *ValidationError
+	if !errors.As(err, &v) {
+		t.Fatalf("got error %v, want a ValidationError", err)
+	}
+	fields := make([]string, len(v.Fields))
+	for i, field := range v.Fields {
+		fields[i] = field.Field
+	}
+	return fields
+}
+
+func TestValidateChart(t *testing.T) {
+	const query = `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`
+	tests := []struct {
+		name       string
+		chart      models.Chart
+		wantFields []string
+	}{
+		{name: "valid", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Config: `{"legend": {"show": true}}`, Query: query}},
+		{name: "missing name and type", chart: models.Chart{Name: " ", Type: "radar", Query: query}, wantFields: []string{"name", "type"}},
+		{name: "long name", chart: models.Chart{Name: strings.Repeat("é", maxVisualizationName+1), Type: models.ChartTypeBar, Query: query}, wantFields: []string{"name"}},
+		{name: "invalid colors", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Config: `{"colors": ["#fff", "red"]}`, Query: query}, wantFields: []string{"config.colors[1]"}},
+		{name: "unknown config key", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Config: `{"legend": {"show": true, "size": 3}}`, Query: query}, wantFields: []string{"config.legend"}},
+		{name: "missing query", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar}, wantFields: []string{"query"}},
+		{name: "query is not JSON", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Query: `{"dataset":`}, wantFields: []string{"query"}},
+		{name: "two query documents", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Query: query + query}, wantFields: []string{"query"}},
+		{name: "unknown group field", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "owner"}}`}, wantFields: []string{"query.group_by.field"}},
+		{name: "invalid project", chart: models.Chart{Name: "Tags", Type: models.ChartTypeBar, Query: `{"dataset": "scenarios", "project_id": 0, "group_by": {"field": "tag"}}`}, wantFields: []string{"query.project_id"}},
+		// Valid against the schema, rejected by ParseChartQuery.
+		{name: "split pie chart", chart: models.Chart{Name: "Tags", Type: models.ChartTypePie, Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}, "split_by": {"field": "status"}}`}, wantFields: []string{"query"}},
+		{name: "run grouping of scenarios", chart: models.Chart{Name: "Runs", Type: models.ChartTypeLine, Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "run"}}`}, wantFields: []string{"query"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart := tt.chart
+			fields := validationFields(t, ValidateChart(&chart))
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("got invalid fields %q, want %q", fields, tt.wantFields)
+			}
+			if tt.chart.Config == "" && chart.Config != "{}" {
+				t.Errorf("got config %q, want the empty config replaced by {}", chart.Config)
+			}
+		})
+	}
+
+	chart := models.Chart{Name: "Split", Type: models.ChartTypePie, Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}, "split_by": {"field": "status"}}`}
+	var v *ValidationError
+	if err := ValidateChart(&chart); !errors.As(err, &v) || strings.HasPrefix(v.Fields[0].Message, ErrInvalidChartQuery.Error()) {
+		t.Errorf("got %v, want the query message without the ErrInvalidChartQuery prefix", err)
+	}
+}
+
+func TestValidateDataTable(t *testing.T) {
+	const query = `{"dataset": "scenarios", "project_id": 1}`
+	tests := []struct {
+		name       string
+		table      models.DataTable
+		wantFields []string
+	}{
+		{name: "valid", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "id"}, {"key": "name", "label": "Scenario", "width": 300}]`, Query: query}},
+		{name: "missing everything", table: models.DataTable{}, wantFields: []string{"name", "columns", "query"}},
+		{name: "no columns", table: models.DataTable{Name: "Scenarios", Columns: `[]`, Query: query}, wantFields: []string{"columns"}},
+		{name: "unknown column key", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "owner"}]`, Query: query}, wantFields: []string{"columns[0].key"}},
+		{name: "narrow column", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "id"}, {"key": "name", "width": 5}]`, Query: query}, wantFields: []string{"columns[1].width"}},
+		{name: "column of the other dataset", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "id"}, {"key": "duration_ms"}, {"key": "run"}]`, Query: query}, wantFields: []string{"columns[1].key", "columns[2].key"}},
+		{name: "test result columns", table: models.DataTable{Name: "Results", Columns: `[{"key": "run"}, {"key": "duration_ms"}]`, Query: `{"dataset": "test_results", "project_id": 1}`}},
+		{name: "limit too high", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "id"}]`, Query: `{"dataset": "scenarios", "project_id": 1, "limit": 5000}`}, wantFields: []string{"query.limit"}},
+		// Valid against the schema, rejected by ParseTableQuery; columns are then not checked against the dataset.
+		{name: "unknown sort column", table: models.DataTable{Name: "Scenarios", Columns: `[{"key": "run"}]`, Query: `{"dataset": "scenarios", "project_id": 1, "sort": {"column": "run"}}`}, wantFields: []string{"query"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := tt.table
+			fields := validationFields(t, ValidateDataTable(&table))
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("got invalid fields %q, want %q", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestChartCRUD(t *testing.T) {
+	openTestDB(t)
+	owner := createTestUser(t, "owner@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"})
+	member := createTestUser(t, "member@example.com", "c2", "t2", models.Project{ID: "1", Name: "Shop"})
+	outsider := createTestUser(t, "outsider@example.com", "c3", "t3", models.Project{ID: "1", Name: "Shop"})
+	team := &models.Team{Name: "QA", CreatedBy: owner.ID}
+	if err := CreateTeam(team); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if _, err := AddTeamMember(team.ID, owner.ID, member.Email, models.RoleViewer); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+
+	chart := &models.Chart{
+		Name: "Tags", Type: models.ChartTypeBar, Config: "{}", UserID: owner.ID, TeamID: &team.ID,
+		Query: `{"dataset": "scenarios", "project_id": 1, "group_by": {"field": "tag"}}`,
+	}
+	if err := CreateChart(chart); err != nil {
+		t.Fatalf("CreateChart: %v", err)
+	}
+
+	for _, user := range []*models.User{owner, member} {
+		if got, err := GetChart(chart.ID, user.ID); err != nil || got.Name != "Tags" || got.UserID != owner.ID {
+			t.Errorf("GetChart as %s: got %+v, %v", user.Email, got, err)
+		}
+		if charts, err := GetChartsByUser(user.ID); err != nil || len(charts) != 1 {
+			t.Errorf("GetChartsByUser as %s: got %d charts, %v", user.Email, len(charts), err)
+		}
+	}
+	if _, err := GetChart(chart.ID, outsider.ID); !errors.Is(err, ErrChartNotFound) {
+		t.Errorf("got error %v, want ErrChartNotFound for a user outside the team", err)
+	}
+	if charts, err := GetChartsByUser(outsider.ID); err != nil || len(charts) != 0 {
+		t.Errorf("GetChartsByUser as outsider: got %d charts, %v", len(charts), err)
+	}
+
+	// The owner's update bumps updated_at but not created_at.
+	if _, err := DB.Exec("UPDATE charts SET created_at = '2020-01-01 00:00:00', updated_at = '2020-01-01 00:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	chart.Name = "Tags by priority"
+	if err := UpdateChart(chart); err != nil {
+		t.Fatalf("UpdateChart: %v", err)
+	}
+	updated, err := GetChart(chart.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("GetChart: %v", err)
+	}
+	if updated.Name != "Tags by priority" || !strings.HasPrefix(updated.CreatedAt, "2020-01-01") || strings.HasPrefix(updated.UpdatedAt, "2020-01-01") {
+		t.Errorf("got %q created %s and updated %s, want the new name and only updated_at bumped", updated.Name, updated.CreatedAt, updated.UpdatedAt)
+	}
+
+	// A team member sees the chart but cannot change it; others do not see it at all.
+	edit := *chart
+	edit.UserID, edit.TeamID = member.ID, nil
+	if err := UpdateChart(&edit); !errors.Is(err, ErrNotVisualizationOwner) {
+		t.Errorf("UpdateChart as member: got %v, want ErrNotVisualizationOwner", err)
+	}
+	if err := DeleteChart(chart.ID, member.ID); !errors.Is(err, ErrNotVisualizationOwner) {
+		t.Errorf("DeleteChart as member: got %v, want ErrNotVisualizationOwner", err)
+	}
+	edit.UserID = outsider.ID
+	if err := UpdateChart(&edit); !errors.Is(err, ErrChartNotFound) {
+		t.Errorf("UpdateChart as outsider: got %v, want ErrChartNotFound", err)
+	}
+	if err := DeleteChart(chart.ID, outsider.ID); !errors.Is(err, ErrChartNotFound) {
+		t.Errorf("DeleteChart as outsider: got %v, want ErrChartNotFound", err)
+	}
+
+	// Only editors and admins of a team share with it.
+	reshare := *chart
+	reshare.UserID = member.ID
+	if fields := validationFields(t, CreateChart(&reshare)); !reflect.DeepEqual(fields, []string{"team_id"}) {
+		t.Errorf("sharing as a viewer: got invalid fields %q, want team_id", fields)
+	}
+	reshare.UserID = outsider.ID
+	if fields := validationFields(t, CreateChart(&reshare)); !reflect.DeepEqual(fields, []string{"team_id"}) {
+		t.Errorf("sharing with another team: got invalid fields %q, want team_id", fields)
+	}
+
+	if err := DeleteChart(chart.ID, owner.ID); err != nil {
+		t.Fatalf("DeleteChart: %v", err)
+	}
+	if _, err := GetChart(chart.ID, owner.ID); !errors.Is(err, ErrChartNotFound) {
+		t.Errorf("got error %v after deleting, want ErrChartNotFound", err)
+	}
+	if err := DeleteChart(chart.ID, owner.ID); !errors.Is(err, ErrChartNotFound) {
+		t.Errorf("deleting twice: got %v, want ErrChartNotFound", err)
+	}
+}
+
+func TestDataTableCRUD(t *testing.T) {
+	openTestDB(t)
+	owner := createTestUser(t, "owner@example.com", "c1", "t1", models.Project{ID: "1", Name: "Shop"})
+	member := createTestUser(t, "member@example.com", "c2", "t2", models.Project{ID: "1", Name: "Shop"})
+	team := &models.Team{Name: "QA", CreatedBy: owner.ID}
+	if err := CreateTeam(team); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if _, err := AddTeamMember(team.ID, owner.ID, member.Email, models.RoleEditor); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+
+	table := &models.DataTable{
+		Name: "Scenarios", Columns: `[{"key": "id"}]`, Query: `{"dataset": "scenarios", "project_id": 1}`,
+		UserID: owner.ID,
+	}
+	if err := CreateDataTable(table); err != nil {
+		t.Fatalf("CreateDataTable: %v", err)
+	}
+	if _, err := GetDataTable(table.ID, member.ID); !errors.Is(err, ErrDataTableNotFound) {
+		t.Errorf("got error %v, want ErrDataTableNotFound before sharing", err)
+	}
+
+	if _, err := DB.Exec("UPDATE data_tables SET updated_at = '2020-01-01 00:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	table.TeamID = &team.ID
+	if err := UpdateDataTable(table); err != nil {
+		t.Fatalf("UpdateDataTable: %v", err)
+	}
+	shared, err := GetDataTable(table.ID, member.ID)
+	if err != nil {
+		t.Fatalf("GetDataTable as member: %v", err)
+	}
+	if shared.TeamID == nil || *shared.TeamID != team.ID || strings.HasPrefix(shared.UpdatedAt, "2020-01-01") {
+		t.Errorf("got team %v updated %s, want shared with %d and updated_at bumped", shared.TeamID, shared.UpdatedAt, team.ID)
+	}
+	if tables, err := GetDataTablesByUser(member.ID); err != nil || len(tables) != 1 {
+		t.Errorf("GetDataTablesByUser as member: got %d tables, %v", len(tables), err)
+	}
+
+	// Even an editor of the team only changes their own data tables.
+	edit := *table
+	edit.UserID = member.ID
+	if err := UpdateDataTable(&edit); !errors.Is(err, ErrNotVisualizationOwner) {
+		t.Errorf("UpdateDataTable as member: got %v, want ErrNotVisualizationOwner", err)
+	}
+	if err := DeleteDataTable(table.ID, member.ID); !errors.Is(err, ErrNotVisualizationOwner) {
+		t.Errorf("DeleteDataTable as member: got %v, want ErrNotVisualizationOwner", err)
+	}
+	if err := DeleteDataTable(table.ID, owner.ID); err != nil {
+		t.Fatalf("DeleteDataTable: %v", err)
+	}
+	if err := DeleteDataTable(table.ID, member.ID); !errors.Is(err, ErrDataTableNotFound) {
+		t.Errorf("got error %v after deleting, want ErrDataTableNotFound", err)
+	}
+}